@@ -6,13 +6,15 @@ import (
 )
 
 const (
-	Prefix = "Error: "
+	Prefix        = "Error: "
+	WarningPrefix = "Warning: "
 )
 
 type PosError struct {
-	Message string
-	Input   string
-	Pos     int
+	Message   string
+	Input     string
+	Pos       int
+	IsWarning bool
 }
 
 func NewPosError(message string, input string, pos int) *PosError {
@@ -23,8 +25,22 @@ func NewPosError(message string, input string, pos int) *PosError {
 	}
 }
 
+// NewPosWarning creates a diagnostic that does not stop compilation.
+func NewPosWarning(message string, input string, pos int) *PosError {
+	return &PosError{
+		Message:   message,
+		Input:     input,
+		Pos:       pos,
+		IsWarning: true,
+	}
+}
+
 func (e *PosError) Error() string {
+	prefix := Prefix
+	if e.IsWarning {
+		prefix = WarningPrefix
+	}
 	offset := e.Pos
-	spaces := strings.Repeat(" ", offset+len(Prefix))
-	return fmt.Sprintf("%s\n%s^ %s", Prefix+e.Input, spaces, e.Message)
+	spaces := strings.Repeat(" ", offset+len(prefix))
+	return fmt.Sprintf("%s\n%s^ %s", prefix+e.Input, spaces, e.Message)
 }
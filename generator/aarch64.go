@@ -0,0 +1,894 @@
+package generator
+
+import (
+	"fmt"
+	"math"
+	"slices"
+	"strings"
+
+	"rkitamu/gocc/ir"
+	"rkitamu/gocc/parser"
+)
+
+// The AArch64 backend generates assembly for the GNU assembler following
+// the AAPCS64, the procedure call standard of 64-bit Arm. It works as the
+// x86-64 one does: every virtual register holds a 64-bit integer, or the
+// bit pattern of a floating value, in an allocated register or in a home in
+// the frame, and the instructions load their operands into x0-x3 and store
+// their results back. x16 and x17 are scratch for addresses and calls.
+//
+// The frame pointer x29 points to the saved x29 and x30, so the slots and
+// homes lie below it, as they do below rbp, and the arguments passed on the
+// stack start at x29+16.
+
+// registers used to pass the first integer arguments, and the number of
+// floating-point arguments passed in v0-v7
+var argRegsAArch64 = []string{"x0", "x1", "x2", "x3", "x4", "x5", "x6", "x7"}
+
+const floatArgRegsAArch64 = 8
+
+// The caller-saved x9-x15 are free of arguments and results, and the
+// callee-saved x19-x28 are saved in the prologue once used. x18 is reserved
+// for the platform.
+var aarch64Regs = regSet{
+	callerSaved: []string{"x9", "x10", "x11", "x12", "x13", "x14", "x15"},
+	calleeSaved: []string{"x19", "x20", "x21", "x22", "x23", "x24", "x25", "x26", "x27", "x28"},
+}
+
+type aarch64 struct {
+	writer
+	fn       *ir.Func    // function being generated
+	alloc    *allocation // where the registers of fn live
+	stackArg int         // number of arguments fn receives on the stack
+}
+
+func newAArch64() *aarch64 {
+	return &aarch64{writer: newWriter()}
+}
+
+func (g *aarch64) generateProgram(prog *ir.Program) (string, error) {
+	g.emitData(prog.Globals)
+	for _, fn := range prog.Funcs {
+		g.defined[fn.Name] = !fn.IsInlineDef
+	}
+
+	g.emit(".text")
+	for _, fn := range prog.Funcs {
+		// another file defines the function of an inline definition
+		if fn.IsInlineDef {
+			continue
+		}
+		if err := g.emitFunction(fn); err != nil {
+			return "", err
+		}
+	}
+
+	g.emit(".section .note.GNU-stack,\"\",%progbits")
+	return g.sb.String(), nil
+}
+
+// wreg returns the 32-bit name of the 64-bit register reg.
+func wreg(reg string) string {
+	return "w" + reg[1:]
+}
+
+// loadImm loads the 64-bit value v into reg, with a single mov if the
+// assembler can encode it, or with movz and a movk for each other nonzero
+// 16-bit part.
+func (g *aarch64) loadImm(reg string, v int64) {
+	if v >= -65536 && v < 65536 {
+		g.emit(fmt.Sprintf("  mov %s, %d", reg, v))
+		return
+	}
+	first := true
+	for shift := 0; shift < 64; shift += 16 {
+		part := uint64(v) >> shift & 0xffff
+		switch {
+		case part == 0:
+		case first:
+			g.emit(fmt.Sprintf("  movz %s, %d, lsl %d", reg, part, shift))
+			first = false
+		default:
+			g.emit(fmt.Sprintf("  movk %s, %d, lsl %d", reg, part, shift))
+		}
+	}
+}
+
+// addImm sets dst to src plus v, going through x17 if v does not fit in
+// the immediate of add or sub. src must not be x17.
+func (g *aarch64) addImm(dst, src string, v int64) {
+	switch {
+	case v >= 0 && v < 4096:
+		g.emit(fmt.Sprintf("  add %s, %s, %d", dst, src, v))
+	case v < 0 && v > -4096:
+		g.emit(fmt.Sprintf("  sub %s, %s, %d", dst, src, -v))
+	default:
+		g.loadImm("x17", v)
+		g.emit(fmt.Sprintf("  add %s, %s, x17", dst, src))
+	}
+}
+
+// mem emits the load or store op, such as ldr or strb, of reg at base plus
+// off, accessing size bytes. Small offsets use the unscaled form, such as
+// ldur, and offsets that fit neither form go through x17. base must not be
+// x17.
+func (g *aarch64) mem(op, reg, base string, off, size int) {
+	switch {
+	case off >= -256 && off < 256 && (off < 0 || off%size != 0):
+		g.emit(fmt.Sprintf("  %su%s %s, [%s, %d]", op[:2], op[2:], reg, base, off))
+	case off >= 0 && off%size == 0 && off/size < 4096:
+		g.emit(fmt.Sprintf("  %s %s, [%s, %d]", op, reg, base, off))
+	default:
+		g.addImm("x17", base, int64(off))
+		g.emit(fmt.Sprintf("  %s %s, [x17]", op, reg))
+	}
+}
+
+// loc returns the machine register of r, or "" if it lives in its home.
+func (g *aarch64) loc(r ir.Reg) string {
+	return g.alloc.regs[r]
+}
+
+// loadReg loads the value of r into reg.
+func (g *aarch64) loadReg(reg string, r ir.Reg) {
+	switch src := g.loc(r); src {
+	case reg:
+	case "":
+		g.mem("ldr", reg, "x29", -g.alloc.homes[r], 8)
+	default:
+		g.emit(fmt.Sprintf("  mov %s, %s", reg, src))
+	}
+}
+
+// storeReg stores reg as the value of r.
+func (g *aarch64) storeReg(r ir.Reg, reg string) {
+	switch dst := g.loc(r); dst {
+	case reg:
+	case "":
+		g.mem("str", reg, "x29", -g.alloc.homes[r], 8)
+	default:
+		g.emit(fmt.Sprintf("  mov %s, %s", dst, reg))
+	}
+}
+
+// blockLabel returns the label of the block b of the current function.
+func (g *aarch64) blockLabel(b *ir.Block) string {
+	return fmt.Sprintf(".L.%s.%d", g.fn.Name, b.ID)
+}
+
+func (g *aarch64) emitFunction(fn *ir.Func) error {
+	g.fn = fn
+
+	if !fn.IsStatic {
+		g.emit(fmt.Sprintf(".global %s", fn.Name))
+	}
+	g.emit(".p2align 2")
+	g.emit(fmt.Sprintf("%s:", fn.Name))
+
+	g.alloc = allocate(fn, aarch64Regs)
+
+	// sp must stay aligned to 16 bytes
+	frame := (fn.FrameSize + g.alloc.size + 15) / 16 * 16
+	g.emit("  stp x29, x30, [sp, -16]!")
+	g.emit("  mov x29, sp")
+	if frame > 0 {
+		g.addImm("sp", "sp", int64(-frame))
+	}
+	for _, reg := range g.alloc.order {
+		g.mem("str", reg, "x29", -g.alloc.saved[reg], 8)
+	}
+
+	gp, fp, stack := g.storeParams(fn)
+	if fn.VaArea != nil {
+		g.saveVaArea(fn.VaArea.Offset, gp, fp, stack)
+	}
+	g.stackArg = stack
+
+	for _, b := range fn.Blocks {
+		g.emit(g.blockLabel(b) + ":")
+		for _, in := range b.Insts {
+			// the callee of a tail call returns in place of the Ret
+			// after it
+			if in.Tail && g.emitTailCall(in) {
+				break
+			}
+			if err := g.emitInst(in); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// storeParams copies the arguments from the argument registers, or from the
+// caller's frame if they were passed on the stack, to the parameter
+// registers, through x16 so that the argument registers are kept for
+// saveVaArea. The upper bits of narrow arguments are unspecified, so they
+// are extended.
+func (g *aarch64) storeParams(fn *ir.Func) (gp, fp, stack int) {
+	for _, param := range fn.Params {
+		ty := fn.Regs[param]
+		switch {
+		case ty.IsFloat() && fp < floatArgRegsAArch64:
+			if ty == ir.F32 {
+				g.emit(fmt.Sprintf("  fmov w16, s%d", fp))
+			} else {
+				g.emit(fmt.Sprintf("  fmov x16, d%d", fp))
+			}
+			fp++
+		case !ty.IsFloat() && gp < len(argRegsAArch64):
+			g.emit(fmt.Sprintf("  mov x16, %s", argRegsAArch64[gp]))
+			g.extend("x16", ty)
+			gp++
+		default:
+			// the saved x29 and x30 lie between x29 and the arguments
+			g.addImm("x16", "x29", int64(16+8*stack))
+			g.load("x16", ty)
+			stack++
+		}
+		g.storeReg(param, "x16")
+	}
+	return gp, fp, stack
+}
+
+// load replaces the address in reg with the value of type ty it points to,
+// extended to 64 bits as the x86-64 load does.
+func (g *aarch64) load(reg string, ty ir.Type) {
+	w := wreg(reg)
+	switch ty {
+	case ir.U8:
+		g.emit(fmt.Sprintf("  ldrb %s, [%s]", w, reg))
+	case ir.I8:
+		g.emit(fmt.Sprintf("  ldrsb %s, [%s]", reg, reg))
+	case ir.U16:
+		g.emit(fmt.Sprintf("  ldrh %s, [%s]", w, reg))
+	case ir.I16:
+		g.emit(fmt.Sprintf("  ldrsh %s, [%s]", reg, reg))
+	case ir.U32, ir.F32:
+		g.emit(fmt.Sprintf("  ldr %s, [%s]", w, reg))
+	case ir.I32:
+		g.emit(fmt.Sprintf("  ldrsw %s, [%s]", reg, reg))
+	default:
+		g.emit(fmt.Sprintf("  ldr %s, [%s]", reg, reg))
+	}
+}
+
+// store writes the value of type ty in x1 to the address in x0.
+func (g *aarch64) store(ty ir.Type) {
+	switch ty.Size() {
+	case 1:
+		g.emit("  strb w1, [x0]")
+	case 2:
+		g.emit("  strh w1, [x0]")
+	case 4:
+		g.emit("  str w1, [x0]")
+	default:
+		g.emit("  str x1, [x0]")
+	}
+}
+
+// extend truncates the integer in reg to the size of ty and sign or zero
+// extends it back to 64 bits.
+func (g *aarch64) extend(reg string, ty ir.Type) {
+	w := wreg(reg)
+	switch ty {
+	case ir.U8:
+		g.emit(fmt.Sprintf("  uxtb %s, %s", w, w))
+	case ir.I8:
+		g.emit(fmt.Sprintf("  sxtb %s, %s", reg, w))
+	case ir.U16:
+		g.emit(fmt.Sprintf("  uxth %s, %s", w, w))
+	case ir.I16:
+		g.emit(fmt.Sprintf("  sxth %s, %s", reg, w))
+	case ir.U32:
+		g.emit(fmt.Sprintf("  mov %s, %s", w, w))
+	case ir.I32:
+		g.emit(fmt.Sprintf("  sxtw %s, %s", reg, w))
+	}
+}
+
+// fmovTo moves the floating value of type ty in the integer register reg to
+// the vector register v, as d<v> or s<v>.
+func (g *aarch64) fmovTo(v int, reg string, ty ir.Type) {
+	if ty == ir.F32 {
+		g.emit(fmt.Sprintf("  fmov s%d, %s", v, wreg(reg)))
+	} else {
+		g.emit(fmt.Sprintf("  fmov d%d, %s", v, reg))
+	}
+}
+
+// fmovFrom moves the floating value of type ty in d<v> or s<v> to reg.
+func (g *aarch64) fmovFrom(reg string, v int, ty ir.Type) {
+	if ty == ir.F32 {
+		g.emit(fmt.Sprintf("  fmov %s, s%d", wreg(reg), v))
+	} else {
+		g.emit(fmt.Sprintf("  fmov %s, d%d", reg, v))
+	}
+}
+
+// sizeSuffixAArch64 gives the suffix of ldr and str accessing 4, 2 and 1
+// bytes of a 32-bit register.
+var sizeSuffixAArch64 = map[int]string{4: "", 2: "h", 1: "b"}
+
+// fpReg returns the name of the vector register v holding a value of ty.
+func fpReg(v int, ty ir.Type) string {
+	if ty == ir.F32 {
+		return fmt.Sprintf("s%d", v)
+	}
+	return fmt.Sprintf("d%d", v)
+}
+
+func (g *aarch64) emitInst(in *ir.Inst) error {
+	switch in.Op {
+	case ir.Const:
+		val := in.Imm
+		switch in.Ty {
+		case ir.F32:
+			val = int64(math.Float32bits(float32(in.FImm)))
+		case ir.F64:
+			val = int64(math.Float64bits(in.FImm))
+		}
+		if reg := g.loc(in.Dst); reg != "" {
+			g.loadImm(reg, val)
+			return nil
+		}
+		g.loadImm("x0", val)
+		g.storeReg(in.Dst, "x0")
+	case ir.Mov:
+		switch {
+		case g.loc(in.Dst) != "":
+			g.loadReg(g.loc(in.Dst), in.Args[0])
+		case g.loc(in.Args[0]) != "":
+			g.storeReg(in.Dst, g.loc(in.Args[0]))
+		default:
+			g.loadReg("x0", in.Args[0])
+			g.storeReg(in.Dst, "x0")
+		}
+	case ir.Add, ir.Sub, ir.Mul, ir.Div, ir.Shl, ir.Shr, ir.And, ir.Or:
+		g.loadReg("x0", in.Args[0])
+		g.loadReg("x1", in.Args[1])
+		if in.Ty.IsFloat() {
+			g.emitFloatBinary(in)
+		} else {
+			g.emitIntBinary(in)
+		}
+		g.storeReg(in.Dst, "x0")
+	case ir.Eq, ir.Ne, ir.Lt, ir.Le:
+		g.loadReg("x0", in.Args[0])
+		g.loadReg("x1", in.Args[1])
+		g.emitCompare(in)
+		g.storeReg(in.Dst, "x0")
+	case ir.Conv:
+		g.loadReg("x0", in.Args[0])
+		g.emitConv(g.fn.Regs[in.Args[0]], in.Ty)
+		g.storeReg(in.Dst, "x0")
+	case ir.SlotAddr:
+		g.addImm("x0", "x29", in.Imm-int64(in.Slot.Offset))
+		g.storeReg(in.Dst, "x0")
+	case ir.Global:
+		sym := in.Sym
+		if in.Imm != 0 {
+			sym = fmt.Sprintf("%s%+d", in.Sym, in.Imm)
+		}
+		if g.defined[in.Sym] {
+			g.emit(fmt.Sprintf("  adrp x0, %s", sym))
+			g.emit(fmt.Sprintf("  add x0, x0, :lo12:%s", sym))
+		} else {
+			// defined in another file, possibly a shared library
+			g.emit(fmt.Sprintf("  adrp x0, :got:%s", in.Sym))
+			g.emit(fmt.Sprintf("  ldr x0, [x0, :got_lo12:%s]", in.Sym))
+			if in.Imm != 0 {
+				g.addImm("x0", "x0", in.Imm)
+			}
+		}
+		g.storeReg(in.Dst, "x0")
+	case ir.Load:
+		g.loadReg("x0", in.Args[0])
+		g.load("x0", in.Ty)
+		g.storeReg(in.Dst, "x0")
+	case ir.Store:
+		g.loadReg("x0", in.Args[0])
+		g.loadReg("x1", in.Args[1])
+		g.store(in.Ty)
+	case ir.MemCopy:
+		g.loadReg("x0", in.Args[0])
+		g.loadReg("x1", in.Args[1])
+		// copy 8 bytes at a time in a loop, then the rest, advancing both
+		// addresses
+		n := int(in.Imm)
+		if n >= 8 {
+			label := g.newLabel()
+			g.loadImm("x3", int64(n/8))
+			g.emit(fmt.Sprintf(".Lcopy%d:", label))
+			g.emit("  ldr x2, [x1], 8")
+			g.emit("  str x2, [x0], 8")
+			g.emit("  subs x3, x3, 1")
+			g.emit(fmt.Sprintf("  b.ne .Lcopy%d", label))
+			n %= 8
+		}
+		for _, size := range []int{4, 2, 1} {
+			for ; n >= size; n -= size {
+				g.emit(fmt.Sprintf("  ldr%s w2, [x1], %d", sizeSuffixAArch64[size], size))
+				g.emit(fmt.Sprintf("  str%s w2, [x0], %d", sizeSuffixAArch64[size], size))
+			}
+		}
+	case ir.MemZero:
+		g.loadReg("x0", in.Args[0])
+		n := int(in.Imm)
+		if n >= 8 {
+			label := g.newLabel()
+			g.loadImm("x1", int64(n/8))
+			g.emit(fmt.Sprintf(".Lzero%d:", label))
+			g.emit("  str xzr, [x0], 8")
+			g.emit("  subs x1, x1, 1")
+			g.emit(fmt.Sprintf("  b.ne .Lzero%d", label))
+			n %= 8
+		}
+		for _, size := range []int{4, 2, 1} {
+			for ; n >= size; n -= size {
+				g.emit(fmt.Sprintf("  str%s wzr, [x0], %d", sizeSuffixAArch64[size], size))
+			}
+		}
+	case ir.Alloca:
+		// Allocate below everything else on the stack. Rounding the size
+		// keeps sp aligned. The epilogue restores sp from x29, which frees
+		// the memory, unless a SetSP frees it earlier.
+		g.loadReg("x0", in.Args[0])
+		g.emit("  add x0, x0, 15")
+		g.emit("  and x0, x0, -16")
+		g.emit("  sub sp, sp, x0")
+		g.emit("  mov x0, sp")
+		g.storeReg(in.Dst, "x0")
+	case ir.GetSP:
+		g.emit("  mov x0, sp")
+		g.storeReg(in.Dst, "x0")
+	case ir.SetSP:
+		g.loadReg("x0", in.Args[0])
+		g.emit("  mov sp, x0")
+	case ir.Call:
+		g.emitCall(in)
+	case ir.VaStart:
+		g.emitVaStart(in)
+	case ir.VaArg:
+		g.emitVaArg(in)
+	case ir.Asm:
+		return g.emitAsm(in)
+	case ir.Jmp:
+		g.emit(fmt.Sprintf("  b %s", g.blockLabel(in.Targets[0])))
+	case ir.Br:
+		g.loadReg("x0", in.Args[0])
+		g.emit(fmt.Sprintf("  cbnz x0, %s", g.blockLabel(in.Targets[0])))
+		g.emit(fmt.Sprintf("  b %s", g.blockLabel(in.Targets[1])))
+	case ir.Ret:
+		if len(in.Args) > 0 {
+			g.loadReg("x0", in.Args[0])
+			if g.fn.RetTy.IsFloat() {
+				g.fmovTo(0, "x0", g.fn.RetTy)
+			}
+		}
+		g.emitEpilogue()
+		g.emit("  ret")
+	default:
+		return fmt.Errorf("unsupported instruction %s", in)
+	}
+	return nil
+}
+
+// emitIntBinary applies a binary operator to the integers in x0 and x1,
+// leaving the result in x0. The shifts take the count modulo 64, as on
+// x86-64.
+func (g *aarch64) emitIntBinary(in *ir.Inst) {
+	op := map[ir.Op]string{ir.Add: "add", ir.Sub: "sub", ir.Mul: "mul", ir.Shl: "lsl", ir.And: "and", ir.Or: "orr"}[in.Op]
+	switch {
+	case in.Op == ir.Div && in.Ty.IsSigned():
+		op = "sdiv"
+	case in.Op == ir.Div:
+		op = "udiv"
+	case in.Op == ir.Shr && in.Ty.IsSigned():
+		op = "asr"
+	case in.Op == ir.Shr:
+		op = "lsr"
+	}
+	g.emit(fmt.Sprintf("  %s x0, x0, x1", op))
+	g.extend("x0", in.Ty)
+}
+
+// emitFloatBinary applies a binary operator to the floating values in x0
+// and x1, leaving the result in x0.
+func (g *aarch64) emitFloatBinary(in *ir.Inst) {
+	g.fmovTo(0, "x0", in.Ty)
+	g.fmovTo(1, "x1", in.Ty)
+	op := map[ir.Op]string{ir.Add: "fadd", ir.Sub: "fsub", ir.Mul: "fmul", ir.Div: "fdiv"}[in.Op]
+	g.emit(fmt.Sprintf("  %s %s, %s, %s", op, fpReg(0, in.Ty), fpReg(0, in.Ty), fpReg(1, in.Ty)))
+	g.fmovFrom("x0", 0, in.Ty)
+}
+
+// emitCompare compares the values of type in.Ty in x0 and x1, leaving 1 in
+// x0 if the comparison holds and 0 otherwise. After fcmp, an unordered
+// result, with a NaN, only satisfies ne among the conditions used.
+func (g *aarch64) emitCompare(in *ir.Inst) {
+	var cond string
+	if in.Ty.IsFloat() {
+		g.fmovTo(0, "x0", in.Ty)
+		g.fmovTo(1, "x1", in.Ty)
+		g.emit(fmt.Sprintf("  fcmp %s, %s", fpReg(0, in.Ty), fpReg(1, in.Ty)))
+		cond = map[ir.Op]string{ir.Eq: "eq", ir.Ne: "ne", ir.Lt: "mi", ir.Le: "ls"}[in.Op]
+	} else {
+		g.emit("  cmp x0, x1")
+		cond = map[ir.Op]string{ir.Eq: "eq", ir.Ne: "ne", ir.Lt: "lo", ir.Le: "ls"}[in.Op]
+		if in.Ty.IsSigned() {
+			cond = map[ir.Op]string{ir.Eq: "eq", ir.Ne: "ne", ir.Lt: "lt", ir.Le: "le"}[in.Op]
+		}
+	}
+	g.emit(fmt.Sprintf("  cset x0, %s", cond))
+}
+
+// emitConv converts the value in x0 from one type to another. Integers
+// narrower than 64 bits are already extended, so only unsigned 64-bit
+// values need the unsigned conversions.
+func (g *aarch64) emitConv(from, to ir.Type) {
+	switch {
+	case from.IsFloat() && to.IsFloat():
+		if from == to {
+			return
+		}
+		g.fmovTo(0, "x0", from)
+		g.emit(fmt.Sprintf("  fcvt %s, %s", fpReg(0, to), fpReg(0, from)))
+		g.fmovFrom("x0", 0, to)
+	case from.IsFloat():
+		g.fmovTo(0, "x0", from)
+		if to == ir.U64 {
+			g.emit(fmt.Sprintf("  fcvtzu x0, %s", fpReg(0, from)))
+			return
+		}
+		g.emit(fmt.Sprintf("  fcvtzs x0, %s", fpReg(0, from)))
+		g.extend("x0", to)
+	case to.IsFloat():
+		if from == ir.U64 {
+			g.emit(fmt.Sprintf("  ucvtf %s, x0", fpReg(0, to)))
+		} else {
+			g.emit(fmt.Sprintf("  scvtf %s, x0", fpReg(0, to)))
+		}
+		g.fmovFrom("x0", 0, to)
+	default:
+		g.extend("x0", to)
+	}
+}
+
+// emitEpilogue restores the callee-saved registers and removes the frame.
+func (g *aarch64) emitEpilogue() {
+	for _, reg := range g.alloc.order {
+		g.mem("ldr", reg, "x29", -g.alloc.saved[reg], 8)
+	}
+	g.emit("  mov sp, x29")
+	g.emit("  ldp x29, x30, [sp], 16")
+}
+
+// callArgs returns the arguments of the call in, which of them are passed
+// on the stack, and how many.
+func (g *aarch64) callArgs(in *ir.Inst) (args []ir.Reg, onStack []bool, stackArgs int) {
+	args = in.Args
+	if in.Sym == "" {
+		args = args[1:]
+	}
+
+	onStack = make([]bool, len(args))
+	gp, fp := 0, 0
+	for i, arg := range args {
+		switch {
+		case g.fn.Regs[arg].IsFloat() && fp < floatArgRegsAArch64:
+			fp++
+		case !g.fn.Regs[arg].IsFloat() && gp < len(argRegsAArch64):
+			gp++
+		default:
+			onStack[i] = true
+			stackArgs++
+		}
+	}
+	return args, onStack, stackArgs
+}
+
+// loadRegArgs loads the arguments that are not passed on the stack into
+// their registers. The floating ones go through x16.
+func (g *aarch64) loadRegArgs(args []ir.Reg, onStack []bool) {
+	gp, fp := 0, 0
+	for i, arg := range args {
+		if onStack[i] {
+			continue
+		}
+		if ty := g.fn.Regs[arg]; ty.IsFloat() {
+			g.loadReg("x16", arg)
+			g.fmovTo(fp, "x16", ty)
+			fp++
+		} else {
+			g.loadReg(argRegsAArch64[gp], arg)
+			gp++
+		}
+	}
+}
+
+// emitCall calls a function following the AAPCS64. Integer arguments go in
+// x0-x7, floating arguments in v0-v7, and the rest in 8-byte slots on the
+// stack, in order from sp. Variadic arguments are passed the same way.
+func (g *aarch64) emitCall(in *ir.Inst) {
+	args, onStack, stackArgs := g.callArgs(in)
+
+	// sp must stay 16-byte aligned
+	size := (8*stackArgs + 15) / 16 * 16
+	if size > 0 {
+		g.addImm("sp", "sp", int64(-size))
+	}
+	slot := 0
+	for i, arg := range args {
+		if onStack[i] {
+			g.loadReg("x0", arg)
+			g.mem("str", "x0", "sp", 8*slot, 8)
+			slot++
+		}
+	}
+
+	g.loadRegArgs(args, onStack)
+	if in.Sym == "" {
+		g.loadReg("x16", in.Args[0])
+		g.emit("  blr x16")
+	} else {
+		g.emit(fmt.Sprintf("  bl %s", in.Sym))
+	}
+	if size > 0 {
+		g.addImm("sp", "sp", int64(size))
+	}
+
+	if in.Ty == ir.Void {
+		return
+	}
+	// the upper bits of narrow return values are unspecified
+	if in.Ty.IsFloat() {
+		g.fmovFrom("x0", 0, in.Ty)
+	} else {
+		g.extend("x0", in.Ty)
+	}
+	g.storeReg(in.Dst, "x0")
+}
+
+// emitTailCall emits the tail call in as a branch to the callee after
+// removing the frame, so that the callee returns to the caller of the
+// function. The stack arguments are stored over those of the function,
+// so it returns false, emitting nothing, if there are more of them.
+func (g *aarch64) emitTailCall(in *ir.Inst) bool {
+	args, onStack, stackArgs := g.callArgs(in)
+	if stackArgs > g.stackArg {
+		return false
+	}
+
+	// the arguments of the function were copied to their registers on
+	// entry, so their places can be overwritten
+	slot := 0
+	for i, arg := range args {
+		if onStack[i] {
+			g.loadReg("x0", arg)
+			g.mem("str", "x0", "x29", 16+8*slot, 8)
+			slot++
+		}
+	}
+	g.loadRegArgs(args, onStack)
+	if in.Sym == "" {
+		g.loadReg("x16", in.Args[0])
+	}
+	g.emitEpilogue()
+	if in.Sym == "" {
+		g.emit("  br x16")
+	} else {
+		g.emit(fmt.Sprintf("  b %s", in.Sym))
+	}
+	return true
+}
+
+// Layout of the register save area of a variadic function: a va_list
+// element followed by the registers that may hold arguments, as the
+// AAPCS64 specifies for va_start.
+const (
+	vaStackAArch64    = 0  // address of the next stack argument
+	vaGrTopAArch64    = 8  // end of the saved integer registers
+	vaVrTopAArch64    = 16 // end of the saved vector registers
+	vaGrOffsAArch64   = 24 // offset of the next integer register argument from the end
+	vaVrOffsAArch64   = 28 // offset of the next vector register argument from the end
+	vaHeaderAArch64   = 32
+	vaGrSizeAArch64   = 8 * 8  // x0-x7
+	vaVrSizeAArch64   = 16 * 8 // q0-q7
+	vaAreaSizeAArch64 = vaHeaderAArch64 + vaGrSizeAArch64 + vaVrSizeAArch64
+)
+
+// saveVaArea saves the argument registers of a variadic function and sets
+// up the va_list element that va_start copies. gp, fp and stack are the
+// numbers of named parameters passed in each place. The offsets count up
+// to 0 from minus the size of the registers left.
+func (g *aarch64) saveVaArea(offset, gp, fp, stack int) {
+	g.addImm("x16", "x29", int64(-offset))
+	for i, reg := range argRegsAArch64 {
+		g.mem("str", reg, "x16", vaHeaderAArch64+8*i, 8)
+	}
+	for i := 0; i < floatArgRegsAArch64; i++ {
+		g.emit(fmt.Sprintf("  str q%d, [x16, %d]", i, vaHeaderAArch64+vaGrSizeAArch64+16*i))
+	}
+
+	g.addImm("x17", "x29", int64(16+8*stack))
+	g.emit(fmt.Sprintf("  str x17, [x16, %d]", vaStackAArch64))
+	g.emit(fmt.Sprintf("  add x17, x16, %d", vaHeaderAArch64+vaGrSizeAArch64))
+	g.emit(fmt.Sprintf("  str x17, [x16, %d]", vaGrTopAArch64))
+	g.emit(fmt.Sprintf("  add x17, x16, %d", vaAreaSizeAArch64))
+	g.emit(fmt.Sprintf("  str x17, [x16, %d]", vaVrTopAArch64))
+	g.loadImm("x17", int64(-(len(argRegsAArch64)-gp)*8))
+	g.emit(fmt.Sprintf("  str w17, [x16, %d]", vaGrOffsAArch64))
+	g.loadImm("x17", int64(-(floatArgRegsAArch64-fp)*16))
+	g.emit(fmt.Sprintf("  str w17, [x16, %d]", vaVrOffsAArch64))
+}
+
+// emitVaStart copies the va_list element set up by saveVaArea to the
+// va_list operand of va_start.
+func (g *aarch64) emitVaStart(in *ir.Inst) {
+	g.loadReg("x0", in.Args[0])
+	g.addImm("x1", "x29", int64(-g.fn.VaArea.Offset))
+	for i := 0; i < vaHeaderAArch64; i += 8 {
+		g.emit(fmt.Sprintf("  ldr x2, [x1, %d]", i))
+		g.emit(fmt.Sprintf("  str x2, [x0, %d]", i))
+	}
+}
+
+// emitVaArg computes the address of the next argument of a va_list, in the
+// saved registers while the offset of its class is negative, otherwise on
+// the stack.
+func (g *aarch64) emitVaArg(in *ir.Inst) {
+	g.loadReg("x1", in.Args[0])
+
+	// integers are in x0-x7, floating-point values in v0-v7
+	offs, top, step := vaGrOffsAArch64, vaGrTopAArch64, 8
+	if in.Ty.IsFloat() {
+		offs, top, step = vaVrOffsAArch64, vaVrTopAArch64, 16
+	}
+
+	label := g.newLabel()
+	g.emit(fmt.Sprintf("  ldrsw x0, [x1, %d]", offs))
+	g.emit(fmt.Sprintf("  tbz x0, 63, .Lva_stack%d", label))
+	g.emit(fmt.Sprintf("  add w2, w0, %d", step))
+	g.emit(fmt.Sprintf("  str w2, [x1, %d]", offs))
+	g.emit(fmt.Sprintf("  ldr x2, [x1, %d]", top))
+	g.emit("  add x0, x2, x0")
+	g.emit(fmt.Sprintf("  b .Lva_end%d", label))
+	g.emit(fmt.Sprintf(".Lva_stack%d:", label))
+	g.emit(fmt.Sprintf("  ldr x0, [x1, %d]", vaStackAArch64))
+	g.emit("  add x2, x0, 8")
+	g.emit(fmt.Sprintf("  str x2, [x1, %d]", vaStackAArch64))
+	g.emit(fmt.Sprintf(".Lva_end%d:", label))
+	g.storeReg(in.Dst, "x0")
+}
+
+// asmRegsAArch64 are the registers given to register operands of inline
+// assembly, in order. No value is kept in them across an asm statement,
+// see allocate, so they are free for the statement.
+var asmRegsAArch64 = []string{
+	"x0", "x1", "x2", "x3", "x4", "x5", "x6", "x7",
+	"x8", "x9", "x10", "x11", "x12", "x13", "x14", "x15",
+}
+
+// emitAsm emits an inline assembly statement as the x86-64 backend does.
+// Clobbered callee-saved registers, x19-x28 and d8-d15, are preserved
+// around the statement.
+func (g *aarch64) emitAsm(in *ir.Inst) error {
+	asm := in.Asm
+	operands := append(append([]*parser.AsmOperand{}, asm.Outputs...), asm.Inputs...)
+
+	clobbered := map[string]bool{}
+	for _, reg := range asm.Clobbers {
+		clobbered[reg] = true
+	}
+	var free []string
+	for _, reg := range asmRegsAArch64 {
+		if !clobbered[reg] {
+			free = append(free, reg)
+		}
+	}
+	regs := make([]string, len(operands))
+	for i, op := range operands {
+		if op.Constraint == parser.ASM_IMM {
+			continue
+		}
+		if len(free) == 0 {
+			return fmt.Errorf("asm operands need more registers than are available")
+		}
+		regs[i], free = free[0], free[1:]
+	}
+
+	var saved []string
+	for _, reg := range asm.Clobbers {
+		if slices.Contains(aarch64Regs.calleeSaved, reg) || reg[0] == 'd' {
+			g.emit(fmt.Sprintf("  str %s, [sp, -16]!", reg))
+			saved = append(saved, reg)
+		}
+	}
+
+	// the operands of in hold the values of register inputs and the
+	// addresses of the others
+	for i, op := range operands {
+		if op.Constraint == parser.ASM_IMM {
+			continue
+		}
+		g.loadReg(regs[i], in.Args[i])
+		if i < len(asm.Outputs) && op.Constraint == parser.ASM_REG && op.InOut {
+			g.loadAsmReg(regs[i], op.Expr.Ty)
+		}
+	}
+
+	var sb strings.Builder
+	for _, piece := range asm.Template {
+		if piece.Operand < 0 {
+			sb.WriteString(piece.Text)
+		} else {
+			sb.WriteString(asmOperandTextAArch64(operands[piece.Operand], regs[piece.Operand], piece.Modifier))
+		}
+	}
+	g.emit("#APP")
+	for _, line := range strings.Split(sb.String(), "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			g.emit("  " + line)
+		}
+	}
+	g.emit("#NO_APP")
+
+	// The addresses of the outputs are not in the asm registers, so each
+	// output is stored through x17 without disturbing the others.
+	for i, op := range asm.Outputs {
+		if op.Constraint != parser.ASM_REG {
+			continue
+		}
+		g.loadReg("x17", in.Args[i])
+		switch op.Expr.Ty.Size {
+		case 1:
+			g.emit(fmt.Sprintf("  strb %s, [x17]", wreg(regs[i])))
+		case 2:
+			g.emit(fmt.Sprintf("  strh %s, [x17]", wreg(regs[i])))
+		case 4:
+			g.emit(fmt.Sprintf("  str %s, [x17]", wreg(regs[i])))
+		default:
+			g.emit(fmt.Sprintf("  str %s, [x17]", regs[i]))
+		}
+	}
+	for i := len(saved) - 1; i >= 0; i-- {
+		g.emit(fmt.Sprintf("  ldr %s, [sp], 16", saved[i]))
+	}
+	return nil
+}
+
+// loadAsmReg replaces the address in reg with the value of type ty it
+// points to.
+func (g *aarch64) loadAsmReg(reg string, ty *parser.Type) {
+	switch ty.Size {
+	case 1:
+		g.emit(fmt.Sprintf("  ldrb %s, [%s]", wreg(reg), reg))
+	case 2:
+		g.emit(fmt.Sprintf("  ldrh %s, [%s]", wreg(reg), reg))
+	case 4:
+		g.emit(fmt.Sprintf("  ldr %s, [%s]", wreg(reg), reg))
+	default:
+		g.emit(fmt.Sprintf("  ldr %s, [%s]", reg, reg))
+	}
+}
+
+// asmOperandTextAArch64 returns how the operand op, given the register
+// reg, is written in an asm template. Register operands are named by their
+// 32-bit names if they fit, unless the modifier asks otherwise.
+func asmOperandTextAArch64(op *parser.AsmOperand, reg string, modifier byte) string {
+	switch op.Constraint {
+	case parser.ASM_IMM:
+		return fmt.Sprintf("%d", op.Val)
+	case parser.ASM_MEM:
+		return fmt.Sprintf("[%s]", reg)
+	}
+
+	switch {
+	case modifier == 'x':
+		return reg
+	case modifier == 'w' || op.Expr.Ty.Size <= 4:
+		return wreg(reg)
+	}
+	return reg
+}
@@ -0,0 +1,183 @@
+package generator
+
+import (
+	"fmt"
+	"strings"
+
+	"rkitamu/gocc/ir"
+	"rkitamu/gocc/parser"
+)
+
+// asmRegs are the registers given to register operands of inline assembly,
+// in order. No value is kept in them across an asm statement, see
+// allocate, so they are free for the statement.
+var asmRegs = []string{"rax", "rcx", "rdx", "rsi", "rdi", "r8", "r9", "r10", "r11"}
+
+// asmRegNames gives the names of the asmRegs by operand size: 1, 2, 4 and 8
+// bytes.
+var asmRegNames = map[string][4]string{
+	"rax": {"al", "ax", "eax", "rax"},
+	"rcx": {"cl", "cx", "ecx", "rcx"},
+	"rdx": {"dl", "dx", "edx", "rdx"},
+	"rsi": {"sil", "si", "esi", "rsi"},
+	"rdi": {"dil", "di", "edi", "rdi"},
+	"r8":  {"r8b", "r8w", "r8d", "r8"},
+	"r9":  {"r9b", "r9w", "r9d", "r9"},
+	"r10": {"r10b", "r10w", "r10d", "r10"},
+	"r11": {"r11b", "r11w", "r11d", "r11"},
+}
+
+// calleeSaved are the registers a function must preserve for its caller,
+// except rbp and rsp.
+var calleeSaved = map[string]bool{"rbx": true, "r12": true, "r13": true, "r14": true, "r15": true}
+
+// asmRegName returns the name of the asm register reg for an operand of
+// size bytes.
+func asmRegName(reg string, size int) string {
+	switch size {
+	case 1:
+		return asmRegNames[reg][0]
+	case 2:
+		return asmRegNames[reg][1]
+	case 4:
+		return asmRegNames[reg][2]
+	}
+	return reg
+}
+
+// emitAsm emits an inline assembly statement. Register and memory operands
+// get registers of their own, which hold the value or the address of the
+// operand in the template. Register outputs are stored to their addresses
+// afterwards. Clobbered callee-saved registers are preserved around the
+// statement.
+func (g *Generator) emitAsm(in *ir.Inst) error {
+	asm := in.Asm
+	operands := append(append([]*parser.AsmOperand{}, asm.Outputs...), asm.Inputs...)
+
+	clobbered := map[string]bool{}
+	for _, reg := range asm.Clobbers {
+		clobbered[reg] = true
+	}
+	var free []string
+	for _, reg := range asmRegs {
+		if !clobbered[reg] {
+			free = append(free, reg)
+		}
+	}
+	regs := make([]string, len(operands))
+	for i, op := range operands {
+		if op.Constraint == parser.ASM_IMM {
+			continue
+		}
+		if len(free) == 0 {
+			return fmt.Errorf("asm operands need more registers than are available")
+		}
+		regs[i], free = free[0], free[1:]
+	}
+
+	var saved []string
+	for _, reg := range asm.Clobbers {
+		if calleeSaved[reg] {
+			g.push(reg)
+			saved = append(saved, reg)
+		}
+	}
+
+	// the operands of in hold the values of register inputs and the
+	// addresses of the others
+	for i, op := range operands {
+		if op.Constraint == parser.ASM_IMM {
+			continue
+		}
+		g.loadReg(regs[i], in.Args[i])
+		if i < len(asm.Outputs) && op.Constraint == parser.ASM_REG && op.InOut {
+			g.loadAsmReg(regs[i], op.Expr.Ty)
+		}
+	}
+
+	var sb strings.Builder
+	for _, piece := range asm.Template {
+		if piece.Operand < 0 {
+			sb.WriteString(piece.Text)
+		} else {
+			sb.WriteString(asmOperandText(operands[piece.Operand], regs[piece.Operand], piece.Modifier))
+		}
+	}
+	// the markers keep the peephole pass away from the text
+	g.emit("#APP")
+	for _, line := range strings.Split(sb.String(), "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			g.emit("  " + line)
+		}
+	}
+	g.emit("#NO_APP")
+
+	// Push the register outputs, then store them from the top, through
+	// rax and rdi, which may be among the registers.
+	var outs []int
+	for i, op := range asm.Outputs {
+		if op.Constraint == parser.ASM_REG {
+			g.push(regs[i])
+			outs = append(outs, i)
+		}
+	}
+	for k := len(outs) - 1; k >= 0; k-- {
+		i := outs[k]
+		g.pop("rdi")
+		g.loadReg("rax", in.Args[i])
+		g.emit(fmt.Sprintf("  mov [rax], %s", asmRegName("rdi", asm.Outputs[i].Expr.Ty.Size)))
+	}
+	for i := len(saved) - 1; i >= 0; i-- {
+		g.pop(saved[i])
+	}
+	return nil
+}
+
+// loadAsmReg replaces the address in reg with the value of type ty it
+// points to.
+func (g *Generator) loadAsmReg(reg string, ty *parser.Type) {
+	switch ty.Size {
+	case 1:
+		g.emit(fmt.Sprintf("  movzx %s, byte ptr [%s]", asmRegName(reg, 4), reg))
+	case 2:
+		g.emit(fmt.Sprintf("  movzx %s, word ptr [%s]", asmRegName(reg, 4), reg))
+	case 4:
+		g.emit(fmt.Sprintf("  mov %s, dword ptr [%s]", asmRegName(reg, 4), reg))
+	default:
+		g.emit(fmt.Sprintf("  mov %s, [%s]", reg, reg))
+	}
+}
+
+// asmOperandText returns how the operand op, given the register reg, is
+// written in an asm template.
+func asmOperandText(op *parser.AsmOperand, reg string, modifier byte) string {
+	switch op.Constraint {
+	case parser.ASM_IMM:
+		return fmt.Sprintf("%d", op.Val)
+	case parser.ASM_MEM:
+		switch op.Expr.Ty.Size {
+		case 1:
+			return fmt.Sprintf("byte ptr [%s]", reg)
+		case 2:
+			return fmt.Sprintf("word ptr [%s]", reg)
+		case 4:
+			return fmt.Sprintf("dword ptr [%s]", reg)
+		case 8:
+			return fmt.Sprintf("qword ptr [%s]", reg)
+		}
+		return fmt.Sprintf("[%s]", reg)
+	}
+
+	size := op.Expr.Ty.Size
+	switch modifier {
+	case 'b':
+		size = 1
+	case 'w':
+		size = 2
+	case 'k':
+		size = 4
+	case 'q':
+		size = 8
+	}
+	return asmRegName(reg, size)
+}
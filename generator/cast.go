@@ -0,0 +1,127 @@
+package generator
+
+import (
+	"fmt"
+
+	"rkitamu/gocc/ir"
+)
+
+// emitConv converts the value in rax from one type to another.
+func (g *Generator) emitConv(from, to ir.Type) {
+	switch {
+	case from.IsFloat() && to.IsFloat():
+		if from == to {
+			return
+		}
+		if from == ir.F32 {
+			g.emit("  movq xmm0, rax")
+			g.emit("  cvtss2sd xmm0, xmm0")
+			g.emit("  movq rax, xmm0")
+		} else {
+			g.emit("  movq xmm0, rax")
+			g.emit("  cvtsd2ss xmm0, xmm0")
+			g.emit("  movd eax, xmm0")
+		}
+	case from.IsFloat():
+		g.emitFloatToInt(from, to)
+	case to.IsFloat():
+		g.emitIntToFloat(from, to)
+	default:
+		g.extend(to)
+	}
+}
+
+// extend truncates the integer in rax to the size of ty and sign or zero
+// extends it back to 64 bits. Integers are always kept in this form, so
+// converting between integer types never needs to know the source type.
+func (g *Generator) extend(ty ir.Type) {
+	switch ty {
+	case ir.U8:
+		g.emit("  movzx eax, al")
+	case ir.I8:
+		g.emit("  movsx rax, al")
+	case ir.U16:
+		g.emit("  movzx eax, ax")
+	case ir.I16:
+		g.emit("  movsx rax, ax")
+	case ir.U32:
+		g.emit("  mov eax, eax")
+	case ir.I32:
+		g.emit("  movsxd rax, eax")
+	}
+}
+
+func (g *Generator) emitIntToFloat(from, to ir.Type) {
+	sfx := floatSuffix(to)
+
+	if from == ir.U64 {
+		// cvtsi2s* only converts signed values. Values with the top bit
+		// set are halved, keeping the lowest bit for correct rounding,
+		// then converted and doubled.
+		label := g.newLabel()
+		g.emit("  test rax, rax")
+		g.emit(fmt.Sprintf("  js .Lcast%d", label))
+		g.emit(fmt.Sprintf("  cvtsi2%s xmm0, rax", sfx))
+		g.emit(fmt.Sprintf("  jmp .Lcastend%d", label))
+		g.emit(fmt.Sprintf(".Lcast%d:", label))
+		g.emit("  mov rdi, rax")
+		g.emit("  and edi, 1")
+		g.emit("  shr rax")
+		g.emit("  or rax, rdi")
+		g.emit(fmt.Sprintf("  cvtsi2%s xmm0, rax", sfx))
+		g.emit(fmt.Sprintf("  add%s xmm0, xmm0", sfx))
+		g.emit(fmt.Sprintf(".Lcastend%d:", label))
+	} else {
+		// narrower integers are already extended to 64 bits
+		g.emit(fmt.Sprintf("  cvtsi2%s xmm0, rax", sfx))
+	}
+
+	g.moveFromXmm0(to)
+}
+
+func (g *Generator) emitFloatToInt(from, to ir.Type) {
+	sfx := floatSuffix(from)
+	g.emit("  movq xmm0, rax")
+
+	if to == ir.U64 {
+		// cvtts*2si only produces signed values. Values of 2^63 and above
+		// have 2^63 subtracted before the conversion and added back after.
+		label := g.newLabel()
+		if sfx == "ss" {
+			g.emit("  mov eax, 0x5f000000")
+		} else {
+			g.emit("  mov rax, 0x43e0000000000000")
+		}
+		g.emit("  movq xmm1, rax")
+		g.emit(fmt.Sprintf("  ucomi%s xmm0, xmm1", sfx))
+		g.emit(fmt.Sprintf("  jae .Lcast%d", label))
+		g.emit(fmt.Sprintf("  cvtt%s2si rax, xmm0", sfx))
+		g.emit(fmt.Sprintf("  jmp .Lcastend%d", label))
+		g.emit(fmt.Sprintf(".Lcast%d:", label))
+		g.emit(fmt.Sprintf("  sub%s xmm0, xmm1", sfx))
+		g.emit(fmt.Sprintf("  cvtt%s2si rax, xmm0", sfx))
+		g.emit("  btc rax, 63")
+		g.emit(fmt.Sprintf(".Lcastend%d:", label))
+		return
+	}
+
+	g.emit(fmt.Sprintf("  cvtt%s2si rax, xmm0", sfx))
+	g.extend(to)
+}
+
+// moveFromXmm0 moves the floating value in xmm0 to rax.
+func (g *Generator) moveFromXmm0(ty ir.Type) {
+	if ty == ir.F32 {
+		g.emit("  movd eax, xmm0")
+	} else {
+		g.emit("  movq rax, xmm0")
+	}
+}
+
+// floatSuffix returns the SSE instruction suffix for a floating type.
+func floatSuffix(ty ir.Type) string {
+	if ty == ir.F32 {
+		return "ss"
+	}
+	return "sd"
+}
@@ -0,0 +1,49 @@
+package generator
+
+import (
+	"fmt"
+
+	"rkitamu/gocc/parser"
+)
+
+// emitData emits the global variables defined in this file. Variables
+// without an initializer are placed in .bss.
+func (w *writer) emitData(globals []*parser.Global) {
+	w.defined = map[string]bool{}
+
+	for _, gv := range globals {
+		if !gv.IsDefinition {
+			continue
+		}
+		w.defined[gv.Name] = true
+
+		if gv.InitData == nil {
+			w.emit(".bss")
+		} else {
+			w.emit(".data")
+		}
+		if !gv.IsStatic {
+			w.emit(fmt.Sprintf(".global %s", gv.Name))
+		}
+		w.emit(fmt.Sprintf(".balign %d", max(gv.Ty.Align, gv.Align)))
+		w.emit(fmt.Sprintf("%s:", gv.Name))
+
+		if gv.InitData == nil {
+			w.emit(fmt.Sprintf("  .zero %d", gv.Ty.Size))
+			continue
+		}
+
+		relocs := map[int]*parser.Reloc{}
+		for _, r := range gv.Relocs {
+			relocs[r.Offset] = r
+		}
+		for i := 0; i < len(gv.InitData); i++ {
+			if r, ok := relocs[i]; ok {
+				w.emit(fmt.Sprintf("  .quad %s%+d", r.Label, r.Addend))
+				i += 7
+				continue
+			}
+			w.emit(fmt.Sprintf("  .byte %d", gv.InitData[i]))
+		}
+	}
+}
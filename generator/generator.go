@@ -2,186 +2,593 @@ package generator
 
 import (
 	"fmt"
+	"math"
+	"rkitamu/gocc/ir"
 	"rkitamu/gocc/parser"
-
-	"strings"
 )
 
+// registers used to pass the first integer arguments
+var argReg64 = []string{"rdi", "rsi", "rdx", "rcx", "r8", "r9"}
+
+// number of floating-point arguments passed in xmm0-xmm7
+const floatArgRegs = 8
+
+// Generator generates x86-64 assembly in Intel syntax for the System V
+// ABI. See Target for the other machines.
 type Generator struct {
-	sb       *strings.Builder
-	labelSeq int
+	writer
+	fn       *ir.Func    // function being generated
+	alloc    *allocation // where the registers of fn live
+	stackArg int         // number of arguments fn receives on the stack
 }
 
-func (g *Generator) newLabel() int {
-	label := g.labelSeq
-	g.labelSeq++
-	return label
+func NewGenerator() *Generator {
+	return &Generator{writer: newWriter()}
 }
 
-func NewGenerator() *Generator {
-	return &Generator{
-		sb:       &strings.Builder{},
-		labelSeq: 0,
+// Generate generates a main function returning the value of the typed
+// expression node.
+func (g *Generator) Generate(node *parser.Node) (string, error) {
+	main := &parser.Function{
+		Name:         "main",
+		Body:         &parser.Node{Kind: parser.RETURN, Lhs: node},
+		IsDefinition: true,
 	}
+	return g.generateFuncs([]*parser.Function{main})
 }
 
-func (g *Generator) Generate(node *parser.Node) (string, error) {
-	g.emit(".intel_syntax noprefix")
-	g.emit(".global main")
-	g.emit("main:")
+func (g *Generator) GenerateForMultiStatement(node []*parser.Node) (string, error) {
+	// 変数26個分の領域を確保
+	main := &parser.Function{
+		Name:         "main",
+		Body:         &parser.Node{Kind: parser.BLOCK, Body: node},
+		StackSize:    208,
+		IsDefinition: true,
+	}
+	return g.generateFuncs([]*parser.Function{main})
+}
 
-	// TODO: now we only support a single expression
-	if err := g.emitExpr(node); err != nil {
+// generateFuncs lowers the function definitions funcs to the IR and
+// generates assembly for them.
+func (g *Generator) generateFuncs(funcs []*parser.Function) (string, error) {
+	prog, err := ir.Lower(funcs, nil)
+	if err != nil {
 		return "", err
 	}
-	g.emit("  pop rax")
-	g.emit("  ret")
-
-	g.emit(".section .note.GNU-stack,\"\",@progbits")
-	return g.sb.String(), nil
+	return g.GenerateProgram(prog)
 }
 
-func (g *Generator) GenerateForMultiStatement(node []*parser.Node) (string, error) {
+// GenerateProgram generates assembly for every global variable and function
+// of prog.
+func (g *Generator) GenerateProgram(prog *ir.Program) (string, error) {
 	g.emit(".intel_syntax noprefix")
-	g.emit(".global main")
-	g.emit("main:")
 
-	// 変数26個分の領域を確保
-	g.emit("  push rbp")
-	g.emit("  mov rbp, rsp")
-	g.emit("  sub rsp, 208")
+	g.emitData(prog.Globals)
+	for _, fn := range prog.Funcs {
+		g.defined[fn.Name] = !fn.IsInlineDef
+	}
 
-	for _, n := range node {
-		err := g.emitExpr(n)
-		if err != nil {
+	g.emit(".text")
+	for _, fn := range prog.Funcs {
+		// another file defines the function of an inline definition
+		if fn.IsInlineDef {
+			continue
+		}
+		if err := g.emitFunction(fn); err != nil {
 			return "", err
 		}
-		g.emit("  pop rax")
 	}
 
 	g.emit(".section .note.GNU-stack,\"\",@progbits")
 	return g.sb.String(), nil
 }
 
-func (g *Generator) emit(line string) {
-	fmt.Fprintln(g.sb, line)
+func (g *Generator) push(operand string) {
+	g.emit("  push " + operand)
 }
 
-func (g *Generator) emitLval(node *parser.Node) error {
-	if node.Kind == parser.LVAR {
-		g.emit("  mov rax, rbp")
-		g.emit(fmt.Sprintf("  sub rax, %d", node.Offset))
-		g.emit("  push rax")
-	} else {
-		return fmt.Errorf("not lval: ")
+func (g *Generator) pop(reg string) {
+	g.emit("  pop " + reg)
+}
+
+// Every virtual register lives in a machine register or, if it was
+// spilled, in an 8-byte home in the frame, see allocate. Instructions load
+// their operands into fixed registers and store their results back.
+
+// loc returns the machine register of r, or the memory operand of its home.
+func (g *Generator) loc(r ir.Reg) string {
+	if reg, ok := g.alloc.regs[r]; ok {
+		return reg
 	}
+	return fmt.Sprintf("[rbp-%d]", g.alloc.homes[r])
+}
 
-	return nil
+// qwordLoc returns loc(r) with the size given for memory, for instructions
+// that cannot tell it from their other operand.
+func (g *Generator) qwordLoc(r ir.Reg) string {
+	if _, ok := g.alloc.regs[r]; ok {
+		return g.loc(r)
+	}
+	return "qword ptr " + g.loc(r)
 }
 
-func (g *Generator) emitExpr(node *parser.Node) error {
-	if node.Kind == parser.NUM {
-		g.emit(fmt.Sprintf("  push %d", node.Val))
-		return nil
-	} else if node.Kind == parser.LVAR {
-		err := g.emitLval(node)
-		if err != nil {
-			return err
-		}
-		g.emit("  pop rax")
-		g.emit("  mov rax, [rax]")
-		g.emit("  push rax")
-		return nil
-	} else if node.Kind == parser.ASSIGN {
-		if err := g.emitLval(node.Lhs); err != nil {
-			return err
-		}
-		if err := g.emitExpr(node.Rhs); err != nil {
-			return err
-		}
+// loadReg loads the value of r into reg.
+func (g *Generator) loadReg(reg string, r ir.Reg) {
+	if src := g.loc(r); src != reg {
+		g.emit(fmt.Sprintf("  mov %s, %s", reg, src))
+	}
+}
 
-		g.emit("  pop rdi")
-		g.emit("  pop rax")
-		g.emit("  mov [rax], rdi")
-		g.emit("  push rdi")
-		return nil
-	} else if node.Kind == parser.RETURN {
-		if err := g.emitExpr(node.Lhs); err != nil {
-			return err
-		}
-		g.emit("  pop rax")
-		g.emit("  mov rsp, rbp")
-		g.emit("  pop rbp")
-		g.emit("  ret")
-		return nil
-	} else if node.Kind == parser.IF {
-		label := g.newLabel()
+// storeReg stores reg as the value of r.
+func (g *Generator) storeReg(r ir.Reg, reg string) {
+	if dst := g.loc(r); dst != reg {
+		g.emit(fmt.Sprintf("  mov %s, %s", dst, reg))
+	}
+}
 
-		// if
-		if err := g.emitExpr(node.Cond); err != nil {
-			return err
-		}
-		g.emit("  pop rax")
-		g.emit("  cmp rax, 0")
-		g.emit(fmt.Sprintf("  je .Lelse%d", label))
+// blockLabel returns the label of the block b of the current function.
+func (g *Generator) blockLabel(b *ir.Block) string {
+	return fmt.Sprintf(".L.%s.%d", g.fn.Name, b.ID)
+}
 
-		// then
-		if err := g.emitExpr(node.Then); err != nil {
-			return err
-		}
-		g.emit(fmt.Sprintf("  jmp .Lend%d", label))
+func (g *Generator) emitFunction(fn *ir.Func) error {
+	g.fn = fn
+
+	if !fn.IsStatic {
+		g.emit(fmt.Sprintf(".global %s", fn.Name))
+	}
+	g.emit(fmt.Sprintf("%s:", fn.Name))
+
+	g.alloc = allocate(fn, x86Regs)
+
+	// keep rsp aligned to 16 bytes as the ABI requires at call sites
+	frame := (fn.FrameSize + g.alloc.size + 15) / 16 * 16
+	g.emit("  push rbp")
+	g.emit("  mov rbp, rsp")
+	g.emit(fmt.Sprintf("  sub rsp, %d", frame))
+	for _, reg := range g.alloc.order {
+		g.emit(fmt.Sprintf("  mov [rbp-%d], %s", g.alloc.saved[reg], reg))
+	}
+
+	gp, fp, stack := g.storeParams(fn)
+	if fn.VaArea != nil {
+		g.saveVaArea(fn.VaArea.Offset, gp, fp, stack)
+	}
+	g.stackArg = stack
 
-		// else (optional)
-		g.emit(fmt.Sprintf(".Lelse%d:", label))
-		if node.Else != nil {
-			if err := g.emitExpr(node.Else); err != nil {
+	for _, b := range fn.Blocks {
+		g.emit(g.blockLabel(b) + ":")
+		for _, in := range b.Insts {
+			// the callee of a tail call returns in place of the Ret
+			// after it
+			if in.Tail && g.emitTailCall(in) {
+				break
+			}
+			if err := g.emitInst(in); err != nil {
 				return err
 			}
 		}
+	}
+	return nil
+}
 
-		g.emit(fmt.Sprintf(".Lend%d:", label))
-		return nil
+// storeParams copies the arguments from the argument registers, or from the
+// caller's frame if they were passed on the stack, to the parameter
+// registers. The upper bits of narrow arguments are unspecified, so they are
+// extended.
+func (g *Generator) storeParams(fn *ir.Func) (gp, fp, stack int) {
+	for _, param := range fn.Params {
+		ty := fn.Regs[param]
+		switch {
+		case ty.IsFloat() && fp < floatArgRegs:
+			if ty == ir.F32 {
+				g.emit(fmt.Sprintf("  movd eax, xmm%d", fp))
+			} else {
+				g.emit(fmt.Sprintf("  movq rax, xmm%d", fp))
+			}
+			fp++
+		case !ty.IsFloat() && gp < len(argReg64):
+			g.emit(fmt.Sprintf("  mov rax, %s", argReg64[gp]))
+			g.extend(ty)
+			gp++
+		default:
+			// the return address and saved rbp lie between rbp and the arguments
+			g.emit(fmt.Sprintf("  lea rax, [rbp+%d]", 16+8*stack))
+			g.load(ty)
+			stack++
+		}
+		g.storeReg(param, "rax")
 	}
+	return gp, fp, stack
+}
 
-	if err := g.emitExpr(node.Lhs); err != nil {
-		return err
+// load replaces the address in rax with the value of type ty it points to.
+// Integers narrower than 8 bytes are sign or zero extended to 64 bits
+// according to their type, so rax always holds the full value. Floating
+// values are kept as their bit pattern, zero extended to 64 bits.
+func (g *Generator) load(ty ir.Type) {
+	switch ty {
+	case ir.U8:
+		g.emit("  movzx eax, byte ptr [rax]")
+	case ir.I8:
+		g.emit("  movsx rax, byte ptr [rax]")
+	case ir.U16:
+		g.emit("  movzx eax, word ptr [rax]")
+	case ir.I16:
+		g.emit("  movsx rax, word ptr [rax]")
+	case ir.U32, ir.F32:
+		g.emit("  mov eax, dword ptr [rax]")
+	case ir.I32:
+		g.emit("  movsxd rax, dword ptr [rax]")
+	default:
+		g.emit("  mov rax, [rax]")
 	}
-	if err := g.emitExpr(node.Rhs); err != nil {
-		return err
+}
+
+// store writes the value of type ty in rdi to the address in rax.
+func (g *Generator) store(ty ir.Type) {
+	switch ty.Size() {
+	case 1:
+		g.emit("  mov [rax], dil")
+	case 2:
+		g.emit("  mov [rax], di")
+	case 4:
+		g.emit("  mov [rax], edi")
+	default:
+		g.emit("  mov [rax], rdi")
 	}
+}
 
-	g.emit("  pop rdi")
-	g.emit("  pop rax")
+func (g *Generator) emitInst(in *ir.Inst) error {
+	switch in.Op {
+	case ir.Const:
+		val := in.Imm
+		switch in.Ty {
+		case ir.F32:
+			val = int64(math.Float32bits(float32(in.FImm)))
+		case ir.F64:
+			val = int64(math.Float64bits(in.FImm))
+		}
+		if _, ok := g.alloc.regs[in.Dst]; ok || val == int64(int32(val)) {
+			g.emit(fmt.Sprintf("  mov %s, %d", g.qwordLoc(in.Dst), val))
+			return nil
+		}
+		// mov only stores a 32-bit immediate to memory
+		g.emit(fmt.Sprintf("  mov rax, %d", val))
+		g.storeReg(in.Dst, "rax")
+	case ir.Mov:
+		_, dstInReg := g.alloc.regs[in.Dst]
+		_, srcInReg := g.alloc.regs[in.Args[0]]
+		if dstInReg || srcInReg {
+			g.storeReg(in.Dst, g.loc(in.Args[0]))
+		} else {
+			g.loadReg("rax", in.Args[0])
+			g.storeReg(in.Dst, "rax")
+		}
+	case ir.Add, ir.Sub, ir.Mul, ir.Div, ir.Shl, ir.Shr, ir.And, ir.Or:
+		g.loadReg("rax", in.Args[0])
+		g.loadReg("rdi", in.Args[1])
+		if in.Ty.IsFloat() {
+			g.emitFloatBinary(in)
+		} else {
+			g.emitIntBinary(in)
+		}
+		g.storeReg(in.Dst, "rax")
+	case ir.Eq, ir.Ne, ir.Lt, ir.Le:
+		g.loadReg("rax", in.Args[0])
+		g.loadReg("rdi", in.Args[1])
+		if in.Ty.IsFloat() {
+			g.emitFloatCompare(in)
+		} else {
+			g.emitIntCompare(in)
+		}
+		g.storeReg(in.Dst, "rax")
+	case ir.Conv:
+		g.loadReg("rax", in.Args[0])
+		g.emitConv(g.fn.Regs[in.Args[0]], in.Ty)
+		g.storeReg(in.Dst, "rax")
+	case ir.SlotAddr:
+		g.emit(fmt.Sprintf("  lea rax, [rbp-%d]", in.Slot.Offset-int(in.Imm)))
+		g.storeReg(in.Dst, "rax")
+	case ir.Global:
+		if g.defined[in.Sym] {
+			if in.Imm != 0 {
+				g.emit(fmt.Sprintf("  lea rax, [rip + %s%+d]", in.Sym, in.Imm))
+			} else {
+				g.emit(fmt.Sprintf("  lea rax, [rip + %s]", in.Sym))
+			}
+		} else {
+			// defined in another file, possibly a shared library
+			g.emit(fmt.Sprintf("  mov rax, [rip + %s@GOTPCREL]", in.Sym))
+			if in.Imm != 0 {
+				g.emit(fmt.Sprintf("  add rax, %d", in.Imm))
+			}
+		}
+		g.storeReg(in.Dst, "rax")
+	case ir.Load:
+		g.loadReg("rax", in.Args[0])
+		g.load(in.Ty)
+		g.storeReg(in.Dst, "rax")
+	case ir.Store:
+		g.loadReg("rax", in.Args[0])
+		g.loadReg("rdi", in.Args[1])
+		g.store(in.Ty)
+	case ir.MemCopy:
+		g.loadReg("rax", in.Args[0])
+		g.loadReg("rdi", in.Args[1])
+		for i := 0; i < int(in.Imm); i++ {
+			g.emit(fmt.Sprintf("  mov r8b, [rdi+%d]", i))
+			g.emit(fmt.Sprintf("  mov [rax+%d], r8b", i))
+		}
+	case ir.MemZero:
+		g.loadReg("rdi", in.Args[0])
+		g.emit(fmt.Sprintf("  mov rcx, %d", in.Imm))
+		g.emit("  mov al, 0")
+		g.emit("  rep stosb")
+	case ir.Alloca:
+		// Allocate below everything else on the stack. Rounding the size
+		// keeps rsp aligned for calls. The epilogue restores rsp from rbp,
+		// which frees the memory, unless a SetSP frees it earlier.
+		g.loadReg("rax", in.Args[0])
+		g.emit("  add rax, 15")
+		g.emit("  and rax, -16")
+		g.emit("  sub rsp, rax")
+		g.storeReg(in.Dst, "rsp")
+	case ir.GetSP:
+		g.storeReg(in.Dst, "rsp")
+	case ir.SetSP:
+		g.loadReg("rax", in.Args[0])
+		g.emit("  mov rsp, rax")
+	case ir.Call:
+		g.emitCall(in)
+	case ir.VaStart:
+		g.emitVaStart(in)
+	case ir.VaArg:
+		g.emitVaArg(in)
+	case ir.Asm:
+		return g.emitAsm(in)
+	case ir.Jmp:
+		g.emit(fmt.Sprintf("  jmp %s", g.blockLabel(in.Targets[0])))
+	case ir.Br:
+		g.loadReg("rax", in.Args[0])
+		g.emit("  cmp rax, 0")
+		g.emit(fmt.Sprintf("  jne %s", g.blockLabel(in.Targets[0])))
+		g.emit(fmt.Sprintf("  jmp %s", g.blockLabel(in.Targets[1])))
+	case ir.Ret:
+		if len(in.Args) > 0 {
+			g.loadReg("rax", in.Args[0])
+			if g.fn.RetTy.IsFloat() {
+				g.emit("  movq xmm0, rax")
+			}
+		}
+		g.emitEpilogue()
+		g.emit("  ret")
+	default:
+		return fmt.Errorf("unsupported instruction %s", in)
+	}
+	return nil
+}
 
-	switch node.Kind {
-	case parser.ADD:
+// emitIntBinary applies a binary operator to the integers in rax and rdi,
+// leaving the result in rax.
+func (g *Generator) emitIntBinary(in *ir.Inst) {
+	switch in.Op {
+	case ir.Add:
 		g.emit("  add rax, rdi")
-	case parser.SUB:
+	case ir.Sub:
 		g.emit("  sub rax, rdi")
-	case parser.MUL:
+	case ir.Mul:
 		g.emit("  imul rax, rdi")
-	case parser.DIV:
-		g.emit("  cqo")
-		g.emit("  idiv rdi")
-	case parser.EQ:
-		g.emit("  cmp rax, rdi")
+	case ir.Div:
+		if in.Ty.IsSigned() {
+			g.emit("  cqo")
+			g.emit("  idiv rdi")
+		} else {
+			g.emit("  mov edx, 0")
+			g.emit("  div rdi")
+		}
+	case ir.Shl:
+		g.emit("  mov rcx, rdi")
+		g.emit("  shl rax, cl")
+	case ir.Shr:
+		g.emit("  mov rcx, rdi")
+		if in.Ty.IsSigned() {
+			g.emit("  sar rax, cl")
+		} else {
+			g.emit("  shr rax, cl")
+		}
+	case ir.And:
+		g.emit("  and rax, rdi")
+	case ir.Or:
+		g.emit("  or rax, rdi")
+	}
+	g.extend(in.Ty)
+}
+
+// emitIntCompare compares the integers in rax and rdi, leaving 1 in rax if
+// the comparison holds and 0 otherwise.
+func (g *Generator) emitIntCompare(in *ir.Inst) {
+	g.emit("  cmp rax, rdi")
+	switch {
+	case in.Op == ir.Eq:
 		g.emit("  sete al")
-		g.emit("  movzb rax, al")
-	case parser.NEQ:
-		g.emit("  cmp rax, rdi")
+	case in.Op == ir.Ne:
 		g.emit("  setne al")
-		g.emit("  movzb rax, al")
-	case parser.LT:
-		g.emit("  cmp rax, rdi")
+	case in.Op == ir.Lt && in.Ty.IsSigned():
 		g.emit("  setl al")
-		g.emit("  movzb rax, al")
-	case parser.LTE:
-		g.emit("  cmp rax, rdi")
+	case in.Op == ir.Lt:
+		g.emit("  setb al")
+	case in.Op == ir.Le && in.Ty.IsSigned():
 		g.emit("  setle al")
-		g.emit("  movzb rax, al")
+	default:
+		g.emit("  setbe al")
 	}
+	g.emit("  movzb rax, al")
+}
+
+// emitFloatBinary applies a binary operator to the floating values in rax
+// and rdi, leaving the result in rax.
+func (g *Generator) emitFloatBinary(in *ir.Inst) {
+	sfx := floatSuffix(in.Ty)
+	g.emit("  movq xmm0, rax")
+	g.emit("  movq xmm1, rdi")
+	op := map[ir.Op]string{ir.Add: "add", ir.Sub: "sub", ir.Mul: "mul", ir.Div: "div"}[in.Op]
+	g.emit(fmt.Sprintf("  %s%s xmm0, xmm1", op, sfx))
+	g.moveFromXmm0(in.Ty)
+}
 
-	g.emit("  push rax")
+// emitFloatCompare compares the floating values in rax and rdi, leaving 1
+// in rax if the comparison holds and 0 otherwise.
+func (g *Generator) emitFloatCompare(in *ir.Inst) {
+	sfx := floatSuffix(in.Ty)
+	g.emit("  movq xmm0, rax")
+	g.emit("  movq xmm1, rdi")
 
-	return nil
+	// ucomis sets the flags like an unsigned comparison, and sets PF if
+	// either operand is NaN, in which case only != is true
+	switch in.Op {
+	case ir.Eq:
+		g.emit(fmt.Sprintf("  ucomi%s xmm0, xmm1", sfx))
+		g.emit("  sete al")
+		g.emit("  setnp dl")
+		g.emit("  and al, dl")
+	case ir.Ne:
+		g.emit(fmt.Sprintf("  ucomi%s xmm0, xmm1", sfx))
+		g.emit("  setne al")
+		g.emit("  setp dl")
+		g.emit("  or al, dl")
+	case ir.Lt:
+		g.emit(fmt.Sprintf("  ucomi%s xmm1, xmm0", sfx))
+		g.emit("  seta al")
+	case ir.Le:
+		g.emit(fmt.Sprintf("  ucomi%s xmm1, xmm0", sfx))
+		g.emit("  setae al")
+	}
+	g.emit("  movzb rax, al")
+}
+
+// emitEpilogue restores the callee-saved registers and removes the frame.
+func (g *Generator) emitEpilogue() {
+	for _, reg := range g.alloc.order {
+		g.emit(fmt.Sprintf("  mov %s, [rbp-%d]", reg, g.alloc.saved[reg]))
+	}
+	g.emit("  mov rsp, rbp")
+	g.emit("  pop rbp")
+}
+
+// callArgs returns the arguments of the call in, which of them are passed
+// on the stack, and how many.
+func (g *Generator) callArgs(in *ir.Inst) (args []ir.Reg, onStack []bool, stackArgs int) {
+	args = in.Args
+	if in.Sym == "" {
+		args = args[1:]
+	}
+
+	onStack = make([]bool, len(args))
+	gp, fp := 0, 0
+	for i, arg := range args {
+		switch {
+		case g.fn.Regs[arg].IsFloat() && fp < floatArgRegs:
+			fp++
+		case !g.fn.Regs[arg].IsFloat() && gp < len(argReg64):
+			gp++
+		default:
+			onStack[i] = true
+			stackArgs++
+		}
+	}
+	return args, onStack, stackArgs
+}
+
+// loadRegArgs loads the arguments that are not passed on the stack into
+// their registers, and sets al to the number of vector registers used,
+// which a variadic callee reads.
+func (g *Generator) loadRegArgs(args []ir.Reg, onStack []bool) {
+	gp, fp := 0, 0
+	for i, arg := range args {
+		if onStack[i] {
+			continue
+		}
+		if g.fn.Regs[arg].IsFloat() {
+			g.emit(fmt.Sprintf("  movq xmm%d, %s", fp, g.qwordLoc(arg)))
+			fp++
+		} else {
+			g.loadReg(argReg64[gp], arg)
+			gp++
+		}
+	}
+	g.emit(fmt.Sprintf("  mov rax, %d", fp))
+}
+
+// emitCall calls a function following the System V AMD64 ABI. Integer
+// arguments go in rdi, rsi, rdx, rcx, r8 and r9, floating arguments in
+// xmm0-xmm7, and the rest are pushed on the stack right to left.
+func (g *Generator) emitCall(in *ir.Inst) {
+	args, onStack, stackArgs := g.callArgs(in)
+
+	// rsp must be 16-byte aligned at the call instruction
+	if stackArgs%2 == 1 {
+		g.emit("  sub rsp, 8")
+		stackArgs++
+	}
+	for i := len(args) - 1; i >= 0; i-- {
+		if onStack[i] {
+			g.push(g.qwordLoc(args[i]))
+		}
+	}
+
+	g.loadRegArgs(args, onStack)
+	if in.Sym == "" {
+		g.loadReg("r10", in.Args[0])
+		g.emit("  call r10")
+	} else {
+		g.emit(fmt.Sprintf("  call %s", in.Sym))
+	}
+	if stackArgs > 0 {
+		g.emit(fmt.Sprintf("  add rsp, %d", 8*stackArgs))
+	}
+
+	if in.Ty == ir.Void {
+		return
+	}
+	// the upper bits of narrow return values are unspecified
+	if in.Ty.IsFloat() {
+		g.moveFromXmm0(in.Ty)
+	} else {
+		g.extend(in.Ty)
+	}
+	g.storeReg(in.Dst, "rax")
+}
+
+// emitTailCall emits the tail call in as a jump to the callee after
+// removing the frame, so that the callee returns to the caller of the
+// function. The stack arguments are stored over those of the function,
+// so it returns false, emitting nothing, if there are more of them.
+func (g *Generator) emitTailCall(in *ir.Inst) bool {
+	args, onStack, stackArgs := g.callArgs(in)
+	if stackArgs > g.stackArg {
+		return false
+	}
+
+	// the arguments of the function were copied to their registers on
+	// entry, so their places can be overwritten
+	slot := 0
+	for i, arg := range args {
+		if onStack[i] {
+			g.loadReg("rax", arg)
+			g.emit(fmt.Sprintf("  mov [rbp+%d], rax", 16+8*slot))
+			slot++
+		}
+	}
+	g.loadRegArgs(args, onStack)
+	if in.Sym == "" {
+		g.loadReg("r10", in.Args[0])
+	}
+	g.emitEpilogue()
+	if in.Sym == "" {
+		g.emit("  jmp r10")
+	} else {
+		g.emit(fmt.Sprintf("  jmp %s", in.Sym))
+	}
+	return true
 }
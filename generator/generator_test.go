@@ -5,9 +5,35 @@ import (
 	"testing"
 
 	"rkitamu/gocc/generator"
+	"rkitamu/gocc/ir"
 	"rkitamu/gocc/parser"
 )
 
+// typed assigns the types to the expression node built by hand, as the
+// parser does to the nodes it builds.
+func typed(node *parser.Node) *parser.Node {
+	parser.AddType(node)
+	return node
+}
+
+// generate types the function definitions funcs, lowers them to the IR and
+// generates assembly for them.
+func generate(t *testing.T, funcs []*parser.Function, globals []*parser.Global) string {
+	t.Helper()
+	for _, fn := range funcs {
+		parser.AddType(fn.Body)
+	}
+	prog, err := ir.Lower(funcs, globals)
+	if err != nil {
+		t.Fatalf("lower error: %v", err)
+	}
+	asm, err := generator.NewGenerator().GenerateProgram(prog)
+	if err != nil {
+		t.Fatalf("generate error: %v", err)
+	}
+	return asm
+}
+
 func TestGenerator_Addition(t *testing.T) {
 	node := &parser.Node{
 		Kind: parser.ADD,
@@ -16,16 +42,14 @@ func TestGenerator_Addition(t *testing.T) {
 	}
 
 	gen := generator.NewGenerator()
-	asm, _ := gen.Generate(node)
+	asm, _ := gen.Generate(typed(node))
 
 	checks := []string{
-		"push 1",
-		"push 2",
-		"pop rdi",
-		"pop rax",
-		"add rax, rdi",
-		"push rax",
-		"pop rax",
+		// the values live in registers
+		"mov r8, 1",
+		"mov r9, 2",
+		"mov rax, r8\n  mov rdi, r9\n  add rax, rdi",
+		"mov r10, rax\n  mov rax, r10\n  mov rsp, rbp",
 		"ret",
 	}
 
@@ -44,7 +68,7 @@ func TestGenerator_Equality(t *testing.T) {
 	}
 
 	gen := generator.NewGenerator()
-	asm, _ := gen.Generate(node)
+	asm, _ := gen.Generate(typed(node))
 
 	expected := []string{
 		"cmp rax, rdi",
@@ -67,7 +91,7 @@ func TestGenerator_Subtraction(t *testing.T) {
 	}
 
 	gen := generator.NewGenerator()
-	asm, _ := gen.Generate(node)
+	asm, _ := gen.Generate(typed(node))
 
 	if !strings.Contains(asm, "sub rax, rdi") {
 		t.Errorf("sub instruction missing in:\n%s", asm)
@@ -86,7 +110,7 @@ func TestGenerator_NestedExpr(t *testing.T) {
 	}
 
 	gen := generator.NewGenerator()
-	asm, _ := gen.Generate(node)
+	asm, _ := gen.Generate(typed(node))
 
 	if !strings.Contains(asm, "imul rax, rdi") {
 		t.Errorf("imul instruction missing in nested expression:\n%s", asm)
@@ -101,7 +125,7 @@ func TestGenerator_LessThan(t *testing.T) {
 	}
 
 	gen := generator.NewGenerator()
-	asm, _ := gen.Generate(node)
+	asm, _ := gen.Generate(typed(node))
 
 	expected := []string{
 		"cmp rax, rdi",
@@ -115,3 +139,716 @@ func TestGenerator_LessThan(t *testing.T) {
 		}
 	}
 }
+
+func TestGenerator_UnsignedDivision(t *testing.T) {
+	node := &parser.Node{
+		Kind: parser.DIV,
+		Lhs:  &parser.Node{Kind: parser.LVAR, Var: &parser.LVar{Offset: 4}, Ty: parser.TyUInt},
+		Rhs:  &parser.Node{Kind: parser.NUM, Val: 2},
+	}
+
+	gen := generator.NewGenerator()
+	asm, _ := gen.Generate(typed(node))
+
+	if !strings.Contains(asm, "div rdi") || strings.Contains(asm, "idiv rdi") {
+		t.Errorf("expected unsigned div in:\n%s", asm)
+	}
+}
+
+func TestGenerator_UnsignedLessThan(t *testing.T) {
+	node := &parser.Node{
+		Kind: parser.LT,
+		Lhs:  &parser.Node{Kind: parser.LVAR, Var: &parser.LVar{Offset: 8}, Ty: parser.TyULong},
+		Rhs:  &parser.Node{Kind: parser.NUM, Val: 2},
+	}
+
+	gen := generator.NewGenerator()
+	asm, _ := gen.Generate(typed(node))
+
+	if !strings.Contains(asm, "setb al") {
+		t.Errorf("expected 'setb al' in:\n%s", asm)
+	}
+}
+
+func TestGenerator_LoadExtension(t *testing.T) {
+	tests := []struct {
+		ty   *parser.Type
+		want string
+	}{
+		{parser.TyChar, "movsx rax, byte ptr [rax]"},
+		{parser.TyUChar, "movzx eax, byte ptr [rax]"},
+		{parser.TyShort, "movsx rax, word ptr [rax]"},
+		{parser.TyUShort, "movzx eax, word ptr [rax]"},
+		{parser.TyInt, "movsxd rax, dword ptr [rax]"},
+		{parser.TyUInt, "mov eax, dword ptr [rax]"},
+		{parser.TyLong, "mov rax, [rax]"},
+	}
+
+	for _, tt := range tests {
+		node := &parser.Node{Kind: parser.LVAR, Var: &parser.LVar{Offset: 8}, Ty: tt.ty}
+
+		gen := generator.NewGenerator()
+		asm, _ := gen.Generate(typed(node))
+
+		if !strings.Contains(asm, tt.want) {
+			t.Errorf("expected '%s' in:\n%s", tt.want, asm)
+		}
+	}
+}
+
+func TestGenerator_DoubleAddition(t *testing.T) {
+	node := &parser.Node{
+		Kind: parser.ADD,
+		Lhs:  &parser.Node{Kind: parser.NUM, FVal: 1.5, Ty: parser.TyDouble},
+		Rhs:  &parser.Node{Kind: parser.NUM, Val: 2},
+	}
+
+	gen := generator.NewGenerator()
+	asm, _ := gen.Generate(typed(node))
+
+	expected := []string{
+		"mov r8, 4609434218613702656",
+		"cvtsi2sd xmm0, rax",
+		"addsd xmm0, xmm1",
+		"movq rax, xmm0",
+	}
+
+	for _, line := range expected {
+		if !strings.Contains(asm, line) {
+			t.Errorf("expected '%s' in:\n%s", line, asm)
+		}
+	}
+}
+
+func TestGenerator_FunctionCall(t *testing.T) {
+	call := &parser.Node{
+		Kind:     parser.FUNCALL,
+		FuncName: "f",
+		Ty:       parser.TyInt,
+		Args: []*parser.Node{
+			{Kind: parser.NUM, Val: 1, Ty: parser.TyInt},
+			{Kind: parser.NUM, FVal: 2, Ty: parser.TyDouble},
+			{Kind: parser.NUM, Val: 3, Ty: parser.TyInt},
+		},
+	}
+	main := &parser.Function{
+		Name:         "main",
+		Body:         &parser.Node{Kind: parser.RETURN, Lhs: call},
+		IsDefinition: true,
+	}
+
+	asm := generate(t, []*parser.Function{main}, nil)
+
+	expected := []string{
+		// the arguments live across the call, so they are in callee-saved
+		// registers, which are preserved for the caller
+		"mov [rbp-8], rbx",
+		"mov rdi, rbx\n  movq xmm0, r12\n  mov rsi, r13",
+		"mov rax, 1\n  call f",
+		"mov rbx, [rbp-8]",
+	}
+
+	for _, line := range expected {
+		if !strings.Contains(asm, line) {
+			t.Errorf("expected '%s' in:\n%s", line, asm)
+		}
+	}
+}
+
+func TestGenerator_InlineDef(t *testing.T) {
+	// main returns add() + &add, where add has only an inline definition
+	add := &parser.Function{
+		Name:         "add",
+		Ty:           &parser.Type{Kind: parser.TY_FUNC, ReturnTy: parser.TyInt},
+		Body:         &parser.Node{Kind: parser.RETURN, Lhs: &parser.Node{Kind: parser.NUM, Val: 1, Ty: parser.TyInt}},
+		IsDefinition: true,
+		IsInlineDef:  true,
+	}
+	call := &parser.Node{Kind: parser.FUNCALL, FuncName: "add", Ty: parser.TyInt}
+	fnPtr := &parser.Type{Kind: parser.TY_PTR, Size: 8, Align: 8, Unsigned: true, Base: add.Ty}
+	addr := &parser.Node{Kind: parser.ADDR, Lhs: &parser.Node{Kind: parser.GVAR, Label: "add", Ty: add.Ty}, Ty: fnPtr}
+	main := &parser.Function{
+		Name:         "main",
+		Body:         &parser.Node{Kind: parser.RETURN, Lhs: &parser.Node{Kind: parser.ADD, Lhs: call, Rhs: addr, Ty: parser.TyLong}},
+		IsDefinition: true,
+	}
+
+	asm := generate(t, []*parser.Function{add, main}, nil)
+
+	// another file defines add
+	if strings.Contains(asm, "add:") {
+		t.Errorf("unexpected definition of add in:\n%s", asm)
+	}
+	for _, line := range []string{"call add", "mov rax, [rip + add@GOTPCREL]"} {
+		if !strings.Contains(asm, line) {
+			t.Errorf("expected '%s' in:\n%s", line, asm)
+		}
+	}
+}
+
+func TestGenerator_Dereference(t *testing.T) {
+	ptr := &parser.Node{Kind: parser.LVAR, Var: &parser.LVar{Offset: 8}, Ty: &parser.Type{Kind: parser.TY_PTR, Size: 8, Align: 8, Unsigned: true, Base: parser.TyChar}}
+	node := &parser.Node{Kind: parser.DEREF, Lhs: ptr}
+
+	gen := generator.NewGenerator()
+	asm, _ := gen.Generate(typed(node))
+
+	expected := []string{
+		"mov rax, [rax]",
+		"movsx rax, byte ptr [rax]",
+	}
+
+	for _, line := range expected {
+		if !strings.Contains(asm, line) {
+			t.Errorf("expected '%s' in:\n%s", line, asm)
+		}
+	}
+}
+
+func TestGenerator_Globals(t *testing.T) {
+	globals := []*parser.Global{
+		{Name: "a", Ty: parser.TyInt, IsDefinition: true, InitData: []byte{1, 0, 0, 0}},
+		{Name: "b", Ty: parser.TyLong, IsStatic: true, IsDefinition: true},
+		{Name: "c", Ty: parser.TyInt},
+	}
+	f := &parser.Function{
+		Name:         "f",
+		IsStatic:     true,
+		IsDefinition: true,
+		Body: &parser.Node{Kind: parser.RETURN, Lhs: &parser.Node{
+			Kind: parser.ADD,
+			Lhs:  &parser.Node{Kind: parser.GVAR, Label: "a", Ty: parser.TyInt},
+			Rhs:  &parser.Node{Kind: parser.GVAR, Label: "c", Ty: parser.TyInt},
+		}},
+	}
+
+	asm := generate(t, []*parser.Function{f}, globals)
+
+	expected := []string{
+		".global a\n",
+		"a:\n  .byte 1\n  .byte 0",
+		".bss",
+		"b:\n  .zero 8",
+		"lea rax, [rip + a]",
+		"mov rax, [rip + c@GOTPCREL]",
+	}
+	for _, line := range expected {
+		if !strings.Contains(asm, line) {
+			t.Errorf("expected '%s' in:\n%s", line, asm)
+		}
+	}
+
+	unexpected := []string{".global b", ".global c", "c:", ".global f"}
+	for _, line := range unexpected {
+		if strings.Contains(asm, line) {
+			t.Errorf("unexpected '%s' in:\n%s", line, asm)
+		}
+	}
+}
+
+func TestGenerator_Aggregates(t *testing.T) {
+	st := &parser.Type{Kind: parser.TY_STRUCT, Size: 2, Align: 1}
+	m := &parser.Member{Name: "y", Ty: parser.TyChar, Offset: 1}
+	st.Members = []*parser.Member{{Name: "x", Ty: parser.TyChar}, m}
+	stmts := []*parser.Node{
+		{Kind: parser.MEMZERO, Var: &parser.LVar{Offset: 4}, Ty: st},
+		{Kind: parser.ASSIGN, Ty: st,
+			Lhs: &parser.Node{Kind: parser.LVAR, Var: &parser.LVar{Offset: 4}, Ty: st},
+			Rhs: &parser.Node{Kind: parser.LVAR, Var: &parser.LVar{Offset: 2}, Ty: st},
+		},
+		{Kind: parser.MEMBER, Member: m, Ty: parser.TyChar,
+			Lhs: &parser.Node{Kind: parser.GVAR, Label: "g", Offset: 8, Ty: st},
+		},
+	}
+
+	gen := generator.NewGenerator()
+	asm, _ := gen.GenerateForMultiStatement(stmts)
+
+	expected := []string{
+		"lea rax, [rbp-4]\n  mov r8, rax\n  mov rdi, r8\n  mov rcx, 2\n  mov al, 0\n  rep stosb",
+		// the addresses copied from are not in r8, which the copy uses
+		"mov rax, rbx\n  mov rdi, r12\n  mov r8b, [rdi+0]",
+		"mov r8b, [rdi+1]\n  mov [rax+1], r8b",
+		"mov rax, [rip + g@GOTPCREL]\n  add rax, 8",
+		"movsx rax, byte ptr [rax]",
+	}
+	for _, line := range expected {
+		if !strings.Contains(asm, line) {
+			t.Errorf("expected '%s' in:\n%s", line, asm)
+		}
+	}
+}
+
+func TestGenerator_Variadic(t *testing.T) {
+	f := &parser.Function{
+		Name:         "f",
+		Ty:           &parser.Type{Kind: parser.TY_FUNC, ReturnTy: parser.TyInt, Params: []*parser.Type{parser.TyDouble}, Prototyped: true, IsVariadic: true},
+		Params:       []*parser.LVar{{Name: "x", Ty: parser.TyDouble, Offset: 8}},
+		VaArea:       &parser.LVar{Offset: 208},
+		StackSize:    208,
+		IsDefinition: true,
+		Body: &parser.Node{Kind: parser.RETURN, Lhs: &parser.Node{
+			Kind: parser.FUNCALL, FuncName: "g", Ty: parser.TyInt,
+			Args: []*parser.Node{
+				{Kind: parser.NUM, FVal: 1.5, Ty: parser.TyDouble},
+				{Kind: parser.NUM, FVal: 2.5, Ty: parser.TyDouble},
+			},
+		}},
+	}
+
+	asm := generate(t, []*parser.Function{f}, nil)
+
+	expected := []string{
+		// no integer and one floating-point named parameter
+		"mov dword ptr [rbp-208], 0\n  mov dword ptr [rbp-204], 64",
+		"lea rax, [rbp+16]\n  mov [rbp-200], rax",
+		"lea rax, [rbp-184]\n  mov [rbp-192], rax",
+		"mov [rbp-184], rdi",
+		"movsd qword ptr [rbp-136], xmm0",
+		"movsd qword ptr [rbp-24], xmm7",
+		"mov rax, 2\n  call g",
+	}
+	for _, line := range expected {
+		if !strings.Contains(asm, line) {
+			t.Errorf("expected '%s' in:\n%s", line, asm)
+		}
+	}
+}
+
+func TestGenerator_IndirectCall(t *testing.T) {
+	fnTy := &parser.Type{Kind: parser.TY_FUNC, ReturnTy: parser.TyInt, Prototyped: true}
+	ptr := &parser.Type{Kind: parser.TY_PTR, Size: 8, Align: 8, Unsigned: true, Base: fnTy}
+	f := &parser.Function{
+		Name:         "f",
+		StackSize:    8,
+		IsDefinition: true,
+		Body: &parser.Node{Kind: parser.RETURN, Lhs: &parser.Node{
+			Kind: parser.FUNCALL, Ty: parser.TyInt,
+			Lhs: &parser.Node{Kind: parser.ADDR, Ty: ptr, Lhs: &parser.Node{Kind: parser.GVAR, Label: "f", Ty: fnTy}},
+		}},
+	}
+
+	asm := generate(t, []*parser.Function{f}, nil)
+
+	expected := []string{
+		"lea rax, [rip + f]",
+		// the callee is loaded after the arguments are in place
+		"mov rax, 0\n  mov r10, rbx\n  call r10",
+	}
+	for _, line := range expected {
+		if !strings.Contains(asm, line) {
+			t.Errorf("expected '%s' in:\n%s", line, asm)
+		}
+	}
+}
+
+func TestGenerator_VLA(t *testing.T) {
+	vla := &parser.Type{Kind: parser.TY_VLA, Size: 8, Align: 8, Base: parser.TyInt}
+	stmts := []*parser.Node{
+		{Kind: parser.VLA_ALLOC, Var: &parser.LVar{Offset: 16}, Lhs: &parser.Node{Kind: parser.LVAR, Var: &parser.LVar{Offset: 8}, Ty: parser.TyLong}},
+		{Kind: parser.COMMA, Ty: parser.TyInt,
+			Lhs: &parser.Node{Kind: parser.NUM, Val: 1, Ty: parser.TyInt},
+			Rhs: &parser.Node{Kind: parser.DEREF, Ty: parser.TyInt,
+				Lhs: &parser.Node{Kind: parser.ADDR, Lhs: &parser.Node{Kind: parser.LVAR, Var: &parser.LVar{Offset: 16}, Ty: vla}},
+			},
+		},
+	}
+
+	gen := generator.NewGenerator()
+	asm, _ := gen.GenerateForMultiStatement(stmts)
+
+	expected := []string{
+		// the size is rounded up to keep the stack aligned
+		"add rax, 15\n  and rax, -16\n  sub rsp, rax\n  mov r9, rsp",
+		// the variable holds the address of the elements
+		"lea rax, [rbp-16]\n  mov r8, rax\n  mov rax, r8\n  mov rdi, r9\n  mov [rax], rdi",
+		"mov rax, [rax]\n  mov r9, rax\n  mov rax, r9\n  movsxd rax, dword ptr [rax]",
+	}
+	for _, line := range expected {
+		if !strings.Contains(asm, line) {
+			t.Errorf("expected '%s' in:\n%s", line, asm)
+		}
+	}
+}
+
+func TestGenerator_Bitfield(t *testing.T) {
+	m := &parser.Member{Name: "b", Ty: parser.TyInt, Offset: 4, IsBitfield: true, BitOffset: 3, BitWidth: 5}
+	st := &parser.Type{Kind: parser.TY_STRUCT, Size: 8, Align: 4, Members: []*parser.Member{m}}
+	ref := func() *parser.Node {
+		return &parser.Node{Kind: parser.MEMBER, Member: m, Ty: parser.TyInt,
+			Lhs: &parser.Node{Kind: parser.LVAR, Var: &parser.LVar{Offset: 8}, Ty: st}}
+	}
+	stmts := []*parser.Node{
+		{Kind: parser.ASSIGN, Ty: parser.TyInt, Lhs: ref(), Rhs: &parser.Node{Kind: parser.NUM, Val: 9, Ty: parser.TyInt}},
+		ref(),
+	}
+
+	gen := generator.NewGenerator()
+	asm, _ := gen.GenerateForMultiStatement(stmts)
+
+	expected := []string{
+		// the value is masked and merged with the other bits of the unit
+		"mov r8, 31",
+		"mov r8, -249",
+		"or rax, rdi",
+		"mov rax, r10\n  mov rdi, r11\n  mov [rax], edi",
+		// the result of the assignment is the value of the bit-field
+		"mov r9, 59",
+		"sar rax, cl",
+		// a read shifts the bits out of the loaded unit
+		"mov r8, 56",
+	}
+	for _, line := range expected {
+		if !strings.Contains(asm, line) {
+			t.Errorf("expected '%s' in:\n%s", line, asm)
+		}
+	}
+}
+
+func TestGenerator_Asm(t *testing.T) {
+	asm := &parser.AsmStmt{
+		Template: []*parser.AsmPiece{
+			{Text: "add ", Operand: -1},
+			{Operand: 0},
+			{Text: ", ", Operand: -1},
+			{Operand: 1},
+			{Text: "\n\tshl ", Operand: -1},
+			{Operand: 0, Modifier: 'q'},
+			{Text: ", ", Operand: -1},
+			{Operand: 2},
+		},
+		Outputs: []*parser.AsmOperand{
+			{Constraint: parser.ASM_REG, InOut: true, Expr: &parser.Node{Kind: parser.LVAR, Var: &parser.LVar{Offset: 8}, Ty: parser.TyInt}},
+		},
+		Inputs: []*parser.AsmOperand{
+			{Constraint: parser.ASM_REG, Expr: &parser.Node{Kind: parser.NUM, Val: 5, Ty: parser.TyInt}},
+			{Constraint: parser.ASM_IMM, Val: 2},
+		},
+		Clobbers: []string{"rbx", "rcx"},
+	}
+
+	gen := generator.NewGenerator()
+	asmText, _ := gen.GenerateForMultiStatement([]*parser.Node{{Kind: parser.ASM, Asm: asm}})
+
+	expected := []string{
+		// a clobbered callee-saved register is preserved
+		"push rbx\n  mov rax, r12",
+		// a read-write output is loaded through its address
+		"mov eax, dword ptr [rax]",
+		// the input gets the next register that is not clobbered
+		"mov rdx, r13",
+		"add eax, edx\n  shl rax, 2",
+		// the output is stored to its address, which is not kept in the
+		// clobbered rbx
+		"push rax\n  pop rdi\n  mov rax, r12\n  mov [rax], edi\n  pop rbx",
+	}
+	for _, line := range expected {
+		if !strings.Contains(asmText, line) {
+			t.Errorf("expected '%s' in:\n%s", line, asmText)
+		}
+	}
+}
+
+func TestGenerator_Spill(t *testing.T) {
+	// seven values live across a call, which only five callee-saved
+	// registers can hold
+	f := &ir.Func{Name: "main", RetTy: ir.I64}
+	b := f.NewBlock()
+	var vals []ir.Reg
+	for i := range 7 {
+		r := f.NewReg(ir.I64)
+		b.Insts = append(b.Insts, &ir.Inst{Op: ir.Const, Ty: ir.I64, Dst: r, Imm: int64(i + 1)})
+		vals = append(vals, r)
+	}
+	b.Insts = append(b.Insts, &ir.Inst{Op: ir.Call, Ty: ir.Void, Sym: "g"})
+	sum := vals[0]
+	for _, r := range vals[1:] {
+		next := f.NewReg(ir.I64)
+		b.Insts = append(b.Insts, &ir.Inst{Op: ir.Add, Ty: ir.I64, Dst: next, Args: []ir.Reg{sum, r}})
+		sum = next
+	}
+	b.Insts = append(b.Insts, &ir.Inst{Op: ir.Ret, Args: []ir.Reg{sum}})
+
+	asm, err := generator.NewGenerator().GenerateProgram(&ir.Program{Funcs: []*ir.Func{f}})
+	if err != nil {
+		t.Fatalf("generate error: %v", err)
+	}
+
+	expected := []string{
+		// the values that are used last are spilled
+		"mov rbx, 1",
+		"mov r15, 5",
+		"mov qword ptr [rbp-8], 6",
+		"mov qword ptr [rbp-16], 7",
+		// the homes and the saved registers are below each other
+		"sub rsp, 64\n  mov [rbp-24], rbx",
+		"mov [rbp-56], r15",
+		"mov rdi, [rbp-16]\n  add rax, rdi",
+	}
+	for _, line := range expected {
+		if !strings.Contains(asm, line) {
+			t.Errorf("expected '%s' in:\n%s", line, asm)
+		}
+	}
+}
+
+func TestGenerator_Peephole(t *testing.T) {
+	x := &parser.Node{Kind: parser.LVAR, Var: &parser.LVar{Offset: 8}, Ty: parser.TyInt}
+	asm := &parser.AsmStmt{
+		Template: []*parser.AsmPiece{{Text: "push rax\n\tpop rax\n\tmov ", Operand: -1}, {Operand: 0}, {Text: ", 1", Operand: -1}},
+		Outputs:  []*parser.AsmOperand{{Constraint: parser.ASM_REG, Expr: x}},
+	}
+	nodes := []*parser.Node{
+		{Kind: parser.ASM, Asm: asm},
+		{
+			Kind: parser.IF,
+			Cond: x,
+			Then: &parser.Node{Kind: parser.RETURN, Lhs: &parser.Node{Kind: parser.NUM, Val: 1, Ty: parser.TyInt}},
+			Else: &parser.Node{Kind: parser.RETURN, Lhs: &parser.Node{Kind: parser.NUM, Val: 2, Ty: parser.TyInt}},
+		},
+	}
+
+	asmText, err := generator.NewGenerator().GenerateForMultiStatement(nodes)
+	if err != nil {
+		t.Fatalf("generate error: %v", err)
+	}
+	asmText = generator.Peephole(asmText)
+
+	expected := []string{
+		// inline assembly is kept as written
+		"#APP\n  push rax\n  pop rax\n  mov eax, 1\n#NO_APP",
+		// a push and a pop become a mov
+		"#NO_APP\n  mov rdi, rax\n",
+		// a comparison with 0 becomes a test, and the branch falls
+		// through to the block that follows
+		"test rax, rax\n  je .L.main.2\n.L.main.1:",
+	}
+	for _, line := range expected {
+		if !strings.Contains(asmText, line) {
+			t.Errorf("expected '%s' in:\n%s", line, asmText)
+		}
+	}
+	for _, line := range []string{"cmp rax, 0", "jne", "jmp .L.main.1\n"} {
+		if strings.Contains(asmText, line) {
+			t.Errorf("unexpected '%s' in:\n%s", line, asmText)
+		}
+	}
+}
+
+func TestGenerator_TailCall(t *testing.T) {
+	// f(a1, ..., a7) returns g(a1, ..., a7, ...), passing n arguments on
+	// the stack
+	build := func(n int) *ir.Func {
+		f := &ir.Func{Name: "f", RetTy: ir.I64}
+		for range 7 {
+			f.Params = append(f.Params, f.NewReg(ir.I64))
+		}
+		b := f.NewBlock()
+		args := append(append([]ir.Reg{}, f.Params...), f.Params[:n-1]...)
+		res := f.NewReg(ir.I64)
+		b.Insts = append(b.Insts,
+			&ir.Inst{Op: ir.Call, Ty: ir.I64, Dst: res, Sym: "g", Args: args, Tail: true},
+			&ir.Inst{Op: ir.Ret, Args: []ir.Reg{res}})
+		return f
+	}
+
+	tests := []struct {
+		stackArgs int
+		want      []string
+		reject    []string
+	}{
+		// the stack argument replaces that of f
+		{1, []string{"mov rax, [rbp-16]\n  mov [rbp+16], rax", "mov rsp, rbp\n  pop rbp\n  jmp g\n"}, []string{"call", "ret"}},
+		// f receives only one argument on the stack
+		{2, []string{"call g", "ret"}, []string{"jmp g"}},
+	}
+	for _, tt := range tests {
+		asm, err := generator.NewGenerator().GenerateProgram(&ir.Program{Funcs: []*ir.Func{build(tt.stackArgs)}})
+		if err != nil {
+			t.Fatalf("generate error: %v", err)
+		}
+		for _, line := range tt.want {
+			if !strings.Contains(asm, line) {
+				t.Errorf("%d stack arguments: expected '%s' in:\n%s", tt.stackArgs, line, asm)
+			}
+		}
+		for _, line := range tt.reject {
+			if strings.Contains(asm, line) {
+				t.Errorf("%d stack arguments: unexpected '%s' in:\n%s", tt.stackArgs, line, asm)
+			}
+		}
+	}
+}
+
+func TestLookupTarget(t *testing.T) {
+	for _, target := range generator.Targets {
+		got, err := generator.LookupTarget(target.Triple)
+		if err != nil || got != target {
+			t.Errorf("LookupTarget(%q) = %v, %v", target.Triple, got, err)
+		}
+	}
+	if _, err := generator.LookupTarget("mips-linux-gnu"); err == nil {
+		t.Errorf("expected an error for an unknown target")
+	}
+}
+
+func TestGenerator_AArch64(t *testing.T) {
+	// f(char c, double d, ...) returns g(1, c, d) + h(a1, ..., a9) * 3
+	f := &ir.Func{Name: "f", RetTy: ir.I64, Variadic: true, VaArea: &ir.Slot{Offset: 224, Size: 224}, FrameSize: 224}
+	c, d := f.NewReg(ir.I8), f.NewReg(ir.F64)
+	f.Params = []ir.Reg{c, d}
+	b := f.NewBlock()
+	one, three := f.NewReg(ir.I64), f.NewReg(ir.I64)
+	x, y, z, r := f.NewReg(ir.I64), f.NewReg(ir.I64), f.NewReg(ir.I64), f.NewReg(ir.I64)
+	var args []ir.Reg
+	for range 9 {
+		args = append(args, one)
+	}
+	b.Insts = append(b.Insts,
+		&ir.Inst{Op: ir.Const, Ty: ir.I64, Dst: one, Imm: 1},
+		&ir.Inst{Op: ir.Const, Ty: ir.I64, Dst: three, Imm: 0x30000},
+		&ir.Inst{Op: ir.Call, Ty: ir.I64, Dst: x, Sym: "g", Args: []ir.Reg{one, c, d}},
+		&ir.Inst{Op: ir.Call, Ty: ir.I64, Dst: y, Sym: "h", Args: args},
+		&ir.Inst{Op: ir.Mul, Ty: ir.I64, Dst: z, Args: []ir.Reg{y, three}},
+		&ir.Inst{Op: ir.Add, Ty: ir.I64, Dst: r, Args: []ir.Reg{x, z}},
+		&ir.Inst{Op: ir.Ret, Args: []ir.Reg{r}})
+
+	asm, err := generator.AArch64.Generate(&ir.Program{Funcs: []*ir.Func{f}})
+	if err != nil {
+		t.Fatalf("generate error: %v", err)
+	}
+
+	expected := []string{
+		"stp x29, x30, [sp, -16]!\n  mov x29, sp\n  sub sp, sp, 272",
+		// narrow arguments are extended, and the offsets beyond the reach
+		// of stur go through x17
+		"mov x16, x0\n  sxtb x16, w16",
+		"sub x17, x29, 264\n  str x23, [x17]",
+		// one integer and one floating-point named parameter
+		"str x7, [x16, 88]",
+		"str q7, [x16, 208]",
+		"add x17, x29, 16\n  str x17, [x16, 0]",
+		"mov x17, -56\n  str w17, [x16, 24]\n  mov x17, -112\n  str w17, [x16, 28]",
+		"movz x22, 3, lsl 16",
+		"mov x16, x20\n  fmov d0, x16\n  bl g",
+		// the ninth integer argument goes on the stack
+		"sub sp, sp, 16\n  mov x0, x21\n  str x0, [sp, 0]",
+		"bl h\n  add sp, sp, 16",
+		"mov sp, x29\n  ldp x29, x30, [sp], 16\n  ret",
+		".section .note.GNU-stack,\"\",%progbits",
+	}
+	for _, line := range expected {
+		if !strings.Contains(asm, line) {
+			t.Errorf("expected '%s' in:\n%s", line, asm)
+		}
+	}
+	if strings.Contains(asm, "intel_syntax") {
+		t.Errorf("unexpected Intel syntax in:\n%s", asm)
+	}
+}
+
+func TestGenerator_RISCV64(t *testing.T) {
+	// f(char c, double d, ...) returns g(1, c, d) + h(a1, ..., a9) * 3,
+	// where g is variadic with one named parameter
+	f := &ir.Func{Name: "f", RetTy: ir.U32, Variadic: true, VaArea: &ir.Slot{Offset: 8, Size: 8}, FrameSize: 8}
+	c, d := f.NewReg(ir.I8), f.NewReg(ir.F64)
+	f.Params = []ir.Reg{c, d}
+	b := f.NewBlock()
+	one, three := f.NewReg(ir.I64), f.NewReg(ir.I64)
+	x, y, z, r := f.NewReg(ir.I64), f.NewReg(ir.I64), f.NewReg(ir.I64), f.NewReg(ir.U32)
+	var args []ir.Reg
+	for range 9 {
+		args = append(args, one)
+	}
+	b.Insts = append(b.Insts,
+		&ir.Inst{Op: ir.Const, Ty: ir.I64, Dst: one, Imm: 1},
+		&ir.Inst{Op: ir.Const, Ty: ir.I64, Dst: three, Imm: 0x30000},
+		&ir.Inst{Op: ir.Call, Ty: ir.I64, Dst: x, Sym: "g", Args: []ir.Reg{one, c, d}, Fixed: 1},
+		&ir.Inst{Op: ir.Call, Ty: ir.I64, Dst: y, Sym: "h", Args: args},
+		&ir.Inst{Op: ir.Mul, Ty: ir.I64, Dst: z, Args: []ir.Reg{y, three}},
+		&ir.Inst{Op: ir.Add, Ty: ir.U32, Dst: r, Args: []ir.Reg{x, z}},
+		&ir.Inst{Op: ir.Ret, Args: []ir.Reg{r}})
+
+	asm, err := generator.RISCV64.Generate(&ir.Program{Funcs: []*ir.Func{f}})
+	if err != nil {
+		t.Fatalf("generate error: %v", err)
+	}
+
+	expected := []string{
+		// a0-a7 are saved below the arguments on the stack
+		"addi sp, sp, -64\n  sd a0, 0(sp)",
+		"sd a7, 56(sp)\n  addi sp, sp, -16\n  sd ra, 8(sp)\n  sd s0, 0(sp)\n  mv s0, sp",
+		// narrow arguments are extended
+		"mv t0, a0\n  slli t0, t0, 56\n  srai t0, t0, 56",
+		"fmv.x.d t0, fa0",
+		// the variadic arguments follow the one named parameter passed in
+		// an integer register
+		"addi t0, s0, 24\n  sd t0, -8(s0)",
+		"li s4, 196608",
+		// variadic floating-point arguments are passed in integer registers
+		"mv a2, s2\n  call g",
+		// the ninth integer argument goes on the stack
+		"addi sp, sp, -16\n  mv t0, s3\n  sd t0, 0(sp)",
+		"call h\n  addi sp, sp, 16",
+		// unsigned ints are returned sign-extended
+		"sext.w a0, a0",
+		"mv sp, s0\n  ld ra, 8(sp)\n  ld s0, 0(sp)\n  addi sp, sp, 80\n  ret",
+		".section .note.GNU-stack,\"\",@progbits",
+	}
+	for _, line := range expected {
+		if !strings.Contains(asm, line) {
+			t.Errorf("expected '%s' in:\n%s", line, asm)
+		}
+	}
+	if strings.Contains(asm, "fmv.d.x fa") {
+		t.Errorf("unexpected floating-point argument register in:\n%s", asm)
+	}
+}
+
+func TestGenerator_AArch64VaArg(t *testing.T) {
+	// f(int n, ...) fetches a long and a double, passes the long to the
+	// function pointer g and returns h of the result as a tail call
+	ap := &ir.Slot{Offset: 32, Size: 32, Align: 8}
+	f := &ir.Func{Name: "f", RetTy: ir.I64, Variadic: true, Slots: []*ir.Slot{ap}, VaArea: &ir.Slot{Offset: 256, Size: 224}, FrameSize: 256}
+	n := f.NewReg(ir.I32)
+	f.Params = []ir.Reg{n}
+	b := f.NewBlock()
+	addr, x, d, g, r, s := f.NewReg(ir.U64), f.NewReg(ir.U64), f.NewReg(ir.U64), f.NewReg(ir.U64), f.NewReg(ir.I64), f.NewReg(ir.I64)
+	b.Insts = append(b.Insts,
+		&ir.Inst{Op: ir.SlotAddr, Dst: addr, Slot: ap},
+		&ir.Inst{Op: ir.VaStart, Args: []ir.Reg{addr}},
+		&ir.Inst{Op: ir.VaArg, Ty: ir.I64, Dst: x, Args: []ir.Reg{addr}},
+		&ir.Inst{Op: ir.VaArg, Ty: ir.F64, Dst: d, Args: []ir.Reg{addr}},
+		&ir.Inst{Op: ir.Global, Dst: g, Sym: "g"},
+		&ir.Inst{Op: ir.Call, Ty: ir.I64, Dst: r, Args: []ir.Reg{g, x}},
+		&ir.Inst{Op: ir.Call, Ty: ir.I64, Dst: s, Sym: "h", Args: []ir.Reg{r}, Tail: true},
+		&ir.Inst{Op: ir.Ret, Args: []ir.Reg{s}})
+
+	asm, err := generator.AArch64.Generate(&ir.Program{Funcs: []*ir.Func{f}})
+	if err != nil {
+		t.Fatalf("generate error: %v", err)
+	}
+
+	expected := []string{
+		// va_start copies the va_list set up in the prologue
+		"sub x1, x29, 256\n  ldr x2, [x1, 0]\n  str x2, [x0, 0]",
+		"ldr x2, [x1, 24]\n  str x2, [x0, 24]",
+		// integers come from x0-x7 through __gr_offs, then from the stack
+		"ldrsw x0, [x1, 24]\n  tbz x0, 63, .Lva_stack0\n  add w2, w0, 8\n  str w2, [x1, 24]\n  ldr x2, [x1, 8]",
+		// floating-point values come from q0-q7 through __vr_offs
+		"ldrsw x0, [x1, 28]\n  tbz x0, 63, .Lva_stack1\n  add w2, w0, 16\n  str w2, [x1, 28]\n  ldr x2, [x1, 16]",
+		".Lva_stack1:\n  ldr x0, [x1, 0]\n  add x2, x0, 8\n  str x2, [x1, 0]\n.Lva_end1:",
+		"adrp x0, :got:g\n  ldr x0, [x0, :got_lo12:g]",
+		"blr x16",
+		// the tail call jumps after removing the frame
+		"mov sp, x29\n  ldp x29, x30, [sp], 16\n  b h",
+	}
+	for _, line := range expected {
+		if !strings.Contains(asm, line) {
+			t.Errorf("expected '%s' in:\n%s", line, asm)
+		}
+	}
+	if strings.Contains(asm, "bl h") {
+		t.Errorf("unexpected call of h in:\n%s", asm)
+	}
+}
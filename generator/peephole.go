@@ -0,0 +1,128 @@
+package generator
+
+import "strings"
+
+// The peephole pass rewrites short sequences of the emitted instructions
+// that the generator produces when it handles each IR instruction on its
+// own:
+//
+//	push X; pop X            removed
+//	push X; pop R            mov R, X
+//	mov A, B; mov B, A       the second mov is removed
+//	jmp L; L:                the jmp is removed
+//	jne L1; jmp L2; L1:      je L2; L1:, and the same with je
+//	cmp R, 0                 test R, R
+//
+// A and B are 64-bit general-purpose registers, since a mov to a 32-bit
+// register also clears the upper half. The text of inline assembly, between
+// #APP and #NO_APP, is left as written.
+
+// gpRegs64 is the set of the 64-bit general-purpose registers.
+var gpRegs64 = map[string]bool{
+	"rax": true, "rbx": true, "rcx": true, "rdx": true, "rsi": true, "rdi": true, "rbp": true, "rsp": true,
+	"r8": true, "r9": true, "r10": true, "r11": true, "r12": true, "r13": true, "r14": true, "r15": true,
+}
+
+// invertedJump maps a conditional jump to the one taken in the opposite case.
+var invertedJump = map[string]string{"je": "jne", "jne": "je"}
+
+// asmLine is a line of assembly split into its parts. An instruction has a
+// mnemonic and operands, and a label has only its name.
+type asmLine struct {
+	text     string
+	label    string
+	op       string
+	operands []string
+	opaque   bool // inline assembly
+}
+
+func parseLine(text string) asmLine {
+	l := asmLine{text: text}
+	if !strings.HasPrefix(text, " ") {
+		if name, ok := strings.CutSuffix(text, ":"); ok {
+			l.label = name
+		}
+		return l
+	}
+	op, rest, _ := strings.Cut(strings.TrimSpace(text), " ")
+	l.op = op
+	if rest != "" {
+		for _, operand := range strings.Split(rest, ",") {
+			l.operands = append(l.operands, strings.TrimSpace(operand))
+		}
+	}
+	return l
+}
+
+func (l asmLine) is(op string, operands int) bool {
+	return !l.opaque && l.op == op && len(l.operands) == operands
+}
+
+// Peephole optimizes the assembly text asm generated by a Generator.
+func Peephole(asm string) string {
+	var lines []asmLine
+	opaque := false
+	for _, text := range strings.Split(strings.TrimSuffix(asm, "\n"), "\n") {
+		switch text {
+		case "#APP":
+			opaque = true
+		case "#NO_APP":
+			opaque = false
+		}
+		l := parseLine(text)
+		l.opaque = opaque
+		lines = append(lines, l)
+	}
+
+	// removing an instruction can bring others together
+	for changed := true; changed; {
+		changed = false
+		out := lines[:0]
+		for i := 0; i < len(lines); i++ {
+			l := lines[i]
+			var next, after asmLine
+			if i+1 < len(lines) {
+				next = lines[i+1]
+			}
+			if i+2 < len(lines) {
+				after = lines[i+2]
+			}
+
+			switch {
+			case l.is("push", 1) && next.is("pop", 1) && gpRegs64[next.operands[0]]:
+				if l.operands[0] != next.operands[0] {
+					out = append(out, parseLine("  mov "+next.operands[0]+", "+l.operands[0]))
+				}
+				i++
+				changed = true
+				continue
+			case l.is("mov", 2) && next.is("mov", 2) && gpRegs64[l.operands[0]] && gpRegs64[l.operands[1]] &&
+				next.operands[0] == l.operands[1] && next.operands[1] == l.operands[0]:
+				out = append(out, l)
+				i++
+				changed = true
+				continue
+			case l.is("jmp", 1) && next.label == l.operands[0]:
+				changed = true
+				continue
+			case invertedJump[l.op] != "" && l.is(l.op, 1) && next.is("jmp", 1) && after.label == l.operands[0]:
+				out = append(out, parseLine("  "+invertedJump[l.op]+" "+next.operands[0]))
+				i++
+				changed = true
+				continue
+			case l.is("cmp", 2) && gpRegs64[l.operands[0]] && l.operands[1] == "0":
+				l = parseLine("  test " + l.operands[0] + ", " + l.operands[0])
+				changed = true
+			}
+			out = append(out, l)
+		}
+		lines = out
+	}
+
+	var sb strings.Builder
+	for _, l := range lines {
+		sb.WriteString(l.text)
+		sb.WriteString("\n")
+	}
+	return sb.String()
+}
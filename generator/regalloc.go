@@ -0,0 +1,287 @@
+package generator
+
+import (
+	"sort"
+
+	"rkitamu/gocc/ir"
+)
+
+// The virtual registers of a function are assigned to machine registers by
+// linear scan, following Poletto and Sarkar, "Linear Scan Register
+// Allocation". The live range of each virtual register is approximated by
+// a single interval over the instructions in the order they are emitted.
+// The registers that do not fit are spilled to homes in the frame, below
+// the slots.
+//
+// On x86-64, rax, rcx, rdx, rsi and rdi are kept free for the
+// instructions, which load their operands into them. The caller-saved
+// r8-r11 are also used as scratch by calls, inline assembly and memory
+// copies, so they only hold values that are not live across one of these,
+// or the function entry, where the arguments arrive in r8 and r9. The
+// callee-saved registers can hold any value not live across an asm
+// statement that clobbers them, but are saved in the prologue once used.
+
+// regSet is the set of machine registers the allocator assigns on a
+// target.
+type regSet struct {
+	callerSaved []string // clobbered by calls, inline assembly, memory copies and the entry
+	calleeSaved []string // saved in the prologue once used
+}
+
+var x86Regs = regSet{
+	callerSaved: []string{"r8", "r9", "r10", "r11"},
+	calleeSaved: []string{"rbx", "r12", "r13", "r14", "r15"},
+}
+
+// allocatable returns the registers of rs in the order they are tried. The
+// caller-saved registers come first, since using them needs no saving.
+func (rs regSet) allocatable() []string {
+	return append(append([]string{}, rs.callerSaved...), rs.calleeSaved...)
+}
+
+// interval is the range of instruction positions where a virtual register
+// is live, from its first definition to its last use.
+type interval struct {
+	reg        ir.Reg
+	start, end int
+	clobbered  map[string]bool // registers clobbered within the interval
+}
+
+// clobber is a position where instructions clobber registers.
+type clobber struct {
+	pos  int
+	regs []string
+}
+
+// allocation is where the virtual registers of a function live.
+type allocation struct {
+	regs  map[ir.Reg]string // machine register
+	homes map[ir.Reg]int    // offset below rbp of the home of a spilled register
+	saved map[string]int    // offset below rbp where a used callee-saved register is saved
+	order []string          // the used callee-saved registers, in order
+	size  int               // size of the homes and the save area, below the slots
+}
+
+// allocate assigns the virtual registers of f to the machine registers of
+// rs or homes.
+func allocate(f *ir.Func, rs regSet) *allocation {
+	allocatable := rs.allocatable()
+	intervals := liveIntervals(f, rs)
+	sort.SliceStable(intervals, func(i, j int) bool { return intervals[i].start < intervals[j].start })
+
+	a := &allocation{regs: map[ir.Reg]string{}, homes: map[ir.Reg]int{}, saved: map[string]int{}}
+	free := map[string]bool{}
+	for _, r := range allocatable {
+		free[r] = true
+	}
+	used := map[string]bool{}
+	var active []*interval // sorted by end
+	spill := func(it *interval) {
+		a.size += 8
+		a.homes[it.reg] = f.FrameSize + a.size
+	}
+
+	for _, it := range intervals {
+		// expire the intervals that ended before this one starts
+		n := 0
+		for _, act := range active {
+			if act.end < it.start {
+				free[a.regs[act.reg]] = true
+			} else {
+				active[n] = act
+				n++
+			}
+		}
+		active = active[:n]
+
+		reg := ""
+		for _, r := range allocatable {
+			if free[r] && !it.clobbered[r] {
+				reg = r
+				break
+			}
+		}
+
+		if reg == "" {
+			// spill the interval that ends last among this one and the
+			// active ones whose register it could take
+			var victim *interval
+			for _, act := range active {
+				r := a.regs[act.reg]
+				if !it.clobbered[r] && act.end > it.end && (victim == nil || act.end > victim.end) {
+					victim = act
+				}
+			}
+			if victim == nil {
+				spill(it)
+				continue
+			}
+			reg = a.regs[victim.reg]
+			delete(a.regs, victim.reg)
+			spill(victim)
+			active = removeInterval(active, victim)
+		}
+
+		free[reg] = false
+		used[reg] = true
+		a.regs[it.reg] = reg
+		active = insertInterval(active, it)
+	}
+
+	for _, r := range rs.calleeSaved {
+		if used[r] {
+			a.size += 8
+			a.saved[r] = f.FrameSize + a.size
+			a.order = append(a.order, r)
+		}
+	}
+	return a
+}
+
+func insertInterval(active []*interval, it *interval) []*interval {
+	i := sort.Search(len(active), func(i int) bool { return active[i].end > it.end })
+	active = append(active, nil)
+	copy(active[i+1:], active[i:])
+	active[i] = it
+	return active
+}
+
+func removeInterval(active []*interval, it *interval) []*interval {
+	for i, act := range active {
+		if act == it {
+			return append(active[:i], active[i+1:]...)
+		}
+	}
+	return active
+}
+
+// liveIntervals computes the live interval of every virtual register of f.
+// Position 0 is the function entry, where the parameters are defined, and
+// the instructions are numbered from 1 in the order of the blocks.
+func liveIntervals(f *ir.Func, rs regSet) []*interval {
+	first := make([]int, len(f.Blocks))
+	last := make([]int, len(f.Blocks))
+	// the entry clobbers the argument registers, such as r8 and r9
+	clobbers := []clobber{{0, rs.callerSaved}}
+	pos := 0
+	for _, b := range f.Blocks {
+		first[b.ID] = pos + 1
+		for _, in := range b.Insts {
+			pos++
+			switch in.Op {
+			case ir.Call, ir.MemCopy:
+				clobbers = append(clobbers, clobber{pos, rs.callerSaved})
+			case ir.Asm:
+				clobbers = append(clobbers, clobber{pos, append(append([]string{}, rs.callerSaved...), in.Asm.Clobbers...)})
+			}
+		}
+		last[b.ID] = pos
+	}
+
+	liveIn, liveOut := liveness(f)
+
+	byReg := map[ir.Reg]*interval{}
+	extend := func(r ir.Reg, p int) {
+		if r == 0 {
+			return
+		}
+		it := byReg[r]
+		if it == nil {
+			byReg[r] = &interval{reg: r, start: p, end: p}
+			return
+		}
+		it.start = min(it.start, p)
+		it.end = max(it.end, p)
+	}
+	for _, r := range f.Params {
+		extend(r, 0)
+	}
+	pos = 0
+	for _, b := range f.Blocks {
+		for r := range liveIn[b.ID] {
+			extend(r, first[b.ID])
+		}
+		for r := range liveOut[b.ID] {
+			extend(r, last[b.ID])
+		}
+		for _, in := range b.Insts {
+			pos++
+			for _, r := range in.Args {
+				extend(r, pos)
+			}
+			if in.HasResult() {
+				extend(in.Dst, pos)
+			}
+		}
+	}
+
+	intervals := make([]*interval, 0, len(byReg))
+	for r := 1; r < len(f.Regs); r++ {
+		it := byReg[ir.Reg(r)]
+		if it == nil {
+			continue
+		}
+		i := sort.Search(len(clobbers), func(i int) bool { return clobbers[i].pos >= it.start })
+		for ; i < len(clobbers) && clobbers[i].pos <= it.end; i++ {
+			if it.clobbered == nil {
+				it.clobbered = map[string]bool{}
+			}
+			for _, reg := range clobbers[i].regs {
+				it.clobbered[reg] = true
+			}
+		}
+		intervals = append(intervals, it)
+	}
+	return intervals
+}
+
+// liveness returns the virtual registers live at the start and at the end
+// of each block of f, indexed by block ID.
+func liveness(f *ir.Func) (liveIn, liveOut []map[ir.Reg]bool) {
+	n := len(f.Blocks)
+	uses := make([]map[ir.Reg]bool, n) // read before any definition in the block
+	defs := make([]map[ir.Reg]bool, n)
+	for _, b := range f.Blocks {
+		uses[b.ID], defs[b.ID] = map[ir.Reg]bool{}, map[ir.Reg]bool{}
+		for _, in := range b.Insts {
+			for _, r := range in.Args {
+				if r != 0 && !defs[b.ID][r] {
+					uses[b.ID][r] = true
+				}
+			}
+			if in.HasResult() {
+				defs[b.ID][in.Dst] = true
+			}
+		}
+	}
+
+	liveIn = make([]map[ir.Reg]bool, n)
+	liveOut = make([]map[ir.Reg]bool, n)
+	for i := range n {
+		liveIn[i], liveOut[i] = map[ir.Reg]bool{}, map[ir.Reg]bool{}
+	}
+	for changed := true; changed; {
+		changed = false
+		for i := n - 1; i >= 0; i-- {
+			b := f.Blocks[i]
+			for _, s := range b.Succs() {
+				for r := range liveIn[s.ID] {
+					if !liveOut[i][r] {
+						liveOut[i][r] = true
+						changed = true
+					}
+				}
+			}
+			for r := range uses[i] {
+				liveIn[i][r] = true
+			}
+			for r := range liveOut[i] {
+				if !defs[i][r] && !liveIn[i][r] {
+					liveIn[i][r] = true
+					changed = true
+				}
+			}
+		}
+	}
+	return liveIn, liveOut
+}
@@ -0,0 +1,800 @@
+package generator
+
+import (
+	"fmt"
+	"math"
+	"slices"
+	"strings"
+
+	"rkitamu/gocc/ir"
+	"rkitamu/gocc/parser"
+)
+
+// The RISC-V backend generates assembly for the GNU assembler for RV64GC
+// following the LP64D calling convention. It works as the other backends
+// do: every virtual register holds a 64-bit integer, or the bit pattern of
+// a floating value, in an allocated register or in a home in the frame, and
+// the instructions load their operands into t0-t2 and store their results
+// back. t5 and t6 are scratch for calls and addresses.
+//
+// The frame pointer s0 points to the saved s0 and ra, so the slots and
+// homes lie below it and the arguments passed on the stack start at s0+16.
+// A variadic function saves a0-a7 between its frame and the arguments on
+// the stack, where va_list walks through them in order.
+
+// registers used to pass the first integer arguments, and the number of
+// floating-point arguments passed in fa0-fa7
+var argRegsRISCV64 = []string{"a0", "a1", "a2", "a3", "a4", "a5", "a6", "a7"}
+
+const floatArgRegsRISCV64 = 8
+
+// The argument registers hold values between calls, along with t3 and t4,
+// and the callee-saved s1-s11 are saved in the prologue once used.
+var riscv64Regs = regSet{
+	callerSaved: []string{"a0", "a1", "a2", "a3", "a4", "a5", "a6", "a7", "t3", "t4"},
+	calleeSaved: []string{"s1", "s2", "s3", "s4", "s5", "s6", "s7", "s8", "s9", "s10", "s11"},
+}
+
+// size of the save area of a0-a7 of variadic functions
+const vaRegSaveRISCV64 = 8 * 8
+
+type riscv64 struct {
+	writer
+	fn       *ir.Func    // function being generated
+	alloc    *allocation // where the registers of fn live
+	stackArg int         // number of arguments fn receives on the stack
+	argBase  int         // offset from s0 of the arguments on the stack
+}
+
+func newRISCV64() *riscv64 {
+	return &riscv64{writer: newWriter()}
+}
+
+func (g *riscv64) generateProgram(prog *ir.Program) (string, error) {
+	g.emitData(prog.Globals)
+	for _, fn := range prog.Funcs {
+		g.defined[fn.Name] = !fn.IsInlineDef
+	}
+
+	g.emit(".text")
+	for _, fn := range prog.Funcs {
+		// another file defines the function of an inline definition
+		if fn.IsInlineDef {
+			continue
+		}
+		if err := g.emitFunction(fn); err != nil {
+			return "", err
+		}
+	}
+
+	g.emit(".section .note.GNU-stack,\"\",@progbits")
+	return g.sb.String(), nil
+}
+
+// addImm sets dst to src plus v, going through t6 if v does not fit in the
+// immediate of addi. src must not be t6.
+func (g *riscv64) addImm(dst, src string, v int64) {
+	if v >= -2048 && v < 2048 {
+		g.emit(fmt.Sprintf("  addi %s, %s, %d", dst, src, v))
+		return
+	}
+	g.emit(fmt.Sprintf("  li t6, %d", v))
+	g.emit(fmt.Sprintf("  add %s, %s, t6", dst, src))
+}
+
+// mem emits the load or store op, such as ld or sb, of reg at base plus
+// off, going through t6 if off does not fit in the instruction. base must
+// not be t6.
+func (g *riscv64) mem(op, reg, base string, off int) {
+	if off >= -2048 && off < 2048 {
+		g.emit(fmt.Sprintf("  %s %s, %d(%s)", op, reg, off, base))
+		return
+	}
+	g.addImm("t6", base, int64(off))
+	g.emit(fmt.Sprintf("  %s %s, 0(t6)", op, reg))
+}
+
+// loc returns the machine register of r, or "" if it lives in its home.
+func (g *riscv64) loc(r ir.Reg) string {
+	return g.alloc.regs[r]
+}
+
+// loadReg loads the value of r into reg.
+func (g *riscv64) loadReg(reg string, r ir.Reg) {
+	switch src := g.loc(r); src {
+	case reg:
+	case "":
+		g.mem("ld", reg, "s0", -g.alloc.homes[r])
+	default:
+		g.emit(fmt.Sprintf("  mv %s, %s", reg, src))
+	}
+}
+
+// storeReg stores reg as the value of r.
+func (g *riscv64) storeReg(r ir.Reg, reg string) {
+	switch dst := g.loc(r); dst {
+	case reg:
+	case "":
+		g.mem("sd", reg, "s0", -g.alloc.homes[r])
+	default:
+		g.emit(fmt.Sprintf("  mv %s, %s", dst, reg))
+	}
+}
+
+// blockLabel returns the label of the block b of the current function.
+func (g *riscv64) blockLabel(b *ir.Block) string {
+	return fmt.Sprintf(".L.%s.%d", g.fn.Name, b.ID)
+}
+
+func (g *riscv64) emitFunction(fn *ir.Func) error {
+	g.fn = fn
+
+	if !fn.IsStatic {
+		g.emit(fmt.Sprintf(".global %s", fn.Name))
+	}
+	g.emit(".p2align 1")
+	g.emit(fmt.Sprintf("%s:", fn.Name))
+
+	g.alloc = allocate(fn, riscv64Regs)
+
+	g.argBase = 16
+	if fn.VaArea != nil {
+		g.argBase += vaRegSaveRISCV64
+		g.emit(fmt.Sprintf("  addi sp, sp, -%d", vaRegSaveRISCV64))
+		for i, reg := range argRegsRISCV64 {
+			g.emit(fmt.Sprintf("  sd %s, %d(sp)", reg, 8*i))
+		}
+	}
+	// sp must stay aligned to 16 bytes
+	frame := (fn.FrameSize + g.alloc.size + 15) / 16 * 16
+	g.emit("  addi sp, sp, -16")
+	g.emit("  sd ra, 8(sp)")
+	g.emit("  sd s0, 0(sp)")
+	g.emit("  mv s0, sp")
+	if frame > 0 {
+		g.addImm("sp", "sp", int64(-frame))
+	}
+	for _, reg := range g.alloc.order {
+		g.mem("sd", reg, "s0", -g.alloc.saved[reg])
+	}
+
+	gp, stack := g.storeParams(fn)
+	if fn.VaArea != nil {
+		// va_start finds the first variadic argument after the named ones
+		// that were passed in the integer registers or on the stack
+		g.addImm("t0", "s0", int64(16+8*(gp+stack)))
+		g.mem("sd", "t0", "s0", -fn.VaArea.Offset)
+	}
+	g.stackArg = stack
+
+	for _, b := range fn.Blocks {
+		g.emit(g.blockLabel(b) + ":")
+		for _, in := range b.Insts {
+			// the callee of a tail call returns in place of the Ret
+			// after it
+			if in.Tail && g.emitTailCall(in) {
+				break
+			}
+			if err := g.emitInst(in); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// storeParams copies the arguments from the argument registers, or from the
+// caller's frame if they were passed on the stack, to the parameter
+// registers. The floating-point parameters left when fa0-fa7 are used up
+// are passed like integers. Narrow arguments are extended.
+func (g *riscv64) storeParams(fn *ir.Func) (gp, stack int) {
+	fp := 0
+	for _, param := range fn.Params {
+		ty := fn.Regs[param]
+		switch {
+		case ty.IsFloat() && fp < floatArgRegsRISCV64:
+			g.fmovFrom("t0", fmt.Sprintf("fa%d", fp), ty)
+			fp++
+		case gp < len(argRegsRISCV64):
+			g.emit(fmt.Sprintf("  mv t0, %s", argRegsRISCV64[gp]))
+			g.extend("t0", ty)
+			gp++
+		default:
+			g.addImm("t0", "s0", int64(g.argBase+8*stack))
+			g.load("t0", ty)
+			stack++
+		}
+		g.storeReg(param, "t0")
+	}
+	return gp, stack
+}
+
+// load replaces the address in reg with the value of type ty it points to,
+// extended to 64 bits.
+func (g *riscv64) load(reg string, ty ir.Type) {
+	op := map[ir.Type]string{
+		ir.U8: "lbu", ir.I8: "lb", ir.U16: "lhu", ir.I16: "lh",
+		ir.U32: "lwu", ir.F32: "lwu", ir.I32: "lw",
+	}[ty]
+	if op == "" {
+		op = "ld"
+	}
+	g.emit(fmt.Sprintf("  %s %s, 0(%s)", op, reg, reg))
+}
+
+// loadOpRISCV64 is the sign-extending load instruction of each size, and
+// storeOpRISCV64 the store instruction.
+var loadOpRISCV64 = map[int]string{1: "lb", 2: "lh", 4: "lw", 8: "ld"}
+
+var storeOpRISCV64 = map[int]string{1: "sb", 2: "sh", 4: "sw", 8: "sd"}
+
+// extend truncates the integer in reg to the size of ty and sign or zero
+// extends it back to 64 bits.
+func (g *riscv64) extend(reg string, ty ir.Type) {
+	switch ty {
+	case ir.U8:
+		g.emit(fmt.Sprintf("  andi %s, %s, 255", reg, reg))
+	case ir.I8, ir.U16, ir.I16, ir.U32:
+		shift := 64 - 8*ty.Size()
+		shr := "srli"
+		if ty.IsSigned() {
+			shr = "srai"
+		}
+		g.emit(fmt.Sprintf("  slli %s, %s, %d", reg, reg, shift))
+		g.emit(fmt.Sprintf("  %s %s, %s, %d", shr, reg, reg, shift))
+	case ir.I32:
+		g.emit(fmt.Sprintf("  sext.w %s, %s", reg, reg))
+	}
+}
+
+// fmovTo moves the floating value of type ty in the integer register reg to
+// the floating-point register freg.
+func (g *riscv64) fmovTo(freg, reg string, ty ir.Type) {
+	if ty == ir.F32 {
+		g.emit(fmt.Sprintf("  fmv.w.x %s, %s", freg, reg))
+	} else {
+		g.emit(fmt.Sprintf("  fmv.d.x %s, %s", freg, reg))
+	}
+}
+
+// fmovFrom moves the floating value of type ty in freg to reg. The bits of
+// a float are zero-extended, as everywhere else.
+func (g *riscv64) fmovFrom(reg, freg string, ty ir.Type) {
+	if ty == ir.F32 {
+		g.emit(fmt.Sprintf("  fmv.x.w %s, %s", reg, freg))
+		g.extend(reg, ir.U32)
+	} else {
+		g.emit(fmt.Sprintf("  fmv.x.d %s, %s", reg, freg))
+	}
+}
+
+// floatSuffixRISCV64 returns the suffix of the floating-point instructions
+// on values of type ty.
+func floatSuffixRISCV64(ty ir.Type) string {
+	if ty == ir.F32 {
+		return "s"
+	}
+	return "d"
+}
+
+func (g *riscv64) emitInst(in *ir.Inst) error {
+	switch in.Op {
+	case ir.Const:
+		val := in.Imm
+		switch in.Ty {
+		case ir.F32:
+			val = int64(math.Float32bits(float32(in.FImm)))
+		case ir.F64:
+			val = int64(math.Float64bits(in.FImm))
+		}
+		if reg := g.loc(in.Dst); reg != "" {
+			g.emit(fmt.Sprintf("  li %s, %d", reg, val))
+			return nil
+		}
+		g.emit(fmt.Sprintf("  li t0, %d", val))
+		g.storeReg(in.Dst, "t0")
+	case ir.Mov:
+		switch {
+		case g.loc(in.Dst) != "":
+			g.loadReg(g.loc(in.Dst), in.Args[0])
+		case g.loc(in.Args[0]) != "":
+			g.storeReg(in.Dst, g.loc(in.Args[0]))
+		default:
+			g.loadReg("t0", in.Args[0])
+			g.storeReg(in.Dst, "t0")
+		}
+	case ir.Add, ir.Sub, ir.Mul, ir.Div, ir.Shl, ir.Shr, ir.And, ir.Or:
+		g.loadReg("t0", in.Args[0])
+		g.loadReg("t1", in.Args[1])
+		if in.Ty.IsFloat() {
+			g.emitFloatBinary(in)
+		} else {
+			g.emitIntBinary(in)
+		}
+		g.storeReg(in.Dst, "t0")
+	case ir.Eq, ir.Ne, ir.Lt, ir.Le:
+		g.loadReg("t0", in.Args[0])
+		g.loadReg("t1", in.Args[1])
+		g.emitCompare(in)
+		g.storeReg(in.Dst, "t0")
+	case ir.Conv:
+		g.loadReg("t0", in.Args[0])
+		g.emitConv(g.fn.Regs[in.Args[0]], in.Ty)
+		g.storeReg(in.Dst, "t0")
+	case ir.SlotAddr:
+		g.addImm("t0", "s0", in.Imm-int64(in.Slot.Offset))
+		g.storeReg(in.Dst, "t0")
+	case ir.Global:
+		if g.defined[in.Sym] {
+			sym := in.Sym
+			if in.Imm != 0 {
+				sym = fmt.Sprintf("%s%+d", in.Sym, in.Imm)
+			}
+			g.emit(fmt.Sprintf("  lla t0, %s", sym))
+		} else {
+			// defined in another file, possibly a shared library
+			label := g.newLabel()
+			g.emit(fmt.Sprintf(".Lgot%d:", label))
+			g.emit(fmt.Sprintf("  auipc t0, %%got_pcrel_hi(%s)", in.Sym))
+			g.emit(fmt.Sprintf("  ld t0, %%pcrel_lo(.Lgot%d)(t0)", label))
+			if in.Imm != 0 {
+				g.addImm("t0", "t0", in.Imm)
+			}
+		}
+		g.storeReg(in.Dst, "t0")
+	case ir.Load:
+		g.loadReg("t0", in.Args[0])
+		g.load("t0", in.Ty)
+		g.storeReg(in.Dst, "t0")
+	case ir.Store:
+		g.loadReg("t0", in.Args[0])
+		g.loadReg("t1", in.Args[1])
+		g.emit(fmt.Sprintf("  %s t1, 0(t0)", storeOpRISCV64[in.Ty.Size()]))
+	case ir.MemCopy:
+		g.loadReg("t0", in.Args[0])
+		g.loadReg("t1", in.Args[1])
+		// copy 8 bytes at a time in a loop, then the rest, advancing both
+		// addresses
+		n := int(in.Imm)
+		if n >= 8 {
+			label := g.newLabel()
+			g.emit(fmt.Sprintf("  li t5, %d", n/8))
+			g.emit(fmt.Sprintf(".Lcopy%d:", label))
+			g.emit("  ld t2, 0(t1)")
+			g.emit("  sd t2, 0(t0)")
+			g.emit("  addi t1, t1, 8")
+			g.emit("  addi t0, t0, 8")
+			g.emit("  addi t5, t5, -1")
+			g.emit(fmt.Sprintf("  bnez t5, .Lcopy%d", label))
+			n %= 8
+		}
+		off := 0
+		for _, size := range []int{4, 2, 1} {
+			for ; n >= size; n -= size {
+				g.emit(fmt.Sprintf("  %s t2, %d(t1)", loadOpRISCV64[size], off))
+				g.emit(fmt.Sprintf("  %s t2, %d(t0)", storeOpRISCV64[size], off))
+				off += size
+			}
+		}
+	case ir.MemZero:
+		g.loadReg("t0", in.Args[0])
+		n := int(in.Imm)
+		if n >= 8 {
+			label := g.newLabel()
+			g.emit(fmt.Sprintf("  li t1, %d", n/8))
+			g.emit(fmt.Sprintf(".Lzero%d:", label))
+			g.emit("  sd zero, 0(t0)")
+			g.emit("  addi t0, t0, 8")
+			g.emit("  addi t1, t1, -1")
+			g.emit(fmt.Sprintf("  bnez t1, .Lzero%d", label))
+			n %= 8
+		}
+		off := 0
+		for _, size := range []int{4, 2, 1} {
+			for ; n >= size; n -= size {
+				g.emit(fmt.Sprintf("  %s zero, %d(t0)", storeOpRISCV64[size], off))
+				off += size
+			}
+		}
+	case ir.Alloca:
+		// Allocate below everything else on the stack. Rounding the size
+		// keeps sp aligned. The epilogue restores sp from s0, which frees
+		// the memory, unless a SetSP frees it earlier.
+		g.loadReg("t0", in.Args[0])
+		g.emit("  addi t0, t0, 15")
+		g.emit("  andi t0, t0, -16")
+		g.emit("  sub sp, sp, t0")
+		g.emit("  mv t0, sp")
+		g.storeReg(in.Dst, "t0")
+	case ir.GetSP:
+		g.emit("  mv t0, sp")
+		g.storeReg(in.Dst, "t0")
+	case ir.SetSP:
+		g.loadReg("t0", in.Args[0])
+		g.emit("  mv sp, t0")
+	case ir.Call:
+		g.emitCall(in)
+	case ir.VaStart:
+		// copy the pointer set up in the prologue
+		g.loadReg("t1", in.Args[0])
+		g.mem("ld", "t0", "s0", -g.fn.VaArea.Offset)
+		g.emit("  sd t0, 0(t1)")
+	case ir.VaArg:
+		// every argument takes 8 bytes, in the saved registers or on the
+		// stack
+		g.loadReg("t1", in.Args[0])
+		g.emit("  ld t0, 0(t1)")
+		g.emit("  addi t2, t0, 8")
+		g.emit("  sd t2, 0(t1)")
+		g.storeReg(in.Dst, "t0")
+	case ir.Asm:
+		return g.emitAsm(in)
+	case ir.Jmp:
+		g.emit(fmt.Sprintf("  j %s", g.blockLabel(in.Targets[0])))
+	case ir.Br:
+		g.loadReg("t0", in.Args[0])
+		g.emit(fmt.Sprintf("  bnez t0, %s", g.blockLabel(in.Targets[0])))
+		g.emit(fmt.Sprintf("  j %s", g.blockLabel(in.Targets[1])))
+	case ir.Ret:
+		if len(in.Args) > 0 {
+			g.loadReg("a0", in.Args[0])
+			switch ty := g.fn.RetTy; {
+			case ty.IsFloat():
+				g.fmovTo("fa0", "a0", ty)
+			case ty == ir.U32:
+				// 32-bit values are sign-extended whatever their type
+				g.emit("  sext.w a0, a0")
+			}
+		}
+		g.emitEpilogue()
+		g.emit("  ret")
+	default:
+		return fmt.Errorf("unsupported instruction %s", in)
+	}
+	return nil
+}
+
+// emitIntBinary applies a binary operator to the integers in t0 and t1,
+// leaving the result in t0. The shifts take the count modulo 64, as on
+// x86-64.
+func (g *riscv64) emitIntBinary(in *ir.Inst) {
+	op := map[ir.Op]string{ir.Add: "add", ir.Sub: "sub", ir.Mul: "mul", ir.Shl: "sll", ir.And: "and", ir.Or: "or"}[in.Op]
+	switch {
+	case in.Op == ir.Div && in.Ty.IsSigned():
+		op = "div"
+	case in.Op == ir.Div:
+		op = "divu"
+	case in.Op == ir.Shr && in.Ty.IsSigned():
+		op = "sra"
+	case in.Op == ir.Shr:
+		op = "srl"
+	}
+	g.emit(fmt.Sprintf("  %s t0, t0, t1", op))
+	g.extend("t0", in.Ty)
+}
+
+// emitFloatBinary applies a binary operator to the floating values in t0
+// and t1, leaving the result in t0.
+func (g *riscv64) emitFloatBinary(in *ir.Inst) {
+	g.fmovTo("ft0", "t0", in.Ty)
+	g.fmovTo("ft1", "t1", in.Ty)
+	op := map[ir.Op]string{ir.Add: "fadd", ir.Sub: "fsub", ir.Mul: "fmul", ir.Div: "fdiv"}[in.Op]
+	g.emit(fmt.Sprintf("  %s.%s ft0, ft0, ft1", op, floatSuffixRISCV64(in.Ty)))
+	g.fmovFrom("t0", "ft0", in.Ty)
+}
+
+// emitCompare compares the values of type in.Ty in t0 and t1, leaving 1 in
+// t0 if the comparison holds and 0 otherwise. The floating-point
+// comparisons are false with a NaN, so != is the negation of ==.
+func (g *riscv64) emitCompare(in *ir.Inst) {
+	if in.Ty.IsFloat() {
+		g.fmovTo("ft0", "t0", in.Ty)
+		g.fmovTo("ft1", "t1", in.Ty)
+		op := map[ir.Op]string{ir.Eq: "feq", ir.Ne: "feq", ir.Lt: "flt", ir.Le: "fle"}[in.Op]
+		g.emit(fmt.Sprintf("  %s.%s t0, ft0, ft1", op, floatSuffixRISCV64(in.Ty)))
+		if in.Op == ir.Ne {
+			g.emit("  xori t0, t0, 1")
+		}
+		return
+	}
+
+	slt := "sltu"
+	if in.Ty.IsSigned() {
+		slt = "slt"
+	}
+	switch in.Op {
+	case ir.Eq:
+		g.emit("  sub t0, t0, t1")
+		g.emit("  seqz t0, t0")
+	case ir.Ne:
+		g.emit("  sub t0, t0, t1")
+		g.emit("  snez t0, t0")
+	case ir.Lt:
+		g.emit(fmt.Sprintf("  %s t0, t0, t1", slt))
+	case ir.Le:
+		g.emit(fmt.Sprintf("  %s t0, t1, t0", slt))
+		g.emit("  xori t0, t0, 1")
+	}
+}
+
+// emitConv converts the value in t0 from one type to another. Integers
+// narrower than 64 bits are already extended, so only unsigned 64-bit
+// values need the unsigned conversions. Conversions to integers truncate
+// toward zero.
+func (g *riscv64) emitConv(from, to ir.Type) {
+	switch {
+	case from.IsFloat() && to.IsFloat():
+		if from == to {
+			return
+		}
+		g.fmovTo("ft0", "t0", from)
+		g.emit(fmt.Sprintf("  fcvt.%s.%s ft0, ft0", floatSuffixRISCV64(to), floatSuffixRISCV64(from)))
+		g.fmovFrom("t0", "ft0", to)
+	case from.IsFloat():
+		g.fmovTo("ft0", "t0", from)
+		if to == ir.U64 {
+			g.emit(fmt.Sprintf("  fcvt.lu.%s t0, ft0, rtz", floatSuffixRISCV64(from)))
+			return
+		}
+		g.emit(fmt.Sprintf("  fcvt.l.%s t0, ft0, rtz", floatSuffixRISCV64(from)))
+		g.extend("t0", to)
+	case to.IsFloat():
+		op := "l"
+		if from == ir.U64 {
+			op = "lu"
+		}
+		g.emit(fmt.Sprintf("  fcvt.%s.%s ft0, t0", floatSuffixRISCV64(to), op))
+		g.fmovFrom("t0", "ft0", to)
+	default:
+		g.extend("t0", to)
+	}
+}
+
+// emitEpilogue restores the callee-saved registers and removes the frame,
+// with the save area of a variadic function.
+func (g *riscv64) emitEpilogue() {
+	for _, reg := range g.alloc.order {
+		g.mem("ld", reg, "s0", -g.alloc.saved[reg])
+	}
+	g.emit("  mv sp, s0")
+	g.emit("  ld ra, 8(sp)")
+	g.emit("  ld s0, 0(sp)")
+	g.emit(fmt.Sprintf("  addi sp, sp, %d", g.argBase))
+}
+
+// argLoc is where an argument of a call is passed: in reg, an integer or
+// floating-point argument register, or else on the stack.
+type argLoc struct {
+	reg   string
+	float bool // reg is a floating-point register
+}
+
+// callArgs returns the arguments of the call in, where each of them is
+// passed, and how many are passed on the stack. Floating-point arguments
+// left when fa0-fa7 are used up, and all the variadic arguments, are passed
+// like integers.
+func (g *riscv64) callArgs(in *ir.Inst) (args []ir.Reg, locs []argLoc, stackArgs int) {
+	args = in.Args
+	if in.Sym == "" {
+		args = args[1:]
+	}
+
+	locs = make([]argLoc, len(args))
+	gp, fp := 0, 0
+	for i, arg := range args {
+		variadic := in.Fixed > 0 && i >= in.Fixed
+		switch {
+		case g.fn.Regs[arg].IsFloat() && !variadic && fp < floatArgRegsRISCV64:
+			locs[i] = argLoc{fmt.Sprintf("fa%d", fp), true}
+			fp++
+		case gp < len(argRegsRISCV64):
+			locs[i] = argLoc{reg: argRegsRISCV64[gp]}
+			gp++
+		default:
+			stackArgs++
+		}
+	}
+	return args, locs, stackArgs
+}
+
+// loadArg loads the argument arg into reg, sign-extending unsigned 32-bit
+// integers as the LP64D convention wants all 32-bit values.
+func (g *riscv64) loadArg(reg string, arg ir.Reg) {
+	g.loadReg(reg, arg)
+	if g.fn.Regs[arg] == ir.U32 {
+		g.emit(fmt.Sprintf("  sext.w %s, %s", reg, reg))
+	}
+}
+
+// storeStackArgs stores the arguments passed on the stack at base plus
+// off, in 8-byte slots.
+func (g *riscv64) storeStackArgs(args []ir.Reg, locs []argLoc, base string, off int) {
+	for i, arg := range args {
+		if locs[i].reg == "" {
+			g.loadArg("t0", arg)
+			g.mem("sd", "t0", base, off)
+			off += 8
+		}
+	}
+}
+
+// loadRegArgs loads the arguments that are not passed on the stack into
+// their registers. The floating ones go through t5.
+func (g *riscv64) loadRegArgs(args []ir.Reg, locs []argLoc) {
+	for i, arg := range args {
+		switch loc := locs[i]; {
+		case loc.float:
+			g.loadReg("t5", arg)
+			g.fmovTo(loc.reg, "t5", g.fn.Regs[arg])
+		case loc.reg != "":
+			g.loadArg(loc.reg, arg)
+		}
+	}
+}
+
+// emitCall calls a function following the LP64D convention.
+func (g *riscv64) emitCall(in *ir.Inst) {
+	args, locs, stackArgs := g.callArgs(in)
+
+	// sp must stay 16-byte aligned
+	size := (8*stackArgs + 15) / 16 * 16
+	if size > 0 {
+		g.addImm("sp", "sp", int64(-size))
+	}
+	g.storeStackArgs(args, locs, "sp", 0)
+	g.loadRegArgs(args, locs)
+	if in.Sym == "" {
+		g.loadReg("t5", in.Args[0])
+		g.emit("  jalr t5")
+	} else {
+		g.emit(fmt.Sprintf("  call %s", in.Sym))
+	}
+	if size > 0 {
+		g.addImm("sp", "sp", int64(size))
+	}
+
+	if in.Ty == ir.Void {
+		return
+	}
+	if in.Ty.IsFloat() {
+		g.fmovFrom("a0", "fa0", in.Ty)
+	} else {
+		g.extend("a0", in.Ty)
+	}
+	g.storeReg(in.Dst, "a0")
+}
+
+// emitTailCall emits the tail call in as a jump to the callee after
+// removing the frame, so that the callee returns to the caller of the
+// function. The stack arguments are stored over those of the function,
+// so it returns false, emitting nothing, if there are more of them.
+func (g *riscv64) emitTailCall(in *ir.Inst) bool {
+	args, locs, stackArgs := g.callArgs(in)
+	if stackArgs > g.stackArg {
+		return false
+	}
+
+	// the arguments of the function were copied to their registers on
+	// entry, so their places can be overwritten
+	g.storeStackArgs(args, locs, "s0", g.argBase)
+	g.loadRegArgs(args, locs)
+	if in.Sym == "" {
+		g.loadReg("t5", in.Args[0])
+	}
+	g.emitEpilogue()
+	if in.Sym == "" {
+		g.emit("  jr t5")
+	} else {
+		g.emit(fmt.Sprintf("  tail %s", in.Sym))
+	}
+	return true
+}
+
+// asmRegsRISCV64 are the registers given to register operands of inline
+// assembly, in order. No value is kept in them across an asm statement,
+// see allocate, so they are free for the statement.
+var asmRegsRISCV64 = []string{"a0", "a1", "a2", "a3", "a4", "a5", "a6", "a7", "t0", "t1", "t2", "t3", "t4"}
+
+// emitAsm emits an inline assembly statement as the x86-64 backend does.
+// Clobbered callee-saved registers, s1-s11 and fs0-fs11, are preserved
+// around the statement.
+func (g *riscv64) emitAsm(in *ir.Inst) error {
+	asm := in.Asm
+	operands := append(append([]*parser.AsmOperand{}, asm.Outputs...), asm.Inputs...)
+
+	clobbered := map[string]bool{}
+	for _, reg := range asm.Clobbers {
+		clobbered[reg] = true
+	}
+	var free []string
+	for _, reg := range asmRegsRISCV64 {
+		if !clobbered[reg] {
+			free = append(free, reg)
+		}
+	}
+	regs := make([]string, len(operands))
+	for i, op := range operands {
+		if op.Constraint == parser.ASM_IMM {
+			continue
+		}
+		if len(free) == 0 {
+			return fmt.Errorf("asm operands need more registers than are available")
+		}
+		regs[i], free = free[0], free[1:]
+	}
+
+	var saved []string
+	for _, reg := range asm.Clobbers {
+		switch {
+		case slices.Contains(riscv64Regs.calleeSaved, reg):
+			g.emit("  addi sp, sp, -16")
+			g.emit(fmt.Sprintf("  sd %s, 0(sp)", reg))
+		case strings.HasPrefix(reg, "fs"):
+			g.emit("  addi sp, sp, -16")
+			g.emit(fmt.Sprintf("  fsd %s, 0(sp)", reg))
+		default:
+			continue
+		}
+		saved = append(saved, reg)
+	}
+
+	// the operands of in hold the values of register inputs and the
+	// addresses of the others
+	for i, op := range operands {
+		if op.Constraint == parser.ASM_IMM {
+			continue
+		}
+		g.loadReg(regs[i], in.Args[i])
+		if i < len(asm.Outputs) && op.Constraint == parser.ASM_REG && op.InOut {
+			g.emit(fmt.Sprintf("  %s %s, 0(%s)", loadOpRISCV64[op.Expr.Ty.Size], regs[i], regs[i]))
+		}
+	}
+
+	var sb strings.Builder
+	for _, piece := range asm.Template {
+		if piece.Operand < 0 {
+			sb.WriteString(piece.Text)
+		} else {
+			sb.WriteString(asmOperandTextRISCV64(operands[piece.Operand], regs[piece.Operand]))
+		}
+	}
+	g.emit("#APP")
+	for _, line := range strings.Split(sb.String(), "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			g.emit("  " + line)
+		}
+	}
+	g.emit("#NO_APP")
+
+	// The addresses of the outputs are not in the asm registers, so each
+	// output is stored through t6 without disturbing the others.
+	for i, op := range asm.Outputs {
+		if op.Constraint != parser.ASM_REG {
+			continue
+		}
+		g.loadReg("t6", in.Args[i])
+		g.emit(fmt.Sprintf("  %s %s, 0(t6)", storeOpRISCV64[op.Expr.Ty.Size], regs[i]))
+	}
+	for i := len(saved) - 1; i >= 0; i-- {
+		if strings.HasPrefix(saved[i], "fs") {
+			g.emit(fmt.Sprintf("  fld %s, 0(sp)", saved[i]))
+		} else {
+			g.emit(fmt.Sprintf("  ld %s, 0(sp)", saved[i]))
+		}
+		g.emit("  addi sp, sp, 16")
+	}
+	return nil
+}
+
+// asmOperandTextRISCV64 returns how the operand op, given the register reg,
+// is written in an asm template. There are no narrower register names, and
+// a memory operand is written as an offset from its address.
+func asmOperandTextRISCV64(op *parser.AsmOperand, reg string) string {
+	switch op.Constraint {
+	case parser.ASM_IMM:
+		return fmt.Sprintf("%d", op.Val)
+	case parser.ASM_MEM:
+		return fmt.Sprintf("0(%s)", reg)
+	}
+	return reg
+}
@@ -0,0 +1,77 @@
+package generator
+
+import (
+	"fmt"
+
+	"rkitamu/gocc/ir"
+)
+
+// Layout of the register save area of a variadic function: a va_list
+// element followed by the registers that may hold arguments, as the System
+// V ABI specifies for the reg_save_area.
+const (
+	vaGpOffset   = 0  // offset of the next integer register argument
+	vaFpOffset   = 4  // offset of the next floating-point register argument
+	vaOverflow   = 8  // address of the next stack argument
+	vaRegSave    = 16 // address of the saved registers
+	vaHeaderSize = 24
+
+	vaGpSize = 8 * 6  // rdi, rsi, rdx, rcx, r8, r9
+	vaFpSize = 16 * 8 // xmm0-xmm7
+)
+
+// saveVaArea saves the argument registers of a variadic function and sets
+// up the va_list element that va_start copies. gp, fp and stack are the
+// numbers of named parameters passed in each place.
+func (g *Generator) saveVaArea(offset, gp, fp, stack int) {
+	g.emit(fmt.Sprintf("  mov dword ptr [rbp-%d], %d", offset-vaGpOffset, gp*8))
+	g.emit(fmt.Sprintf("  mov dword ptr [rbp-%d], %d", offset-vaFpOffset, vaGpSize+fp*16))
+	g.emit(fmt.Sprintf("  lea rax, [rbp+%d]", 16+8*stack))
+	g.emit(fmt.Sprintf("  mov [rbp-%d], rax", offset-vaOverflow))
+	g.emit(fmt.Sprintf("  lea rax, [rbp-%d]", offset-vaHeaderSize))
+	g.emit(fmt.Sprintf("  mov [rbp-%d], rax", offset-vaRegSave))
+
+	save := offset - vaHeaderSize
+	for i, reg := range argReg64 {
+		g.emit(fmt.Sprintf("  mov [rbp-%d], %s", save-8*i, reg))
+	}
+	for i := 0; i < floatArgRegs; i++ {
+		g.emit(fmt.Sprintf("  movsd qword ptr [rbp-%d], xmm%d", save-vaGpSize-16*i, i))
+	}
+}
+
+// emitVaStart copies the va_list element set up by saveVaArea to the
+// va_list operand of va_start.
+func (g *Generator) emitVaStart(in *ir.Inst) {
+	g.loadReg("rax", in.Args[0])
+	for i := 0; i < vaHeaderSize; i += 8 {
+		g.emit(fmt.Sprintf("  mov rdx, [rbp-%d]", g.fn.VaArea.Offset-i))
+		g.emit(fmt.Sprintf("  mov [rax+%d], rdx", i))
+	}
+}
+
+// emitVaArg computes the address of the next argument of a va_list, in the
+// saved registers while there are any left of its class, otherwise on the
+// stack.
+func (g *Generator) emitVaArg(in *ir.Inst) {
+	g.loadReg("rdx", in.Args[0])
+
+	// integers are in rdi-r9, floating-point values in xmm0-xmm7
+	field, limit, step := vaGpOffset, vaGpSize, 8
+	if in.Ty.IsFloat() {
+		field, limit, step = vaFpOffset, vaGpSize+vaFpSize, 16
+	}
+
+	label := g.newLabel()
+	g.emit(fmt.Sprintf("  mov eax, dword ptr [rdx+%d]", field))
+	g.emit(fmt.Sprintf("  cmp eax, %d", limit))
+	g.emit(fmt.Sprintf("  jae .Lva_stack%d", label))
+	g.emit(fmt.Sprintf("  add rax, [rdx+%d]", vaRegSave))
+	g.emit(fmt.Sprintf("  add dword ptr [rdx+%d], %d", field, step))
+	g.emit(fmt.Sprintf("  jmp .Lva_end%d", label))
+	g.emit(fmt.Sprintf(".Lva_stack%d:", label))
+	g.emit(fmt.Sprintf("  mov rax, [rdx+%d]", vaOverflow))
+	g.emit(fmt.Sprintf("  add qword ptr [rdx+%d], 8", vaOverflow))
+	g.emit(fmt.Sprintf(".Lva_end%d:", label))
+	g.storeReg(in.Dst, "rax")
+}
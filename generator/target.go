@@ -0,0 +1,82 @@
+package generator
+
+import (
+	"fmt"
+	"strings"
+
+	"rkitamu/gocc/ir"
+	"rkitamu/gocc/parser"
+)
+
+// Target is a machine, with its ABI and assembler syntax, that assembly can
+// be generated for.
+type Target struct {
+	Triple string      // the name given to -target
+	Arch   parser.Arch // what the front end needs to know of the target
+
+	// Peephole optimizes the generated assembly, or is nil if the target
+	// has no peephole pass.
+	Peephole func(asm string) string
+
+	generate func(prog *ir.Program) (string, error)
+}
+
+var (
+	X86_64 = &Target{
+		Triple:   "x86_64-linux-gnu",
+		Arch:     parser.ARCH_X86_64,
+		Peephole: Peephole,
+		generate: func(prog *ir.Program) (string, error) { return NewGenerator().GenerateProgram(prog) },
+	}
+	AArch64 = &Target{
+		Triple:   "aarch64-linux-gnu",
+		Arch:     parser.ARCH_AARCH64,
+		generate: func(prog *ir.Program) (string, error) { return newAArch64().generateProgram(prog) },
+	}
+	RISCV64 = &Target{
+		Triple:   "riscv64-linux-gnu",
+		Arch:     parser.ARCH_RISCV64,
+		generate: func(prog *ir.Program) (string, error) { return newRISCV64().generateProgram(prog) },
+	}
+)
+
+// Targets lists the supported targets, the default first.
+var Targets = []*Target{X86_64, AArch64, RISCV64}
+
+// LookupTarget returns the target named by triple.
+func LookupTarget(triple string) (*Target, error) {
+	for _, t := range Targets {
+		if t.Triple == triple {
+			return t, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown target %q", triple)
+}
+
+// Generate generates assembly for every global variable and function of
+// prog.
+func (t *Target) Generate(prog *ir.Program) (string, error) {
+	return t.generate(prog)
+}
+
+// writer holds the assembly text being generated, with what the backends
+// of all targets need to produce it.
+type writer struct {
+	sb       *strings.Builder
+	labelSeq int
+	defined  map[string]bool // globals and functions defined in this file
+}
+
+func newWriter() writer {
+	return writer{sb: &strings.Builder{}}
+}
+
+func (w *writer) emit(line string) {
+	fmt.Fprintln(w.sb, line)
+}
+
+func (w *writer) newLabel() int {
+	label := w.labelSeq
+	w.labelSeq++
+	return label
+}
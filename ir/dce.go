@@ -0,0 +1,61 @@
+package ir
+
+// DCE removes the instructions of f whose results are never used by an
+// instruction with an effect beyond its result, such as a store, a call or
+// a branch. Loads are removed too unless they are volatile.
+func DCE(f *Func) {
+	defs := map[Reg][]*Inst{}
+	for _, b := range f.Blocks {
+		for _, in := range b.Insts {
+			if in.HasResult() {
+				defs[in.Dst] = append(defs[in.Dst], in)
+			}
+		}
+	}
+
+	live := map[*Inst]bool{}
+	var work []*Inst
+	for _, b := range f.Blocks {
+		for _, in := range b.Insts {
+			if hasEffect(in) {
+				live[in] = true
+				work = append(work, in)
+			}
+		}
+	}
+	for len(work) > 0 {
+		in := work[len(work)-1]
+		work = work[:len(work)-1]
+		for _, r := range in.Args {
+			for _, def := range defs[r] {
+				if !live[def] {
+					live[def] = true
+					work = append(work, def)
+				}
+			}
+		}
+	}
+
+	for _, b := range f.Blocks {
+		insts := b.Insts[:0]
+		for _, in := range b.Insts {
+			if live[in] {
+				insts = append(insts, in)
+			}
+		}
+		b.Insts = insts
+	}
+}
+
+// hasEffect reports whether in must be kept even if its result is unused.
+func hasEffect(in *Inst) bool {
+	switch in.Op {
+	case Load:
+		return in.Volatile
+	case Alloca, GetSP, VaArg:
+		// Alloca moves the stack pointer, which GetSP must read in
+		// place, and VaArg advances the va_list
+		return true
+	}
+	return !isPure(in.Op)
+}
@@ -0,0 +1,144 @@
+package ir
+
+import "slices"
+
+// Preds returns the predecessors of each block of f, indexed by block ID,
+// in the order of the blocks and their successors.
+func Preds(f *Func) [][]*Block {
+	preds := make([][]*Block, len(f.Blocks))
+	for _, b := range f.Blocks {
+		for _, s := range b.Succs() {
+			preds[s.ID] = append(preds[s.ID], b)
+		}
+	}
+	return preds
+}
+
+// DomTree is the dominator tree of a function. A block a dominates b if
+// every path from the entry to b passes through a. All slices are indexed
+// by block ID, so every block must be reachable, see RemoveUnreachable.
+type DomTree struct {
+	Idom     []*Block   // immediate dominator, nil for the entry
+	Children [][]*Block // blocks immediately dominated
+	Frontier [][]*Block // dominance frontier
+	Preds    [][]*Block
+
+	pre, post []int // numbering of a depth-first walk of the tree
+}
+
+// Dominators computes the dominator tree of f with the algorithm of
+// Cooper, Harvey and Kennedy, "A Simple, Fast Dominance Algorithm".
+func Dominators(f *Func) *DomTree {
+	n := len(f.Blocks)
+	t := &DomTree{
+		Idom:     make([]*Block, n),
+		Children: make([][]*Block, n),
+		Frontier: make([][]*Block, n),
+		Preds:    Preds(f),
+	}
+
+	// number the blocks in reverse postorder
+	order := reversePostorder(f)
+	rpo := make([]int, n)
+	for i, b := range order {
+		rpo[b.ID] = i
+	}
+
+	entry := f.Blocks[0]
+	t.Idom[entry.ID] = entry
+	intersect := func(a, b *Block) *Block {
+		for a != b {
+			for rpo[a.ID] > rpo[b.ID] {
+				a = t.Idom[a.ID]
+			}
+			for rpo[b.ID] > rpo[a.ID] {
+				b = t.Idom[b.ID]
+			}
+		}
+		return a
+	}
+	for changed := true; changed; {
+		changed = false
+		for _, b := range order[1:] {
+			var idom *Block
+			for _, p := range t.Preds[b.ID] {
+				if t.Idom[p.ID] == nil {
+					continue
+				}
+				if idom == nil {
+					idom = p
+				} else {
+					idom = intersect(p, idom)
+				}
+			}
+			if t.Idom[b.ID] != idom {
+				t.Idom[b.ID] = idom
+				changed = true
+			}
+		}
+	}
+	t.Idom[entry.ID] = nil
+
+	for _, b := range order[1:] {
+		idom := t.Idom[b.ID]
+		t.Children[idom.ID] = append(t.Children[idom.ID], b)
+	}
+
+	// a join point is in the frontier of every block from its
+	// predecessors up to, but excluding, its immediate dominator
+	for _, b := range f.Blocks {
+		if len(t.Preds[b.ID]) < 2 {
+			continue
+		}
+		for _, p := range t.Preds[b.ID] {
+			for r := p; r != t.Idom[b.ID]; r = t.Idom[r.ID] {
+				if !slices.Contains(t.Frontier[r.ID], b) {
+					t.Frontier[r.ID] = append(t.Frontier[r.ID], b)
+				}
+			}
+		}
+	}
+
+	t.pre = make([]int, n)
+	t.post = make([]int, n)
+	seq := 0
+	var number func(b *Block)
+	number = func(b *Block) {
+		t.pre[b.ID] = seq
+		seq++
+		for _, c := range t.Children[b.ID] {
+			number(c)
+		}
+		t.post[b.ID] = seq
+		seq++
+	}
+	number(entry)
+	return t
+}
+
+// Dominates reports whether a dominates b. Every block dominates itself.
+func (t *DomTree) Dominates(a, b *Block) bool {
+	return t.pre[a.ID] <= t.pre[b.ID] && t.post[b.ID] <= t.post[a.ID]
+}
+
+// reversePostorder returns the blocks of f reachable from the entry in
+// reverse postorder, in which a block comes before its successors except
+// along back edges.
+func reversePostorder(f *Func) []*Block {
+	visited := make([]bool, len(f.Blocks))
+	var post []*Block
+	var visit func(b *Block)
+	visit = func(b *Block) {
+		visited[b.ID] = true
+		for _, s := range b.Succs() {
+			if !visited[s.ID] {
+				visit(s)
+			}
+		}
+		post = append(post, b)
+	}
+	visit(f.Blocks[0])
+
+	slices.Reverse(post)
+	return post
+}
@@ -0,0 +1,167 @@
+package ir
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Dump returns the textual form of the program.
+func Dump(prog *Program) string {
+	var sb strings.Builder
+	for i, f := range prog.Funcs {
+		if i > 0 {
+			sb.WriteString("\n")
+		}
+		sb.WriteString(DumpFunc(f))
+	}
+	return sb.String()
+}
+
+// DumpFunc returns the textual form of f, such as
+//
+//	func @add(i32 %1, i32 %2) i32 {
+//	  $0 a size 4 align 4 offset 4
+//	b0:
+//	  %3 = slot $0
+//	  store i32 %3, %1
+//	  ...
+//	  ret i32 %7
+//	}
+func DumpFunc(f *Func) string {
+	var sb strings.Builder
+	params := make([]string, len(f.Params))
+	for i, r := range f.Params {
+		params[i] = fmt.Sprintf("%s %s", f.typeOf(r), r)
+	}
+	if f.Variadic {
+		params = append(params, "...")
+	}
+	linkage := ""
+	if f.IsStatic {
+		linkage = "static "
+	}
+	if f.IsInlineDef {
+		linkage = "inline "
+	}
+	fmt.Fprintf(&sb, "%sfunc @%s(%s) %s {\n", linkage, f.Name, strings.Join(params, ", "), f.RetTy)
+
+	for _, s := range f.Slots {
+		fmt.Fprintf(&sb, "  $%d %s size %d align %d offset %d\n", s.ID, s.Name, s.Size, s.Align, s.Offset)
+	}
+	for _, b := range f.Blocks {
+		fmt.Fprintf(&sb, "%s:\n", b)
+		for _, in := range b.Insts {
+			fmt.Fprintf(&sb, "  %s\n", in)
+		}
+	}
+	sb.WriteString("}\n")
+	return sb.String()
+}
+
+func (f *Func) typeOf(r Reg) Type {
+	if int(r) < len(f.Regs) {
+		return f.Regs[r]
+	}
+	return Void
+}
+
+func (r Reg) String() string {
+	return fmt.Sprintf("%%%d", int(r))
+}
+
+func (b *Block) String() string {
+	return fmt.Sprintf("b%d", b.ID)
+}
+
+func (in *Inst) String() string {
+	args := make([]string, len(in.Args))
+	for i, r := range in.Args {
+		args[i] = r.String()
+	}
+
+	var s string
+	switch in.Op {
+	case Const:
+		if in.Ty.IsFloat() {
+			s = fmt.Sprintf("const %s %g", in.Ty, in.FImm)
+		} else {
+			s = fmt.Sprintf("const %s %d", in.Ty, in.Imm)
+		}
+	case SlotAddr:
+		s = fmt.Sprintf("slot $%d%s", in.Slot.ID, offsetString(in.Imm))
+	case Global:
+		s = fmt.Sprintf("global @%s%s", in.Sym, offsetString(in.Imm))
+	case Conv:
+		s = fmt.Sprintf("conv %s to %s", args[0], in.Ty)
+	case MemCopy, MemZero:
+		s = fmt.Sprintf("%s %s, %d", in.Op, strings.Join(args, ", "), in.Imm)
+	case Alloca, SetSP, VaStart:
+		s = fmt.Sprintf("%s %s", in.Op, args[0])
+	case GetSP:
+		s = in.Op.String()
+	case Call:
+		callee := "@" + in.Sym
+		if in.Sym == "" {
+			callee, args = args[0], args[1:]
+		}
+		// the variadic arguments follow an ellipsis
+		if in.Fixed > 0 {
+			args = append(append(args[:in.Fixed:in.Fixed], "..."), args[in.Fixed:]...)
+		}
+		s = fmt.Sprintf("call %s %s(%s)", in.Ty, callee, strings.Join(args, ", "))
+	case Asm:
+		s = fmt.Sprintf("asm %q(%s)", asmTemplate(in), strings.Join(args, ", "))
+	case Phi:
+		incoming := make([]string, len(args))
+		for i, a := range args {
+			incoming[i] = fmt.Sprintf("[%s, %s]", a, in.Targets[i])
+		}
+		s = fmt.Sprintf("phi %s %s", in.Ty, strings.Join(incoming, ", "))
+	case Jmp:
+		s = fmt.Sprintf("jmp %s", in.Targets[0])
+	case Br:
+		s = fmt.Sprintf("br %s, %s, %s", args[0], in.Targets[0], in.Targets[1])
+	case Ret:
+		s = "ret"
+		if len(args) > 0 {
+			s = fmt.Sprintf("ret %s", args[0])
+		}
+	default:
+		s = fmt.Sprintf("%s %s %s", in.Op, in.Ty, strings.Join(args, ", "))
+	}
+	if in.Volatile {
+		s = "volatile " + s
+	}
+	if in.Tail {
+		s = "tail " + s
+	}
+	if in.HasResult() {
+		s = fmt.Sprintf("%s = %s", in.Dst, s)
+	}
+	return s
+}
+
+func offsetString(off int64) string {
+	if off == 0 {
+		return ""
+	}
+	return fmt.Sprintf("%+d", off)
+}
+
+// asmTemplate returns the template of an Asm instruction with the operands
+// written as %N.
+func asmTemplate(in *Inst) string {
+	var sb strings.Builder
+	for _, piece := range in.Asm.Template {
+		if piece.Operand < 0 {
+			sb.WriteString(strings.ReplaceAll(piece.Text, "%", "%%"))
+			continue
+		}
+		sb.WriteByte('%')
+		if piece.Modifier != 0 {
+			sb.WriteByte(piece.Modifier)
+		}
+		fmt.Fprintf(&sb, "%d", piece.Operand)
+	}
+	return sb.String()
+}
@@ -0,0 +1,112 @@
+package ir
+
+import "math"
+
+// valueKey identifies the value computed by a pure instruction from its
+// operands.
+type valueKey struct {
+	op   Op
+	ty   Type
+	a, b Reg
+	imm  int64
+	fimm uint64
+	sym  string
+	slot *Slot
+}
+
+// GVN performs global value numbering on f, which must be in SSA form. A
+// pure instruction that computes the same value as one dominating it is
+// removed, and its uses refer to the earlier result instead. Copies, and
+// Phis that merge a single value, are removed the same way.
+func GVN(f *Func) {
+	RemoveUnreachable(f)
+	dom := Dominators(f)
+	repl := map[Reg]Reg{}
+	find := func(r Reg) Reg {
+		for {
+			to, ok := repl[r]
+			if !ok {
+				return r
+			}
+			r = to
+		}
+	}
+
+	avail := map[valueKey]Reg{}
+	var walk func(b *Block)
+	walk = func(b *Block) {
+		var added []valueKey
+		insts := b.Insts[:0]
+		for _, in := range b.Insts {
+			for i, r := range in.Args {
+				in.Args[i] = find(r)
+			}
+			switch {
+			case in.Op == Mov:
+				repl[in.Dst] = in.Args[0]
+				continue
+			case in.Op == Phi:
+				if r, ok := sameValue(in); ok {
+					repl[in.Dst] = r
+					continue
+				}
+			case isPure(in.Op):
+				key := keyOf(in)
+				if r, ok := avail[key]; ok {
+					repl[in.Dst] = r
+					continue
+				}
+				avail[key] = in.Dst
+				added = append(added, key)
+			}
+			insts = append(insts, in)
+		}
+		b.Insts = insts
+
+		for _, c := range dom.Children[b.ID] {
+			walk(c)
+		}
+		// the values of b are not available outside its subtree
+		for _, key := range added {
+			delete(avail, key)
+		}
+	}
+	walk(f.Blocks[0])
+
+	// the operands of Phis may come from blocks walked later
+	replaceUses(f, repl)
+}
+
+// sameValue reports whether every operand of the Phi in is either a single
+// register or the result of the Phi itself, and returns that register.
+func sameValue(in *Inst) (Reg, bool) {
+	var same Reg
+	for _, r := range in.Args {
+		if r == in.Dst || r == same {
+			continue
+		}
+		if same != 0 {
+			return 0, false
+		}
+		same = r
+	}
+	return same, same != 0
+}
+
+func keyOf(in *Inst) valueKey {
+	key := valueKey{op: in.Op, ty: in.Ty, imm: in.Imm, fimm: math.Float64bits(in.FImm), sym: in.Sym, slot: in.Slot}
+	if len(in.Args) > 0 {
+		key.a = in.Args[0]
+	}
+	if len(in.Args) > 1 {
+		key.b = in.Args[1]
+	}
+	// the operands of commutative operations are put in order
+	switch in.Op {
+	case Add, Mul, And, Or, Eq, Ne:
+		if key.a > key.b {
+			key.a, key.b = key.b, key.a
+		}
+	}
+	return key
+}
@@ -0,0 +1,321 @@
+package ir
+
+import "rkitamu/gocc/parser"
+
+// Inline replaces calls to the functions of prog by copies of their bodies,
+// before the program is put in SSA form. A function is inlined unless it is
+// recursive, variadic, allocates on the stack, or does not match the call,
+// and only if
+//
+//   - it asks to be with __attribute__((always_inline)),
+//   - or, without __attribute__((noinline)) and if limit is not negative,
+//     it is static and called once, or its cost, the number of its
+//     instructions, is at most limit, or four times limit if it is declared
+//     inline.
+//
+// The callees are processed before their callers, so that calls inlined in
+// a callee are inlined along with it. Static functions that were inlined,
+// or that ask to be, are removed once no longer referenced, and inline
+// definitions in any case: the calls left go to the external definition in
+// another file.
+func Inline(prog *Program, limit int) {
+	in := &inliner{funcs: map[string]*Func{}, calls: map[string]int{}, limit: limit, inlined: map[string]bool{}}
+	for _, f := range prog.Funcs {
+		in.funcs[f.Name] = f
+	}
+	for _, f := range prog.Funcs {
+		for _, b := range f.Blocks {
+			for _, i := range b.Insts {
+				if i.Op == Call && i.Sym != "" {
+					in.calls[i.Sym]++
+				}
+			}
+		}
+	}
+
+	for _, f := range in.postorder(prog.Funcs) {
+		in.inlineCalls(f)
+	}
+	in.removeUnused(prog)
+}
+
+type inliner struct {
+	funcs   map[string]*Func // the functions defined in the program
+	calls   map[string]int   // number of call sites of each function
+	limit   int
+	inlined map[string]bool // functions inlined at a call site
+}
+
+// postorder returns funcs ordered so that a function comes after the
+// functions it calls, except along recursive calls.
+func (in *inliner) postorder(funcs []*Func) []*Func {
+	visited := map[*Func]bool{}
+	var order []*Func
+	var visit func(f *Func)
+	visit = func(f *Func) {
+		if visited[f] {
+			return
+		}
+		visited[f] = true
+		for _, callee := range in.callees(f) {
+			visit(callee)
+		}
+		order = append(order, f)
+	}
+	for _, f := range funcs {
+		visit(f)
+	}
+	return order
+}
+
+// callees returns the functions of the program called directly by f.
+func (in *inliner) callees(f *Func) []*Func {
+	var callees []*Func
+	for _, b := range f.Blocks {
+		for _, i := range b.Insts {
+			if callee := in.funcs[i.Sym]; i.Op == Call && callee != nil {
+				callees = append(callees, callee)
+			}
+		}
+	}
+	return callees
+}
+
+// isRecursive reports whether f may call itself, directly or not.
+func (in *inliner) isRecursive(f *Func) bool {
+	visited := map[*Func]bool{}
+	var reaches func(g *Func) bool
+	reaches = func(g *Func) bool {
+		for _, callee := range in.callees(g) {
+			if callee == f {
+				return true
+			}
+			if !visited[callee] {
+				visited[callee] = true
+				if reaches(callee) {
+					return true
+				}
+			}
+		}
+		return false
+	}
+	return reaches(f)
+}
+
+// canInline reports whether callee may replace the call.
+func (in *inliner) canInline(f *Func, call *Inst, callee *Func) bool {
+	if callee.Variadic || len(call.Args) != len(callee.Params) || in.isRecursive(callee) {
+		return false
+	}
+	if call.Ty != Void && call.Ty != callee.RetTy {
+		return false
+	}
+	for i, r := range call.Args {
+		if f.Regs[r] != callee.Regs[callee.Params[i]] {
+			return false
+		}
+	}
+	for _, b := range callee.Blocks {
+		for _, i := range b.Insts {
+			if i.Op == Alloca {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// shouldInline decides by the heuristics whether to inline callee.
+func (in *inliner) shouldInline(callee *Func) bool {
+	switch callee.Inline {
+	case parser.INLINE_ALWAYS:
+		return true
+	case parser.INLINE_NEVER:
+		return false
+	}
+	if in.limit < 0 {
+		return false
+	}
+	if callee.IsStatic && in.calls[callee.Name] == 1 {
+		return true
+	}
+	limit := in.limit
+	if callee.Inline == parser.INLINE_HINT {
+		limit *= 4
+	}
+	return cost(callee) <= limit
+}
+
+func cost(f *Func) int {
+	n := 0
+	for _, b := range f.Blocks {
+		n += len(b.Insts)
+	}
+	return n
+}
+
+// inlineCalls inlines the calls of f chosen by the heuristics.
+func (in *inliner) inlineCalls(f *Func) {
+	for bi := 0; bi < len(f.Blocks); bi++ {
+		for j, call := range f.Blocks[bi].Insts {
+			callee := in.funcs[call.Sym]
+			if call.Op != Call || callee == nil || callee == f {
+				continue
+			}
+			if !in.shouldInline(callee) || !in.canInline(f, call, callee) {
+				continue
+			}
+			in.inlined[callee.Name] = true
+			// the copied blocks need no further inlining, so continue
+			// with the rest of the block after them
+			bi += inlineCall(f, bi, j, callee)
+			break
+		}
+	}
+}
+
+// inlineCall replaces the call at f.Blocks[bi].Insts[j] by a copy of the
+// body of callee, and returns the number of blocks inserted before the
+// block with the instructions after the call.
+//
+// The block of the call jumps to the copy of the entry of callee after
+// moving the arguments to the copies of its parameter registers, and the
+// returns jump to the rest of the block. The copies of the slots of callee
+// are placed below the slots of f, at an offset aligned for all of them. A
+// callee with several returns passes the result through a slot of its own,
+// which SSA form turns into a Phi.
+func inlineCall(f *Func, bi, j int, callee *Func) int {
+	b := f.Blocks[bi]
+	call := b.Insts[j]
+
+	regs := make([]Reg, len(callee.Regs))
+	for r := 1; r < len(callee.Regs); r++ {
+		regs[r] = f.NewReg(callee.Regs[r])
+	}
+	align := 16
+	for _, s := range callee.Slots {
+		align = max(align, s.Align)
+	}
+	base := (f.FrameSize + align - 1) / align * align
+	slots := map[*Slot]*Slot{}
+	for _, s := range callee.Slots {
+		c := *s
+		c.ID = len(f.Slots)
+		c.Offset = base + s.Offset
+		f.Slots = append(f.Slots, &c)
+		slots[s] = &c
+	}
+	f.FrameSize = base + callee.FrameSize
+
+	rest := &Block{Insts: append([]*Inst{}, b.Insts[j+1:]...)}
+	copies := make([]*Block, len(callee.Blocks))
+	blocks := map[*Block]*Block{}
+	for i, cb := range callee.Blocks {
+		copies[i] = &Block{}
+		blocks[cb] = copies[i]
+	}
+
+	var rets []*Inst
+	for _, cb := range callee.Blocks {
+		for _, i := range cb.Insts {
+			if i.Op == Ret {
+				rets = append(rets, i)
+			}
+		}
+	}
+	// the result slot, if the result does not come from a single return
+	var result *Slot
+	if call.Dst != 0 && (len(rets) != 1 || len(rets[0].Args) == 0) {
+		size := call.Ty.Size()
+		f.FrameSize = (f.FrameSize + size + size - 1) / size * size
+		result = &Slot{ID: len(f.Slots), Name: callee.Name + ".result", Size: size, Align: size, Offset: f.FrameSize}
+		f.Slots = append(f.Slots, result)
+	}
+
+	b.Insts = b.Insts[:j:j]
+	for i, r := range call.Args {
+		p := callee.Params[i]
+		b.Insts = append(b.Insts, &Inst{Op: Mov, Ty: callee.Regs[p], Dst: regs[p], Args: []Reg{r}})
+	}
+	b.Insts = append(b.Insts, &Inst{Op: Jmp, Targets: []*Block{copies[0]}})
+
+	for k, cb := range callee.Blocks {
+		c := copies[k]
+		for _, i := range cb.Insts {
+			if i.Op == Ret {
+				if call.Dst != 0 && len(i.Args) > 0 {
+					if result == nil {
+						c.Insts = append(c.Insts, &Inst{Op: Mov, Ty: call.Ty, Dst: call.Dst, Args: []Reg{regs[i.Args[0]]}})
+					} else {
+						addr := f.NewReg(U64)
+						c.Insts = append(c.Insts,
+							&Inst{Op: SlotAddr, Dst: addr, Slot: result},
+							&Inst{Op: Store, Ty: call.Ty, Args: []Reg{addr, regs[i.Args[0]]}})
+					}
+				}
+				c.Insts = append(c.Insts, &Inst{Op: Jmp, Targets: []*Block{rest}})
+				continue
+			}
+
+			ci := *i
+			ci.Dst = regs[i.Dst]
+			ci.Args = make([]Reg, len(i.Args))
+			for n, r := range i.Args {
+				ci.Args[n] = regs[r]
+			}
+			ci.Targets = make([]*Block, len(i.Targets))
+			for n, t := range i.Targets {
+				ci.Targets[n] = blocks[t]
+			}
+			if i.Slot != nil {
+				ci.Slot = slots[i.Slot]
+			}
+			c.Insts = append(c.Insts, &ci)
+		}
+	}
+	if result != nil {
+		addr := f.NewReg(U64)
+		rest.Insts = append([]*Inst{
+			{Op: SlotAddr, Dst: addr, Slot: result},
+			{Op: Load, Ty: call.Ty, Dst: call.Dst, Args: []Reg{addr}},
+		}, rest.Insts...)
+	}
+
+	blocks2 := append([]*Block{}, f.Blocks[:bi+1]...)
+	blocks2 = append(blocks2, copies...)
+	blocks2 = append(blocks2, rest)
+	f.Blocks = append(blocks2, f.Blocks[bi+1:]...)
+	for id, b := range f.Blocks {
+		b.ID = id
+	}
+	return len(copies)
+}
+
+// removeUnused removes the inline definitions, and the static functions
+// that were inlined or ask to be and that are no longer referenced.
+func (in *inliner) removeUnused(prog *Program) {
+	used := map[string]bool{}
+	for _, f := range prog.Funcs {
+		for _, b := range f.Blocks {
+			for _, i := range b.Insts {
+				if i.Op == Call || i.Op == Global {
+					used[i.Sym] = true
+				}
+			}
+		}
+	}
+	for _, g := range prog.Globals {
+		for _, r := range g.Relocs {
+			used[r.Label] = true
+		}
+	}
+
+	funcs := prog.Funcs[:0]
+	for _, f := range prog.Funcs {
+		removable := f.IsStatic && (in.inlined[f.Name] || f.Inline == parser.INLINE_HINT || f.Inline == parser.INLINE_ALWAYS)
+		if !f.IsInlineDef && (!removable || used[f.Name]) {
+			funcs = append(funcs, f)
+		}
+	}
+	prog.Funcs = funcs
+}
@@ -0,0 +1,227 @@
+// Package ir defines the intermediate representation between the AST and
+// the assembly: a typed three-address code. The instructions of a function
+// compute values in an unlimited number of virtual registers, access memory
+// only through explicit loads and stores, and are grouped into basic blocks
+// that end with a branch or a return.
+package ir
+
+import "rkitamu/gocc/parser"
+
+// Type is the type of a value in a virtual register, or of an access to
+// memory. An integer register always holds the full 64-bit value, extended
+// from the width of its type according to its signedness, so an instruction
+// of a narrow integer type truncates its result and extends it back.
+type Type int
+
+const (
+	Void Type = iota
+	I8
+	I16
+	I32
+	I64
+	U8
+	U16
+	U32
+	U64 // also addresses
+	F32
+	F64
+)
+
+var typeNames = [...]string{"void", "i8", "i16", "i32", "i64", "u8", "u16", "u32", "u64", "f32", "f64"}
+
+func (t Type) String() string {
+	return typeNames[t]
+}
+
+// Size returns the size of a value of the type in bytes.
+func (t Type) Size() int {
+	switch t {
+	case I8, U8:
+		return 1
+	case I16, U16:
+		return 2
+	case I32, U32, F32:
+		return 4
+	case Void:
+		return 0
+	}
+	return 8
+}
+
+// IsInt reports whether t is an integer type.
+func (t Type) IsInt() bool {
+	return I8 <= t && t <= U64
+}
+
+// IsSigned reports whether t is a signed integer type.
+func (t Type) IsSigned() bool {
+	return I8 <= t && t <= I64
+}
+
+// IsFloat reports whether t is a floating-point type.
+func (t Type) IsFloat() bool {
+	return t == F32 || t == F64
+}
+
+// Op is the operation of an instruction.
+type Op int
+
+const (
+	Const    Op = iota // Dst = Imm, or FImm for a floating type
+	Mov                // Dst = Args[0]
+	Phi                // Dst = Args[i] if control came from Targets[i]; only at the start of a block in SSA form
+	Add                // Dst = Args[0] + Args[1]
+	Sub                // Dst = Args[0] - Args[1]
+	Mul                // Dst = Args[0] * Args[1]
+	Div                // Dst = Args[0] / Args[1]
+	Shl                // Dst = Args[0] << Args[1]
+	Shr                // Dst = Args[0] >> Args[1], arithmetic for a signed Ty
+	And                // Dst = Args[0] & Args[1]
+	Or                 // Dst = Args[0] | Args[1]
+	Eq                 // Dst = Args[0] == Args[1], an i32 0 or 1; Ty is the type of the operands
+	Ne                 // Dst = Args[0] != Args[1]
+	Lt                 // Dst = Args[0] < Args[1]
+	Le                 // Dst = Args[0] <= Args[1]
+	Conv               // Dst = Args[0] converted to Ty
+	SlotAddr           // Dst = address of Slot plus Imm
+	Global             // Dst = address of the symbol Sym plus Imm
+	Load               // Dst = the Ty at address Args[0]
+	Store              // store Args[1] as a Ty at address Args[0]
+	MemCopy            // copy Imm bytes from address Args[1] to address Args[0]
+	MemZero            // zero Imm bytes at address Args[0]
+	Alloca             // Dst = address of Args[0] bytes allocated on the stack until the function returns or a SetSP
+	GetSP              // Dst = the stack pointer
+	SetSP              // set the stack pointer to Args[0], from a GetSP, freeing what Alloca allocated since
+	Call               // Dst = Sym(Args...), or Args[0](Args[1:]...) without Sym; no Dst if Ty is void
+	VaStart            // initialize the va_list at address Args[0] for the variadic arguments
+	VaArg              // Dst = address of the next variadic argument, of type Ty, of the va_list at Args[0]
+	Asm                // inline assembly; Args are the operands, see AsmStmt
+	Jmp                // jump to Targets[0]
+	Br                 // jump to Targets[0] if Args[0] is not zero, otherwise to Targets[1]
+	Ret                // return Args[0], or nothing without Args
+)
+
+var opNames = [...]string{
+	"const", "mov", "phi", "add", "sub", "mul", "div", "shl", "shr", "and", "or",
+	"eq", "ne", "lt", "le", "conv", "slot", "global", "load", "store",
+	"memcpy", "memzero", "alloca", "getsp", "setsp", "call", "vastart", "vaarg", "asm",
+	"jmp", "br", "ret",
+}
+
+func (op Op) String() string {
+	return opNames[op]
+}
+
+// IsTerminator reports whether op ends a basic block.
+func (op Op) IsTerminator() bool {
+	return op == Jmp || op == Br || op == Ret
+}
+
+// Reg is a virtual register. Registers are numbered from 1, so 0 means no
+// register.
+type Reg int
+
+// Inst is an instruction.
+type Inst struct {
+	Op       Op
+	Ty       Type // type of the result, or of the operands or memory access as noted at Op
+	Dst      Reg  // result, 0 if none
+	Args     []Reg
+	Imm      int64
+	FImm     float64
+	Sym      string   // symbol for Global and Call
+	Slot     *Slot    // stack slot for SlotAddr
+	Targets  []*Block // successors for Jmp and Br, predecessors for Phi
+	Volatile bool     // the Load or Store must be neither removed nor merged
+	Tail     bool     // the Call is followed by a Ret of its result, see TailCalls
+	Fixed    int      // number of named arguments of a Call to a variadic function, 0 for other calls
+
+	// Asm is the statement of an Asm instruction. Its Args are, for each
+	// of the outputs and then the inputs, the address of the operand for
+	// outputs and memory operands, the value for register inputs, and 0
+	// for immediates.
+	Asm *parser.AsmStmt
+}
+
+// HasResult reports whether the instruction defines Dst.
+func (in *Inst) HasResult() bool {
+	switch in.Op {
+	case Store, MemCopy, MemZero, SetSP, VaStart, Asm, Jmp, Br, Ret:
+		return false
+	case Call:
+		return in.Ty != Void
+	}
+	return true
+}
+
+// Block is a basic block: a sequence of instructions ending with a
+// terminator, which is the only instruction that transfers control.
+type Block struct {
+	ID    int
+	Insts []*Inst
+}
+
+// Terminator returns the last instruction of b, or nil if b is empty.
+func (b *Block) Terminator() *Inst {
+	if len(b.Insts) == 0 {
+		return nil
+	}
+	return b.Insts[len(b.Insts)-1]
+}
+
+// Succs returns the blocks control may pass to after b.
+func (b *Block) Succs() []*Block {
+	if t := b.Terminator(); t != nil {
+		return t.Targets
+	}
+	return nil
+}
+
+// Slot is an object in the stack frame, such as a local variable whose
+// address may be taken.
+type Slot struct {
+	ID     int
+	Name   string
+	Size   int
+	Align  int
+	Offset int // offset of the object below the frame pointer
+}
+
+// Func is a function definition.
+type Func struct {
+	Name        string
+	IsStatic    bool              // internal linkage, not visible to other files
+	IsInlineDef bool              // only for inlining, another file defines the function
+	Inline      parser.InlineKind // how the function asks to be inlined
+	RetTy       Type
+	Params      []Reg // registers receiving the arguments, in order
+	Variadic    bool
+	VaArea      *Slot // register save area of a variadic function, see the backend
+	Slots       []*Slot
+	FrameSize   int      // size of the part of the frame holding the Slots
+	Blocks      []*Block // Blocks[0] is the entry
+	Regs        []Type   // type of each register, indexed by Reg
+}
+
+// NewReg adds a register of type ty to f.
+func (f *Func) NewReg(ty Type) Reg {
+	if len(f.Regs) == 0 {
+		f.Regs = append(f.Regs, Void)
+	}
+	f.Regs = append(f.Regs, ty)
+	return Reg(len(f.Regs) - 1)
+}
+
+// NewBlock adds an empty block to f.
+func (f *Func) NewBlock() *Block {
+	b := &Block{ID: len(f.Blocks)}
+	f.Blocks = append(f.Blocks, b)
+	return b
+}
+
+// Program is a translation unit: the function definitions, and the global
+// variables, which are emitted as they are.
+type Program struct {
+	Funcs   []*Func
+	Globals []*parser.Global
+}
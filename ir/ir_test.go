@@ -0,0 +1,549 @@
+package ir_test
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"rkitamu/gocc/ir"
+	"rkitamu/gocc/lexer"
+	"rkitamu/gocc/parser"
+	"rkitamu/gocc/sema"
+)
+
+// lower parses, checks and lowers input, failing the test on errors.
+func lower(t *testing.T, input string) *ir.Program {
+	t.Helper()
+	tokens, err := lexer.NewLexer(input).Lex()
+	if err != nil {
+		t.Fatalf("lex error: %v", err)
+	}
+	p := parser.NewParser(tokens, input)
+	if err := p.Parse(); err != nil {
+		t.Fatalf("parse error: %v", err)
+	}
+	if err := sema.Check(p.Funcs, input); err != nil {
+		t.Fatalf("check error: %v", err)
+	}
+	prog, err := ir.Lower(p.Funcs, p.Globals)
+	if err != nil {
+		t.Fatalf("lower error: %v", err)
+	}
+	if err := ir.VerifyProgram(prog); err != nil {
+		t.Fatalf("verify error: %v", err)
+	}
+	return prog
+}
+
+func TestLower_Dump(t *testing.T) {
+	prog := lower(t, "int add(int a, int b) { if (a < b) return a + b; return b; }")
+
+	want := `func @add(i32 %1, i32 %2) i32 {
+  $0 a size 4 align 4 offset 4
+  $1 b size 4 align 4 offset 8
+b0:
+  %3 = slot $0
+  store i32 %3, %1
+  %4 = slot $1
+  store i32 %4, %2
+  %5 = slot $0
+  %6 = load i32 %5
+  %7 = slot $1
+  %8 = load i32 %7
+  %9 = lt i32 %6, %8
+  br %9, b1, b2
+b1:
+  %10 = slot $0
+  %11 = load i32 %10
+  %12 = slot $1
+  %13 = load i32 %12
+  %14 = add i32 %11, %13
+  ret %14
+b2:
+  jmp b3
+b3:
+  %15 = slot $1
+  %16 = load i32 %15
+  ret %16
+}
+`
+	if got := ir.Dump(prog); got != want {
+		t.Errorf("expected:\n%s\nbut got:\n%s", want, got)
+	}
+}
+
+func TestLower_Insts(t *testing.T) {
+	tests := []struct {
+		input string
+		want  []string
+	}{
+		// the unreachable code after a return is removed
+		{"int main() { return 1; return 2; }", []string{"ret %1"}},
+		{"long f(int x) { return x; }", []string{"conv %4 to i64"}},
+		{"unsigned f(unsigned x) { return x / 2; }", []string{"div u32"}},
+		{"double f(int x) { return x; }", []string{"conv %4 to f64"}},
+		{"_Bool f(long x) { return x; }", []string{"ne i64", "to u8"}},
+		{"int f(int *p) { return p[1]; }", []string{"mul i64", "add u64", "load i32"}},
+		{"struct S { int a, b; }; int f() { struct S x, y; x = y; return 0; }", []string{"memcpy %", ", 8"}},
+		{"struct S { int a : 3; }; int f(struct S *s) { return s->a; }", []string{"shl i64", "shr i64"}},
+		{"int g(int); int f() { return g(1) + g(2); }", []string{"call i32 @g(%1)", "call i32 @g(%3)"}},
+		{"int f(int (*p)(void)) { return p(); }", []string{"call i32 %"}},
+		{"int g(int, ...); int f() { return g(1, 2); }", []string{"call i32 @g(%1, ..., %2)"}},
+		{"int f(int n, ...) { va_list ap; va_start(ap, n); return 0; }", []string{"vastart %"}},
+		{"int f(int x) { volatile int y = x; return y; }", []string{"volatile store i32", "volatile load i32"}},
+		{"int f(int n) { int a[n]; return 0; }", []string{"alloca %"}},
+	}
+
+	for _, tt := range tests {
+		got := ir.Dump(lower(t, tt.input))
+		for _, w := range tt.want {
+			if !strings.Contains(got, w) {
+				t.Errorf("%s: expected %q in:\n%s", tt.input, w, got)
+			}
+		}
+	}
+}
+
+func TestVerify_Errors(t *testing.T) {
+	tests := []struct {
+		name  string
+		build func(f *ir.Func)
+		want  string
+	}{
+		{"missing terminator", func(f *ir.Func) {
+			b := f.NewBlock()
+			b.Insts = append(b.Insts, &ir.Inst{Op: ir.Const, Ty: ir.I32, Dst: f.NewReg(ir.I32)})
+		}, "a block must end with its only terminator"},
+		{"terminator in the middle", func(f *ir.Func) {
+			b := f.NewBlock()
+			b.Insts = append(b.Insts, &ir.Inst{Op: ir.Ret}, &ir.Inst{Op: ir.Ret})
+		}, "a block must end with its only terminator"},
+		{"empty block", func(f *ir.Func) {
+			f.NewBlock()
+		}, "empty block"},
+		{"tail call", func(f *ir.Func) {
+			b := f.NewBlock()
+			x := f.NewReg(ir.I32)
+			b.Insts = append(b.Insts,
+				&ir.Inst{Op: ir.Call, Ty: ir.I32, Dst: f.NewReg(ir.I32), Sym: "g", Tail: true},
+				&ir.Inst{Op: ir.Const, Ty: ir.I32, Dst: x},
+				&ir.Inst{Op: ir.Ret, Args: []ir.Reg{x}})
+		}, "tail call not followed by a return of its result"},
+		{"undefined register", func(f *ir.Func) {
+			b := f.NewBlock()
+			r := f.NewReg(ir.I32)
+			b.Insts = append(b.Insts, &ir.Inst{Op: ir.Ret, Args: []ir.Reg{r}})
+		}, "operand 0 is never defined"},
+		{"no register", func(f *ir.Func) {
+			b := f.NewBlock()
+			b.Insts = append(b.Insts, &ir.Inst{Op: ir.Ret, Args: []ir.Reg{7}})
+		}, "no register %7"},
+		{"operand type", func(f *ir.Func) {
+			b := f.NewBlock()
+			v := f.NewReg(ir.I32)
+			b.Insts = append(b.Insts,
+				&ir.Inst{Op: ir.Const, Ty: ir.I32, Dst: v},
+				&ir.Inst{Op: ir.Load, Ty: ir.I32, Dst: f.NewReg(ir.I32), Args: []ir.Reg{v}},
+				&ir.Inst{Op: ir.Ret})
+		}, "operand 0 must be u64"},
+		{"result type", func(f *ir.Func) {
+			b := f.NewBlock()
+			x := f.NewReg(ir.I64)
+			b.Insts = append(b.Insts,
+				&ir.Inst{Op: ir.Const, Ty: ir.I64, Dst: x},
+				&ir.Inst{Op: ir.Lt, Ty: ir.I64, Dst: f.NewReg(ir.I64), Args: []ir.Reg{x, x}},
+				&ir.Inst{Op: ir.Ret})
+		}, "result must be i32"},
+		{"operand count", func(f *ir.Func) {
+			b := f.NewBlock()
+			x := f.NewReg(ir.I32)
+			b.Insts = append(b.Insts,
+				&ir.Inst{Op: ir.Const, Ty: ir.I32, Dst: x},
+				&ir.Inst{Op: ir.Add, Ty: ir.I32, Dst: f.NewReg(ir.I32), Args: []ir.Reg{x}},
+				&ir.Inst{Op: ir.Ret})
+		}, "add takes 2 operands"},
+		{"foreign target", func(f *ir.Func) {
+			b := f.NewBlock()
+			b.Insts = append(b.Insts, &ir.Inst{Op: ir.Jmp, Targets: []*ir.Block{{ID: 0}}})
+		}, "target is not a block of the function"},
+		{"foreign slot", func(f *ir.Func) {
+			b := f.NewBlock()
+			b.Insts = append(b.Insts,
+				&ir.Inst{Op: ir.SlotAddr, Dst: f.NewReg(ir.U64), Slot: &ir.Slot{}},
+				&ir.Inst{Op: ir.Ret})
+		}, "slot of another function"},
+		{"phi after other instructions", func(f *ir.Func) {
+			b := f.NewBlock()
+			x := f.NewReg(ir.I32)
+			b.Insts = append(b.Insts,
+				&ir.Inst{Op: ir.Const, Ty: ir.I32, Dst: x},
+				&ir.Inst{Op: ir.Phi, Ty: ir.I32, Dst: f.NewReg(ir.I32)},
+				&ir.Inst{Op: ir.Ret})
+		}, "phi after other instructions"},
+		{"phi without a value for a predecessor", func(f *ir.Func) {
+			b0, b1 := f.NewBlock(), f.NewBlock()
+			b0.Insts = append(b0.Insts, &ir.Inst{Op: ir.Jmp, Targets: []*ir.Block{b1}})
+			b1.Insts = append(b1.Insts,
+				&ir.Inst{Op: ir.Phi, Ty: ir.I32, Dst: f.NewReg(ir.I32)},
+				&ir.Inst{Op: ir.Ret})
+		}, "phi takes a value for each predecessor"},
+		{"return value of a void function", func(f *ir.Func) {
+			b := f.NewBlock()
+			x := f.NewReg(ir.I32)
+			b.Insts = append(b.Insts,
+				&ir.Inst{Op: ir.Const, Ty: ir.I32, Dst: x},
+				&ir.Inst{Op: ir.Ret, Args: []ir.Reg{x}})
+		}, "invalid ret"},
+	}
+
+	for _, tt := range tests {
+		f := &ir.Func{Name: "f"}
+		tt.build(f)
+		err := ir.Verify(f)
+		if err == nil {
+			t.Errorf("%s: expected an error", tt.name)
+			continue
+		}
+		if !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: expected %q, but got %q", tt.name, tt.want, err.Error())
+		}
+	}
+}
+
+func TestDominators(t *testing.T) {
+	// b0 branches to b1 and b2, which join at b3
+	f := lower(t, "int f(int c) { int r; if (c) r = 1; else r = 2; return r; }").Funcs[0]
+	dom := ir.Dominators(f)
+
+	b := f.Blocks
+	if len(b) != 4 {
+		t.Fatalf("expected 4 blocks, but got:\n%s", ir.DumpFunc(f))
+	}
+	for _, x := range b[1:] {
+		if dom.Idom[x.ID] != b[0] {
+			t.Errorf("expected b0 to be the immediate dominator of %s, but got %s", x, dom.Idom[x.ID])
+		}
+	}
+	if !dom.Dominates(b[0], b[3]) || dom.Dominates(b[1], b[3]) || !dom.Dominates(b[2], b[2]) {
+		t.Errorf("wrong dominance")
+	}
+	for _, x := range b[1:3] {
+		if len(dom.Frontier[x.ID]) != 1 || dom.Frontier[x.ID][0] != b[3] {
+			t.Errorf("expected the frontier of %s to be b3, but got %v", x, dom.Frontier[x.ID])
+		}
+	}
+	if len(dom.Frontier[0]) != 0 {
+		t.Errorf("expected an empty frontier for b0, but got %v", dom.Frontier[0])
+	}
+}
+
+func TestPasses(t *testing.T) {
+	tests := []struct {
+		input  string
+		passes []func(*ir.Func)
+		want   []string
+		reject []string
+	}{
+		// the variables are promoted and merged with a phi
+		{"int f(int c) { int r; if (c) r = 1; else r = 2; return r; }",
+			[]func(*ir.Func){ir.BuildSSA}, []string{"phi i32 [%", "b1], [%", "b2]"}, []string{"slot", "load", "store"}},
+		// a variable whose address is taken stays in memory
+		{"int f() { int x = 1; int *p = &x; return *p; }",
+			[]func(*ir.Func){ir.BuildSSA}, []string{"$0 x", "store i32"}, []string{"$1 p"}},
+		{"int f(int a) { int x; return x + a; }",
+			[]func(*ir.Func){ir.BuildSSA}, []string{"const i32 0"}, []string{"load"}},
+		// the branch is known to be taken
+		{"int f() { int x = 3; if (x < 4) return 1; return 2; }",
+			[]func(*ir.Func){ir.BuildSSA, ir.SCCP}, []string{"const i32 1", "ret"}, []string{"br", "const i32 2"}},
+		{"int f(int c) { int r = 5; if (c) r = 5; return r * 2; }",
+			[]func(*ir.Func){ir.BuildSSA, ir.SCCP}, []string{"const i32 10"}, []string{"mul"}},
+		// the arithmetic wraps at the width of the type
+		{"char f() { char c = 127; c = c + 1; return c; }",
+			[]func(*ir.Func){ir.BuildSSA, ir.SCCP}, []string{"const i8 -128"}, nil},
+		{"unsigned f() { unsigned x = 0; return x - 1 > 0; }",
+			[]func(*ir.Func){ir.BuildSSA, ir.SCCP}, []string{"const i32 1"}, []string{"lt"}},
+		// division by zero is left to happen at run time
+		{"int f() { int x = 0; return 1 / x; }",
+			[]func(*ir.Func){ir.BuildSSA, ir.SCCP}, []string{"div i32"}, nil},
+		{"int f(int a, int b) { return (a + b) * (b + a); }",
+			[]func(*ir.Func){ir.BuildSSA, ir.GVN}, []string{"mul i32 %9, %9"}, nil},
+		{"int f(int a) { int x = a * 2; return a; }",
+			[]func(*ir.Func){ir.BuildSSA, ir.DCE}, []string{"ret %1"}, []string{"mul"}},
+		// a volatile load is kept
+		{"int f(volatile int *p) { int x = *p; return 0; }",
+			[]func(*ir.Func){ir.BuildSSA, ir.DCE}, []string{"volatile load"}, nil},
+		{"int f(int c) { int r; if (c) r = 1; else r = 2; return r; }",
+			[]func(*ir.Func){ir.BuildSSA, ir.LeaveSSA}, []string{"mov i32"}, []string{"phi"}},
+		// only calls whose result is returned are tail calls
+		{"int g(int x); int f(int x) { if (x) return g(x); return g(x) + 1; }",
+			[]func(*ir.Func){ir.BuildSSA, ir.TailCalls}, []string{"tail call i32 @g", "= call i32 @g"}, nil},
+		{"void g(); void f() { g(); }",
+			[]func(*ir.Func){ir.BuildSSA, ir.TailCalls}, []string{"tail call void @g"}, nil},
+		// the callee might use the address of a local
+		{"int g(int *p); int f(int x) { return g(&x); }",
+			[]func(*ir.Func){ir.BuildSSA, ir.TailCalls}, nil, []string{"tail"}},
+	}
+
+	for _, tt := range tests {
+		f := lower(t, tt.input).Funcs[0]
+		for _, pass := range tt.passes {
+			pass(f)
+			if err := ir.Verify(f); err != nil {
+				t.Errorf("%s: verify error: %v\n%s", tt.input, err, ir.DumpFunc(f))
+			}
+		}
+		got := ir.DumpFunc(f)
+		for _, w := range tt.want {
+			if !strings.Contains(got, w) {
+				t.Errorf("%s: expected %q in:\n%s", tt.input, w, got)
+			}
+		}
+		for _, r := range tt.reject {
+			if strings.Contains(got, r) {
+				t.Errorf("%s: unexpected %q in:\n%s", tt.input, r, got)
+			}
+		}
+	}
+}
+
+func TestInline(t *testing.T) {
+	tests := []struct {
+		input   string
+		limit   int
+		inlined bool     // whether the call of main is inlined
+		funcs   []string // the functions left
+	}{
+		{"int add(int a, int b) { return a + b; } int main() { return add(1, 2); }", 20, true, []string{"add", "main"}},
+		{"int add(int a, int b) { return a + b; } int main() { return add(1, 2); }", 2, false, []string{"add", "main"}},
+		// the cost limit is larger for functions declared inline
+		{"inline int add(int a, int b) { return a + b; } int main() { return add(1, 2); }", 5, true, []string{"main"}},
+		// an inline definition is removed even where it is not inlined,
+		// unless a declaration makes it external
+		{"inline int add(int a, int b) { return a + b; } int main() { return add(1, 2); }", -1, false, []string{"main"}},
+		{"extern int add(int a, int b); inline int add(int a, int b) { return a + b; } int main() { return add(1, 2); }", 5, true, []string{"add", "main"}},
+		{"inline int add(int a, int b) { return a + b; } int add(int a, int b); int main() { return add(1, 2); }", 5, true, []string{"add", "main"}},
+		// a static function inlined everywhere is removed
+		{"static int add(int a, int b) { return a + b; } int main() { return add(1, 2); }", 0, true, []string{"main"}},
+		{"static inline int add(int a, int b) { return a + b; } int main() { return add(1, 2) + add(3, 4); }", 10, true, []string{"main"}},
+		{"__attribute__((always_inline)) int add(int a, int b) { return a + b; } int main() { return add(1, 2); }", -1, true, []string{"add", "main"}},
+		{"__attribute__((noinline)) static int add(int a, int b) { return a + b; } int main() { return add(1, 2); }", 100, false, []string{"add", "main"}},
+		{"int add(int a, int b) { return a + b; } int main() { return add(1, 2); }", -1, false, []string{"add", "main"}},
+		// recursive and variadic functions are not inlined
+		{"static int f(int n) { if (n) return f(n - 1); return 0; } int main() { return f(3); }", 100, false, []string{"f", "main"}},
+		{"int f(int n, ...) { return n; } int main() { return f(1, 2); }", 100, false, []string{"f", "main"}},
+		// callees are inlined into their callers first
+		{"int g(int x) { return x + 1; } int f(int x) { return g(x) * 2; } int main() { return f(1); }", 100, true, []string{"g", "f", "main"}},
+	}
+
+	for _, tt := range tests {
+		prog := lower(t, tt.input)
+		ir.Inline(prog, tt.limit)
+		if err := ir.VerifyProgram(prog); err != nil {
+			t.Errorf("%s: verify error: %v\n%s", tt.input, err, ir.Dump(prog))
+			continue
+		}
+		var funcs []string
+		var main *ir.Func
+		for _, f := range prog.Funcs {
+			funcs = append(funcs, f.Name)
+			if f.Name == "main" {
+				main = f
+			}
+		}
+		if strings.Join(funcs, " ") != strings.Join(tt.funcs, " ") {
+			t.Errorf("%s: expected the functions %v, but got %v", tt.input, tt.funcs, funcs)
+		}
+		got := ir.DumpFunc(main)
+		if inlined := !strings.Contains(got, "call"); inlined != tt.inlined {
+			t.Errorf("%s: expected inlined = %v in:\n%s", tt.input, tt.inlined, got)
+		}
+	}
+
+	// the copies of the locals get their own slots, below those of the
+	// caller, and each return passes the result through another slot
+	prog := lower(t, "static int sel(int c) { int x = 5; if (c) return x; return 7; } int main() { int y = 1; return sel(y) + sel(0); }")
+	ir.Inline(prog, 100)
+	main := prog.Funcs[0]
+	want := []string{"y", "c", "x", "sel.result", "c", "x", "sel.result"}
+	if len(main.Slots) != len(want) {
+		t.Fatalf("expected the slots %v, but got %d:\n%s", want, len(main.Slots), ir.DumpFunc(main))
+	}
+	offset := 0
+	for i, s := range main.Slots {
+		if s.Name != want[i] || s.Offset <= 0 || s.Offset > main.FrameSize {
+			t.Errorf("slot %d: expected %s within the frame of %d bytes, but got %+v", i, want[i], main.FrameSize, s)
+		}
+		if i > 0 && want[i] == "c" && s.Offset <= offset {
+			t.Errorf("slot %d: expected the copy below offset %d, but got %d", i, offset, s.Offset)
+		}
+		offset = max(offset, s.Offset)
+	}
+
+	// the copies stay aligned beyond the 16 bytes of the frame
+	prog = lower(t, "static int f(int c) { int x = c; return x; } int main() { int y = 1; return f(y); }")
+	x := prog.Funcs[0].Slots[1]
+	x.Align, x.Offset, prog.Funcs[0].FrameSize = 32, 32, 32
+	ir.Inline(prog, 100)
+	main = prog.Funcs[0]
+	if len(main.Slots) != 3 {
+		t.Fatalf("expected the slots y, c and x, but got:\n%s", ir.DumpFunc(main))
+	}
+	for _, s := range main.Slots {
+		if s.Offset%s.Align != 0 {
+			t.Errorf("expected slot %s aligned to %d, but got offset %d:\n%s", s.Name, s.Align, s.Offset, ir.DumpFunc(main))
+		}
+	}
+}
+
+// countedLoop builds a function that stores the running sum of a*a to p[i]
+// for i from 0 to n-1, with a loop of a single block:
+//
+//	int f(int *p, int a) { int s = 0; for (int i = 0; i < n; i++) p[i] = s += a * a / a; return s; }
+func countedLoop(n int64) *ir.Func {
+	f := &ir.Func{Name: "f", RetTy: ir.I32}
+	p, a := f.NewReg(ir.U64), f.NewReg(ir.I32)
+	f.Params = []ir.Reg{p, a}
+	b0, b1, b2 := f.NewBlock(), f.NewBlock(), f.NewBlock()
+
+	zero, one, bound := f.NewReg(ir.I32), f.NewReg(ir.I32), f.NewReg(ir.I32)
+	b0.Insts = []*ir.Inst{
+		{Op: ir.Const, Ty: ir.I32, Dst: zero},
+		{Op: ir.Const, Ty: ir.I32, Dst: one, Imm: 1},
+		{Op: ir.Const, Ty: ir.I32, Dst: bound, Imm: n},
+		{Op: ir.Jmp, Targets: []*ir.Block{b1}},
+	}
+
+	i, s, next, sum := f.NewReg(ir.I32), f.NewReg(ir.I32), f.NewReg(ir.I32), f.NewReg(ir.I32)
+	sq, q, wide, two, off, off64, addr, cond := f.NewReg(ir.I32), f.NewReg(ir.I32), f.NewReg(ir.I64), f.NewReg(ir.I64), f.NewReg(ir.I64), f.NewReg(ir.U64), f.NewReg(ir.U64), f.NewReg(ir.I32)
+	b1.Insts = []*ir.Inst{
+		{Op: ir.Phi, Ty: ir.I32, Dst: i, Args: []ir.Reg{zero, next}, Targets: []*ir.Block{b0, b1}},
+		{Op: ir.Phi, Ty: ir.I32, Dst: s, Args: []ir.Reg{zero, sum}, Targets: []*ir.Block{b0, b1}},
+		{Op: ir.Mul, Ty: ir.I32, Dst: sq, Args: []ir.Reg{a, a}},
+		{Op: ir.Div, Ty: ir.I32, Dst: q, Args: []ir.Reg{sq, a}},
+		{Op: ir.Add, Ty: ir.I32, Dst: sum, Args: []ir.Reg{s, q}},
+		{Op: ir.Conv, Ty: ir.I64, Dst: wide, Args: []ir.Reg{i}},
+		{Op: ir.Const, Ty: ir.I64, Dst: two, Imm: 2},
+		{Op: ir.Shl, Ty: ir.I64, Dst: off, Args: []ir.Reg{wide, two}},
+		{Op: ir.Conv, Ty: ir.U64, Dst: off64, Args: []ir.Reg{off}},
+		{Op: ir.Add, Ty: ir.U64, Dst: addr, Args: []ir.Reg{p, off64}},
+		{Op: ir.Store, Ty: ir.I32, Args: []ir.Reg{addr, sum}},
+		{Op: ir.Add, Ty: ir.I32, Dst: next, Args: []ir.Reg{i, one}},
+		{Op: ir.Lt, Ty: ir.I32, Dst: cond, Args: []ir.Reg{next, bound}},
+		{Op: ir.Br, Args: []ir.Reg{cond}, Targets: []*ir.Block{b1, b2}},
+	}
+	b2.Insts = []*ir.Inst{{Op: ir.Ret, Args: []ir.Reg{sum}}}
+	return f
+}
+
+// opsIn returns the operations of the instructions of b.
+func opsIn(b *ir.Block) []ir.Op {
+	var ops []ir.Op
+	for _, in := range b.Insts {
+		ops = append(ops, in.Op)
+	}
+	return ops
+}
+
+func TestFindLoops(t *testing.T) {
+	// b1 to b3 is a loop around the loop of b2
+	f := &ir.Func{Name: "f"}
+	b := []*ir.Block{f.NewBlock(), f.NewBlock(), f.NewBlock(), f.NewBlock(), f.NewBlock()}
+	c := f.NewReg(ir.I32)
+	b[0].Insts = []*ir.Inst{{Op: ir.Const, Ty: ir.I32, Dst: c}, {Op: ir.Jmp, Targets: []*ir.Block{b[1]}}}
+	b[1].Insts = []*ir.Inst{{Op: ir.Jmp, Targets: []*ir.Block{b[2]}}}
+	b[2].Insts = []*ir.Inst{{Op: ir.Br, Args: []ir.Reg{c}, Targets: []*ir.Block{b[2], b[3]}}}
+	b[3].Insts = []*ir.Inst{{Op: ir.Br, Args: []ir.Reg{c}, Targets: []*ir.Block{b[1], b[4]}}}
+	b[4].Insts = []*ir.Inst{{Op: ir.Ret}}
+
+	loops := ir.FindLoops(f)
+	if len(loops) != 2 {
+		t.Fatalf("expected 2 loops, but got %d", len(loops))
+	}
+	tests := []struct {
+		header  *ir.Block
+		latches []*ir.Block
+		blocks  []*ir.Block
+	}{
+		{b[2], []*ir.Block{b[2]}, []*ir.Block{b[2]}},
+		{b[1], []*ir.Block{b[3]}, []*ir.Block{b[1], b[2], b[3]}},
+	}
+	for i, tt := range tests {
+		l := loops[i]
+		if l.Header != tt.header || fmt.Sprint(l.Latches) != fmt.Sprint(tt.latches) || len(l.Blocks) != len(tt.blocks) {
+			t.Errorf("loop %d: expected header %s, latches %v and blocks %v, but got %s, %v and %d blocks", i, tt.header, tt.latches, tt.blocks, l.Header, l.Latches, len(l.Blocks))
+		}
+		for _, x := range tt.blocks {
+			if !l.Blocks[x] {
+				t.Errorf("loop %d: expected %s in the loop", i, x)
+			}
+		}
+	}
+
+	if loops := ir.FindLoops(lower(t, "int f(int c) { if (c) return 1; return 2; }").Funcs[0]); len(loops) != 0 {
+		t.Errorf("expected no loops, but got %d", len(loops))
+	}
+
+	// a for statement is lowered to a single block, which tests the
+	// condition again at its end
+	f = lower(t, "int f(int n) { int s = 0; for (int i = 0; i < n; i = i + 1) s = s + i; return s; }").Funcs[0]
+	if loops := ir.FindLoops(f); len(loops) != 1 || len(loops[0].Blocks) != 1 {
+		t.Errorf("expected a loop of a single block in:\n%s", ir.DumpFunc(f))
+	}
+}
+
+func TestLoopPasses(t *testing.T) {
+	tests := []struct {
+		name   string
+		n      int64
+		pass   func(*ir.Func)
+		entry  []string // expected in the dump of the entry block
+		loop   []string // expected in the dump of the loop
+		reject []string // not expected in the dump of the loop
+		loops  int      // the loops left
+	}{
+		// a*a is invariant, a*a/a could trap and stays in the loop
+		{"licm", 10, ir.LICM, []string{"mul i32 %2, %2", "const i64 2"}, []string{"div i32"}, []string{"mul", "const"}, 1},
+		// the offset of p[i] becomes a pointer bumped by 4 bytes
+		{"strength", 10, ir.StrengthReduce, []string{"const u64 4"}, []string{"phi u64 [%", "add u64 %"}, []string{"shl"}, 1},
+		{"unroll", 3, ir.Unroll, nil, []string{"jmp b2"}, []string{"phi", "br"}, 0},
+		// too many iterations
+		{"unroll", 100, ir.Unroll, nil, []string{"phi", "br"}, nil, 1},
+	}
+
+	for _, tt := range tests {
+		f := countedLoop(tt.n)
+		tt.pass(f)
+		if err := ir.Verify(f); err != nil {
+			t.Errorf("%s: verify error: %v\n%s", tt.name, err, ir.DumpFunc(f))
+			continue
+		}
+		got := ir.DumpFunc(f)
+		entry, loop, _ := strings.Cut(got, "\nb1:")
+		for _, w := range tt.entry {
+			if !strings.Contains(entry, w) {
+				t.Errorf("%s: expected %q in the entry of:\n%s", tt.name, w, got)
+			}
+		}
+		for _, w := range tt.loop {
+			if !strings.Contains(loop, w) {
+				t.Errorf("%s: expected %q in the loop of:\n%s", tt.name, w, got)
+			}
+		}
+		for _, r := range tt.reject {
+			if strings.Contains(loop, r) {
+				t.Errorf("%s: unexpected %q in the loop of:\n%s", tt.name, r, got)
+			}
+		}
+		if loops := ir.FindLoops(f); len(loops) != tt.loops {
+			t.Errorf("%s: expected %d loops, but got %d:\n%s", tt.name, tt.loops, len(loops), got)
+		}
+	}
+
+	// each iteration stores its sum
+	f := countedLoop(3)
+	ir.Unroll(f)
+	stores := strings.Count(ir.DumpFunc(f), "store i32")
+	if stores != 3 {
+		t.Errorf("expected 3 stores, but got %d:\n%s", stores, ir.DumpFunc(f))
+	}
+}
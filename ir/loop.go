@@ -0,0 +1,556 @@
+package ir
+
+import (
+	"slices"
+	"sort"
+)
+
+// Loop is a natural loop of a function: the header and the blocks from
+// which a latch, a block with a back edge to the header, can be reached
+// without passing through the header. The header dominates the whole loop,
+// so control enters it only through the header.
+type Loop struct {
+	Header  *Block
+	Latches []*Block
+	Blocks  map[*Block]bool // the blocks of the loop, with the header
+}
+
+// FindLoops returns the natural loops of f, the inner loops before the
+// loops containing them. Back edges to the same header make a single loop.
+// As for Dominators, every block must be reachable.
+func FindLoops(f *Func) []*Loop {
+	dom := Dominators(f)
+	byHeader := map[*Block]*Loop{}
+	var loops []*Loop
+	for _, b := range f.Blocks {
+		for _, h := range b.Succs() {
+			if !dom.Dominates(h, b) {
+				continue
+			}
+			l := byHeader[h]
+			if l == nil {
+				l = &Loop{Header: h, Blocks: map[*Block]bool{h: true}}
+				byHeader[h] = l
+				loops = append(loops, l)
+			}
+			if slices.Contains(l.Latches, b) {
+				continue
+			}
+			l.Latches = append(l.Latches, b)
+
+			// walk back from the latch up to the header
+			work := []*Block{b}
+			for len(work) > 0 {
+				x := work[len(work)-1]
+				work = work[:len(work)-1]
+				if l.Blocks[x] {
+					continue
+				}
+				l.Blocks[x] = true
+				work = append(work, dom.Preds[x.ID]...)
+			}
+		}
+	}
+
+	// a loop nested in another has fewer blocks
+	sort.SliceStable(loops, func(i, j int) bool { return len(loops[i].Blocks) < len(loops[j].Blocks) })
+	return loops
+}
+
+// sortedBlocks returns the blocks of l in reverse postorder, in which the
+// definitions come before their uses except in Phis.
+func (l *Loop) sortedBlocks(f *Func) []*Block {
+	var blocks []*Block
+	for _, b := range reversePostorder(f) {
+		if l.Blocks[b] {
+			blocks = append(blocks, b)
+		}
+	}
+	return blocks
+}
+
+// preheader returns the single block outside l that jumps to its header,
+// adding one to f if needed. The predecessors outside the loop then jump to
+// the new block instead, and the values they pass to the Phis of the header
+// are merged there. The new block is added to the loops of loops that
+// contain l. It returns nil if the header is the entry of f.
+func preheader(f *Func, l *Loop, loops []*Loop) *Block {
+	h := l.Header
+	if h == f.Blocks[0] {
+		return nil
+	}
+	var outside []*Block
+	for _, p := range Preds(f)[h.ID] {
+		if !l.Blocks[p] && !slices.Contains(outside, p) {
+			outside = append(outside, p)
+		}
+	}
+	if len(outside) == 1 && len(outside[0].Succs()) == 1 {
+		return outside[0]
+	}
+
+	pre := &Block{}
+	for _, p := range outside {
+		for i, t := range p.Terminator().Targets {
+			if t == h {
+				p.Terminator().Targets[i] = pre
+			}
+		}
+	}
+	for _, in := range h.Insts {
+		if in.Op != Phi {
+			break
+		}
+		var args []Reg
+		var targets []*Block
+		phi := &Inst{Op: Phi, Ty: in.Ty, Dst: f.NewReg(in.Ty)}
+		for i, p := range in.Targets {
+			if l.Blocks[p] {
+				args = append(args, in.Args[i])
+				targets = append(targets, p)
+			} else {
+				phi.Args = append(phi.Args, in.Args[i])
+				phi.Targets = append(phi.Targets, p)
+			}
+		}
+		if len(phi.Args) == 1 {
+			args = append(args, phi.Args[0])
+		} else {
+			pre.Insts = append(pre.Insts, phi)
+			args = append(args, phi.Dst)
+		}
+		in.Args = args
+		in.Targets = append(targets, pre)
+	}
+	pre.Insts = append(pre.Insts, &Inst{Op: Jmp, Targets: []*Block{h}})
+
+	// place it just before the header, where it falls through
+	i := slices.Index(f.Blocks, h)
+	f.Blocks = slices.Insert(f.Blocks, i, pre)
+	for id, b := range f.Blocks {
+		b.ID = id
+	}
+	for _, outer := range loops {
+		if outer != l && outer.Blocks[h] {
+			outer.Blocks[pre] = true
+		}
+	}
+	return pre
+}
+
+// definitions returns the instruction defining each register of f, which
+// must be in SSA form.
+func definitions(f *Func) map[Reg]*Inst {
+	defs := map[Reg]*Inst{}
+	for _, b := range f.Blocks {
+		for _, in := range b.Insts {
+			if in.HasResult() {
+				defs[in.Dst] = in
+			}
+		}
+	}
+	return defs
+}
+
+// definedIn returns the registers defined by the blocks of l.
+func (l *Loop) definedIn() map[Reg]bool {
+	regs := map[Reg]bool{}
+	for b := range l.Blocks {
+		for _, in := range b.Insts {
+			if in.HasResult() {
+				regs[in.Dst] = true
+			}
+		}
+	}
+	return regs
+}
+
+// insertBefore inserts the instructions ins before the terminator of b.
+func insertBefore(b *Block, ins ...*Inst) {
+	last := len(b.Insts) - 1
+	b.Insts = append(b.Insts[:last], append(ins, b.Insts[last])...)
+}
+
+// LICM moves the computations of the loops of f whose operands do not
+// change in the loop, the loop invariants, to a preheader that runs once
+// before the loop. f must be in SSA form. Only the pure instructions that
+// cannot trap are moved, since they may not have run at all in the loop:
+// Phis, divisions and loads stay where they are.
+func LICM(f *Func) {
+	RemoveUnreachable(f)
+	loops := FindLoops(f)
+	for _, l := range loops {
+		inside := l.definedIn()
+		var hoisted []*Inst
+		for _, b := range l.sortedBlocks(f) {
+			for _, in := range b.Insts {
+				if isHoistable(in) && !slices.ContainsFunc(in.Args, func(r Reg) bool { return inside[r] }) {
+					hoisted = append(hoisted, in)
+					delete(inside, in.Dst)
+				}
+			}
+		}
+		if len(hoisted) == 0 {
+			continue
+		}
+		pre := preheader(f, l, loops)
+		if pre == nil {
+			continue
+		}
+		for b := range l.Blocks {
+			b.Insts = slices.DeleteFunc(b.Insts, func(in *Inst) bool { return slices.Contains(hoisted, in) })
+		}
+		insertBefore(pre, hoisted...)
+	}
+}
+
+func isHoistable(in *Inst) bool {
+	return isPure(in.Op) && in.Op != Phi && in.Op != Div
+}
+
+// inductionVar is a value that changes by the same step in each iteration
+// of a loop, as an affine function of a basic induction variable: a Phi of
+// the header that is incremented by a constant on the back edge.
+type inductionVar struct {
+	phi  *Inst // the basic induction variable
+	next *Inst // the increment of phi for the next iteration
+	mul  int64 // the value changes by mul times the step of phi
+}
+
+// StrengthReduce replaces the multiplications and shifts of the basic
+// induction variables of the loops of f by new induction variables
+// incremented by the product on each iteration. Most often these scale an
+// index into an offset of an array, which then becomes a pointer bumped by
+// the size of the elements. f must be in SSA form.
+//
+// The induction values are followed through additions of loop invariants,
+// multiplications and shifts by constants, and conversions that are linear
+// in the arithmetic modulo the width of their type, including the sign
+// extension of an int, which does not overflow in a valid program.
+func StrengthReduce(f *Func) {
+	RemoveUnreachable(f)
+	loops := FindLoops(f)
+	for _, l := range loops {
+		if len(l.Latches) != 1 {
+			continue
+		}
+		defs := definitions(f)
+		inside := l.definedIn()
+
+		ivs := map[Reg]inductionVar{}
+		for _, in := range l.Header.Insts {
+			if in.Op != Phi {
+				break
+			}
+			if next := basicStep(in, l, defs); next != nil {
+				ivs[in.Dst] = inductionVar{phi: in, next: next, mul: 1}
+			}
+		}
+		if len(ivs) == 0 {
+			continue
+		}
+
+		constant := func(r Reg) (int64, bool) {
+			if def := defs[r]; def != nil && def.Op == Const && def.Ty.IsInt() {
+				return def.Imm, true
+			}
+			return 0, false
+		}
+		invariant := func(r Reg) bool {
+			_, ok := constant(r)
+			return ok || !inside[r]
+		}
+		var reduce []*Inst
+		for _, b := range l.sortedBlocks(f) {
+			for _, in := range b.Insts {
+				if !in.HasResult() || !in.Ty.IsInt() || len(in.Args) == 0 {
+					continue
+				}
+				iv, ok := ivs[in.Args[0]]
+				switch in.Op {
+				case Conv:
+					from := f.Regs[in.Args[0]]
+					ok = ok && (in.Ty.Size() <= from.Size() || from == I32 && in.Ty.Size() == 8)
+				case Add, Sub:
+					if !ok && in.Op == Add {
+						iv, ok = ivs[in.Args[1]]
+						ok = ok && invariant(in.Args[0])
+					} else {
+						ok = ok && invariant(in.Args[1])
+					}
+				case Mul:
+					k, isConst := constant(in.Args[1])
+					if !ok {
+						iv, ok = ivs[in.Args[1]]
+						k, isConst = constant(in.Args[0])
+					}
+					ok = ok && isConst
+					iv.mul *= k
+				case Shl:
+					k, isConst := constant(in.Args[1])
+					ok = ok && isConst && k >= 0 && k < 64
+					iv.mul <<= k
+				default:
+					ok = false
+				}
+				if !ok || in == iv.next {
+					continue
+				}
+				ivs[in.Dst] = iv
+				if iv.mul != 1 && in.Op != Conv {
+					reduce = append(reduce, in)
+				}
+			}
+		}
+
+		if len(reduce) == 0 {
+			continue
+		}
+		pre := preheader(f, l, loops)
+		if pre == nil {
+			continue
+		}
+
+		// the later values are computed from the earlier ones, which must
+		// still be there to be copied into the preheader
+		for _, in := range slices.Backward(reduce) {
+			iv := ivs[in.Dst]
+			initReg := iv.phi.Args[slices.Index(iv.phi.Targets, pre)]
+			start := cloneAt(f, pre, in.Dst, map[Reg]Reg{iv.phi.Dst: initReg}, inside, defs)
+
+			step, _ := constant(iv.next.Args[0])
+			if iv.next.Args[0] == iv.phi.Dst {
+				step, _ = constant(iv.next.Args[1])
+			}
+			stepReg := f.NewReg(in.Ty)
+			insertBefore(pre, &Inst{Op: Const, Ty: in.Ty, Dst: stepReg, Imm: extend(in.Ty, step*iv.mul)})
+
+			// the new variable takes the place of in
+			phi := &Inst{Op: Phi, Ty: in.Ty, Dst: f.NewReg(in.Ty)}
+			bumped := &Inst{Op: Add, Ty: in.Ty, Dst: f.NewReg(in.Ty), Args: []Reg{phi.Dst, stepReg}}
+			for i, p := range iv.phi.Targets {
+				if p == pre {
+					phi.Args = append(phi.Args, start)
+				} else {
+					phi.Args = append(phi.Args, bumped.Dst)
+				}
+				phi.Targets = append(phi.Targets, iv.phi.Targets[i])
+			}
+			l.Header.Insts = slices.Insert(l.Header.Insts, 0, phi)
+			b := blockOf(l, iv.next)
+			b.Insts = slices.Insert(b.Insts, slices.Index(b.Insts, iv.next)+1, bumped)
+			*in = Inst{Op: Mov, Ty: in.Ty, Dst: in.Dst, Args: []Reg{phi.Dst}}
+		}
+	}
+}
+
+// basicStep returns the increment of phi by a constant on the back edge of
+// l, if phi is a basic induction variable of l.
+func basicStep(phi *Inst, l *Loop, defs map[Reg]*Inst) *Inst {
+	if !phi.Ty.IsInt() {
+		return nil
+	}
+	i := slices.Index(phi.Targets, l.Latches[0])
+	if i < 0 {
+		return nil
+	}
+	next := defs[phi.Args[i]]
+	if next == nil || next.Op != Add || blockOf(l, next) == nil {
+		return nil
+	}
+	a, b := next.Args[0], next.Args[1]
+	if b == phi.Dst {
+		a, b = b, a
+	}
+	if a != phi.Dst || defs[b] == nil || defs[b].Op != Const {
+		return nil
+	}
+	return next
+}
+
+// blockOf returns the block of l containing in, or nil.
+func blockOf(l *Loop, in *Inst) *Block {
+	for b := range l.Blocks {
+		if slices.Contains(b.Insts, in) {
+			return b
+		}
+	}
+	return nil
+}
+
+// cloneAt computes r again at the end of b, from the registers that regs
+// maps to their replacements, by copying the instructions defining r in the
+// loop whose registers are inside. It returns the register with the copy of
+// r.
+func cloneAt(f *Func, b *Block, r Reg, regs map[Reg]Reg, inside map[Reg]bool, defs map[Reg]*Inst) Reg {
+	if to, ok := regs[r]; ok {
+		return to
+	}
+	if !inside[r] {
+		return r
+	}
+	def := defs[r]
+	c := *def
+	c.Dst = f.NewReg(f.Regs[r])
+	c.Args = make([]Reg, len(def.Args))
+	for i, a := range def.Args {
+		c.Args[i] = cloneAt(f, b, a, regs, inside, defs)
+	}
+	insertBefore(b, &c)
+	regs[r] = c.Dst
+	return c.Dst
+}
+
+// maxUnrollTrips and maxUnrollInsts bound the size of the loops that
+// Unroll replaces.
+const (
+	maxUnrollTrips = 16
+	maxUnrollInsts = 256
+)
+
+// Unroll fully unrolls the loops of f made of a single block that runs a
+// constant number of times, at most maxUnrollTrips, into a copy of the
+// block for each iteration. The number of iterations is found by running
+// the block from the constant initial values of its Phis. f must be in SSA
+// form.
+func Unroll(f *Func) {
+	RemoveUnreachable(f)
+	loops := FindLoops(f)
+	for _, l := range loops {
+		b := l.Header
+		br := b.Terminator()
+		if len(l.Blocks) != 1 || br.Op != Br || br.Targets[0] == br.Targets[1] {
+			continue
+		}
+		pre := preheader(f, l, loops)
+		if pre == nil {
+			continue
+		}
+		trips := tripCount(f, b, pre)
+		if trips == 0 || trips*len(b.Insts) > maxUnrollInsts {
+			continue
+		}
+		exit := br.Targets[0]
+		if exit == b {
+			exit = br.Targets[1]
+		}
+
+		// the last copy defines the registers of the loop, which may be
+		// used after it
+		var insts []*Inst
+		var prev map[Reg]Reg
+		for k := 0; k < trips; k++ {
+			regs := map[Reg]Reg{}
+			for _, in := range b.Insts {
+				if in.HasResult() {
+					regs[in.Dst] = in.Dst
+					if k < trips-1 {
+						regs[in.Dst] = f.NewReg(f.Regs[in.Dst])
+					}
+				}
+			}
+			for _, in := range b.Insts[:len(b.Insts)-1] {
+				if in.Op == Phi {
+					arg := in.Args[slices.Index(in.Targets, pre)]
+					if k > 0 {
+						arg = in.Args[slices.Index(in.Targets, b)]
+						if to, ok := prev[arg]; ok {
+							arg = to
+						}
+					}
+					insts = append(insts, &Inst{Op: Mov, Ty: in.Ty, Dst: regs[in.Dst], Args: []Reg{arg}})
+					continue
+				}
+				c := *in
+				c.Dst = regs[in.Dst]
+				c.Args = make([]Reg, len(in.Args))
+				for i, a := range in.Args {
+					c.Args[i] = a
+					if to, ok := regs[a]; ok {
+						c.Args[i] = to
+					}
+				}
+				insts = append(insts, &c)
+			}
+			prev = regs
+		}
+		b.Insts = append(insts, &Inst{Op: Jmp, Targets: []*Block{exit}})
+	}
+}
+
+// tripCount returns the number of times the loop of the single block b,
+// entered from pre, runs, or 0 if it is not known or too large.
+func tripCount(f *Func, b, pre *Block) int {
+	defs := definitions(f)
+	vals := map[Reg]int64{}
+	known := func(r Reg) (int64, bool) {
+		if v, ok := vals[r]; ok {
+			return v, true
+		}
+		if def := defs[r]; def != nil && def.Op == Const && def.Ty.IsInt() {
+			return def.Imm, true
+		}
+		return 0, false
+	}
+
+	for trips := 1; trips <= maxUnrollTrips; trips++ {
+		// the Phis take their values at once
+		next := map[Reg]int64{}
+		for _, in := range b.Insts {
+			if in.Op != Phi {
+				break
+			}
+			from := pre
+			if trips > 1 {
+				from = b
+			}
+			if v, ok := known(in.Args[slices.Index(in.Targets, from)]); ok {
+				next[in.Dst] = v
+			}
+		}
+		for _, in := range b.Insts {
+			if in.Op == Phi {
+				delete(vals, in.Dst)
+				if v, ok := next[in.Dst]; ok {
+					vals[in.Dst] = v
+				}
+				continue
+			}
+			if in.HasResult() {
+				delete(vals, in.Dst)
+				if !f.Regs[in.Dst].IsInt() {
+					continue
+				}
+				switch in.Op {
+				case Mov, Conv:
+					if v, ok := known(in.Args[0]); ok && f.Regs[in.Args[0]].IsInt() {
+						vals[in.Dst] = extend(in.Ty, v)
+					}
+				case Add, Sub, Mul, Div, Shl, Shr, And, Or, Eq, Ne, Lt, Le:
+					x, okx := known(in.Args[0])
+					y, oky := known(in.Args[1])
+					if !okx || !oky {
+						continue
+					}
+					if v, ok := foldInt(in.Op, in.Ty, x, y); ok {
+						vals[in.Dst] = v
+					}
+				}
+			}
+		}
+
+		br := b.Terminator()
+		c, ok := known(br.Args[0])
+		if !ok {
+			return 0
+		}
+		taken := br.Targets[1]
+		if c != 0 {
+			taken = br.Targets[0]
+		}
+		if taken != b {
+			return trips
+		}
+	}
+	return 0
+}
@@ -0,0 +1,607 @@
+package ir
+
+import (
+	"fmt"
+	"slices"
+
+	"rkitamu/gocc/parser"
+)
+
+// lowerer translates one function from the AST.
+type lowerer struct {
+	f     *Func
+	cur   *Block // block being filled
+	slots map[*parser.LVar]*Slot
+}
+
+// Lower translates the function definitions in funcs to the IR. The
+// functions must have been checked by sema, which lays out their frames.
+func Lower(funcs []*parser.Function, globals []*parser.Global) (*Program, error) {
+	prog := &Program{Globals: globals}
+	for _, fn := range funcs {
+		if !fn.IsDefinition {
+			continue
+		}
+		f, err := LowerFunc(fn)
+		if err != nil {
+			return nil, err
+		}
+		prog.Funcs = append(prog.Funcs, f)
+	}
+	return prog, nil
+}
+
+// LowerFunc translates the function definition fn to the IR.
+func LowerFunc(fn *parser.Function) (*Func, error) {
+	f := &Func{Name: fn.Name, IsStatic: fn.IsStatic, IsInlineDef: fn.IsInlineDef, Inline: fn.Inline, RetTy: I32, FrameSize: fn.StackSize}
+	if fn.Ty != nil {
+		f.RetTy = typeOf(fn.Ty.ReturnTy)
+		f.Variadic = fn.Ty.IsVariadic
+	}
+	l := &lowerer{f: f, slots: map[*parser.LVar]*Slot{}}
+	l.cur = f.NewBlock()
+
+	// the parameters are stored to their variables, whose address may be
+	// taken
+	for _, param := range fn.Params {
+		f.Params = append(f.Params, f.NewReg(typeOf(param.Ty)))
+	}
+	for i, param := range fn.Params {
+		l.store(typeOf(param.Ty), l.slotAddr(param, 0), f.Params[i], param.Ty.IsVolatile)
+	}
+	if fn.VaArea != nil {
+		f.VaArea = l.slot(fn.VaArea)
+	}
+
+	if err := l.stmt(fn.Body); err != nil {
+		return nil, err
+	}
+
+	// reaching the end of main returns 0 (C11 5.1.2.2.3)
+	if fn.Name == "main" {
+		l.emit(&Inst{Op: Ret, Args: []Reg{l.constInt(I32, 0)}})
+	} else {
+		l.emit(&Inst{Op: Ret})
+	}
+	RemoveUnreachable(f)
+	return f, nil
+}
+
+// typeOf returns the type of a value of the C type ty in a register. The
+// value of an array, struct or function is its address.
+func typeOf(ty *parser.Type) Type {
+	switch ty.Kind {
+	case parser.TY_VOID:
+		return Void
+	case parser.TY_FLOAT:
+		return F32
+	case parser.TY_DOUBLE:
+		return F64
+	case parser.TY_BOOL, parser.TY_CHAR, parser.TY_SHORT, parser.TY_INT, parser.TY_LONG:
+		t := map[int]Type{1: I8, 2: I16, 4: I32, 8: I64}[ty.Size]
+		if ty.Unsigned {
+			t += U8 - I8
+		}
+		return t
+	}
+	return U64
+}
+
+// isAggregate reports whether a value of type ty is kept in memory and
+// referred to by its address.
+func isAggregate(ty *parser.Type) bool {
+	switch ty.Kind {
+	case parser.TY_ARRAY, parser.TY_VLA, parser.TY_STRUCT, parser.TY_FUNC:
+		return true
+	}
+	return false
+}
+
+// emit appends in to the current block. Instructions after a terminator
+// are unreachable and go to a new block, which is removed later.
+func (l *lowerer) emit(in *Inst) {
+	if t := l.cur.Terminator(); t != nil && t.Op.IsTerminator() {
+		l.cur = l.f.NewBlock()
+	}
+	l.cur.Insts = append(l.cur.Insts, in)
+}
+
+// value emits an instruction with a new result register of type ty.
+func (l *lowerer) value(in *Inst, ty Type) Reg {
+	in.Dst = l.f.NewReg(ty)
+	l.emit(in)
+	return in.Dst
+}
+
+func (l *lowerer) constInt(ty Type, val int64) Reg {
+	return l.value(&Inst{Op: Const, Ty: ty, Imm: val}, ty)
+}
+
+func (l *lowerer) binary(op Op, ty Type, a, b Reg) Reg {
+	resTy := ty
+	if op >= Eq && op <= Le {
+		resTy = I32
+	}
+	return l.value(&Inst{Op: op, Ty: ty, Args: []Reg{a, b}}, resTy)
+}
+
+// conv converts the value in r to ty, if it is not of that type already.
+func (l *lowerer) conv(r Reg, ty Type) Reg {
+	if l.f.Regs[r] == ty {
+		return r
+	}
+	return l.value(&Inst{Op: Conv, Ty: ty, Args: []Reg{r}}, ty)
+}
+
+func (l *lowerer) load(ty Type, addr Reg, volatile bool) Reg {
+	return l.value(&Inst{Op: Load, Ty: ty, Args: []Reg{addr}, Volatile: volatile}, ty)
+}
+
+func (l *lowerer) store(ty Type, addr, val Reg, volatile bool) {
+	l.emit(&Inst{Op: Store, Ty: ty, Args: []Reg{addr, val}, Volatile: volatile})
+}
+
+// jump ends the current block with a jump to b, unless it already ends.
+func (l *lowerer) jump(b *Block) {
+	if t := l.cur.Terminator(); t == nil || !t.Op.IsTerminator() {
+		l.emit(&Inst{Op: Jmp, Targets: []*Block{b}})
+	}
+}
+
+// slot returns the stack slot of the local variable v, at the place sema
+// assigned to it.
+func (l *lowerer) slot(v *parser.LVar) *Slot {
+	if s, ok := l.slots[v]; ok {
+		return s
+	}
+	s := &Slot{ID: len(l.f.Slots), Name: v.Name, Align: max(v.Align, 1), Offset: v.Offset}
+	if v.Ty != nil {
+		s.Size = v.Ty.Size
+	}
+	l.f.Slots = append(l.f.Slots, s)
+	l.slots[v] = s
+	l.f.FrameSize = max(l.f.FrameSize, (v.Offset+15)/16*16)
+	return s
+}
+
+func (l *lowerer) slotAddr(v *parser.LVar, offset int) Reg {
+	return l.value(&Inst{Op: SlotAddr, Slot: l.slot(v), Imm: int64(offset)}, U64)
+}
+
+func (l *lowerer) stmt(node *parser.Node) error {
+	switch node.Kind {
+	case parser.RETURN:
+		ret := &Inst{Op: Ret}
+		if node.Lhs != nil {
+			r, err := l.expr(node.Lhs)
+			if err != nil {
+				return err
+			}
+			if r != 0 && l.f.RetTy != Void {
+				ret.Args = []Reg{l.conv(r, l.f.RetTy)}
+			}
+		}
+		l.emit(ret)
+		return nil
+	case parser.IF:
+		cond, err := l.truth(node.Cond)
+		if err != nil {
+			return err
+		}
+		then, els, end := l.f.NewBlock(), l.f.NewBlock(), l.f.NewBlock()
+		l.emit(&Inst{Op: Br, Args: []Reg{cond}, Targets: []*Block{then, els}})
+
+		l.cur = then
+		if err := l.stmt(node.Then); err != nil {
+			return err
+		}
+		l.jump(end)
+
+		l.cur = els
+		if node.Else != nil {
+			if err := l.stmt(node.Else); err != nil {
+				return err
+			}
+		}
+		l.jump(end)
+		l.cur = end
+		return nil
+	case parser.FOR:
+		// the loop is rotated: the condition is tested before the first
+		// iteration and again after each, so that a body without branches
+		// is a single block that jumps back to itself
+		if node.Init != nil {
+			if err := l.stmt(node.Init); err != nil {
+				return err
+			}
+		}
+		body, end := l.f.NewBlock(), l.f.NewBlock()
+		if err := l.loopTest(node.Cond, body, end); err != nil {
+			return err
+		}
+
+		l.cur = body
+		if err := l.stmt(node.Then); err != nil {
+			return err
+		}
+		if node.Inc != nil {
+			if _, err := l.expr(node.Inc); err != nil {
+				return err
+			}
+		}
+		if err := l.loopTest(node.Cond, body, end); err != nil {
+			return err
+		}
+		l.cur = end
+		return nil
+	case parser.BLOCK:
+		for _, n := range node.Body {
+			if err := l.stmt(n); err != nil {
+				return err
+			}
+		}
+		return nil
+	case parser.MEMZERO:
+		l.emit(&Inst{Op: MemZero, Args: []Reg{l.slotAddr(node.Var, 0)}, Imm: int64(node.Ty.Size)})
+		return nil
+	case parser.VLA_ALLOC:
+		// the variable holds the address of the elements
+		size, err := l.expr(node.Lhs)
+		if err != nil {
+			return err
+		}
+		p := l.value(&Inst{Op: Alloca, Args: []Reg{l.conv(size, U64)}}, U64)
+		l.store(U64, l.slotAddr(node.Var, 0), p, false)
+		return nil
+	case parser.VLA_SAVE:
+		sp := l.value(&Inst{Op: GetSP}, U64)
+		l.store(U64, l.slotAddr(node.Var, 0), sp, false)
+		return nil
+	case parser.VLA_FREE:
+		sp := l.load(U64, l.slotAddr(node.Var, 0), false)
+		l.emit(&Inst{Op: SetSP, Args: []Reg{sp}})
+		return nil
+	case parser.ASM:
+		return l.asm(node.Asm)
+	}
+
+	// expression statement: discard the value
+	_, err := l.expr(node)
+	return err
+}
+
+// loopTest ends the current block with a branch to body if the loop
+// condition cond holds, and to end otherwise. A loop without a condition
+// always runs its body.
+func (l *lowerer) loopTest(cond *parser.Node, body, end *Block) error {
+	if cond == nil {
+		l.jump(body)
+		return nil
+	}
+	r, err := l.truth(cond)
+	if err != nil {
+		return err
+	}
+	l.emit(&Inst{Op: Br, Args: []Reg{r}, Targets: []*Block{body, end}})
+	return nil
+}
+
+// truth evaluates the scalar node to a register that is not zero if and
+// only if node is true.
+func (l *lowerer) truth(node *parser.Node) (Reg, error) {
+	r, err := l.expr(node)
+	if err != nil {
+		return 0, err
+	}
+	if ty := l.f.Regs[r]; ty.IsFloat() {
+		zero := l.value(&Inst{Op: Const, Ty: ty}, ty)
+		return l.binary(Ne, ty, r, zero), nil
+	}
+	return r, nil
+}
+
+// addr evaluates the address of the lvalue node.
+func (l *lowerer) addr(node *parser.Node) (Reg, error) {
+	switch node.Kind {
+	case parser.LVAR:
+		a := l.slotAddr(node.Var, node.Offset)
+		if node.Ty.Kind == parser.TY_VLA {
+			// the variable holds the address of the elements
+			return l.load(U64, a, false), nil
+		}
+		return a, nil
+	case parser.GVAR:
+		return l.value(&Inst{Op: Global, Sym: node.Label, Imm: int64(node.Offset)}, U64), nil
+	case parser.DEREF:
+		// the address is the value of the pointer
+		return l.expr(node.Lhs)
+	case parser.MEMBER:
+		base, err := l.addr(node.Lhs)
+		if err != nil {
+			return 0, err
+		}
+		if node.Member.Offset == 0 {
+			return base, nil
+		}
+		return l.binary(Add, U64, base, l.constInt(U64, int64(node.Member.Offset))), nil
+	case parser.COMMA:
+		if err := l.stmt(node.Lhs); err != nil {
+			return 0, err
+		}
+		return l.addr(node.Rhs)
+	}
+	return 0, fmt.Errorf("not an lvalue: node kind %d", node.Kind)
+}
+
+// expr evaluates node to a register of type typeOf(node.Ty), or to 0 if
+// node is void.
+func (l *lowerer) expr(node *parser.Node) (Reg, error) {
+	switch node.Kind {
+	case parser.NUM:
+		ty := typeOf(node.Ty)
+		if ty.IsFloat() {
+			return l.value(&Inst{Op: Const, Ty: ty, FImm: node.FVal}, ty), nil
+		}
+		return l.constInt(ty, int64(node.Val)), nil
+	case parser.LVAR, parser.GVAR, parser.MEMBER:
+		a, err := l.addr(node)
+		if err != nil {
+			return 0, err
+		}
+		if isAggregate(node.Ty) {
+			return a, nil
+		}
+		if node.Kind == parser.MEMBER && node.Member.IsBitfield {
+			return l.loadBitfield(a, node.Member, node.Ty.IsVolatile), nil
+		}
+		return l.load(typeOf(node.Ty), a, node.Ty.IsVolatile), nil
+	case parser.ASSIGN:
+		return l.assign(node)
+	case parser.CAST:
+		r, err := l.expr(node.Lhs)
+		if err != nil {
+			return 0, err
+		}
+		return l.cast(r, node.Lhs.Ty, node.Ty), nil
+	case parser.FUNCALL:
+		return l.call(node)
+	case parser.ADDR:
+		return l.addr(node.Lhs)
+	case parser.DEREF:
+		a, err := l.expr(node.Lhs)
+		if err != nil {
+			return 0, err
+		}
+		if isAggregate(node.Ty) {
+			return a, nil
+		}
+		return l.load(typeOf(node.Ty), a, node.Ty.IsVolatile), nil
+	case parser.VA_START:
+		ap, err := l.expr(node.Lhs)
+		if err != nil {
+			return 0, err
+		}
+		l.emit(&Inst{Op: VaStart, Args: []Reg{ap}})
+		return 0, nil
+	case parser.VA_ARG:
+		ap, err := l.expr(node.Lhs)
+		if err != nil {
+			return 0, err
+		}
+		ty := typeOf(node.Ty)
+		a := l.value(&Inst{Op: VaArg, Ty: ty, Args: []Reg{ap}}, U64)
+		return l.load(ty, a, false), nil
+	case parser.COMMA:
+		if err := l.stmt(node.Lhs); err != nil {
+			return 0, err
+		}
+		return l.expr(node.Rhs)
+	case parser.ADD, parser.SUB, parser.MUL, parser.DIV, parser.EQ, parser.NEQ, parser.LT, parser.LTE:
+		a, err := l.expr(node.Lhs)
+		if err != nil {
+			return 0, err
+		}
+		b, err := l.expr(node.Rhs)
+		if err != nil {
+			return 0, err
+		}
+		op := map[parser.NodeKind]Op{
+			parser.ADD: Add, parser.SUB: Sub, parser.MUL: Mul, parser.DIV: Div,
+			parser.EQ: Eq, parser.NEQ: Ne, parser.LT: Lt, parser.LTE: Le,
+		}[node.Kind]
+
+		// comparisons are done in the type of the converted operands
+		ty := typeOf(node.Ty)
+		if op >= Eq {
+			ty = typeOf(node.Lhs.Ty)
+		}
+		return l.binary(op, ty, l.conv(a, ty), l.conv(b, ty)), nil
+	case parser.SHL:
+		a, err := l.expr(node.Lhs)
+		if err != nil {
+			return 0, err
+		}
+		b, err := l.expr(node.Rhs)
+		if err != nil {
+			return 0, err
+		}
+		ty := typeOf(node.Ty)
+		return l.binary(Shl, ty, a, l.conv(b, ty)), nil
+	}
+	return 0, fmt.Errorf("unsupported node kind %d", node.Kind)
+}
+
+// cast converts the value r of type from to type to.
+func (l *lowerer) cast(r Reg, from, to *parser.Type) Reg {
+	switch {
+	case to.Kind == parser.TY_VOID:
+		return 0
+	case to.Kind == parser.TY_BOOL:
+		// any value but zero converts to 1, NaN included
+		ty := l.f.Regs[r]
+		zero := l.value(&Inst{Op: Const, Ty: ty}, ty)
+		return l.conv(l.binary(Ne, ty, r, zero), U8)
+	}
+	return l.conv(r, typeOf(to))
+}
+
+// assign stores the value of the right operand of the assignment node to
+// the left operand. The result is the stored value; for a struct, whose
+// bytes are copied, it is the address of the left operand.
+func (l *lowerer) assign(node *parser.Node) (Reg, error) {
+	a, err := l.addr(node.Lhs)
+	if err != nil {
+		return 0, err
+	}
+	v, err := l.expr(node.Rhs)
+	if err != nil {
+		return 0, err
+	}
+
+	lhs := node.Lhs
+	switch {
+	case lhs.Ty.Kind == parser.TY_STRUCT:
+		l.emit(&Inst{Op: MemCopy, Args: []Reg{a, v}, Imm: int64(lhs.Ty.Size)})
+		return a, nil
+	case lhs.Kind == parser.MEMBER && lhs.Member.IsBitfield:
+		return l.storeBitfield(a, v, lhs.Member, lhs.Ty.IsVolatile), nil
+	}
+	ty := typeOf(lhs.Ty)
+	v = l.conv(v, ty)
+	l.store(ty, a, v, lhs.Ty.IsVolatile)
+	return v, nil
+}
+
+// extractBits returns the width bits of r from bit offset on, sign or zero
+// extended according to ty.
+func (l *lowerer) extractBits(r Reg, offset, width int, ty Type) Reg {
+	shiftTy := I64
+	if !ty.IsSigned() {
+		shiftTy = U64
+	}
+	r = l.binary(Shl, shiftTy, l.conv(r, shiftTy), l.constInt(shiftTy, int64(64-width-offset)))
+	r = l.binary(Shr, shiftTy, r, l.constInt(shiftTy, int64(64-width)))
+	return l.conv(r, ty)
+}
+
+// loadBitfield reads the bit-field m from its storage unit at address a.
+func (l *lowerer) loadBitfield(a Reg, m *parser.Member, volatile bool) Reg {
+	ty := typeOf(m.Ty)
+	return l.extractBits(l.load(ty, a, volatile), m.BitOffset, m.BitWidth, ty)
+}
+
+// storeBitfield writes v to the bits of the bit-field m in its storage unit
+// at address a, keeping the other bits of the unit. It returns the value as
+// read back from the bit-field.
+func (l *lowerer) storeBitfield(a, v Reg, m *parser.Member, volatile bool) Reg {
+	ty := typeOf(m.Ty)
+	mask := uint64(1)<<m.BitWidth - 1
+	v = l.conv(v, U64)
+	bits := l.binary(And, U64, v, l.constInt(U64, int64(mask)))
+	bits = l.binary(Shl, U64, bits, l.constInt(U64, int64(m.BitOffset)))
+
+	unit := l.conv(l.load(ty, a, volatile), U64)
+	unit = l.binary(And, U64, unit, l.constInt(U64, int64(^(mask<<m.BitOffset))))
+	unit = l.binary(Or, U64, unit, bits)
+	l.store(ty, a, l.conv(unit, ty), volatile)
+
+	return l.extractBits(v, 0, m.BitWidth, ty)
+}
+
+// call evaluates the arguments from left to right and calls the function.
+func (l *lowerer) call(node *parser.Node) (Reg, error) {
+	in := &Inst{Op: Call, Ty: typeOf(node.Ty), Sym: node.FuncName, Fixed: node.Fixed}
+	if node.Lhs != nil {
+		fn, err := l.expr(node.Lhs)
+		if err != nil {
+			return 0, err
+		}
+		in.Sym = ""
+		in.Args = append(in.Args, fn)
+	}
+	for _, arg := range node.Args {
+		r, err := l.expr(arg)
+		if err != nil {
+			return 0, err
+		}
+		in.Args = append(in.Args, r)
+	}
+	if in.Ty == Void {
+		l.emit(in)
+		return 0, nil
+	}
+	return l.value(in, in.Ty), nil
+}
+
+// asm evaluates the operands of an inline assembly statement: the
+// addresses of the outputs, then the inputs.
+func (l *lowerer) asm(asm *parser.AsmStmt) error {
+	in := &Inst{Op: Asm, Asm: asm}
+	for _, op := range asm.Outputs {
+		a, err := l.addr(op.Expr)
+		if err != nil {
+			return err
+		}
+		in.Args = append(in.Args, a)
+	}
+	for _, op := range asm.Inputs {
+		var r Reg
+		var err error
+		switch op.Constraint {
+		case parser.ASM_REG:
+			r, err = l.expr(op.Expr)
+		case parser.ASM_MEM:
+			r, err = l.addr(op.Expr)
+		}
+		if err != nil {
+			return err
+		}
+		in.Args = append(in.Args, r)
+	}
+	l.emit(in)
+	return nil
+}
+
+// RemoveUnreachable removes the blocks of f that cannot be reached from the
+// entry, and renumbers the others in order. The Phis drop the values from
+// blocks that are no longer predecessors.
+func RemoveUnreachable(f *Func) {
+	reached := map[*Block]bool{}
+	var visit func(b *Block)
+	visit = func(b *Block) {
+		if reached[b] {
+			return
+		}
+		reached[b] = true
+		for _, s := range b.Succs() {
+			visit(s)
+		}
+	}
+	visit(f.Blocks[0])
+
+	blocks := f.Blocks[:0]
+	for _, b := range f.Blocks {
+		if reached[b] {
+			b.ID = len(blocks)
+			blocks = append(blocks, b)
+		}
+	}
+	f.Blocks = blocks
+
+	preds := Preds(f)
+	for _, b := range f.Blocks {
+		for _, in := range b.Insts {
+			if in.Op != Phi {
+				break
+			}
+			args, targets := in.Args[:0], in.Targets[:0]
+			for i, p := range in.Targets {
+				if slices.Contains(preds[b.ID], p) {
+					args = append(args, in.Args[i])
+					targets = append(targets, p)
+				}
+			}
+			in.Args, in.Targets = args, targets
+		}
+	}
+}
@@ -0,0 +1,329 @@
+package ir
+
+// lattice is the value of a register during SCCP: unknown until an
+// executable definition is seen, then a constant, then overdefined once it
+// may take more than one value.
+type lattice struct {
+	state int // latticeTop, latticeConst or latticeBottom
+	val   int64
+}
+
+const (
+	latticeTop = iota
+	latticeConst
+	latticeBottom
+)
+
+var bottom = lattice{state: latticeBottom}
+
+// meet returns the value of a register that may be a or b.
+func meet(a, b lattice) lattice {
+	switch {
+	case a.state == latticeTop:
+		return b
+	case b.state == latticeTop:
+		return a
+	case a == b:
+		return a
+	}
+	return bottom
+}
+
+// SCCP performs sparse conditional constant propagation on f, which must be
+// in SSA form, following Wegman and Zadeck, "Constant Propagation with
+// Conditional Branches". Integer registers that hold the same constant on
+// every executable path are replaced by Consts, branches on constants
+// become jumps, and the blocks that cannot execute are removed.
+func SCCP(f *Func) {
+	s := &sccp{
+		f:        f,
+		vals:     make([]lattice, len(f.Regs)),
+		executed: make([]bool, len(f.Blocks)),
+		edges:    map[[2]*Block]bool{},
+		uses:     map[Reg][]*Inst{},
+		blockOf:  map[*Inst]*Block{},
+	}
+	for _, r := range f.Params {
+		s.vals[r] = bottom
+	}
+	for _, b := range f.Blocks {
+		for _, in := range b.Insts {
+			s.blockOf[in] = b
+			for _, r := range in.Args {
+				s.uses[r] = append(s.uses[r], in)
+			}
+		}
+	}
+	s.run()
+	s.rewrite()
+}
+
+type sccp struct {
+	f        *Func
+	vals     []lattice
+	executed []bool
+	edges    map[[2]*Block]bool // executable edges
+	uses     map[Reg][]*Inst
+	blockOf  map[*Inst]*Block
+
+	flowWork []*Block // blocks reached by a new executable edge
+	ssaWork  []Reg    // registers whose value changed
+}
+
+func (s *sccp) run() {
+	s.flowWork = append(s.flowWork, s.f.Blocks[0])
+	for len(s.flowWork) > 0 || len(s.ssaWork) > 0 {
+		for len(s.flowWork) > 0 {
+			b := s.flowWork[len(s.flowWork)-1]
+			s.flowWork = s.flowWork[:len(s.flowWork)-1]
+			if s.executed[b.ID] {
+				// only the Phis see the new edge
+				for _, in := range b.Insts {
+					if in.Op != Phi {
+						break
+					}
+					s.visit(b, in)
+				}
+				continue
+			}
+			s.executed[b.ID] = true
+			for _, in := range b.Insts {
+				s.visit(b, in)
+			}
+		}
+		for len(s.ssaWork) > 0 {
+			r := s.ssaWork[len(s.ssaWork)-1]
+			s.ssaWork = s.ssaWork[:len(s.ssaWork)-1]
+			for _, in := range s.uses[r] {
+				if b := s.blockOf[in]; s.executed[b.ID] {
+					s.visit(b, in)
+				}
+			}
+		}
+	}
+}
+
+// markEdge makes the edge from b to succ executable.
+func (s *sccp) markEdge(b, succ *Block) {
+	e := [2]*Block{b, succ}
+	if !s.edges[e] {
+		s.edges[e] = true
+		s.flowWork = append(s.flowWork, succ)
+	}
+}
+
+// visit evaluates in, an instruction of the executable block b.
+func (s *sccp) visit(b *Block, in *Inst) {
+	switch in.Op {
+	case Jmp:
+		s.markEdge(b, in.Targets[0])
+		return
+	case Br:
+		switch c := s.vals[in.Args[0]]; c.state {
+		case latticeConst:
+			if c.val != 0 {
+				s.markEdge(b, in.Targets[0])
+			} else {
+				s.markEdge(b, in.Targets[1])
+			}
+		case latticeBottom:
+			s.markEdge(b, in.Targets[0])
+			s.markEdge(b, in.Targets[1])
+		}
+		return
+	}
+	if !in.HasResult() {
+		return
+	}
+
+	val := s.eval(b, in)
+	if old := s.vals[in.Dst]; meet(old, val) != old {
+		s.vals[in.Dst] = meet(old, val)
+		s.ssaWork = append(s.ssaWork, in.Dst)
+	}
+}
+
+// eval returns the value of the result of in given the current values of
+// its operands.
+func (s *sccp) eval(b *Block, in *Inst) lattice {
+	if in.Op == Phi {
+		val := lattice{}
+		for i, p := range in.Targets {
+			if s.edges[[2]*Block{p, b}] {
+				val = meet(val, s.vals[in.Args[i]])
+			}
+		}
+		return val
+	}
+	if s.f.Regs[in.Dst].IsFloat() {
+		return bottom
+	}
+
+	args := make([]int64, len(in.Args))
+	for i, r := range in.Args {
+		switch v := s.vals[r]; v.state {
+		case latticeTop:
+			return lattice{}
+		case latticeBottom:
+			return bottom
+		default:
+			args[i] = v.val
+		}
+	}
+	switch in.Op {
+	case Const:
+		return lattice{state: latticeConst, val: in.Imm}
+	case Mov:
+		return lattice{state: latticeConst, val: args[0]}
+	case Conv:
+		if s.f.Regs[in.Args[0]].IsFloat() {
+			return bottom
+		}
+		return lattice{state: latticeConst, val: extend(in.Ty, args[0])}
+	case Add, Sub, Mul, Div, Shl, Shr, And, Or, Eq, Ne, Lt, Le:
+		if in.Ty.IsFloat() {
+			return bottom
+		}
+		if val, ok := foldInt(in.Op, in.Ty, args[0], args[1]); ok {
+			return lattice{state: latticeConst, val: val}
+		}
+	}
+	return bottom
+}
+
+// rewrite replaces the constant registers and branches, and removes the
+// blocks that never execute.
+func (s *sccp) rewrite() {
+	for _, b := range s.f.Blocks {
+		if !s.executed[b.ID] {
+			continue
+		}
+		// Phis that became Consts go after the remaining Phis
+		var phis, consts, rest []*Inst
+		for _, in := range b.Insts {
+			if in.HasResult() && in.Op != Const && isPure(in.Op) && s.vals[in.Dst].state == latticeConst {
+				in = &Inst{Op: Const, Ty: s.f.Regs[in.Dst], Dst: in.Dst, Imm: s.vals[in.Dst].val}
+				consts = append(consts, in)
+				continue
+			}
+			if in.Op == Br {
+				if c := s.vals[in.Args[0]]; c.state == latticeConst {
+					taken := in.Targets[1]
+					if c.val != 0 {
+						taken = in.Targets[0]
+					}
+					in = &Inst{Op: Jmp, Targets: []*Block{taken}}
+				}
+			}
+			if in.Op == Phi {
+				phis = append(phis, in)
+			} else {
+				rest = append(rest, in)
+			}
+		}
+		b.Insts = append(append(phis, consts...), rest...)
+	}
+	RemoveUnreachable(s.f)
+}
+
+// isPure reports whether an instruction of op only computes its result
+// from its operands, so it can be removed or replaced when the result is
+// known or unused.
+func isPure(op Op) bool {
+	switch op {
+	case Const, Mov, Phi, Add, Sub, Mul, Div, Shl, Shr, And, Or, Eq, Ne, Lt, Le, Conv, SlotAddr, Global:
+		return true
+	}
+	return false
+}
+
+// foldInt computes a binary operation of the integer type ty on the
+// canonical values a and b as the generated code does, with wrapping
+// arithmetic and shift counts taken modulo 64. It reports false for a
+// division by zero, and for the overflowing signed division of the
+// minimum value by -1, which trap.
+func foldInt(op Op, ty Type, a, b int64) (int64, bool) {
+	var v int64
+	switch op {
+	case Add:
+		v = a + b
+	case Sub:
+		v = a - b
+	case Mul:
+		v = a * b
+	case Div:
+		switch {
+		case b == 0:
+			return 0, false
+		case !ty.IsSigned():
+			v = int64(uint64(a) / uint64(b))
+		case a == -1<<63 && b == -1:
+			return 0, false
+		default:
+			v = a / b
+		}
+	case Shl:
+		v = a << (uint64(b) & 63)
+	case Shr:
+		if ty.IsSigned() {
+			v = a >> (uint64(b) & 63)
+		} else {
+			v = int64(uint64(a) >> (uint64(b) & 63))
+		}
+	case And:
+		v = a & b
+	case Or:
+		v = a | b
+	case Eq, Ne, Lt, Le:
+		return boolInt(compareInt(op, ty, a, b)), true
+	default:
+		return 0, false
+	}
+	return extend(ty, v), true
+}
+
+func compareInt(op Op, ty Type, a, b int64) bool {
+	switch op {
+	case Eq:
+		return a == b
+	case Ne:
+		return a != b
+	}
+	if !ty.IsSigned() {
+		if op == Lt {
+			return uint64(a) < uint64(b)
+		}
+		return uint64(a) <= uint64(b)
+	}
+	if op == Lt {
+		return a < b
+	}
+	return a <= b
+}
+
+func boolInt(b bool) int64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// extend truncates v to the width of the integer type ty and extends it
+// back to its canonical 64-bit value.
+func extend(ty Type, v int64) int64 {
+	switch ty {
+	case I8:
+		return int64(int8(v))
+	case I16:
+		return int64(int16(v))
+	case I32:
+		return int64(int32(v))
+	case U8:
+		return int64(uint8(v))
+	case U16:
+		return int64(uint16(v))
+	case U32:
+		return int64(uint32(v))
+	}
+	return v
+}
@@ -0,0 +1,228 @@
+package ir
+
+// The lowering already defines every register once, but keeps each local
+// variable in a stack slot. BuildSSA promotes the slots whose address does
+// not escape to registers, adding Phi instructions where the values
+// stored along different paths meet, which puts the function in SSA form:
+// every register is defined by a single instruction that dominates its
+// uses. The optimizations rely on this; LeaveSSA replaces the Phis with
+// copies before the code is generated.
+
+// BuildSSA promotes the slots of f that are only loaded and stored as a
+// whole to registers, following Cytron et al., "Efficiently Computing
+// Static Single Assignment Form and the Control Dependence Graph".
+func BuildSSA(f *Func) {
+	RemoveUnreachable(f)
+	promoted := promotableSlots(f)
+	if len(promoted) == 0 {
+		return
+	}
+	dom := Dominators(f)
+
+	addrs := map[Reg]*Slot{}
+	stores := map[*Slot][]*Block{}
+	for _, b := range f.Blocks {
+		for _, in := range b.Insts {
+			if _, ok := promoted[in.Slot]; ok && in.Op == SlotAddr {
+				addrs[in.Dst] = in.Slot
+			}
+			if in.Op == Store && addrs[in.Args[0]] != nil {
+				stores[addrs[in.Args[0]]] = append(stores[addrs[in.Args[0]]], b)
+			}
+		}
+	}
+
+	// a slot needs a Phi at the iterated dominance frontier of its stores
+	phis := map[*Inst]*Slot{}
+	for _, s := range f.Slots {
+		ty, ok := promoted[s]
+		if !ok {
+			continue
+		}
+		work := append([]*Block(nil), stores[s]...)
+		placed := make([]bool, len(f.Blocks))
+		for len(work) > 0 {
+			b := work[len(work)-1]
+			work = work[:len(work)-1]
+			for _, d := range dom.Frontier[b.ID] {
+				if placed[d.ID] {
+					continue
+				}
+				placed[d.ID] = true
+				preds := dom.Preds[d.ID]
+				phi := &Inst{Op: Phi, Ty: ty, Dst: f.NewReg(ty), Args: make([]Reg, len(preds)), Targets: append([]*Block(nil), preds...)}
+				d.Insts = append([]*Inst{phi}, d.Insts...)
+				phis[phi] = s
+				work = append(work, d)
+			}
+		}
+	}
+
+	// Reading a variable before it is written is undefined, so it can
+	// read anything. Zero is as good as any other value.
+	undef := map[Type]Reg{}
+	var entry []*Inst
+	undefined := func(ty Type) Reg {
+		if r, ok := undef[ty]; ok {
+			return r
+		}
+		r := f.NewReg(ty)
+		entry = append(entry, &Inst{Op: Const, Ty: ty, Dst: r})
+		undef[ty] = r
+		return r
+	}
+
+	// rename walks the dominator tree, replacing the loads by the value
+	// last stored on the path from the entry
+	repl := map[Reg]Reg{}
+	values := map[*Slot][]Reg{}
+	current := func(s *Slot) Reg {
+		if vs := values[s]; len(vs) > 0 {
+			return vs[len(vs)-1]
+		}
+		return undefined(promoted[s])
+	}
+	var rename func(b *Block)
+	rename = func(b *Block) {
+		pushed := map[*Slot]int{}
+		insts := b.Insts[:0]
+		for _, in := range b.Insts {
+			switch {
+			case in.Op == Phi && phis[in] != nil:
+				values[phis[in]] = append(values[phis[in]], in.Dst)
+				pushed[phis[in]]++
+			case in.Op == SlotAddr && addrs[in.Dst] != nil:
+				continue
+			case in.Op == Load && addrs[in.Args[0]] != nil:
+				repl[in.Dst] = current(addrs[in.Args[0]])
+				continue
+			case in.Op == Store && addrs[in.Args[0]] != nil:
+				s := addrs[in.Args[0]]
+				values[s] = append(values[s], in.Args[1])
+				pushed[s]++
+				continue
+			}
+			insts = append(insts, in)
+		}
+		b.Insts = insts
+
+		for _, succ := range b.Succs() {
+			for _, in := range succ.Insts {
+				if s := phis[in]; s != nil {
+					for i, p := range in.Targets {
+						if p == b {
+							in.Args[i] = current(s)
+						}
+					}
+				}
+			}
+		}
+		for _, c := range dom.Children[b.ID] {
+			rename(c)
+		}
+		for s, n := range pushed {
+			values[s] = values[s][:len(values[s])-n]
+		}
+	}
+	rename(f.Blocks[0])
+	f.Blocks[0].Insts = append(entry, f.Blocks[0].Insts...)
+
+	slots := f.Slots[:0]
+	for _, s := range f.Slots {
+		if _, ok := promoted[s]; !ok {
+			slots = append(slots, s)
+		}
+	}
+	f.Slots = slots
+	replaceUses(f, repl)
+}
+
+// promotableSlots returns the slots of f whose address is only used to
+// load and store values of a single type of the size of the slot, with
+// that type. Such a slot can live in a register.
+func promotableSlots(f *Func) map[*Slot]Type {
+	types := map[*Slot]Type{}
+	escaped := map[*Slot]bool{f.VaArea: true}
+	addrs := map[Reg]*Slot{}
+	for _, b := range f.Blocks {
+		for _, in := range b.Insts {
+			if in.Op == SlotAddr {
+				addrs[in.Dst] = in.Slot
+				if in.Imm != 0 {
+					escaped[in.Slot] = true
+				}
+			}
+		}
+	}
+
+	for _, b := range f.Blocks {
+		for _, in := range b.Insts {
+			for i, r := range in.Args {
+				s := addrs[r]
+				if s == nil {
+					continue
+				}
+				if i != 0 || (in.Op != Load && in.Op != Store) || in.Volatile || in.Ty.Size() != s.Size {
+					escaped[s] = true
+					continue
+				}
+				if ty, ok := types[s]; ok && ty != in.Ty {
+					escaped[s] = true
+				}
+				types[s] = in.Ty
+			}
+		}
+	}
+
+	promoted := map[*Slot]Type{}
+	for s, ty := range types {
+		if !escaped[s] {
+			promoted[s] = ty
+		}
+	}
+	return promoted
+}
+
+// LeaveSSA replaces the Phi instructions of f by copies. Each Phi gets a
+// new register, which is copied to its result at the start of the block
+// and assigned at the end of each predecessor. The copies of different
+// Phis do not interfere, even along edges from a block with several
+// successors, since each register is only read by its own Phi.
+func LeaveSSA(f *Func) {
+	for _, b := range f.Blocks {
+		for i, in := range b.Insts {
+			if in.Op != Phi {
+				break
+			}
+			tmp := f.NewReg(in.Ty)
+			for j, p := range in.Targets {
+				mov := &Inst{Op: Mov, Ty: in.Ty, Dst: tmp, Args: []Reg{in.Args[j]}}
+				last := len(p.Insts) - 1
+				p.Insts = append(p.Insts[:last], mov, p.Insts[last])
+			}
+			b.Insts[i] = &Inst{Op: Mov, Ty: in.Ty, Dst: in.Dst, Args: []Reg{tmp}}
+		}
+	}
+}
+
+// replaceUses replaces each register in the operands of the instructions
+// of f by its replacement in repl, following chains of replacements.
+func replaceUses(f *Func, repl map[Reg]Reg) {
+	if len(repl) == 0 {
+		return
+	}
+	for _, b := range f.Blocks {
+		for _, in := range b.Insts {
+			for i, r := range in.Args {
+				for {
+					to, ok := repl[r]
+					if !ok {
+						break
+					}
+					r = to
+				}
+				in.Args[i] = r
+			}
+		}
+	}
+}
@@ -0,0 +1,35 @@
+package ir
+
+// TailCalls marks the calls of f that are followed by a return of their
+// result, or by a return without a value, as tail calls, which the backend
+// may turn into jumps that reuse the frame of f. This requires the frame to
+// be dead by the time of the call: f must have no slots, whose addresses
+// the callee could hold, and allocate nothing on the stack.
+func TailCalls(f *Func) {
+	if len(f.Slots) > 0 || f.VaArea != nil {
+		return
+	}
+	for _, b := range f.Blocks {
+		for _, in := range b.Insts {
+			if in.Op == Alloca {
+				return
+			}
+		}
+	}
+
+	for _, b := range f.Blocks {
+		n := len(b.Insts)
+		if n < 2 {
+			continue
+		}
+		call, ret := b.Insts[n-2], b.Insts[n-1]
+		if call.Op == Call && ret.Op == Ret && isTailReturn(call, ret) {
+			call.Tail = true
+		}
+	}
+}
+
+// isTailReturn reports whether ret returns what the call before it returns.
+func isTailReturn(call, ret *Inst) bool {
+	return len(ret.Args) == 0 || call.Dst != 0 && ret.Args[0] == call.Dst
+}
@@ -0,0 +1,227 @@
+package ir
+
+import (
+	"fmt"
+	"slices"
+)
+
+// Verify checks that f is well formed: every block ends with its only
+// terminator, which branches to blocks of f, and every instruction has the
+// operands its Op requires, of the right types, in registers defined by
+// some instruction or parameter. Phis must come first in their block and
+// have a value for each predecessor, and tail calls must come just before
+// a return. It reports the first problem found.
+func Verify(f *Func) error {
+	if len(f.Blocks) == 0 {
+		return fmt.Errorf("%s: no blocks", f.Name)
+	}
+	v := &verifier{f: f, blocks: map[*Block]bool{}, slots: map[*Slot]bool{}, defined: map[Reg]bool{}, preds: map[*Block][]*Block{}}
+	for i, b := range f.Blocks {
+		if b.ID != i {
+			return fmt.Errorf("%s: block %d has ID %d", f.Name, i, b.ID)
+		}
+		v.blocks[b] = true
+	}
+	for _, b := range f.Blocks {
+		for _, s := range b.Succs() {
+			v.preds[s] = append(v.preds[s], b)
+		}
+	}
+	for _, s := range f.Slots {
+		v.slots[s] = true
+	}
+	for _, r := range f.Params {
+		if err := v.reg(r); err != nil {
+			return fmt.Errorf("%s: parameter: %w", f.Name, err)
+		}
+		v.defined[r] = true
+	}
+	for _, b := range f.Blocks {
+		for _, in := range b.Insts {
+			if in.HasResult() {
+				v.defined[in.Dst] = true
+			}
+		}
+	}
+
+	for _, b := range f.Blocks {
+		if len(b.Insts) == 0 {
+			return fmt.Errorf("%s: %s: empty block", f.Name, b)
+		}
+		v.cur = b
+		for i, in := range b.Insts {
+			if in.Op.IsTerminator() != (i == len(b.Insts)-1) {
+				return fmt.Errorf("%s: %s: %s: a block must end with its only terminator", f.Name, b, in)
+			}
+			if in.Op == Phi && i > 0 && b.Insts[i-1].Op != Phi {
+				return fmt.Errorf("%s: %s: %s: phi after other instructions", f.Name, b, in)
+			}
+			if in.Tail && (in.Op != Call || i != len(b.Insts)-2 || b.Insts[i+1].Op != Ret || !isTailReturn(in, b.Insts[i+1])) {
+				return fmt.Errorf("%s: %s: %s: tail call not followed by a return of its result", f.Name, b, in)
+			}
+			if err := v.inst(in); err != nil {
+				return fmt.Errorf("%s: %s: %s: %w", f.Name, b, in, err)
+			}
+		}
+	}
+	return nil
+}
+
+// VerifyProgram verifies every function of prog.
+func VerifyProgram(prog *Program) error {
+	for _, f := range prog.Funcs {
+		if err := Verify(f); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type verifier struct {
+	f       *Func
+	blocks  map[*Block]bool
+	slots   map[*Slot]bool
+	defined map[Reg]bool
+	preds   map[*Block][]*Block
+	cur     *Block // block being checked
+}
+
+// reg checks that r is a register of f.
+func (v *verifier) reg(r Reg) error {
+	if r <= 0 || int(r) >= len(v.f.Regs) {
+		return fmt.Errorf("no register %s", r)
+	}
+	if v.f.Regs[r] == Void {
+		return fmt.Errorf("register %s has no type", r)
+	}
+	return nil
+}
+
+func (v *verifier) inst(in *Inst) error {
+	if in.HasResult() {
+		if err := v.reg(in.Dst); err != nil {
+			return err
+		}
+	} else if in.Dst != 0 {
+		return fmt.Errorf("%s has no result", in.Op)
+	}
+	for i, r := range in.Args {
+		if r == 0 && in.Op == Asm {
+			continue
+		}
+		if err := v.reg(r); err != nil {
+			return err
+		}
+		if !v.defined[r] {
+			return fmt.Errorf("operand %d is never defined", i)
+		}
+	}
+
+	switch in.Op {
+	case Const:
+		return v.check(in, 0, in.Ty != Void, in.Ty)
+	case Mov:
+		return v.check(in, 1, in.Ty != Void, in.Ty, in.Ty)
+	case Add, Sub, Mul, Div:
+		return v.check(in, 2, in.Ty.IsInt() || in.Ty.IsFloat(), in.Ty, in.Ty, in.Ty)
+	case Shl, Shr, And, Or:
+		return v.check(in, 2, in.Ty.IsInt(), in.Ty, in.Ty, in.Ty)
+	case Eq, Ne, Lt, Le:
+		return v.check(in, 2, in.Ty.IsInt() || in.Ty.IsFloat(), I32, in.Ty, in.Ty)
+	case Phi:
+		if len(in.Targets) != len(v.preds[v.cur]) {
+			return fmt.Errorf("phi takes a value for each predecessor")
+		}
+		for _, p := range in.Targets {
+			if !slices.Contains(v.preds[v.cur], p) {
+				return fmt.Errorf("%s is not a predecessor", p)
+			}
+		}
+		args := make([]Type, len(in.Args))
+		for i := range args {
+			args[i] = in.Ty
+		}
+		return v.check(in, len(in.Targets), in.Ty != Void, in.Ty, args...)
+	case Conv:
+		return v.check(in, 1, in.Ty != Void && v.f.Regs[in.Args[0]] != Void, in.Ty)
+	case SlotAddr:
+		if !v.slots[in.Slot] {
+			return fmt.Errorf("slot of another function")
+		}
+		return v.check(in, 0, true, U64)
+	case Global:
+		return v.check(in, 0, in.Sym != "", U64)
+	case Load:
+		return v.check(in, 1, in.Ty != Void, in.Ty, U64)
+	case Store:
+		return v.check(in, 2, in.Ty != Void, Void, U64, in.Ty)
+	case MemCopy:
+		return v.check(in, 2, in.Imm >= 0, Void, U64, U64)
+	case MemZero:
+		return v.check(in, 1, in.Imm >= 0, Void, U64)
+	case Alloca:
+		return v.check(in, 1, true, U64, U64)
+	case GetSP:
+		return v.check(in, 0, true, U64)
+	case SetSP:
+		return v.check(in, 1, true, Void, U64)
+	case VaStart:
+		return v.check(in, 1, v.f.VaArea != nil, Void, U64)
+	case VaArg:
+		return v.check(in, 1, in.Ty != Void && v.f.VaArea != nil, U64, U64)
+	case Call:
+		if in.Sym == "" && (len(in.Args) == 0 || v.f.Regs[in.Args[0]] != U64) {
+			return fmt.Errorf("indirect call without a callee address")
+		}
+		return v.check(in, len(in.Args), true, in.Ty)
+	case Asm:
+		if in.Asm == nil || len(in.Args) != len(in.Asm.Outputs)+len(in.Asm.Inputs) {
+			return fmt.Errorf("asm operands do not match the statement")
+		}
+	case Jmp:
+		return v.targets(in, 1)
+	case Br:
+		if err := v.targets(in, 2); err != nil {
+			return err
+		}
+		return v.check(in, 1, v.f.Regs[in.Args[0]].IsInt(), Void)
+	case Ret:
+		if len(in.Args) == 0 {
+			return nil
+		}
+		return v.check(in, 1, v.f.RetTy != Void, Void, v.f.RetTy)
+	}
+	return nil
+}
+
+// check checks that in has n operands, that ok holds, and that the result
+// and the first operands have the given types. A Void type is not checked.
+func (v *verifier) check(in *Inst, n int, ok bool, result Type, args ...Type) error {
+	if len(in.Args) != n {
+		return fmt.Errorf("%s takes %d operands", in.Op, n)
+	}
+	if !ok {
+		return fmt.Errorf("invalid %s", in.Op)
+	}
+	if result != Void && in.HasResult() && v.f.Regs[in.Dst] != result {
+		return fmt.Errorf("result must be %s", result)
+	}
+	for i, ty := range args {
+		if ty != Void && v.f.Regs[in.Args[i]] != ty {
+			return fmt.Errorf("operand %d must be %s", i, ty)
+		}
+	}
+	return nil
+}
+
+func (v *verifier) targets(in *Inst, n int) error {
+	if len(in.Targets) != n {
+		return fmt.Errorf("%s takes %d targets", in.Op, n)
+	}
+	for _, b := range in.Targets {
+		if !v.blocks[b] {
+			return fmt.Errorf("target is not a block of the function")
+		}
+	}
+	return nil
+}
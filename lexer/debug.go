@@ -10,7 +10,9 @@ func (l *Lexer) DebugPrintTokens(tok *Token) {
 		if tok.Str != "" {
 			fmt.Printf("(%q)", tok.Str)
 		}
-		if tok.Kind == NUM {
+		if tok.Kind == NUM && (tok.LitType == LIT_FLOAT || tok.LitType == LIT_DOUBLE) {
+			fmt.Printf(" val=%g", tok.FVal)
+		} else if tok.Kind == NUM {
 			fmt.Printf(" val=%d", tok.Val)
 		}
 		fmt.Println(" ->")
@@ -27,6 +29,8 @@ func tokenKindToString(kind TokenKind) string {
 		return "NUM"
 	case IDENT:
 		return "IDENT"
+	case STR:
+		return "STR"
 	case EOF:
 		return "EOF"
 	default:
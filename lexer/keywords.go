@@ -2,9 +2,44 @@ package lexer
 
 // Keywords maps keyword strings to their corresponding TokenKind.
 var Keywords = map[string]TokenKind{
-	"return": RETURN,
-	"if":     IF,
-	"else":   ELSE,
-	//"while":  WHILE,
-	//"for":    FOR,
+	"return":         RETURN,
+	"if":             IF,
+	"else":           ELSE,
+	"while":          WHILE,
+	"for":            FOR,
+	"char":           CHAR,
+	"short":          SHORT,
+	"int":            INT,
+	"long":           LONG,
+	"_Bool":          BOOL,
+	"signed":         SIGNED,
+	"unsigned":       UNSIGNED,
+	"void":           VOID,
+	"float":          FLOAT,
+	"double":         DOUBLE,
+	"static":         STATIC,
+	"extern":         EXTERN,
+	"const":          CONST,
+	"volatile":       VOLATILE,
+	"__volatile__":   VOLATILE,
+	"restrict":       RESTRICT,
+	"inline":         INLINE,
+	"__inline":       INLINE,
+	"__inline__":     INLINE,
+	"__attribute__":  ATTRIBUTE,
+	"__attribute":    ATTRIBUTE,
+	"struct":         STRUCT,
+	"va_list":        VA_LIST,
+	"va_start":       VA_START,
+	"va_arg":         VA_ARG,
+	"va_end":         VA_END,
+	"va_copy":        VA_COPY,
+	"_Static_assert": STATIC_ASSERT,
+	"_Alignof":       ALIGNOF,
+	"_Alignas":       ALIGNAS,
+	"_Generic":       GENERIC,
+	"sizeof":         SIZEOF,
+	"asm":            ASM,
+	"__asm__":        ASM,
+	"__asm":          ASM,
 }
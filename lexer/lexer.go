@@ -2,7 +2,6 @@ package lexer
 
 import (
 	"fmt"
-	"strconv"
 	"strings"
 
 	"rkitamu/gocc/errors"
@@ -25,13 +24,17 @@ func isDigit(ch rune) bool {
 }
 
 func isSymbol(ch rune) bool {
-	return strings.ContainsRune("+-*/=()<>;", ch)
+	return strings.ContainsRune("+-*/&=()<>;,{}[].:", ch)
 }
 
 func isAlpha(ch rune) bool {
 	return ('a' <= ch && ch <= 'z') || ('A' <= ch && ch <= 'Z')
 }
 
+func isIdentStart(ch rune) bool {
+	return isAlpha(ch) || ch == '_'
+}
+
 func isAlNum(ch rune) bool {
 	return isDigit(ch) || isAlpha(ch) || ch == '_'
 }
@@ -54,27 +57,41 @@ func (l *Lexer) Lex() (*Token, error) {
 		}
 
 		// if it's a digit, create a NUM token
-		if isDigit(ch) {
+		if isDigit(ch) || (ch == '.' && pos+1 < len(runes) && isDigit(runes[pos+1])) {
 			start := pos
-			for pos < len(runes) && isDigit(runes[pos]) {
-				pos++
+			for pos < len(runes) {
+				if isAlNum(runes[pos]) || runes[pos] == '.' {
+					pos++
+				} else if (runes[pos] == '+' || runes[pos] == '-') && strings.ContainsRune("eEpP", runes[pos-1]) {
+					// sign of an exponent
+					pos++
+				} else {
+					break
+				}
 			}
-			valueStr := string(runes[start:pos])
-			valueInt, err := strconv.Atoi(valueStr)
+			tok, err := l.readNumber(string(runes[start:pos]), start)
+			if err != nil {
+				return nil, err
+			}
+			cur.Next = tok
+			cur = cur.Next
+			continue
+		}
+
+		// if it's a string literal, create a STR token
+		if ch == '"' {
+			tok, next, err := l.readStringLiteral(runes, pos)
 			if err != nil {
-				return nil, errors.NewPosError(
-					fmt.Sprintf("invalid numeric literal: %s", valueStr),
-					l.input,
-					start,
-				)
+				return nil, err
 			}
-			cur.Next = &Token{Kind: NUM, Str: valueStr, Val: valueInt, Pos: start}
+			cur.Next = tok
 			cur = cur.Next
+			pos = next
 			continue
 		}
 
 		// if it's an identifier or keywords
-		if isAlpha(ch) {
+		if isIdentStart(ch) {
 			start := pos
 			for pos < len(runes) && isAlNum(runes[pos]) {
 				pos++
@@ -93,10 +110,16 @@ func (l *Lexer) Lex() (*Token, error) {
 		}
 
 		// if it's a symbol, check for multi-character operators
+		if pos+2 < len(runes) && string(runes[pos:pos+3]) == "..." {
+			cur.Next = &Token{Kind: RESERVED, Str: "...", Pos: pos}
+			cur = cur.Next
+			pos += 3
+			continue
+		}
 		if pos+1 < len(runes) {
 			two := string(runes[pos : pos+2])
 			switch two {
-			case "==", "!=", "<=", ">=":
+			case "==", "!=", "<=", ">=", "->":
 				cur.Next = &Token{Kind: RESERVED, Str: two, Pos: pos}
 				cur = cur.Next
 				pos += 2
@@ -120,6 +143,6 @@ func (l *Lexer) Lex() (*Token, error) {
 		)
 	}
 
-	cur.Next = &Token{Kind: EOF, Str: "EOF", Pos: pos}
+	cur.Next = &Token{Kind: EOF, Pos: pos}
 	return head.Next, nil
 }
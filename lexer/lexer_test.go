@@ -1,6 +1,10 @@
 package lexer
 
-import "testing"
+import (
+	"testing"
+
+	"rkitamu/gocc/errors"
+)
 
 func TestLexer(t *testing.T) {
 	cases := []struct {
@@ -20,6 +24,16 @@ func TestLexer(t *testing.T) {
 			},
 			wantErr: false,
 		},
+		{
+			name:  "loop keywords",
+			input: "while for",
+			want: []Token{
+				{Kind: WHILE, Str: "while"},
+				{Kind: FOR, Str: "for"},
+				{Kind: EOF, Str: ""},
+			},
+			wantErr: false,
+		},
 		{
 			name:  "complex test",
 			input: "3*(4-5)",
@@ -154,6 +168,109 @@ func TestLexer(t *testing.T) {
 			},
 			wantErr: false,
 		},
+		{
+			name:  "type keywords test",
+			input: "unsigned long long _Bool x",
+			want: []Token{
+				{Kind: UNSIGNED, Str: "unsigned"},
+				{Kind: LONG, Str: "long"},
+				{Kind: LONG, Str: "long"},
+				{Kind: BOOL, Str: "_Bool"},
+				{Kind: IDENT, Str: "x"},
+				{Kind: EOF, Str: ""},
+			},
+			wantErr: false,
+		},
+		{
+			name:  "storage class keywords test",
+			input: "static extern",
+			want: []Token{
+				{Kind: STATIC, Str: "static"},
+				{Kind: EXTERN, Str: "extern"},
+				{Kind: EOF, Str: ""},
+			},
+			wantErr: false,
+		},
+		{
+			name:  "C11 keywords test",
+			input: "_Static_assert _Alignof _Alignas _Generic(x, int: 1)",
+			want: []Token{
+				{Kind: STATIC_ASSERT, Str: "_Static_assert"},
+				{Kind: ALIGNOF, Str: "_Alignof"},
+				{Kind: ALIGNAS, Str: "_Alignas"},
+				{Kind: GENERIC, Str: "_Generic"},
+				{Kind: RESERVED, Str: "("},
+				{Kind: IDENT, Str: "x"},
+				{Kind: RESERVED, Str: ","},
+				{Kind: INT, Str: "int"},
+				{Kind: RESERVED, Str: ":"},
+				{Kind: NUM, Str: "1"},
+				{Kind: RESERVED, Str: ")"},
+				{Kind: EOF, Str: ""},
+			},
+			wantErr: false,
+		},
+		{
+			name:  "sizeof test",
+			input: "sizeof(int)",
+			want: []Token{
+				{Kind: SIZEOF, Str: "sizeof"},
+				{Kind: RESERVED, Str: "("},
+				{Kind: INT, Str: "int"},
+				{Kind: RESERVED, Str: ")"},
+				{Kind: EOF, Str: ""},
+			},
+			wantErr: false,
+		},
+		{
+			name:  "asm keywords test",
+			input: "asm __asm__ __volatile__",
+			want: []Token{
+				{Kind: ASM, Str: "asm"},
+				{Kind: ASM, Str: "__asm__"},
+				{Kind: VOLATILE, Str: "__volatile__"},
+				{Kind: EOF, Str: ""},
+			},
+			wantErr: false,
+		},
+		{
+			name:  "qualifier keywords test",
+			input: "const volatile restrict",
+			want: []Token{
+				{Kind: CONST, Str: "const"},
+				{Kind: VOLATILE, Str: "volatile"},
+				{Kind: RESTRICT, Str: "restrict"},
+				{Kind: EOF, Str: ""},
+			},
+			wantErr: false,
+		},
+		{
+			name:  "function specifier keywords test",
+			input: "inline __inline__ __attribute__",
+			want: []Token{
+				{Kind: INLINE, Str: "inline"},
+				{Kind: INLINE, Str: "__inline__"},
+				{Kind: ATTRIBUTE, Str: "__attribute__"},
+				{Kind: EOF, Str: ""},
+			},
+			wantErr: false,
+		},
+		{
+			name:  "variadic test",
+			input: "f(int n, ...) va_list",
+			want: []Token{
+				{Kind: IDENT, Str: "f"},
+				{Kind: RESERVED, Str: "("},
+				{Kind: INT, Str: "int"},
+				{Kind: IDENT, Str: "n"},
+				{Kind: RESERVED, Str: ","},
+				{Kind: RESERVED, Str: "..."},
+				{Kind: RESERVED, Str: ")"},
+				{Kind: VA_LIST, Str: "va_list"},
+				{Kind: EOF, Str: ""},
+			},
+			wantErr: false,
+		},
 		{
 			name:    "error test",
 			input:   "1+2a",
@@ -197,3 +314,166 @@ func TestLexer(t *testing.T) {
 		})
 	}
 }
+
+func TestLexer_IntLiteral(t *testing.T) {
+	cases := []struct {
+		input   string
+		val     int
+		litType LiteralType
+	}{
+		{"42", 42, LIT_INT},
+		{"0", 0, LIT_INT},
+		{"0x1F", 31, LIT_INT},
+		{"0XfF", 255, LIT_INT},
+		{"017", 15, LIT_INT},
+		{"0b101", 5, LIT_INT},
+		{"10u", 10, LIT_UINT},
+		{"10L", 10, LIT_LONG},
+		{"10ll", 10, LIT_LONG},
+		{"10uL", 10, LIT_ULONG},
+		{"10LLU", 10, LIT_ULONG},
+		{"2147483647", 2147483647, LIT_INT},
+		{"2147483648", 2147483648, LIT_LONG},
+		{"0x80000000", 0x80000000, LIT_UINT},
+		{"4294967296", 4294967296, LIT_LONG},
+		{"0xFFFFFFFFFFFFFFFF", -1, LIT_ULONG},
+		{"18446744073709551615u", -1, LIT_ULONG},
+	}
+
+	for _, c := range cases {
+		t.Run(c.input, func(t *testing.T) {
+			tok, err := NewLexer(c.input).Lex()
+			if err != nil {
+				t.Fatalf("Lex() unexpected error: %v", err)
+			}
+			if tok.Kind != NUM || tok.Val != c.val || tok.LitType != c.litType {
+				t.Errorf("got = %+v, want val = %d, type = %d", tok, c.val, c.litType)
+			}
+		})
+	}
+}
+
+func TestLexer_IntLiteralError(t *testing.T) {
+	cases := []struct {
+		input string
+		pos   int
+	}{
+		{"1 + 09", 4},
+		{"1 + 0b12", 4},
+		{"0x", 0},
+		{"12abc", 2},
+		{"1lL", 1},
+		{"1uu", 1},
+		{"18446744073709551616", 0},
+		{"9223372036854775808", 0},
+	}
+
+	for _, c := range cases {
+		t.Run(c.input, func(t *testing.T) {
+			_, err := NewLexer(c.input).Lex()
+			posErr, ok := err.(*errors.PosError)
+			if !ok {
+				t.Fatalf("Lex() expected PosError, but got %v", err)
+			}
+			if posErr.Pos != c.pos {
+				t.Errorf("error position: got = %d, want = %d (%s)", posErr.Pos, c.pos, posErr.Message)
+			}
+		})
+	}
+}
+
+func TestLexer_FloatLiteral(t *testing.T) {
+	cases := []struct {
+		input   string
+		val     float64
+		litType LiteralType
+	}{
+		{"1.5", 1.5, LIT_DOUBLE},
+		{".25", 0.25, LIT_DOUBLE},
+		{"3.", 3, LIT_DOUBLE},
+		{"1e3", 1000, LIT_DOUBLE},
+		{"2.5E-1", 0.25, LIT_DOUBLE},
+		{"1.5f", 1.5, LIT_FLOAT},
+		{"1.5L", 1.5, LIT_DOUBLE},
+		{"0x1.8p1", 3, LIT_DOUBLE},
+		{"0x10P-4f", 1, LIT_FLOAT},
+	}
+
+	for _, c := range cases {
+		t.Run(c.input, func(t *testing.T) {
+			tok, err := NewLexer(c.input).Lex()
+			if err != nil {
+				t.Fatalf("Lex() unexpected error: %v", err)
+			}
+			if tok.Kind != NUM || tok.FVal != c.val || tok.LitType != c.litType {
+				t.Errorf("got = %+v, want val = %g, type = %d", tok, c.val, c.litType)
+			}
+			if tok.Next.Kind != EOF {
+				t.Errorf("literal split into several tokens: %+v", tok.Next)
+			}
+		})
+	}
+}
+
+func TestLexer_FloatLiteralError(t *testing.T) {
+	cases := []string{"1e", "1.5x", "0x1.8", "1e400", "1.5ff"}
+
+	for _, input := range cases {
+		t.Run(input, func(t *testing.T) {
+			if _, err := NewLexer(input).Lex(); err == nil {
+				t.Errorf("Lex() expected error, but got none")
+			}
+		})
+	}
+}
+
+func TestLexer_StringLiteral(t *testing.T) {
+	cases := []struct {
+		input string
+		val   string
+	}{
+		{`"abc"`, "abc"},
+		{`""`, ""},
+		{`"a\nb\t\"\\"`, "a\nb\t\"\\"},
+		{`"\101\0"`, "A\x00"},
+		{`"\x41z"`, "Az"},
+		{`"\e\?"`, "\x1b?"},
+	}
+
+	for _, c := range cases {
+		t.Run(c.input, func(t *testing.T) {
+			tok, err := NewLexer(c.input).Lex()
+			if err != nil {
+				t.Fatalf("Lex() unexpected error: %v", err)
+			}
+			if tok.Kind != STR || tok.Str != c.input || tok.StrVal != c.val {
+				t.Errorf("got = %+v, want val = %q", tok, c.val)
+			}
+		})
+	}
+}
+
+func TestLexer_StringLiteralError(t *testing.T) {
+	cases := []struct {
+		input string
+		pos   int
+	}{
+		{`1 + "abc`, 4},
+		{"\"ab\ncd\"", 0},
+		{`"a\j"`, 2},
+		{`"\xg"`, 1},
+	}
+
+	for _, c := range cases {
+		t.Run(c.input, func(t *testing.T) {
+			_, err := NewLexer(c.input).Lex()
+			posErr, ok := err.(*errors.PosError)
+			if !ok {
+				t.Fatalf("Lex() expected PosError, but got %v", err)
+			}
+			if posErr.Pos != c.pos {
+				t.Errorf("error position: got = %d, want = %d (%s)", posErr.Pos, c.pos, posErr.Message)
+			}
+		})
+	}
+}
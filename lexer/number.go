@@ -0,0 +1,183 @@
+package lexer
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"rkitamu/gocc/errors"
+)
+
+// intSuffixes maps the valid integer suffixes (in lower case) to whether
+// they make the literal long and unsigned.
+var intSuffixes = map[string]struct{ long, unsigned bool }{
+	"":    {false, false},
+	"u":   {false, true},
+	"l":   {true, false},
+	"ll":  {true, false},
+	"ul":  {true, true},
+	"lu":  {true, true},
+	"ull": {true, true},
+	"llu": {true, true},
+}
+
+func isHexDigit(ch byte) bool {
+	return ('0' <= ch && ch <= '9') || ('a' <= ch && ch <= 'f') || ('A' <= ch && ch <= 'F')
+}
+
+// readNumber converts the text of a numeric literal into a NUM token.
+// pos is the position of the literal in the input, used for diagnostics.
+func (l *Lexer) readNumber(str string, pos int) (*Token, error) {
+	lower := strings.ToLower(str)
+	isFloat := strings.Contains(lower, ".")
+	if strings.HasPrefix(lower, "0x") {
+		isFloat = isFloat || strings.Contains(lower, "p")
+	} else {
+		isFloat = isFloat || strings.Contains(lower, "e")
+	}
+
+	if isFloat {
+		return l.readFloatLiteral(str, pos)
+	}
+	return l.readIntLiteral(str, pos)
+}
+
+// readFloatLiteral converts the text of a floating literal into a NUM token.
+// Both decimal (1.5e3) and hexadecimal (0x1.8p3) forms are accepted, with an
+// optional f (float) or l (long double, treated as double) suffix.
+func (l *Lexer) readFloatLiteral(str string, pos int) (*Token, error) {
+	body := str
+	litType := LIT_DOUBLE
+	bitSize := 64
+	switch str[len(str)-1] {
+	case 'f', 'F':
+		body = str[:len(str)-1]
+		litType = LIT_FLOAT
+		bitSize = 32
+	case 'l', 'L':
+		body = str[:len(str)-1]
+	}
+
+	// ParseFloat accepts more than C does, such as digit separators and
+	// hexadecimal mantissas without an exponent
+	lower := strings.ToLower(body)
+	isHex := strings.HasPrefix(lower, "0x")
+	if strings.Contains(body, "_") || (isHex && !strings.Contains(lower, "p")) {
+		return nil, errors.NewPosError(
+			fmt.Sprintf("invalid floating literal: %s", str),
+			l.input,
+			pos,
+		)
+	}
+
+	val, err := strconv.ParseFloat(body, bitSize)
+	if err != nil {
+		if err.(*strconv.NumError).Err == strconv.ErrRange {
+			return nil, errors.NewPosError(
+				fmt.Sprintf("floating literal is out of range: %s", str),
+				l.input,
+				pos,
+			)
+		}
+		return nil, errors.NewPosError(
+			fmt.Sprintf("invalid floating literal: %s", str),
+			l.input,
+			pos,
+		)
+	}
+
+	return &Token{Kind: NUM, Str: str, FVal: val, LitType: litType, Pos: pos}, nil
+}
+
+// readIntLiteral converts the text of an integer literal into a NUM token.
+// Decimal, hexadecimal (0x), octal (0) and binary (0b) forms are accepted,
+// optionally followed by a combination of the u, l and ll suffixes.
+// pos is the position of the literal in the input, used for diagnostics.
+func (l *Lexer) readIntLiteral(str string, pos int) (*Token, error) {
+	base := 10
+	start := 0
+	lower := strings.ToLower(str)
+	switch {
+	case strings.HasPrefix(lower, "0x"):
+		base, start = 16, 2
+	case strings.HasPrefix(lower, "0b"):
+		base, start = 2, 2
+	case str[0] == '0':
+		base = 8
+	}
+
+	// octal and binary digits are validated by ParseUint below, so that
+	// "09" is reported as a bad digit rather than as a bad suffix
+	end := start
+	for end < len(str) && (isDigit(rune(str[end])) || (base == 16 && isHexDigit(str[end]))) {
+		end++
+	}
+	digits, suffix := str[start:end], str[end:]
+	if digits == "" {
+		return nil, errors.NewPosError(
+			fmt.Sprintf("invalid integer literal: %s", str),
+			l.input,
+			pos,
+		)
+	}
+
+	sfx, ok := intSuffixes[strings.ToLower(suffix)]
+	if !ok || strings.Contains(suffix, "lL") || strings.Contains(suffix, "Ll") {
+		return nil, errors.NewPosError(
+			fmt.Sprintf("invalid suffix %q on integer literal", suffix),
+			l.input,
+			pos+end,
+		)
+	}
+
+	val, err := strconv.ParseUint(digits, base, 64)
+	if err != nil {
+		if err.(*strconv.NumError).Err == strconv.ErrRange {
+			return nil, errors.NewPosError(
+				fmt.Sprintf("integer literal is too large: %s", str),
+				l.input,
+				pos,
+			)
+		}
+		return nil, errors.NewPosError(
+			fmt.Sprintf("invalid digit in base %d literal: %s", base, str),
+			l.input,
+			pos,
+		)
+	}
+
+	litType, ok := intLiteralType(val, base, sfx.long, sfx.unsigned)
+	if !ok {
+		return nil, errors.NewPosError(
+			fmt.Sprintf("integer literal is too large for any signed type: %s", str),
+			l.input,
+			pos,
+		)
+	}
+
+	return &Token{Kind: NUM, Str: str, Val: int(val), LitType: litType, Pos: pos}, nil
+}
+
+// intLiteralType picks the first type in the list given by C11 6.4.4.1
+// that can represent val. Decimal literals without a u suffix are only
+// ever given a signed type.
+func intLiteralType(val uint64, base int, long, unsigned bool) (LiteralType, bool) {
+	signedOnly := base == 10 && !unsigned
+
+	if !long {
+		if !unsigned && val <= math.MaxInt32 {
+			return LIT_INT, true
+		}
+		if !signedOnly && val <= math.MaxUint32 {
+			return LIT_UINT, true
+		}
+	}
+	if !unsigned && val <= math.MaxInt64 {
+		return LIT_LONG, true
+	}
+	if !signedOnly {
+		return LIT_ULONG, true
+	}
+	return 0, false
+}
@@ -0,0 +1,103 @@
+package lexer
+
+import (
+	"rkitamu/gocc/errors"
+)
+
+// readStringLiteral reads the string literal starting at the opening quote
+// runes[start] and returns a STR token and the position just after it.
+func (l *Lexer) readStringLiteral(runes []rune, start int) (*Token, int, error) {
+	var buf []byte
+	pos := start + 1
+	for {
+		if pos >= len(runes) || runes[pos] == '\n' {
+			return nil, 0, errors.NewPosError("unclosed string literal", l.input, start)
+		}
+		if runes[pos] == '"' {
+			break
+		}
+		if runes[pos] != '\\' {
+			buf = append(buf, string(runes[pos])...)
+			pos++
+			continue
+		}
+
+		c, next, err := l.readEscape(runes, pos+1)
+		if err != nil {
+			return nil, 0, err
+		}
+		buf = append(buf, c)
+		pos = next
+	}
+	pos++
+
+	tok := &Token{Kind: STR, Str: string(runes[start:pos]), StrVal: string(buf), Pos: start}
+	return tok, pos, nil
+}
+
+// readEscape reads the escape sequence following a backslash at pos and
+// returns its value and the position just after it.
+func (l *Lexer) readEscape(runes []rune, pos int) (byte, int, error) {
+	if pos >= len(runes) {
+		return 0, 0, errors.NewPosError("unclosed string literal", l.input, pos-1)
+	}
+
+	// octal escapes have up to three digits
+	if '0' <= runes[pos] && runes[pos] <= '7' {
+		c := 0
+		end := pos
+		for end < len(runes) && end < pos+3 && '0' <= runes[end] && runes[end] <= '7' {
+			c = c*8 + int(runes[end]-'0')
+			end++
+		}
+		return byte(c), end, nil
+	}
+
+	// hexadecimal escapes take all the hex digits that follow
+	if runes[pos] == 'x' {
+		c := 0
+		end := pos + 1
+		for end < len(runes) && runes[end] < 0x80 && isHexDigit(byte(runes[end])) {
+			c = c*16 + hexValue(byte(runes[end]))
+			end++
+		}
+		if end == pos+1 {
+			return 0, 0, errors.NewPosError("invalid hex escape sequence", l.input, pos-1)
+		}
+		return byte(c), end, nil
+	}
+
+	switch runes[pos] {
+	case 'a':
+		return '\a', pos + 1, nil
+	case 'b':
+		return '\b', pos + 1, nil
+	case 't':
+		return '\t', pos + 1, nil
+	case 'n':
+		return '\n', pos + 1, nil
+	case 'v':
+		return '\v', pos + 1, nil
+	case 'f':
+		return '\f', pos + 1, nil
+	case 'r':
+		return '\r', pos + 1, nil
+	case 'e':
+		// GNU extension for the escape character
+		return 27, pos + 1, nil
+	case '\\', '\'', '"', '?':
+		return byte(runes[pos]), pos + 1, nil
+	}
+	return 0, 0, errors.NewPosError("unknown escape sequence", l.input, pos-1)
+}
+
+func hexValue(ch byte) int {
+	switch {
+	case '0' <= ch && ch <= '9':
+		return int(ch - '0')
+	case 'a' <= ch && ch <= 'f':
+		return int(ch-'a') + 10
+	default:
+		return int(ch-'A') + 10
+	}
+}
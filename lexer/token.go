@@ -7,15 +7,63 @@ const (
 	RETURN
 	IF
 	ELSE
+	WHILE
+	FOR
+	CHAR
+	SHORT
+	INT
+	LONG
+	BOOL
+	SIGNED
+	UNSIGNED
+	VOID
+	FLOAT
+	DOUBLE
+	STATIC
+	EXTERN
+	CONST
+	VOLATILE
+	RESTRICT
+	INLINE
+	ATTRIBUTE
+	STRUCT
+	VA_LIST
+	VA_START
+	VA_ARG
+	VA_END
+	VA_COPY
+	STATIC_ASSERT
+	ALIGNOF
+	ALIGNAS
+	GENERIC
+	SIZEOF
+	ASM
 	IDENT
 	NUM
+	STR
 	EOF
 )
 
+// LiteralType is the C type of a numeric literal, chosen by the lexer from
+// its value, base and suffix.
+type LiteralType int
+
+const (
+	LIT_INT    LiteralType = iota // int
+	LIT_UINT                      // unsigned int
+	LIT_LONG                      // long, long long
+	LIT_ULONG                     // unsigned long, unsigned long long
+	LIT_FLOAT                     // float
+	LIT_DOUBLE                    // double, long double
+)
+
 type Token struct {
-	Kind TokenKind
-	Next *Token
-	Str  string
-	Val  int
-	Pos  int // Position in the input string
+	Kind    TokenKind
+	Next    *Token
+	Str     string
+	Val     int
+	FVal    float64     // Value of a floating literal
+	LitType LiteralType // Type of the literal (only used if Kind == NUM)
+	StrVal  string      // Contents of a string literal, escapes resolved (only used if Kind == STR)
+	Pos     int         // Position in the input string
 }
@@ -4,38 +4,83 @@ import (
 	"flag"
 	"fmt"
 	"os"
+	"strings"
 
 	"rkitamu/gocc/generator"
+	"rkitamu/gocc/ir"
 	"rkitamu/gocc/lexer"
 	"rkitamu/gocc/parser"
+	"rkitamu/gocc/sema"
 )
 
 type Args struct {
-	Input  string
-	Output string
-	Debug  bool
+	Input          string
+	Output         string
+	Debug          bool
+	OptLevel       optLevel
+	PrintAfterAll  bool
+	DisabledPasses string
+	UnrollLoops    bool
+	Target         *generator.Target
 }
 
 func parseArgs() (*Args, error) {
 	input := flag.String("i", "", "Input file name")
 	output := flag.String("o", "out.s", "Output file name")
 	debug := flag.Bool("d", false, "Enable debug mode")
+	opt := flag.String("O", "0", "Optimization level: 0, 1, 2 or s, 1 if -O is given alone")
+	printAfterAll := flag.Bool("print-after-all", false, "Print the AST, IR or assembly after each pass")
+	disabled := flag.String("disable-pass", "", "Comma-separated names of passes not to run")
+	unrollLoops := flag.Bool("funroll-loops", false, "Unroll loops with a small constant number of iterations")
+	triple := flag.String("target", generator.Targets[0].Triple, "Target triple: x86_64-linux-gnu, aarch64-linux-gnu or riscv64-linux-gnu")
 
-	flag.Parse()
+	flag.CommandLine.Parse(optArgs(os.Args[1:]))
 
 	if *input == "" {
 		return nil, fmt.Errorf("input file name is required")
 	}
 
+	level, err := parseOptLevel(*opt)
+	if err != nil {
+		return nil, err
+	}
+
+	target, err := generator.LookupTarget(*triple)
+	if err != nil {
+		return nil, err
+	}
+
 	args := &Args{
-		Input:  *input,
-		Output: *output,
-		Debug:  *debug,
+		Input:          *input,
+		Output:         *output,
+		Debug:          *debug,
+		OptLevel:       level,
+		PrintAfterAll:  *printAfterAll,
+		DisabledPasses: *disabled,
+		UnrollLoops:    *unrollLoops,
+		Target:         target,
 	}
 
 	return args, nil
 }
 
+// optArgs rewrites the optimization options of the command line args as
+// the flag package expects them: -O2 as -O=2, and a bare -O as -O=1, as cc
+// takes them.
+func optArgs(args []string) []string {
+	var rewritten []string
+	for _, arg := range args {
+		if level, ok := strings.CutPrefix(arg, "-O"); ok && (level == "" || level[0] != '=') {
+			if level == "" {
+				level = "1"
+			}
+			arg = "-O=" + level
+		}
+		rewritten = append(rewritten, arg)
+	}
+	return rewritten
+}
+
 func main() {
 	if err := run(); err != nil {
 		fmt.Println(err)
@@ -57,43 +102,105 @@ func run() error {
 		return fmt.Errorf("failed to read input file: %w", err)
 	}
 
+	pm := newPassManager(cliArgs.OptLevel, cliArgs.Target, cliArgs.UnrollLoops)
+	pm.printAfterAll = cliArgs.PrintAfterAll
+	if err := pm.disable(cliArgs.DisabledPasses); err != nil {
+		return err
+	}
+
+	asm, err := compile(input, cliArgs.Debug, cliArgs.Target, pm)
+	if err != nil {
+		return err
+	}
+
+	// write to output file
+	if err := os.WriteFile(cliArgs.Output, []byte(asm), 0644); err != nil {
+		return fmt.Errorf("failed to write output file: %w", err)
+	}
+
+	return nil
+}
+
+// compile translates C source code into assembly for target, optimizing it
+// with the passes of pm.
+func compile(input string, debug bool, target *generator.Target, pm *passManager) (string, error) {
+	prog, err := compileIR(input, debug, target, pm)
+	if err != nil {
+		return "", err
+	}
+
+	// generate assembly code
+	asm, err := target.Generate(prog)
+	if err != nil {
+		return "", err
+	}
+	return pm.runAsm(asm), nil
+}
+
+// compileIR translates C source code into the IR for target, optimized by
+// the IR passes of pm.
+func compileIR(input string, debug bool, target *generator.Target, pm *passManager) (*ir.Program, error) {
 	// lex input
 	lexer := lexer.NewLexer(input)
 	tokens, err := lexer.Lex()
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	// optionally print tokens
-	if cliArgs.Debug {
+	if debug {
 		fmt.Println("=== Tokens ===")
 		lexer.DebugPrintTokens(tokens)
 	}
 
 	// parse tokens
 	parser := parser.NewParser(tokens, input)
+	parser.Arch = target.Arch
 	err = parser.Parse()
 	if err != nil {
-		return err
+		return nil, err
+	}
+	for _, w := range parser.Warnings {
+		fmt.Fprintln(os.Stderr, w)
 	}
 
+	// check the AST and lay out the stack frames
+	if err := sema.Check(parser.Funcs, input); err != nil {
+		return nil, err
+	}
+
+	// optimize the AST
+	pm.runAST(parser)
+
 	// optionally print AST
-	if cliArgs.Debug {
+	if debug {
 		fmt.Println("=== AST ===")
-		parser.PrintTreeForMultiStatement(parser.Code)
+		for _, fn := range parser.Funcs {
+			if fn.IsDefinition {
+				fmt.Printf("%s:\n", fn.Name)
+				parser.PrintTree(fn.Body)
+			}
+		}
 	}
 
-	// generate assembly code
-	gen := generator.NewGenerator()
-	asm, err := gen.GenerateForMultiStatement(parser.Code)
+	// lower to the IR
+	prog, err := ir.Lower(parser.Funcs, parser.Globals)
 	if err != nil {
-		return err
+		return nil, err
+	}
+	if err := ir.VerifyProgram(prog); err != nil {
+		return nil, fmt.Errorf("invalid IR: %w", err)
 	}
 
-	// write to output file
-	if err := os.WriteFile(cliArgs.Output, []byte(asm), 0644); err != nil {
-		return fmt.Errorf("failed to write output file: %w", err)
+	// optimize the IR
+	if err := pm.runIR(prog); err != nil {
+		return nil, err
 	}
 
-	return nil
+	// optionally print IR
+	if debug {
+		fmt.Println("=== IR ===")
+		fmt.Print(ir.Dump(prog))
+	}
+	return prog, nil
 }
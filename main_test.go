@@ -1 +1,392 @@
 package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	"rkitamu/gocc/generator"
+	"rkitamu/gocc/ir"
+	"rkitamu/gocc/rvemu"
+)
+
+// compileTargets are the targets TestCompile builds for, with the C
+// compiler that assembles and links for them, the command that runs the
+// binaries, if they cannot run natively, and a program using inline
+// assembly of the target, which must return 44. When the tools are not
+// installed, the assembly is checked by the first assembler of as that is,
+// given the input file as its last argument, and run by emulate if it is
+// not nil.
+var compileTargets = []struct {
+	target       *generator.Target
+	cc           string
+	run          []string
+	asm          string
+	unsignedChar bool // plain char is unsigned
+	as           [][]string
+	emulate      func(asm string) (int, error)
+}{
+	{
+		generator.X86_64, "cc", nil,
+		"int x; int add(int a, int b) { asm(\"add %0, %1\" : \"+r\"(a) : \"g\"(b)); return a; } int main() { long y; asm volatile(\"mov dword ptr [rip + x], 5\"); asm(\"lea %[out], [%[in] + %c2]\" : [out] \"=r\"(y) : [in] \"r\"(x), \"i\"(30) : \"rbx\", \"memory\"); asm(\"add %0, 4\" : \"+m\"(x)); return add(y, x); }",
+		false, nil, nil,
+	},
+	{
+		generator.AArch64, "aarch64-linux-gnu-gcc", []string{"qemu-aarch64", "-L", "/usr/aarch64-linux-gnu"},
+		"int x; int add(int a, int b) { asm(\"add %0, %0, %1\" : \"+r\"(a) : \"g\"(b)); return a; } int main() { long y; asm volatile(\"adrp x8, x\\n add x8, x8, :lo12:x\\n mov w9, 5\\n str w9, [x8]\" : : : \"x8\", \"w9\", \"memory\"); asm(\"add %[out], %x[in], %2\" : [out] \"=r\"(y) : [in] \"r\"(x), \"i\"(30) : \"x19\", \"d8\", \"memory\"); asm(\"ldr w9, %0\\n add w9, w9, 4\\n str w9, %0\" : \"+m\"(x) : : \"x9\"); return add(y, x); }",
+		true,
+		[][]string{
+			{"aarch64-linux-gnu-as", "-o", os.DevNull},
+			{"llvm-mc", "-triple=aarch64-linux-gnu", "-filetype=obj", "-o", os.DevNull},
+		},
+		nil,
+	},
+	{
+		generator.RISCV64, "riscv64-linux-gnu-gcc", []string{"qemu-riscv64", "-L", "/usr/riscv64-linux-gnu"},
+		"int x; int add(int a, int b) { asm(\"addw %0, %0, %1\" : \"+r\"(a) : \"g\"(b)); return a; } int main() { long y; asm volatile(\"lla t0, x\\n li t1, 5\\n sw t1, 0(t0)\" : : : \"t0\", \"x6\", \"memory\"); asm(\"addi %[out], %[in], %c2\" : [out] \"=r\"(y) : [in] \"r\"(x), \"i\"(30) : \"s1\", \"fs0\", \"memory\"); asm(\"lw t0, %0\\n addi t0, t0, 4\\n sw t0, %0\" : \"+m\"(x) : : \"t0\"); return add(y, x); }",
+		true,
+		[][]string{
+			{"riscv64-linux-gnu-as", "-march=rv64gc", "-o", os.DevNull},
+			{"llvm-mc", "-triple=riscv64-linux-gnu", "-mattr=+m,+a,+f,+d,+c", "-filetype=obj", "-o", os.DevNull},
+		},
+		func(asm string) (int, error) { return rvemu.Run(asm, io.Discard) },
+	},
+}
+
+// TestCompile compiles each program for each target at every optimization
+// level, assembles and links it with the C compiler of the target and
+// checks the exit status of the resulting binary. Targets whose tools are
+// not installed are assembled and emulated where possible, and skipped
+// otherwise.
+func TestCompile(t *testing.T) {
+	type test struct {
+		name  string
+		input string
+		want  int
+	}
+	tests := []test{
+		{"arithmetic", "return 1 + 2 * 3;", 7},
+		{"variables", "int a = 3; int b = a * 2; return a + b;", 9},
+		{"if", "int a = 1; if (a == 1) return 2; else return 3;", 2},
+		{"while", "int i = 0; int s = 0; while (i < 5) { s = s + i; i = i + 1; } return s;", 10},
+		{"for", "int s = 0; for (int i = 0; i < 10; i = i + 1) s = s + i; return s;", 45},
+		{"for without a condition", "int s = 0; for (;;) { s = s + 1; if (s == 7) return s; }", 7},
+		{"block scope", "int x = 5; int main() { { int x = 2; } return x; }", 5},
+		{"shadowing", "int f(int a) { int r = a; { int a = 2; r = r + a; { int a = 3; r = r + a; } r = r + a; } return r + a; } int main() { return f(1); }", 9},
+		{"top-level block scope", "int x = 1; { int x = 2; x = x + 1; } return x;", 1},
+		{"for scope", "int i = 20; int main() { int s = 0; for (int i = 0; i < 3; i = i + 1) s = s + i; for (int i = 5; i < 7; i = i + 1) { int i = 1; s = s + i; } return s + i; }", 25},
+		{"nested loops", "int a[4]; int main() { int i; int j; int s = 0; for (i = 0; i < 4; i = i + 1) a[i] = i * 3; for (i = 0; i < 4; i = i + 1) for (j = 0; j < i; j = j + 1) s = s + a[j]; return s; }", 12},
+		{"char truncation", "char c = 300; return c;", 44},
+		{"unsigned char wrap", "unsigned char c = 255; c = c + 1; return c == 0;", 1},
+		{"unsigned comparison", "unsigned int u = 0; u = u - 1; return u > 5;", 1},
+		{"signed comparison", "int i = 0; i = i - 1; return i > 5;", 0},
+		{"unsigned division", "unsigned int x = 4294967295; return x / 2147483647;", 2},
+		{"int overflow wraps", "int x = 2147483647; x = x + 1; return x < 0;", 1},
+		{"_Bool", "_Bool b = 5; return b;", 1},
+		{"integer literals", "return 0x10 + 010 + 0b10;", 26},
+		{"unsigned literal", "return -1 < 0u;", 0},
+		{"long literal", "return 5000000000 / 1000000000;", 5},
+		{"function", "int add(int a, int b) { return a + b; } int main() { return add(3, 4); }", 7},
+		{"recursion", "int fib(int n) { if (n < 2) return n; return fib(n-1) + fib(n-2); } int main() { return fib(10); }", 55},
+		{"stack arguments", "long f(long a, long b, long c, long d, long e, long f, long g, long h, long i) { return i - a - b; } int main() { return f(1, 1, 1, 1, 1, 1, 1, 1, 9); }", 7},
+		{"double arithmetic", "double d = 0.1 + 0.2; return d > 0.3;", 1},
+		{"double to int", "double d = 3.99; int i = d; return i;", 3},
+		{"float to double", "float f = 1.5f; double d = f; return d == 1.5;", 1},
+		{"hex float", "return 0x1.8p1 == 3.0;", 1},
+		{"double to unsigned long", "double d = 1e19; unsigned long u = d; return u == 10000000000000000000u;", 1},
+		{"unsigned long to double", "unsigned long u = 18446744073709551615u; double d = u; return d > 1e19;", 1},
+		{"double condition", "double a = 0.5; if (a) return 1; return 0;", 1},
+		{"double arguments", "double sum(double a, double b, double c, double d, double e, double f, double g, double h, double i, double j) { return a+b+c+d+e+f+g+h+i+j; } int main() { return sum(0.5, 0.5, 0.5, 0.5, 0.5, 0.5, 0.5, 0.5, 0.5, 0.5); }", 5},
+		{"mixed arguments", "float g(float x, int y, double z) { return x + y + z; } int main() { return g(0.5f, 1, 0.5); }", 2},
+		{"libm", "double sqrt(double); int main() { return sqrt(16.0) == 4; }", 1},
+		{"cast truncation", "return (char)300;", 44},
+		{"cast zero extension", "return (unsigned char)-1;", 255},
+		{"cast double to int", "return (int)3.7;", 3},
+		{"cast before division", "return (int)((double)1 / 2 * 10);", 5},
+		{"cast to void", "int x = 3; (void)x; return x;", 3},
+		{"pointer store", "int x = 3; int *p = &x; *p = 5; return x;", 5},
+		{"pointer arithmetic", "int x = 1; int *p = &x; return (p + 2) - p + (1 + p == p + 1);", 3},
+		{"pointer round trip", "int x = 7; long n = (long)&x; return *(int *)n;", 7},
+		{"pointer to pointer", "char c = 1; char *p = &c; char **pp = &p; **pp = 42; return c;", 42},
+		{"pointer argument", "void set(int *p, int v) { *p = v; } int main() { int x = 0; set(&x, 12); return x; }", 12},
+		{"global", "int g = 5; int main() { return g; }", 5},
+		{"global zero initialized", "long g; int main() { g = g + 3; return g; }", 3},
+		{"global address", "int x = 3; int *p = &x; int main() { *p = 9; return x; }", 9},
+		{"global constant folding", "char c = 300; double d = 1.5 * 2; int main() { return c + d; }", 47},
+		{"tentative definitions", "int x; int x; int x = 4; int main() { return x; }", 4},
+		{"static global", "static int g; int main() { g = 3; return g; }", 3},
+		{"static local", "int count() { static int n = 10; n = n + 1; return n; } int main() { count(); return count(); }", 12},
+		{"static function", "static int f() { return 6; } int main() { return f(); }", 6},
+		{"block extern", "int main() { extern int g; return g; } int g = 8;", 8},
+		{"dynamic initializer", "int x = 2; int y = x + 1; return y;", 3},
+		{"local array initializer", "int main() { int a[3] = {1, 2}; return a[0] + a[1] + a[2]; }", 3},
+		{"array of unknown length", "int main() { int a[] = {1, 2, 3}; return a[0] + a[1] + a[2]; }", 6},
+		{"nested braces", "int main() { int a[2][3] = {{1, 2}, {3, 4, 5}}; return a[1][0] + a[1][2]; }", 8},
+		{"brace elision", "int main() { int a[2][3] = {1, 2, 3, 4}; return a[1][0] + a[0][2]; }", 7},
+		{"array designator", "int main() { int a[5] = {[3] = 5, 6}; return a[3] + a[4] + a[0]; }", 11},
+		{"struct designator", "int main() { struct { int x; char y; long z; } s = {.z = 10, .x = 1}; return s.x + s.y + s.z; }", 11},
+		{"nested designator", "int main() { struct { int a[2]; int b; } s = {.a[1] = 1}; return s.a[1] + s.a[0] + s.b; }", 1},
+		{"struct copy", "struct P { int x; int y; }; int main() { struct P p = {1, 2}; struct P q = p; return q.x + q.y; }", 3},
+		{"struct pointer", "struct S { struct S *next; int v; }; int main() { struct S a = {0, 1}; struct S b = {&a, 2}; return b.v + b.next->v; }", 3},
+		{"char array string", "int main() { char s[] = \"abc\"; return s[1]; }", 98},
+		{"string literal", "int main() { char *s = \"ab\" \"c\"; return s[2]; }", 99},
+		{"global array initializer", "int g[4] = {1, 2, [3] = 12}; int main() { return g[0] + g[1] + g[2] + g[3]; }", 15},
+		{"global struct initializer", "struct { int a; int b[2]; } g = {1, {2, 4}}; int main() { return g.a + g.b[0] + g.b[1]; }", 7},
+		{"global address array", "int x = 5; int *p[2] = {0, &x}; int main() { return *p[1]; }", 5},
+		{"global string", "char *s = \"xyz\"; char t[4] = \"ab\"; int main() { return s[2] - t[0] + t[3]; }", 25},
+		{"static local array", "int main() { static int a[3] = {1, 2, 3}; return a[0] + a[1] + a[2]; }", 6},
+		{"array parameter", "int f(int a[]) { return a[1]; } int main() { int x[2] = {1, 3}; return f(x); }", 3},
+		{"variadic integers", "int sum(int n, ...) { va_list ap; va_start(ap, n); int s = 0; s = s + va_arg(ap, int); s = s + va_arg(ap, int); s = s + va_arg(ap, int); va_end(ap); return s + n; } int main() { return sum(3, 1, 2, 3); }", 9},
+		{"variadic stack arguments", "long f(long a, long b, long c, long d, long e, long g, long h, ...) { va_list ap; va_start(ap, h); long x = va_arg(ap, long); double y = va_arg(ap, double); return a + b + c + d + e + g + h + x + y; } int main() { return f(1, 2, 3, 4, 5, 6, 2, 4, 3.0); }", 30},
+		{"variadic doubles", "double fsum(int n, ...) { va_list ap; va_start(ap, n); double s = va_arg(ap, double) + va_arg(ap, double) + va_arg(ap, double) + va_arg(ap, double) + va_arg(ap, double); return s + va_arg(ap, double) + va_arg(ap, double) + va_arg(ap, double) + va_arg(ap, double) + va_arg(ap, double); } int main() { return fsum(10, 1.0, 2.0, 3.0, 4.0, 5.0, 6.0, 7.0, 8.0, 9.0, 10.0); }", 55},
+		{"va_copy", "int f(int n, ...) { va_list a; va_list b; va_start(a, n); va_copy(b, a); int x = va_arg(a, int); return x + va_arg(b, int) + n; } int main() { return f(1, 2); }", 5},
+		{"libc vsnprintf", "int vsnprintf(char *buf, long n, char *fmt, va_list ap); int fmt(char *buf, char *f, ...) { va_list ap; va_start(ap, f); int n = vsnprintf(buf, 100, f, ap); va_end(ap); return n; } int main() { char b[100]; fmt(b, \"%d%c%.1f\", 12, 65, 2.5); return b[1] + b[2] + b[5]; }", 168},
+		{"function pointer", "int add(int a, int b) { return a + b; } int main() { int (*fp)(int, int) = add; return fp(3, 4) + (*fp)(1, 1); }", 9},
+		{"function parameter", "int mul(int a, int b) { return a * b; } int apply(int f(int, int), int x, int y) { return f(x, y); } int main() { return apply(mul, 3, 4); }", 12},
+		{"function pointer array", "int add(int a, int b) { return a + b; } int sub(int a, int b) { return a - b; } int (*ops[2])(int, int) = {add, sub}; int main() { return ops[0](3, 4) + ops[1](5, 3); }", 9},
+		{"function returning function pointer", "int add(int a, int b) { return a + b; } int (*pick(int n))(int, int) { return &add; } int main() { return pick(0)(3, 5); }", 8},
+		{"indirect call with stack arguments", "long f(long a, long b, long c, long d, long e, long g, long h, long i) { return a + b + c + d + e + g + h + i; } int main() { long (*fp)(long, long, long, long, long, long, long, long) = f; long x = 1; return x * fp(1, 2, 3, 4, 5, 6, 7, 8); }", 36},
+		{"libc function pointer", "int strlen(char *s); int main() { int (*fp)(char *) = strlen; return fp(\"abcd\"); }", 4},
+		{"dynamic array initializer", "int x = 3; int a[2] = {1, x}; return a[0] + a[1];", 4},
+		{"const qualifiers", "int sum(const int *a, int n) { int s = 0; int i = 0; if (i < n) s = s + a[0]; if (i + 1 < n) s = s + a[1]; return s; } int main() { const int a[2] = {3, 4}; int *const p = (int *)a; *p = 5; return sum(a, 2); }", 9},
+		{"compound literals", "struct P { int x; int y; }; int *g = (int[]){4, 9}; int sum(int *a) { return a[0] + a[1]; } int main() { int x = 2; struct P p = (struct P){.y = 3}; return g[1] + sum((int[]){x, 4}) + p.y + (int[]){5, 6}[1] + *&(int){1}; }", 25},
+		{"sizeof", "struct P { char c; long x; }; int main() { int a[5]; return sizeof(int) + sizeof a + sizeof(struct P) + sizeof \"abc\"; }", 44},
+		{"variable length array", "int f(int n) { int a[n]; a[0] = 1; a[n - 1] = 2; return a[0] + a[n - 1] + sizeof(a); } int main() { return f(10); }", 43},
+		{"two-dimensional variable length array", "int main() { int n = 2; int m = 3; long a[n][m]; a[1][2] = 5; long *p = &a[0][0]; return p[5] + sizeof a[0] + sizeof(char[n][m]); }", 35},
+		{"variable length arrays in recursion", "int sum(int n) { int a[n]; a[n - 1] = n; if (n > 1) a[n - 1] = a[n - 1] + sum(n - 1); return a[n - 1]; } int main() { return sum(10); }", 55},
+		{"variable length array in a loop", "int main() { int n = 1000; int s = 0; for (int i = 0; i < 100000; i = i + 1) { int a[n]; a[n - 1] = i; s = s + (a[n - 1] == i); } for (int i = 0; i < 100000; i = i + 1) for (long b[n]; i < 0;) {} return s == 100000; }", 1},
+		{"bit-fields", "struct F { unsigned a : 3; int b : 5; char c; long d : 40; } g = {9, -3, 1, 7}; int main() { struct F f = {1, 2, 3, 4}; f.b = -1; f.a = f.a + 6; return f.a + f.b + f.c + f.d + g.a + g.b + (g.d = 1099511627775) + sizeof f; }", 18},
+		{"static assertion and alignment", "_Static_assert(_Alignof(double) == 8, \"double\"); _Alignas(32) char g; int main() { _Alignas(16) char c; long a = (long)&c; long b = (long)&g; return (a / 16 * 16 == a) + (b / 32 * 32 == b); }", 2},
+		{"generic selection", "int main() { long x = 0; const char *s = \"a\"; return _Generic(x, int: 1, long: 2, default: 0) + _Generic(s, char *: 5, const char *: 10, default: 9); }", 12},
+		{"plain char type", "int main() { char c = 0; return _Generic(c, char: 1, signed char: 2, unsigned char: 3) + _Generic((signed char)c, char: 1, signed char: 2, unsigned char: 3) * 4 + _Generic((unsigned char)c, char: 1, signed char: 2, unsigned char: 3) * 16 + _Generic(&c, signed char *: 1, unsigned char *: 1, default: 0) * 64; }", 57},
+		{"inlining", "static inline int max(int a, int b) { if (a < b) return b; return a; } __attribute__((always_inline)) inline long sq(long x) { long y[1]; y[0] = x; return y[0] * y[0]; } __attribute__((noinline)) int twice(int x) { return max(x, 0) * 2; } int main() { int a = 3; return max(a, 4) + sq(a) + twice(5) + max(8, a); }", 31},
+		{"extern inline definition", "inline int sq(int x) { return x * x; } extern int sq(int x); int main() { return sq(5); }", 25},
+		{"tail calls", "long sum(long a, long b, long c, long d, long e, long f, long n, long acc) { if (n == 0) return acc; return sum(a, b, c, d, e, f, n - 1, acc + n); } long (*fp)(long, long, long, long, long, long, long, long) = sum; long call(long n) { return fp(0, 0, 0, 0, 0, 0, n, 0); } int main() { return call(1000); }", 20},
+		{"volatile and restrict", "int copy(int *restrict dst, const int *restrict src) { *dst = *src; return *dst; } int main() { volatile int v = 6; int x; return copy(&x, (const int *)&v); }", 6},
+	}
+
+	for _, ct := range compileTargets {
+		t.Run(ct.target.Triple, func(t *testing.T) {
+			cc, err := exec.LookPath(ct.cc)
+			if err == nil && ct.run != nil {
+				_, err = exec.LookPath(ct.run[0])
+			}
+			native := err == nil
+			var as []string
+			for _, cmd := range ct.as {
+				if _, err := exec.LookPath(cmd[0]); err == nil && !native {
+					as = cmd
+					break
+				}
+			}
+			if !native && as == nil && ct.emulate == nil {
+				t.Skip(err)
+			}
+
+			// plain char is signed or unsigned, but signed char is signed
+			plainChar := test{"plain char", "char c = 200; signed char s = 200; return (c > 0) + (s < 0) * 2;", 2}
+			if ct.unsignedChar {
+				plainChar.want = 3
+			}
+
+			dir := t.TempDir()
+			for _, tt := range append(tests, plainChar, test{"inline assembly", ct.asm, 44}) {
+				for _, level := range []string{"0", "1", "2", "s"} {
+					t.Run(fmt.Sprintf("%s/O%s", tt.name, level), func(t *testing.T) {
+						opt, err := parseOptLevel(level)
+						if err != nil {
+							t.Fatal(err)
+						}
+						asm, err := compile(tt.input, false, ct.target, newPassManager(opt, ct.target, false))
+						if err != nil {
+							t.Fatalf("compile error: %v", err)
+						}
+
+						var got int
+						switch {
+						case native:
+							got = runAsm(t, cc, ct.run, dir, asm)
+						case as != nil:
+							assemble(t, as, dir, asm)
+							if ct.emulate == nil {
+								return
+							}
+							fallthrough
+						default:
+							if got, err = ct.emulate(asm); err != nil {
+								t.Fatalf("emulation error: %v\n%s", err, asm)
+							}
+						}
+						if got != tt.want {
+							t.Errorf("exit status: got = %d, want = %d", got, tt.want)
+						}
+					})
+				}
+			}
+		})
+	}
+}
+
+// assemble assembles asm in dir with the command as, followed by the name
+// of the file, to check that it is valid.
+func assemble(t *testing.T, as []string, dir, asm string) {
+	t.Helper()
+	asmFile := filepath.Join(dir, "out.s")
+	if err := os.WriteFile(asmFile, []byte(asm), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if out, err := exec.Command(as[0], append(as[1:], asmFile)...).CombinedOutput(); err != nil {
+		t.Fatalf("assemble error: %v\n%s\n%s", err, out, asm)
+	}
+}
+
+// runAsm assembles and links asm with cc in dir, runs the binary, with the
+// command run if not nil, and returns its exit status.
+func runAsm(t *testing.T, cc string, run []string, dir, asm string) int {
+	t.Helper()
+	asmFile := filepath.Join(dir, "out.s")
+	binFile := filepath.Join(dir, "out")
+	if err := os.WriteFile(asmFile, []byte(asm), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if out, err := exec.Command(cc, "-o", binFile, asmFile, "-lm").CombinedOutput(); err != nil {
+		t.Fatalf("assemble error: %v\n%s\n%s", err, out, asm)
+	}
+
+	cmd := exec.Command(binFile)
+	if run != nil {
+		cmd = exec.Command(run[0], append(run[1:], binFile)...)
+	}
+	err := cmd.Run()
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		return exitErr.ExitCode()
+	} else if err != nil {
+		t.Fatal(err)
+	}
+	return 0
+}
+
+func TestOptArgs(t *testing.T) {
+	tests := []struct {
+		args []string
+		want []string
+	}{
+		{[]string{"-i", "a.c"}, []string{"-i", "a.c"}},
+		{[]string{"-O2", "-i", "a.c"}, []string{"-O=2", "-i", "a.c"}},
+		{[]string{"-O", "-i", "a.c"}, []string{"-O=1", "-i", "a.c"}},
+		{[]string{"-Os"}, []string{"-O=s"}},
+		{[]string{"-O=0"}, []string{"-O=0"}},
+	}
+	for _, tt := range tests {
+		if diff := cmp.Diff(tt.want, optArgs(tt.args)); diff != "" {
+			t.Errorf("optArgs(%q) mismatch (-want +got):\n%s", tt.args, diff)
+		}
+	}
+}
+
+func TestPassManager(t *testing.T) {
+	tests := []struct {
+		level   optLevel
+		target  *generator.Target
+		unroll  bool
+		disable string
+		want    []string // names of the passes to run
+	}{
+		{O0, generator.X86_64, false, "", []string{"inline"}},
+		{O0, generator.X86_64, true, "", []string{"inline"}},
+		{O1, generator.X86_64, false, "", []string{"fold", "inline", "ssa", "sccp", "licm", "dce", "peephole"}},
+		{O2, generator.X86_64, false, "", []string{"fold", "inline", "ssa", "sccp", "gvn", "licm", "strength", "dce", "tailcall", "peephole"}},
+		{Os, generator.X86_64, false, "", []string{"fold", "inline", "ssa", "sccp", "gvn", "licm", "dce", "tailcall", "peephole"}},
+		{O2, generator.X86_64, false, "gvn, peephole", []string{"fold", "inline", "ssa", "sccp", "licm", "strength", "dce", "tailcall"}},
+		{O1, generator.X86_64, true, "", []string{"fold", "inline", "ssa", "unroll", "sccp", "licm", "dce", "peephole"}},
+		{O2, generator.AArch64, false, "", []string{"fold", "inline", "ssa", "sccp", "gvn", "licm", "strength", "dce", "tailcall"}},
+		{O1, generator.RISCV64, false, "", []string{"fold", "inline", "ssa", "sccp", "licm", "dce"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%d/%s/%v/%s", tt.level, tt.target.Triple, tt.unroll, tt.disable), func(t *testing.T) {
+			pm := newPassManager(tt.level, tt.target, tt.unroll)
+			if err := pm.disable(tt.disable); err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, p := range pm.enabled() {
+				got = append(got, p.name)
+			}
+			if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+				t.Errorf("passes: got = %v, want = %v", got, tt.want)
+			}
+		})
+	}
+
+	if err := newPassManager(O1, generator.X86_64, false).disable("gvn"); err == nil {
+		t.Errorf("expected an error for a pass that is not registered")
+	}
+
+	// without the ssa pass, the passes that need SSA form are skipped
+	pm := newPassManager(O2, generator.X86_64, false)
+	if err := pm.disable("ssa"); err != nil {
+		t.Fatal(err)
+	}
+	asm, err := compile("int main() { int x = 2; return x * 3; }", false, generator.X86_64, pm)
+	if err != nil {
+		t.Fatalf("compile error: %v", err)
+	}
+	if !strings.Contains(asm, "imul") {
+		t.Errorf("expected the multiplication to be kept:\n%s", asm)
+	}
+}
+
+// TestLoopPasses compiles C loops and checks that each loop pass moves an
+// instruction out of the loops, where it stays with the pass disabled.
+func TestLoopPasses(t *testing.T) {
+	tests := []struct {
+		pass   string
+		level  optLevel
+		unroll bool
+		input  string
+		op     string
+	}{
+		// a * a is invariant
+		{"licm", O1, false, "int f(int *p, int a, int n) { int i; for (i = 0; i < n; i = i + 1) p[i] = a * a; return 0; }", "mul i32"},
+		// the offset of p[i] becomes a pointer bumped by 4 bytes
+		{"strength", O2, false, "int f(int *p, int n) { int s = 0; int i; for (i = 0; i < n; i = i + 1) s = s + p[i]; return s; }", "shl i64"},
+		// the loop is gone
+		{"unroll", O1, true, "int f() { int s = 0; for (int i = 0; i < 4; i = i + 1) s = s + i; return s; }", "add i32"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.pass, func(t *testing.T) {
+			for _, disabled := range []bool{false, true} {
+				pm := newPassManager(tt.level, generator.X86_64, tt.unroll)
+				if disabled {
+					if err := pm.disable(tt.pass); err != nil {
+						t.Fatal(err)
+					}
+				}
+				prog, err := compileIR(tt.input, false, generator.X86_64, pm)
+				if err != nil {
+					t.Fatalf("compile error: %v", err)
+				}
+				f := prog.Funcs[0]
+				var loops strings.Builder
+				for _, l := range ir.FindLoops(f) {
+					for b := range l.Blocks {
+						for _, in := range b.Insts {
+							fmt.Fprintln(&loops, in)
+						}
+					}
+				}
+				if got := strings.Contains(loops.String(), tt.op); got != disabled {
+					t.Errorf("disabled = %v: expected %q in a loop = %v in:\n%s", disabled, tt.op, disabled, ir.DumpFunc(f))
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,81 @@
+package parser
+
+// Arch is the target machine, as far as the front end depends on it: the
+// signedness of plain char, the layout of va_list and of the register save
+// area of variadic functions, and the register names of inline assembly.
+type Arch int
+
+const (
+	ARCH_X86_64  Arch = iota // System V AMD64 ABI
+	ARCH_AARCH64             // AAPCS64
+	ARCH_RISCV64             // RISC-V LP64D
+)
+
+// vaElem is the element type of va_list on x86-64, laid out as the System V
+// ABI specifies.
+var vaElem = &Type{Kind: TY_STRUCT, Size: 24, Align: 8, Members: []*Member{
+	{Name: "gp_offset", Ty: TyUInt, Offset: 0},
+	{Name: "fp_offset", Ty: TyUInt, Offset: 4},
+	{Name: "overflow_arg_area", Ty: pointerTo(TyVoid), Offset: 8},
+	{Name: "reg_save_area", Ty: pointerTo(TyVoid), Offset: 16},
+}}
+
+// vaElemAArch64 is the element type of va_list on AArch64, laid out as the
+// AAPCS64 specifies.
+var vaElemAArch64 = &Type{Kind: TY_STRUCT, Size: 32, Align: 8, Members: []*Member{
+	{Name: "__stack", Ty: pointerTo(TyVoid), Offset: 0},
+	{Name: "__gr_top", Ty: pointerTo(TyVoid), Offset: 8},
+	{Name: "__vr_top", Ty: pointerTo(TyVoid), Offset: 16},
+	{Name: "__gr_offs", Ty: TyInt, Offset: 24},
+	{Name: "__vr_offs", Ty: TyInt, Offset: 28},
+}}
+
+// TyVaList is va_list on x86-64, an array of one element so that it is
+// passed to functions such as vprintf by reference.
+var TyVaList = arrayOf(vaElem, 1)
+
+// tyVaListAArch64 is va_list on AArch64. The AAPCS64 makes it a structure,
+// which is passed by reference as it is larger than 16 bytes, so an array
+// is passed the same way.
+var tyVaListAArch64 = arrayOf(vaElemAArch64, 1)
+
+// tyVaListRISCV64 is va_list on RISC-V, a pointer to the next argument.
+// The variadic arguments are all passed in integer registers or on the
+// stack, and the backend saves the registers next to the stack arguments,
+// so the pointer walks through them in order.
+var tyVaListRISCV64 = pointerTo(TyVoid)
+
+// plainChar returns the type of plain char on a. The System V ABI makes it
+// signed, and the AAPCS64 and the RISC-V psABI unsigned. Either way it is
+// not compatible with signed char or unsigned char.
+func (a Arch) plainChar() *Type {
+	switch a {
+	case ARCH_AARCH64, ARCH_RISCV64:
+		return TyPlainUChar
+	}
+	return TyPlainChar
+}
+
+// vaList returns the type of va_list on a.
+func (a Arch) vaList() *Type {
+	switch a {
+	case ARCH_AARCH64:
+		return tyVaListAArch64
+	case ARCH_RISCV64:
+		return tyVaListRISCV64
+	}
+	return TyVaList
+}
+
+// vaAreaSize returns the size of the register save area of a variadic
+// function on a, in longs: the va_list element that va_start copies,
+// followed by the registers that may hold arguments. See the generator.
+func (a Arch) vaAreaSize() int {
+	switch a {
+	case ARCH_AARCH64:
+		return (32 + 8*8 + 16*8) / 8 // x0-x7 and q0-q7
+	case ARCH_RISCV64:
+		return 1 // a0-a7 are saved above the frame
+	}
+	return (24 + 8*6 + 16*8) / 8 // rdi-r9 and xmm0-xmm7
+}
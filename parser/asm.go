@@ -0,0 +1,440 @@
+package parser
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"rkitamu/gocc/errors"
+	"rkitamu/gocc/lexer"
+)
+
+// asmRegNames lists the general-purpose registers inline assembly may
+// clobber, by their 64-bit, 32-bit, 16-bit and 8-bit names. rsp and rbp hold
+// the stack frame and cannot be clobbered.
+var asmRegNames = [][4]string{
+	{"rax", "eax", "ax", "al"},
+	{"rbx", "ebx", "bx", "bl"},
+	{"rcx", "ecx", "cx", "cl"},
+	{"rdx", "edx", "dx", "dl"},
+	{"rsi", "esi", "si", "sil"},
+	{"rdi", "edi", "di", "dil"},
+	{"r8", "r8d", "r8w", "r8b"},
+	{"r9", "r9d", "r9w", "r9b"},
+	{"r10", "r10d", "r10w", "r10b"},
+	{"r11", "r11d", "r11w", "r11b"},
+	{"r12", "r12d", "r12w", "r12b"},
+	{"r13", "r13d", "r13w", "r13b"},
+	{"r14", "r14d", "r14w", "r14b"},
+	{"r15", "r15d", "r15w", "r15b"},
+}
+
+// asm-stmt = "asm" "volatile"* "(" str (":" asm-operands? (":" asm-operands? (":" asm-clobbers?)?)?)? ")" ";"
+//
+// Without a colon, the template is basic asm and emitted exactly as
+// written. Otherwise %N and %[name] in the template refer to the operands,
+// outputs first, and %% is a literal %. The statement is never removed or
+// moved, so volatile changes nothing.
+func (p *Parser) asmStmt() (*Node, error) {
+	p.advance()
+	for p.current.Kind == lexer.VOLATILE {
+		p.advance()
+	}
+	if err := p.expect("("); err != nil {
+		return nil, err
+	}
+	if p.current.Kind != lexer.STR {
+		return nil, errors.NewPosError("expected string literal", p.input, p.current.Pos)
+	}
+	tmplTok := p.current
+	tmpl := p.stringLiteral()
+
+	asm := &AsmStmt{}
+	if !p.match(":") {
+		asm.Template = []*AsmPiece{{Text: tmpl, Operand: -1}}
+	} else {
+		p.advance()
+		var err error
+		if asm.Outputs, err = p.asmOperands(true, 0); err != nil {
+			return nil, err
+		}
+		if p.match(":") {
+			p.advance()
+			if asm.Inputs, err = p.asmOperands(false, len(asm.Outputs)); err != nil {
+				return nil, err
+			}
+		}
+		if p.match(":") {
+			p.advance()
+			if asm.Clobbers, err = p.asmClobbers(); err != nil {
+				return nil, err
+			}
+		}
+		if asm.Template, err = p.asmTemplate(tmpl, tmplTok, asm); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := p.expect(")"); err != nil {
+		return nil, err
+	}
+	if err := p.expect(";"); err != nil {
+		return nil, err
+	}
+	return &Node{Kind: ASM, Asm: asm}, nil
+}
+
+// asm-operands = asm-operand ("," asm-operand)*
+//
+// The operands are numbered from first on.
+func (p *Parser) asmOperands(output bool, first int) ([]*AsmOperand, error) {
+	var ops []*AsmOperand
+	for !p.match(":") && !p.match(")") {
+		if len(ops) > 0 {
+			if err := p.expect(","); err != nil {
+				return nil, err
+			}
+		}
+		op, err := p.asmOperand(output, first+len(ops))
+		if err != nil {
+			return nil, err
+		}
+		ops = append(ops, op)
+	}
+	return ops, nil
+}
+
+// asm-operand = ("[" ident "]")? str "(" expr ")"
+//
+// The constraint may allow several places for the operand. An immediate is
+// preferred for a constant, then a register, then memory.
+func (p *Parser) asmOperand(output bool, n int) (*AsmOperand, error) {
+	op := &AsmOperand{}
+	if p.match("[") {
+		p.advance()
+		if p.current.Kind != lexer.IDENT {
+			return nil, errors.NewPosError("expected operand name", p.input, p.current.Pos)
+		}
+		op.Name = p.current.Str
+		p.advance()
+		if err := p.expect("]"); err != nil {
+			return nil, err
+		}
+	}
+
+	if p.current.Kind != lexer.STR {
+		return nil, errors.NewPosError("expected string literal", p.input, p.current.Pos)
+	}
+	ctok := p.current
+	constraint := p.stringLiteral()
+	if err := p.expect("("); err != nil {
+		return nil, err
+	}
+	start := p.current
+	expr, err := p.expr()
+	if err != nil {
+		return nil, err
+	}
+	if err := p.expect(")"); err != nil {
+		return nil, err
+	}
+	AddType(expr)
+
+	letters := constraint
+	if output {
+		switch {
+		case strings.HasPrefix(letters, "="):
+			letters = letters[1:]
+		case strings.HasPrefix(letters, "+"):
+			op.InOut = true
+			letters = letters[1:]
+		default:
+			return nil, errors.NewPosError("output operand constraint lacks '='", p.input, ctok.Pos)
+		}
+	} else if strings.ContainsAny(letters, "=+") {
+		return nil, errors.NewPosError("input operand constraint contains '=' or '+'", p.input, ctok.Pos)
+	}
+
+	var reg, mem, imm bool
+	for _, c := range letters {
+		switch {
+		case c == 'r':
+			reg = true
+		case c == 'm':
+			mem = true
+		case (c == 'i' || c == 'n') && !output:
+			imm = true
+		case c == 'g' && !output:
+			reg, mem, imm = true, true, true
+		case c == 'g':
+			reg, mem = true, true
+		case c == '&' && output:
+			// every output gets a register of its own anyway
+		default:
+			return nil, errors.NewPosError(fmt.Sprintf("unsupported constraint %q in asm", constraint), p.input, ctok.Pos)
+		}
+	}
+	if !reg && !mem && !imm {
+		return nil, errors.NewPosError(fmt.Sprintf("unsupported constraint %q in asm", constraint), p.input, ctok.Pos)
+	}
+
+	// Outputs and memory operands are lvalues. Outputs are checked to be
+	// modifiable by sema.
+	op.Pos = start.Pos
+	lval := undecay(expr)
+	fits := func(ty *Type) bool { return ty.IsInteger() || ty.IsPointer() }
+
+	switch {
+	case imm && isIntConst(expr):
+		op.Constraint = ASM_IMM
+		op.Val, _, _ = eval(expr)
+	case reg && output && fits(lval.Ty) && !isBitfield(lval):
+		op.Constraint = ASM_REG
+		op.Expr = lval
+	case reg && !output && fits(expr.Ty):
+		op.Constraint = ASM_REG
+		op.Expr = expr
+	case mem && (output || IsLvalue(lval)) && !isBitfield(lval):
+		op.Constraint = ASM_MEM
+		op.Expr = lval
+	case imm && !reg && !mem:
+		return nil, errors.NewPosError(fmt.Sprintf("asm operand %d must be an integer constant", n), p.input, start.Pos)
+	default:
+		return nil, errors.NewPosError(fmt.Sprintf("impossible constraint for asm operand %d", n), p.input, start.Pos)
+	}
+	return op, nil
+}
+
+// isIntConst reports whether node is an integer constant expression.
+func isIntConst(node *Node) bool {
+	AddType(node)
+	_, label, ok := eval(node)
+	return ok && label == "" && node.Ty.IsInteger()
+}
+
+// asm-clobbers = str ("," str)*
+//
+// It returns the clobbered general-purpose registers, and the callee-saved
+// d8-d15 on AArch64 and fs0-fs11 on RISC-V, which the backend must preserve
+// for the caller. The
+// backend keeps no value in them across the statement, and values are not
+// cached from memory or kept in the flags or vector registers across
+// statements, so "memory", "cc" and the other vector registers need no
+// care.
+func (p *Parser) asmClobbers() ([]string, error) {
+	var regs []string
+	for !p.match(")") {
+		if p.current.Kind != lexer.STR {
+			return nil, errors.NewPosError("expected string literal", p.input, p.current.Pos)
+		}
+		tok := p.current
+		name := strings.TrimPrefix(p.stringLiteral(), "%")
+		reg, vector := asmRegName(name), isXmmName(name)
+		switch p.Arch {
+		case ARCH_AARCH64:
+			reg, vector = asmRegNameAArch64(name), isVectorNameAArch64(name)
+		case ARCH_RISCV64:
+			reg, vector = asmRegNameRISCV64(name), isFloatNameRISCV64(name)
+		}
+		switch {
+		case reg != "":
+			if !slices.Contains(regs, reg) {
+				regs = append(regs, reg)
+			}
+		case name == "memory" || name == "cc" || vector:
+		default:
+			return nil, errors.NewPosError(fmt.Sprintf("unknown register name %q in asm", name), p.input, tok.Pos)
+		}
+		if !p.match(",") {
+			break
+		}
+		p.advance()
+	}
+	return regs, nil
+}
+
+// asmRegName returns the 64-bit name of the general-purpose register name,
+// or "" if there is none.
+func asmRegName(name string) string {
+	for _, names := range asmRegNames {
+		for _, n := range names {
+			if n == name {
+				return names[0]
+			}
+		}
+	}
+	return ""
+}
+
+// isXmmName reports whether name is one of xmm0 to xmm15.
+func isXmmName(name string) bool {
+	for i := 0; i < 16; i++ {
+		if name == fmt.Sprintf("xmm%d", i) {
+			return true
+		}
+	}
+	return false
+}
+
+// asmRegNameAArch64 returns the 64-bit name of the AArch64 general-purpose
+// register name, x0 to x28 or x30 by their 64-bit or 32-bit names, or d8
+// to d15 for any name of v8 to v15, or "" if there is none. x29 holds the
+// stack frame and cannot be clobbered.
+func asmRegNameAArch64(name string) string {
+	for i := 0; i <= 30; i++ {
+		if i != 29 && (name == fmt.Sprintf("x%d", i) || name == fmt.Sprintf("w%d", i)) {
+			return fmt.Sprintf("x%d", i)
+		}
+	}
+	for i := 8; i <= 15; i++ {
+		for _, prefix := range []string{"v", "q", "d", "s", "h", "b"} {
+			if name == fmt.Sprintf("%s%d", prefix, i) {
+				return fmt.Sprintf("d%d", i)
+			}
+		}
+	}
+	return ""
+}
+
+// isVectorNameAArch64 reports whether name is one of the AArch64 vector
+// registers v0 to v31, by any of their names.
+func isVectorNameAArch64(name string) bool {
+	for i := 0; i < 32; i++ {
+		for _, prefix := range []string{"v", "q", "d", "s", "h", "b"} {
+			if name == fmt.Sprintf("%s%d", prefix, i) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// riscvRegNames gives the ABI names of the RISC-V integer registers x0 to
+// x31. zero, sp, gp, tp and s0, the frame pointer, cannot be clobbered.
+var riscvRegNames = []string{
+	"zero", "ra", "sp", "gp", "tp", "t0", "t1", "t2",
+	"s0", "s1", "a0", "a1", "a2", "a3", "a4", "a5",
+	"a6", "a7", "s2", "s3", "s4", "s5", "s6", "s7",
+	"s8", "s9", "s10", "s11", "t3", "t4", "t5", "t6",
+}
+
+// riscvFloatRegNames gives the ABI names of the RISC-V floating-point
+// registers f0 to f31.
+var riscvFloatRegNames = []string{
+	"ft0", "ft1", "ft2", "ft3", "ft4", "ft5", "ft6", "ft7",
+	"fs0", "fs1", "fa0", "fa1", "fa2", "fa3", "fa4", "fa5",
+	"fa6", "fa7", "fs2", "fs3", "fs4", "fs5", "fs6", "fs7",
+	"fs8", "fs9", "fs10", "fs11", "ft8", "ft9", "ft10", "ft11",
+}
+
+// asmRegNameRISCV64 returns the ABI name of the RISC-V integer register
+// name, given by its ABI name or as x0 to x31, or of the callee-saved
+// floating-point register name, or "" if there is none.
+func asmRegNameRISCV64(name string) string {
+	if name == "fp" {
+		return ""
+	}
+	for i, abi := range riscvRegNames {
+		if name == abi || name == fmt.Sprintf("x%d", i) {
+			if slices.Contains([]string{"zero", "sp", "gp", "tp", "s0"}, abi) {
+				return ""
+			}
+			return abi
+		}
+	}
+	for i, abi := range riscvFloatRegNames {
+		if strings.HasPrefix(abi, "fs") && (name == abi || name == fmt.Sprintf("f%d", i)) {
+			return abi
+		}
+	}
+	return ""
+}
+
+// isFloatNameRISCV64 reports whether name is one of the RISC-V
+// floating-point registers f0 to f31, by either of their names.
+func isFloatNameRISCV64(name string) bool {
+	for i, abi := range riscvFloatRegNames {
+		if name == abi || name == fmt.Sprintf("f%d", i) {
+			return true
+		}
+	}
+	return false
+}
+
+// asmTemplate splits the template of the extended asm statement asm, given
+// by the string literal at tok, into text and operand references, which are
+// %N or %[name], optionally with a modifier letter after the %.
+func (p *Parser) asmTemplate(tmpl string, tok *lexer.Token, asm *AsmStmt) ([]*AsmPiece, error) {
+	operands := append(append([]*AsmOperand{}, asm.Outputs...), asm.Inputs...)
+
+	var pieces []*AsmPiece
+	var text strings.Builder
+	for i := 0; i < len(tmpl); i++ {
+		if tmpl[i] != '%' {
+			text.WriteByte(tmpl[i])
+			continue
+		}
+		i++
+		if i < len(tmpl) && tmpl[i] == '%' {
+			text.WriteByte('%')
+			continue
+		}
+
+		piece := &AsmPiece{}
+		modifiers := "bwkqc"
+		switch p.Arch {
+		case ARCH_AARCH64:
+			modifiers = "wxc"
+		case ARCH_RISCV64:
+			modifiers = "c"
+		}
+		if i < len(tmpl) && strings.IndexByte(modifiers, tmpl[i]) >= 0 {
+			piece.Modifier = tmpl[i]
+			i++
+		}
+		switch {
+		case i < len(tmpl) && tmpl[i] == '[':
+			end := strings.IndexByte(tmpl[i:], ']')
+			if end < 0 {
+				return nil, errors.NewPosError("invalid operand reference in asm", p.input, tok.Pos)
+			}
+			name := tmpl[i+1 : i+end]
+			piece.Operand = -1
+			for j, op := range operands {
+				if op.Name == name {
+					piece.Operand = j
+					break
+				}
+			}
+			if piece.Operand < 0 {
+				return nil, errors.NewPosError(fmt.Sprintf("undefined named operand %q", name), p.input, tok.Pos)
+			}
+			i += end
+		case i < len(tmpl) && '0' <= tmpl[i] && tmpl[i] <= '9':
+			for ; i < len(tmpl) && '0' <= tmpl[i] && tmpl[i] <= '9'; i++ {
+				piece.Operand = piece.Operand*10 + int(tmpl[i]-'0')
+			}
+			i--
+			if piece.Operand >= len(operands) {
+				return nil, errors.NewPosError("operand number out of range", p.input, tok.Pos)
+			}
+		default:
+			return nil, errors.NewPosError("invalid operand reference in asm", p.input, tok.Pos)
+		}
+
+		c := operands[piece.Operand].Constraint
+		if (piece.Modifier == 'c' && c != ASM_IMM) || (piece.Modifier != 0 && piece.Modifier != 'c' && c != ASM_REG) {
+			return nil, errors.NewPosError(fmt.Sprintf("invalid operand modifier '%c' in asm", piece.Modifier), p.input, tok.Pos)
+		}
+
+		if text.Len() > 0 {
+			pieces = append(pieces, &AsmPiece{Text: text.String(), Operand: -1})
+			text.Reset()
+		}
+		pieces = append(pieces, piece)
+	}
+	if text.Len() > 0 {
+		pieces = append(pieces, &AsmPiece{Text: text.String(), Operand: -1})
+	}
+	return pieces, nil
+}
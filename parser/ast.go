@@ -4,20 +4,37 @@ package parser
 type NodeKind int
 
 const (
-	ADD    NodeKind = iota // +
-	SUB                    // -
-	MUL                    // *
-	DIV                    // /
-	EQ                     // ==
-	NEQ                    // !=
-	LT                     // <
-	LTE                    // <=
-	NUM                    // number literal
-	ASSIGN                 // =
-	LVAR                   // variable
-	RETURN                 // return statement
-	IF                     // if statement
-	EOF                    // end of file (optional, not usually needed in AST)
+	ADD       NodeKind = iota // +
+	SUB                       // -
+	MUL                       // *
+	DIV                       // /
+	EQ                        // ==
+	NEQ                       // !=
+	LT                        // <
+	LTE                       // <=
+	NUM                       // number literal
+	ASSIGN                    // =
+	LVAR                      // local variable
+	GVAR                      // variable with static storage duration
+	RETURN                    // return statement
+	IF                        // if statement
+	FOR                       // for or while statement
+	BLOCK                     // list of statements
+	CAST                      // type conversion
+	FUNCALL                   // function call
+	ADDR                      // unary &
+	DEREF                     // unary *
+	MEMBER                    // . struct member access
+	MEMZERO                   // zero-clear a local variable
+	VA_START                  // va_start, initializing a va_list
+	VA_ARG                    // va_arg, fetching the next variadic argument
+	COMMA                     // evaluate Lhs as a statement, then Rhs
+	VLA_ALLOC                 // allocate a variable length array on the stack
+	VLA_SAVE                  // save the stack pointer in Var before the variable length arrays of a scope
+	VLA_FREE                  // free those arrays by restoring the stack pointer from Var
+	ASM                       // inline assembly statement
+	SHL                       // <<, only made by Fold from a multiplication
+	EOF                       // end of file (optional, not usually needed in AST)
 )
 
 // Node represents a node in the abstract syntax tree (AST).
@@ -26,14 +43,119 @@ type Node struct {
 	Lhs    *Node    // Left-hand side expression
 	Rhs    *Node    // Right-hand side expression
 	Val    int      // Literal value (only used if Kind == NUM)
-	Offset int      // Offset for local variables (only used if Kind == LVAR)
-	Cond   *Node    // Condition for if statements
-	Then   *Node    // Then branch for if statements
+	FVal   float64  // Floating literal value (only used if Kind == NUM)
+	Offset int      // Offset of the accessed part within Var for LVAR, or from Label for GVAR
+	Var    *LVar    // Local variable (only used if Kind == LVAR, MEMZERO, VA_START or VLA_*)
+	Label  string   // Symbol of the variable (only used if Kind == GVAR)
+	Member *Member  // Accessed member (only used if Kind == MEMBER)
+	Cond   *Node    // Condition for if statements and loops, nil if a loop has none
+	Then   *Node    // Then branch for if statements, body of loops
 	Else   *Node    // Else branch for if statements
+	Init   *Node    // Initialization for for statements
+	Inc    *Node    // Increment for for statements
+	Body   []*Node  // Statements in a block
+	Ty     *Type    // Type of the expression, set as the parser builds the node
+	Pos    int      // Position of the operator in the input string (only used if Kind == ASSIGN, ADDR or DIV)
+	IsInit bool     // The ASSIGN initializes its Lhs, which may be read-only
+
+	FuncName string  // Called function (only used if Kind == FUNCALL)
+	Args     []*Node // Arguments converted to the parameter types
+	Fixed    int     // Number of named arguments if the called function is variadic, 0 otherwise
+
+	Asm *AsmStmt // Inline assembly (only used if Kind == ASM)
+}
+
+// AsmStmt is an inline assembly statement. Its template is emitted as is,
+// with the operands substituted.
+type AsmStmt struct {
+	Template []*AsmPiece
+	Outputs  []*AsmOperand
+	Inputs   []*AsmOperand
+	Clobbers []string // clobbered registers by their 64-bit names, see asmClobbers
+}
+
+// AsmConstraint is the place chosen for an asm operand among those its
+// constraint allows.
+type AsmConstraint int
+
+const (
+	ASM_REG AsmConstraint = iota // "r": in a general-purpose register
+	ASM_MEM                      // "m": in memory, at the address of Expr
+	ASM_IMM                      // "i" or "n": an integer constant
+)
+
+// AsmOperand is an output or input operand of an inline assembly statement.
+type AsmOperand struct {
+	Name       string // symbolic name given in brackets, or ""
+	Constraint AsmConstraint
+	InOut      bool  // "+": an output that is also read
+	Expr       *Node // the lvalue of an output or memory operand, or the value of an input
+	Val        int   // value of an immediate operand
+	Pos        int   // position of Expr in the input string
+}
+
+// AsmPiece is literal text of an asm template, or a reference to an operand.
+type AsmPiece struct {
+	Text     string
+	Operand  int  // index in the outputs followed by the inputs, -1 for text
+	Modifier byte // 'b', 'w', 'k' or 'q' for a register of that size, 'w' or 'x' on AArch64, 'c' for a bare constant, or 0
 }
 
 type LVar struct {
 	Next   *LVar
 	Name   string
+	Ty     *Type
+	Align  int // alignment of the variable, at least that of its type
+	Offset int // offset below rbp, assigned by sema
+
+	// Global is set for static and extern variables declared in a
+	// function. They take no stack space, so Offset repeats the previous one.
+	Global *Global
+}
+
+// Global represents a variable with static storage duration: a file-scope
+// variable or a static local.
+type Global struct {
+	Name         string // assembler symbol
+	Ty           *Type
+	Align        int  // alignment requested by _Alignas, 0 if none
+	IsStatic     bool // internal linkage, not visible to other files
+	IsDefinition bool // false for extern declarations defined elsewhere
+	InitData     []byte
+	Relocs       []*Reloc // addresses stored in InitData
+	Pos          int      // Position of the name in the input string
+}
+
+// Reloc is an address of another symbol within the initial value of a
+// global, resolved by the linker.
+type Reloc struct {
 	Offset int
+	Label  string
+	Addend int
 }
+
+// Function represents a function definition or declaration.
+type Function struct {
+	Name         string
+	Ty           *Type   // Function type
+	Params       []*LVar // Parameters, also found in Locals
+	Locals       *LVar
+	Body         *Node // Function body (only used if IsDefinition)
+	StackSize    int   // Size of the stack frame for Locals, set by sema
+	IsDefinition bool
+	IsStatic     bool       // internal linkage, not visible to other files
+	IsInlineDef  bool       // only an inline definition, not emitted, see declareFunc
+	Inline       InlineKind // how the function asks to be inlined
+	VaArea       *LVar      // register save area of a variadic function
+	Pos          int        // Position of the name in the input string
+}
+
+// InlineKind is how a function asks to be inlined at its call sites.
+type InlineKind int
+
+const (
+	INLINE_DEFAULT InlineKind = iota // as the optimizer sees fit
+	INLINE_HINT                      // declared inline
+	INLINE_ALWAYS                    // __attribute__((always_inline))
+	INLINE_NEVER                     // __attribute__((noinline))
+)
@@ -0,0 +1,60 @@
+package parser
+
+import (
+	"fmt"
+
+	"rkitamu/gocc/errors"
+	"rkitamu/gocc/lexer"
+)
+
+// bitWidth parses ":" const-expr, the width of the bit-field name of type
+// ty. name is nil for an unnamed bit-field, which may have zero width.
+func (p *Parser) bitWidth(ty *Type, name *lexer.Token) (int, error) {
+	desc, pos := "unnamed bit-field", p.current.Pos
+	if name != nil {
+		desc, pos = "bit-field "+name.Str, name.Pos
+	}
+	if !ty.IsInteger() {
+		return 0, errors.NewPosError(fmt.Sprintf("%s has invalid type", desc), p.input, pos)
+	}
+	p.advance()
+
+	start := p.current
+	width, err := p.constExpr()
+	if err != nil {
+		return 0, err
+	}
+	bits := ty.Size * 8
+	if ty.Kind == TY_BOOL {
+		bits = 1
+	}
+	switch {
+	case width < 0:
+		return 0, errors.NewPosError(fmt.Sprintf("negative width in %s", desc), p.input, start.Pos)
+	case width > bits:
+		return 0, errors.NewPosError(fmt.Sprintf("width of %s exceeds its type", desc), p.input, start.Pos)
+	case width == 0 && name != nil:
+		return 0, errors.NewPosError(fmt.Sprintf("zero width for %s", desc), p.input, start.Pos)
+	}
+	return width, nil
+}
+
+// isBitfield reports whether node accesses a bit-field.
+func isBitfield(node *Node) bool {
+	return node.Kind == MEMBER && node.Member.IsBitfield
+}
+
+// promoteBitfield converts the value of a bit-field to int if int can
+// represent all of its values, as the integer promotions do for types
+// narrower than int. Other expressions are returned unchanged.
+func promoteBitfield(node *Node) *Node {
+	AddType(node)
+	if !isBitfield(node) || integerRank(unqual(node.Ty)) > integerRank(TyInt) {
+		return node
+	}
+	m := node.Member
+	if m.BitWidth < 32 || (m.BitWidth == 32 && !m.Ty.Unsigned) {
+		return newCast(node, TyInt)
+	}
+	return node
+}
@@ -0,0 +1,213 @@
+package parser
+
+import (
+	"fmt"
+	"rkitamu/gocc/errors"
+	"rkitamu/gocc/lexer"
+)
+
+// maxStackAlign is the largest alignment a local variable can have, since
+// the frame is only as aligned as the stack pointer at the call.
+const maxStackAlign = 16
+
+// staticAssert = "_Static_assert" "(" const-expr "," str ")" ";"
+//
+// The assertion is checked while parsing and produces no code.
+func (p *Parser) staticAssert() error {
+	p.advance()
+	if err := p.expect("("); err != nil {
+		return err
+	}
+	start := p.current
+	val, err := p.constExpr()
+	if err != nil {
+		return err
+	}
+	if err := p.expect(","); err != nil {
+		return err
+	}
+	if p.current.Kind != lexer.STR {
+		return errors.NewPosError("expected string literal", p.input, p.current.Pos)
+	}
+	msg := p.stringLiteral()
+	if err := p.expect(")"); err != nil {
+		return err
+	}
+	if err := p.expect(";"); err != nil {
+		return err
+	}
+	if val == 0 {
+		return errors.NewPosError(fmt.Sprintf("static assertion failed: %q", msg), p.input, start.Pos)
+	}
+	return nil
+}
+
+// alignas = "_Alignas" "(" (type-name | const-expr) ")"
+//
+// It returns the requested alignment, where 0 requests no change.
+func (p *Parser) alignas() (int, error) {
+	p.advance()
+	if err := p.expect("("); err != nil {
+		return 0, err
+	}
+	var align int
+	if p.isTypename() {
+		ty, err := p.typeName()
+		if err != nil {
+			return 0, err
+		}
+		align = ty.Align
+	} else {
+		start := p.current
+		val, err := p.constExpr()
+		if err != nil {
+			return 0, err
+		}
+		if val < 0 || val&(val-1) != 0 {
+			return 0, errors.NewPosError("requested alignment is not a power of 2", p.input, start.Pos)
+		}
+		align = val
+	}
+	if err := p.expect(")"); err != nil {
+		return 0, err
+	}
+	return align, nil
+}
+
+// declAlign returns the alignment of the variable name of type ty, taking
+// the alignment requested by _Alignas in attr into account. It may not be
+// less strict than the alignment of the type.
+func (p *Parser) declAlign(name *lexer.Token, ty *Type, attr *VarAttr) (int, error) {
+	if attr.Align == 0 {
+		return ty.Align, nil
+	}
+	if ty.Kind == TY_FUNC {
+		return 0, errors.NewPosError(
+			fmt.Sprintf("_Alignas cannot be applied to function %s", name.Str),
+			p.input,
+			name.Pos,
+		)
+	}
+	if attr.Align < ty.Align {
+		return 0, errors.NewPosError(
+			fmt.Sprintf("requested alignment of %s is less than the alignment of its type", name.Str),
+			p.input,
+			name.Pos,
+		)
+	}
+	return attr.Align, nil
+}
+
+// stackAlignError reports that the local variable name needs a stricter
+// alignment than the stack frame provides.
+func (p *Parser) stackAlignError(name *lexer.Token) error {
+	return errors.NewPosError(
+		fmt.Sprintf("requested alignment of %s exceeds %d bytes, the maximum for a local variable", name.Str, maxStackAlign),
+		p.input,
+		name.Pos,
+	)
+}
+
+// alignof = "_Alignof" "(" type-name ")"
+func (p *Parser) alignof() (*Node, error) {
+	p.advance()
+	if err := p.expect("("); err != nil {
+		return nil, err
+	}
+	start := p.current
+	ty, err := p.typeName()
+	if err != nil {
+		return nil, err
+	}
+	if ty.Kind == TY_FUNC || ty.Size < 0 {
+		return nil, errors.NewPosError("invalid application of _Alignof", p.input, start.Pos)
+	}
+	if err := p.expect(")"); err != nil {
+		return nil, err
+	}
+	return &Node{Kind: NUM, Val: ty.Align, Ty: TyULong}, nil
+}
+
+// generic = "_Generic" "(" assign ("," generic-assoc)+ ")"
+// generic-assoc = (type-name | "default") ":" assign
+//
+// The controlling expression is not evaluated. Its type, after lvalue
+// conversion, selects the association with a compatible type, or else the
+// default one.
+func (p *Parser) generic() (*Node, error) {
+	tok := p.current
+	p.advance()
+	if err := p.expect("("); err != nil {
+		return nil, err
+	}
+	ctrl, err := p.assign()
+	if err != nil {
+		return nil, err
+	}
+	ctrl = decay(ctrl)
+	ctrlTy := unqual(ctrl.Ty)
+
+	var selected, def *Node
+	var types []*Type
+	for p.match(",") {
+		p.advance()
+
+		start := p.current
+		var ty *Type
+		if p.match("default") {
+			if def != nil {
+				return nil, errors.NewPosError("duplicate default generic association", p.input, start.Pos)
+			}
+			p.advance()
+		} else {
+			if !p.isTypename() {
+				return nil, errors.NewPosError("expected a type name or default", p.input, start.Pos)
+			}
+			ty, err = p.typeName()
+			if err != nil {
+				return nil, err
+			}
+			if ty.Kind == TY_FUNC || ty.Size < 0 {
+				return nil, errors.NewPosError("generic association has incomplete or function type", p.input, start.Pos)
+			}
+			for _, t := range types {
+				if isCompatible(t, ty) {
+					return nil, errors.NewPosError(
+						"generic association type is compatible with a previous association",
+						p.input,
+						start.Pos,
+					)
+				}
+			}
+			types = append(types, ty)
+		}
+		if err := p.expect(":"); err != nil {
+			return nil, err
+		}
+		expr, err := p.assign()
+		if err != nil {
+			return nil, err
+		}
+
+		if ty == nil {
+			def = expr
+		} else if isCompatible(ctrlTy, ty) {
+			selected = expr
+		}
+	}
+	if err := p.expect(")"); err != nil {
+		return nil, err
+	}
+
+	if selected == nil {
+		selected = def
+	}
+	if selected == nil {
+		return nil, errors.NewPosError(
+			"controlling expression type is not compatible with any generic association",
+			p.input,
+			tok.Pos,
+		)
+	}
+	return selected, nil
+}
@@ -0,0 +1,327 @@
+package parser
+
+import (
+	"encoding/binary"
+	"math"
+)
+
+// initGlobal sets the initial data of g from init. Parts of init that are
+// not constant expressions are left zero, and returned as assignments to be
+// run before the program starts.
+func initGlobal(g *Global, init *Initializer) []*Node {
+	g.InitData = make([]byte, g.Ty.Size)
+	return writeInit(g, init, 0)
+}
+
+func writeInit(g *Global, init *Initializer, offset int) []*Node {
+	if init.Expr != nil {
+		if init.Ty.IsScalar() && writeScalar(g, offset, init.Ty, init.Expr) {
+			return nil
+		}
+		node := &Node{
+			Kind:   ASSIGN,
+			Lhs:    &Node{Kind: GVAR, Label: g.Name, Offset: offset, Ty: init.Ty},
+			Rhs:    init.Expr,
+			IsInit: true,
+		}
+		AddType(node)
+		return []*Node{node}
+	}
+
+	var nodes []*Node
+	for i, child := range init.Children {
+		if init.Ty.Kind == TY_ARRAY {
+			nodes = append(nodes, writeInit(g, child, offset+i*init.Ty.Base.Size)...)
+			continue
+		}
+		m := init.Ty.Members[i]
+		if m.IsBitfield && child.Expr != nil {
+			if writeBitfield(g, offset+m.Offset, m, child.Expr) {
+				continue
+			}
+			lhs := &Node{Kind: GVAR, Label: g.Name, Offset: offset, Ty: init.Ty}
+			node := &Node{Kind: ASSIGN, Lhs: bitfieldRef(lhs, m), Rhs: child.Expr, IsInit: true}
+			AddType(node)
+			nodes = append(nodes, node)
+			continue
+		}
+		nodes = append(nodes, writeInit(g, child, offset+m.Offset)...)
+	}
+	return nodes
+}
+
+// writeBitfield merges expr, converted to the type of the bit-field m, into
+// the storage unit at offset in the initial data of g. It reports false if
+// expr is not an integer constant expression.
+func writeBitfield(g *Global, offset int, m *Member, expr *Node) bool {
+	val, label, ok := eval(newCast(expr, m.Ty))
+	if !ok || label != "" {
+		return false
+	}
+	data := g.InitData[offset : offset+m.Ty.Size]
+	mask := (uint64(1)<<m.BitWidth - 1) << m.BitOffset
+	for i := range data {
+		shift := 8 * i
+		data[i] = data[i]&^byte(mask>>shift) | byte((uint64(val)<<m.BitOffset&mask)>>shift)
+	}
+	return true
+}
+
+// writeScalar writes expr, converted to ty, to the initial data of g at
+// offset. It reports false if expr is not a constant expression.
+func writeScalar(g *Global, offset int, ty *Type, expr *Node) bool {
+	expr = newCast(expr, ty)
+	data := g.InitData[offset : offset+ty.Size]
+
+	if ty.IsFloat() {
+		f, ok := evalFloat(expr)
+		if !ok {
+			return false
+		}
+		if ty.Kind == TY_FLOAT {
+			binary.LittleEndian.PutUint32(data, math.Float32bits(float32(f)))
+		} else {
+			binary.LittleEndian.PutUint64(data, math.Float64bits(f))
+		}
+		return true
+	}
+
+	val, label, ok := eval(expr)
+	if !ok {
+		return false
+	}
+	if label != "" {
+		g.Relocs = append(g.Relocs, &Reloc{Offset: offset, Label: label, Addend: val})
+		val = 0
+	}
+	for i := range data {
+		data[i] = byte(val >> (8 * i))
+	}
+	return true
+}
+
+// eval evaluates a constant expression of integer or pointer type. An
+// address constant evaluates to label plus the returned value, where label
+// is the symbol of a global; label is empty for other constants.
+func eval(node *Node) (val int, label string, ok bool) {
+	AddType(node)
+
+	switch node.Kind {
+	case NUM:
+		return node.Val, "", true
+	case ADD:
+		l, llabel, ok := eval(node.Lhs)
+		if !ok {
+			return 0, "", false
+		}
+		r, rlabel, ok := eval(node.Rhs)
+		if !ok || (llabel != "" && rlabel != "") {
+			return 0, "", false
+		}
+		return truncate(l+r, node.Ty), llabel + rlabel, true
+	case SUB:
+		l, llabel, ok := eval(node.Lhs)
+		if !ok {
+			return 0, "", false
+		}
+		r, rlabel, ok := eval(node.Rhs)
+		if !ok || rlabel != "" {
+			return 0, "", false
+		}
+		return truncate(l-r, node.Ty), llabel, true
+	case ADDR:
+		return evalAddr(node.Lhs)
+	case CAST:
+		from := node.Lhs.Ty
+		if from.IsFloat() {
+			f, ok := evalFloat(node.Lhs)
+			if !ok {
+				return 0, "", false
+			}
+			if node.Ty.Kind == TY_BOOL {
+				return boolToInt(f != 0), "", true
+			}
+			if node.Ty.Unsigned && node.Ty.Size == 8 {
+				return int(uint64(f)), "", true
+			}
+			return truncate(int(f), node.Ty), "", true
+		}
+
+		v, label, ok := eval(node.Lhs)
+		if !ok {
+			return 0, "", false
+		}
+		if label != "" {
+			// addresses only fit in 64-bit types
+			if node.Ty.Size != 8 {
+				return 0, "", false
+			}
+			return v, label, true
+		}
+		if node.Ty.Kind == TY_BOOL {
+			return boolToInt(v != 0), "", true
+		}
+		return truncate(v, node.Ty), "", true
+	}
+
+	// the remaining operators do not apply to addresses
+	if node.Lhs == nil || node.Rhs == nil {
+		return 0, "", false
+	}
+	if node.Lhs.Ty.IsFloat() {
+		l, lok := evalFloat(node.Lhs)
+		r, rok := evalFloat(node.Rhs)
+		if !lok || !rok {
+			return 0, "", false
+		}
+		switch node.Kind {
+		case EQ:
+			return boolToInt(l == r), "", true
+		case NEQ:
+			return boolToInt(l != r), "", true
+		case LT:
+			return boolToInt(l < r), "", true
+		case LTE:
+			return boolToInt(l <= r), "", true
+		}
+		return 0, "", false
+	}
+
+	l, llabel, lok := eval(node.Lhs)
+	r, rlabel, rok := eval(node.Rhs)
+	if !lok || !rok || llabel != "" || rlabel != "" {
+		return 0, "", false
+	}
+	unsigned := node.Lhs.Ty.Unsigned
+	switch node.Kind {
+	case MUL:
+		return truncate(l*r, node.Ty), "", true
+	case SHL:
+		return truncate(l<<(uint(r)&63), node.Ty), "", true
+	case DIV:
+		if r == 0 {
+			return 0, "", false
+		}
+		if unsigned {
+			return truncate(int(uint64(l)/uint64(r)), node.Ty), "", true
+		}
+		return truncate(l/r, node.Ty), "", true
+	case EQ:
+		return boolToInt(l == r), "", true
+	case NEQ:
+		return boolToInt(l != r), "", true
+	case LT:
+		if unsigned {
+			return boolToInt(uint64(l) < uint64(r)), "", true
+		}
+		return boolToInt(l < r), "", true
+	case LTE:
+		if unsigned {
+			return boolToInt(uint64(l) <= uint64(r)), "", true
+		}
+		return boolToInt(l <= r), "", true
+	}
+	return 0, "", false
+}
+
+// evalAddr evaluates the address of the object designated by node.
+func evalAddr(node *Node) (val int, label string, ok bool) {
+	switch node.Kind {
+	case GVAR:
+		return node.Offset, node.Label, true
+	case DEREF:
+		return eval(node.Lhs)
+	case MEMBER:
+		v, label, ok := evalAddr(node.Lhs)
+		if !ok {
+			return 0, "", false
+		}
+		return v + node.Member.Offset, label, true
+	}
+	return 0, "", false
+}
+
+// evalFloat evaluates a constant expression of floating type.
+func evalFloat(node *Node) (float64, bool) {
+	AddType(node)
+
+	switch node.Kind {
+	case NUM:
+		if node.Ty.Kind == TY_FLOAT {
+			return float64(float32(node.FVal)), true
+		}
+		return node.FVal, true
+	case CAST:
+		var f float64
+		if node.Lhs.Ty.IsFloat() {
+			v, ok := evalFloat(node.Lhs)
+			if !ok {
+				return 0, false
+			}
+			f = v
+		} else {
+			v, label, ok := eval(node.Lhs)
+			if !ok || label != "" {
+				return 0, false
+			}
+			if node.Lhs.Ty.Unsigned && node.Lhs.Ty.Size == 8 {
+				f = float64(uint64(v))
+			} else {
+				f = float64(v)
+			}
+		}
+		if node.Ty.Kind == TY_FLOAT {
+			f = float64(float32(f))
+		}
+		return f, true
+	case ADD, SUB, MUL, DIV:
+		l, lok := evalFloat(node.Lhs)
+		r, rok := evalFloat(node.Rhs)
+		if !lok || !rok {
+			return 0, false
+		}
+		var f float64
+		switch node.Kind {
+		case ADD:
+			f = l + r
+		case SUB:
+			f = l - r
+		case MUL:
+			f = l * r
+		case DIV:
+			f = l / r
+		}
+		if node.Ty.Kind == TY_FLOAT {
+			f = float64(float32(f))
+		}
+		return f, true
+	}
+	return 0, false
+}
+
+// truncate converts an integer value to ty, the way the generator's extend
+// does at run time.
+func truncate(v int, ty *Type) int {
+	switch {
+	case ty.Size == 1 && ty.Unsigned:
+		return int(uint8(v))
+	case ty.Size == 1:
+		return int(int8(v))
+	case ty.Size == 2 && ty.Unsigned:
+		return int(uint16(v))
+	case ty.Size == 2:
+		return int(int16(v))
+	case ty.Size == 4 && ty.Unsigned:
+		return int(uint32(v))
+	case ty.Size == 4:
+		return int(int32(v))
+	}
+	return v
+}
+
+func boolToInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
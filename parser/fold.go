@@ -0,0 +1,138 @@
+package parser
+
+import "math/bits"
+
+// Fold simplifies the bodies of the parsed function definitions, which must
+// have been typed. Constant subexpressions are evaluated with the wrapping
+// arithmetic of the generated code, if statements with a constant
+// condition are replaced by the branch taken, and additions of 0,
+// multiplications and divisions by 1, and multiplications by powers of two
+// are simplified. A division by a constant zero is left to trap at run
+// time; the parser has already warned about it.
+func (p *Parser) Fold() {
+	f := &folder{}
+	for _, fn := range p.Funcs {
+		if fn.IsDefinition {
+			f.fold(fn.Body)
+		}
+	}
+}
+
+type folder struct{}
+
+// fold simplifies node and its children in place.
+func (f *folder) fold(node *Node) {
+	if node == nil {
+		return
+	}
+	f.fold(node.Lhs)
+	f.fold(node.Rhs)
+	f.fold(node.Cond)
+	f.fold(node.Then)
+	f.fold(node.Else)
+	f.fold(node.Init)
+	f.fold(node.Inc)
+	for _, n := range node.Body {
+		f.fold(n)
+	}
+	for _, n := range node.Args {
+		f.fold(n)
+	}
+	if node.Asm != nil {
+		for _, op := range append(append([]*AsmOperand{}, node.Asm.Outputs...), node.Asm.Inputs...) {
+			f.fold(op.Expr)
+		}
+	}
+
+	switch node.Kind {
+	case IF:
+		if cond, ok := constTruth(node.Cond); ok {
+			taken := node.Else
+			if cond {
+				taken = node.Then
+			}
+			if taken == nil {
+				taken = &Node{Kind: BLOCK}
+			}
+			*node = *taken
+		}
+		return
+	case ADD, SUB, MUL, DIV, EQ, NEQ, LT, LTE, CAST:
+	default:
+		return
+	}
+
+	switch {
+	case node.Kind == DIV && node.Ty.IsInteger() && isConstInt(node.Rhs, 0):
+		// left to trap at run time
+	case node.Ty.IsFloat():
+		if v, ok := evalFloat(node); ok {
+			*node = Node{Kind: NUM, FVal: v, Ty: node.Ty}
+		}
+	case node.Ty.IsInteger() || node.Ty.IsPointer():
+		if v, label, ok := eval(node); ok && label == "" {
+			*node = Node{Kind: NUM, Val: v, Ty: node.Ty}
+			return
+		}
+		simplify(node)
+	}
+}
+
+// simplify applies the arithmetic identities to node, whose operands are
+// not both constant. Since the operands of arithmetic have been converted
+// to a common type, an operand has the type of the result unless it is
+// the integer added to a pointer.
+func simplify(node *Node) {
+	lhs, rhs := node.Lhs, node.Rhs
+	switch node.Kind {
+	case ADD:
+		switch {
+		case isConstInt(rhs, 0):
+			*node = *lhs
+		case isConstInt(lhs, 0) && rhs.Ty == node.Ty:
+			*node = *rhs
+		}
+	case SUB:
+		if isConstInt(rhs, 0) && lhs.Ty == node.Ty {
+			*node = *lhs
+		}
+	case MUL:
+		if isIntLit(lhs) {
+			lhs, rhs = rhs, lhs
+		}
+		if !isIntLit(rhs) || rhs.Val <= 0 || rhs.Val&(rhs.Val-1) != 0 {
+			return
+		}
+		if rhs.Val == 1 {
+			*node = *lhs
+			return
+		}
+		shift := &Node{Kind: NUM, Val: bits.TrailingZeros64(uint64(rhs.Val)), Ty: TyInt}
+		*node = Node{Kind: SHL, Lhs: lhs, Rhs: shift, Ty: node.Ty}
+	case DIV:
+		if isConstInt(rhs, 1) {
+			*node = *lhs
+		}
+	}
+}
+
+// isIntLit reports whether node is an integer literal.
+func isIntLit(node *Node) bool {
+	return node.Kind == NUM && node.Ty.IsInteger()
+}
+
+// isConstInt reports whether node is the integer constant val.
+func isConstInt(node *Node, val int) bool {
+	return isIntLit(node) && node.Val == val
+}
+
+// constTruth returns whether the condition node holds, if it is constant.
+func constTruth(node *Node) (bool, bool) {
+	if node.Kind != NUM {
+		return false, false
+	}
+	if node.Ty.IsFloat() {
+		return node.FVal != 0, true
+	}
+	return node.Val != 0, true
+}
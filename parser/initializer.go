@@ -0,0 +1,431 @@
+package parser
+
+import (
+	"fmt"
+
+	"rkitamu/gocc/errors"
+	"rkitamu/gocc/lexer"
+)
+
+// Initializer is a parsed variable initializer. Aggregates have one child
+// per array element or struct member, and scalars have an expression.
+// Children without an expression are zero-initialized.
+type Initializer struct {
+	Ty       *Type
+	Children []*Initializer
+	Expr     *Node // value of a scalar, or of a whole struct copied from another
+
+	// an array of unknown length, which is given by the initializer
+	IsFlexible bool
+}
+
+func newInitializer(ty *Type, flexible bool) *Initializer {
+	init := &Initializer{Ty: ty}
+	switch ty.Kind {
+	case TY_ARRAY:
+		if flexible && ty.Size < 0 {
+			init.IsFlexible = true
+			return init
+		}
+		for i := 0; i < ty.ArrayLen; i++ {
+			init.Children = append(init.Children, newInitializer(ty.Base, false))
+		}
+	case TY_STRUCT:
+		for _, m := range ty.Members {
+			init.Children = append(init.Children, newInitializer(m.Ty, false))
+		}
+	}
+	return init
+}
+
+// initializer parses the initializer of a variable of type ty. If ty is an
+// array of unknown length, the returned type has the length filled in.
+func (p *Parser) initializer(ty *Type) (*Initializer, *Type, error) {
+	init := newInitializer(ty, true)
+	if ty.Kind == TY_STRUCT && !p.match("{") {
+		// braces may only be omitted for nested structs
+		start := p.current
+		expr, err := p.assign()
+		if err != nil {
+			return nil, nil, err
+		}
+		if err := p.checkAssignable(ty, expr, start); err != nil {
+			return nil, nil, err
+		}
+		init.Expr = expr
+		return init, ty, nil
+	}
+	if err := p.initializer2(init); err != nil {
+		return nil, nil, err
+	}
+	return init, init.Ty, nil
+}
+
+// initializer = string-initializer | array-initializer | struct-initializer
+//
+//	| "{" initializer "}"
+//	| assign
+//
+// Inner braces may be omitted, in which case an aggregate takes as many
+// of the following initializers as it has elements or members.
+func (p *Parser) initializer2(init *Initializer) error {
+	switch init.Ty.Kind {
+	case TY_ARRAY:
+		if init.Ty.Base.Kind == TY_CHAR && p.current.Kind == lexer.STR {
+			return p.stringInitializer(init)
+		}
+		if p.match("{") {
+			return p.arrayInitializer1(init)
+		}
+		if init.IsFlexible {
+			return errors.NewPosError("array initializer must be an initializer list", p.input, p.current.Pos)
+		}
+		return p.arrayInitializer2(init, 0)
+	case TY_STRUCT:
+		if p.match("{") {
+			return p.structInitializer1(init)
+		}
+
+		// a struct can also be initialized by another struct
+		start := p.current
+		expr, err := p.assign()
+		if err != nil {
+			return err
+		}
+		AddType(expr)
+		if expr.Ty.Kind == TY_STRUCT {
+			if err := p.checkAssignable(init.Ty, expr, start); err != nil {
+				return err
+			}
+			init.Expr = expr
+			return nil
+		}
+		p.current = start
+		return p.structInitializer2(init, 0)
+	}
+
+	if p.match("{") {
+		// braces around a scalar
+		p.advance()
+		if err := p.initializer2(init); err != nil {
+			return err
+		}
+		if !p.consumeEnd() {
+			return errors.NewPosError("excess elements in scalar initializer", p.input, p.current.Pos)
+		}
+		return nil
+	}
+
+	start := p.current
+	expr, err := p.assign()
+	if err != nil {
+		return err
+	}
+	if err := p.checkAssignable(init.Ty, expr, start); err != nil {
+		return err
+	}
+	init.Expr = expr
+	return nil
+}
+
+// string-initializer = string-literal+
+func (p *Parser) stringInitializer(init *Initializer) error {
+	data := append([]byte(p.stringLiteral()), 0)
+	if init.IsFlexible {
+		*init = *newInitializer(arrayOf(init.Ty.Base, len(data)), false)
+	}
+
+	// the terminating NUL is dropped if the array is exactly as long as
+	// the string without it
+	for i := 0; i < init.Ty.ArrayLen && i < len(data); i++ {
+		init.Children[i].Expr = &Node{Kind: NUM, Val: int(data[i]), Ty: TyInt}
+	}
+	return nil
+}
+
+// array-initializer = "{" (designation | initializer) ("," (designation | initializer))* ","? "}"
+func (p *Parser) arrayInitializer1(init *Initializer) error {
+	p.advance()
+	if init.IsFlexible {
+		n, err := p.countArrayInitElements(init.Ty)
+		if err != nil {
+			return err
+		}
+		*init = *newInitializer(arrayOf(init.Ty.Base, n), false)
+	}
+
+	for i := 0; !p.consumeEnd(); i++ {
+		if i > 0 {
+			if err := p.expect(","); err != nil {
+				return err
+			}
+		}
+
+		if p.match("[") {
+			idx, err := p.arrayDesignator(init.Ty)
+			if err != nil {
+				return err
+			}
+			if err := p.designation(init.Children[idx]); err != nil {
+				return err
+			}
+			// the following initializers continue after the designated element
+			i = idx
+			continue
+		}
+
+		if i >= init.Ty.ArrayLen {
+			return errors.NewPosError("excess elements in array initializer", p.input, p.current.Pos)
+		}
+		if err := p.initializer2(init.Children[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// arrayInitializer2 initializes the elements of an array from index i on,
+// without enclosing braces. It stops at a designator, which belongs to the
+// enclosing initializer list.
+func (p *Parser) arrayInitializer2(init *Initializer, i int) error {
+	for ; i < init.Ty.ArrayLen && !p.isEnd(); i++ {
+		start := p.current
+		if i > 0 {
+			if err := p.expect(","); err != nil {
+				return err
+			}
+		}
+		if p.match("[") || p.match(".") {
+			p.current = start
+			return nil
+		}
+		if err := p.initializer2(init.Children[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// struct-initializer = "{" (designation | initializer) ("," (designation | initializer))* ","? "}"
+func (p *Parser) structInitializer1(init *Initializer) error {
+	p.advance()
+
+	i := 0
+	for first := true; !p.consumeEnd(); first = false {
+		if !first {
+			if err := p.expect(","); err != nil {
+				return err
+			}
+		}
+
+		if p.match(".") {
+			idx, err := p.structDesignator(init.Ty)
+			if err != nil {
+				return err
+			}
+			if err := p.designation(init.Children[idx]); err != nil {
+				return err
+			}
+			i = idx + 1
+			continue
+		}
+
+		if i >= len(init.Children) {
+			return errors.NewPosError("excess elements in struct initializer", p.input, p.current.Pos)
+		}
+		if err := p.initializer2(init.Children[i]); err != nil {
+			return err
+		}
+		i++
+	}
+	return nil
+}
+
+// structInitializer2 initializes the members of a struct from the i-th on,
+// without enclosing braces, like arrayInitializer2.
+func (p *Parser) structInitializer2(init *Initializer, i int) error {
+	for ; i < len(init.Children) && !p.isEnd(); i++ {
+		start := p.current
+		if i > 0 {
+			if err := p.expect(","); err != nil {
+				return err
+			}
+		}
+		if p.match("[") || p.match(".") {
+			p.current = start
+			return nil
+		}
+		if err := p.initializer2(init.Children[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// designation = ("[" const-expr "]" | "." ident)* "=" initializer
+//
+// The designators after the first select a subobject of init. Initializers
+// without a designator that follow continue with the next subobject.
+func (p *Parser) designation(init *Initializer) error {
+	if p.match("[") {
+		if init.Ty.Kind != TY_ARRAY {
+			return errors.NewPosError("array index in non-array initializer", p.input, p.current.Pos)
+		}
+		idx, err := p.arrayDesignator(init.Ty)
+		if err != nil {
+			return err
+		}
+		if err := p.designation(init.Children[idx]); err != nil {
+			return err
+		}
+		return p.arrayInitializer2(init, idx+1)
+	}
+
+	if p.match(".") {
+		if init.Ty.Kind != TY_STRUCT {
+			return errors.NewPosError("field name not in struct initializer", p.input, p.current.Pos)
+		}
+		idx, err := p.structDesignator(init.Ty)
+		if err != nil {
+			return err
+		}
+		if err := p.designation(init.Children[idx]); err != nil {
+			return err
+		}
+		// members are initialized one by one instead of as a whole
+		init.Expr = nil
+		return p.structInitializer2(init, idx+1)
+	}
+
+	if err := p.expect("="); err != nil {
+		return err
+	}
+	return p.initializer2(init)
+}
+
+// arrayDesignator parses "[" const-expr "]" and returns the index.
+func (p *Parser) arrayDesignator(ty *Type) (int, error) {
+	p.advance()
+	start := p.current
+	idx, err := p.constExpr()
+	if err != nil {
+		return 0, err
+	}
+	if idx < 0 || (ty.ArrayLen >= 0 && idx >= ty.ArrayLen) {
+		return 0, errors.NewPosError("array designator index exceeds array bounds", p.input, start.Pos)
+	}
+	if err := p.expect("]"); err != nil {
+		return 0, err
+	}
+	return idx, nil
+}
+
+// structDesignator parses "." ident and returns the index of the member.
+func (p *Parser) structDesignator(ty *Type) (int, error) {
+	p.advance()
+	if p.current.Kind != lexer.IDENT {
+		return 0, errors.NewPosError(
+			fmt.Sprintf("expected member name, but got %s", p.current.Str),
+			p.input,
+			p.current.Pos,
+		)
+	}
+	for i, m := range ty.Members {
+		if m.Name == p.current.Str {
+			p.advance()
+			return i, nil
+		}
+	}
+	return 0, errors.NewPosError(
+		fmt.Sprintf("struct has no member named %s", p.current.Str),
+		p.input,
+		p.current.Pos,
+	)
+}
+
+// countArrayInitElements returns the length of an array of unknown length
+// by looking ahead at its initializer list, which has been opened already.
+func (p *Parser) countArrayInitElements(ty *Type) (int, error) {
+	start := p.current
+	defer func() { p.current = start }()
+
+	dummy := newInitializer(ty.Base, false)
+	i, max := 0, 0
+	for first := true; !p.consumeEnd(); first = false {
+		if !first {
+			if err := p.expect(","); err != nil {
+				return 0, err
+			}
+		}
+
+		if p.match("[") {
+			idx, err := p.arrayDesignator(ty)
+			if err != nil {
+				return 0, err
+			}
+			i = idx
+			if err := p.designation(dummy); err != nil {
+				return 0, err
+			}
+		} else if err := p.initializer2(dummy); err != nil {
+			return 0, err
+		}
+
+		i++
+		if i > max {
+			max = i
+		}
+	}
+	return max, nil
+}
+
+// isEnd reports whether the tokens ahead close an initializer list.
+func (p *Parser) isEnd() bool {
+	return p.match("}") || (p.match(",") && p.current.Next != nil && p.current.Next.Str == "}")
+}
+
+// consumeEnd skips the "}" or ", }" closing an initializer list.
+func (p *Parser) consumeEnd() bool {
+	if !p.isEnd() {
+		return false
+	}
+	if p.match(",") {
+		p.advance()
+	}
+	p.advance()
+	return true
+}
+
+// initAssigns lowers init, for an object at offset within a variable, into
+// assignments. lval returns the subobject at an offset of the variable.
+// Subobjects without an initializer get no assignment.
+func initAssigns(init *Initializer, offset int, lval func(offset int, ty *Type) *Node) []*Node {
+	if init.Expr != nil {
+		node := &Node{Kind: ASSIGN, Lhs: lval(offset, init.Ty), Rhs: init.Expr, IsInit: true}
+		AddType(node)
+		return []*Node{node}
+	}
+
+	var nodes []*Node
+	for i, child := range init.Children {
+		if init.Ty.Kind == TY_ARRAY {
+			nodes = append(nodes, initAssigns(child, offset+i*init.Ty.Base.Size, lval)...)
+			continue
+		}
+		m := init.Ty.Members[i]
+		if m.IsBitfield && child.Expr != nil {
+			// the other bits of the storage unit must be kept
+			node := &Node{Kind: ASSIGN, Lhs: bitfieldRef(lval(offset, init.Ty), m), Rhs: child.Expr, IsInit: true}
+			AddType(node)
+			nodes = append(nodes, node)
+			continue
+		}
+		nodes = append(nodes, initAssigns(child, offset+m.Offset, lval)...)
+	}
+	return nodes
+}
+
+// bitfieldRef returns the access to the bit-field m of the struct node.
+func bitfieldRef(node *Node, m *Member) *Node {
+	return &Node{Kind: MEMBER, Lhs: node, Member: m, Ty: m.Ty}
+}
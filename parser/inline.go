@@ -0,0 +1,94 @@
+package parser
+
+import (
+	"fmt"
+	"rkitamu/gocc/errors"
+	"rkitamu/gocc/lexer"
+)
+
+// function-specifier = "inline" | attributes
+// attributes = "__attribute__" "(" "(" attribute? ("," attribute?)* ")" ")"
+// attribute = ident ("(" balanced-tokens ")")?
+//
+// The inline keyword and the always_inline and noinline attributes set how
+// the function asks to be inlined. An attribute overrides the keyword, and
+// other attributes are ignored with a warning.
+func (p *Parser) funcSpecifier(attr *VarAttr) error {
+	if attr == nil {
+		return errors.NewPosError("function specifier is not allowed in this context", p.input, p.current.Pos)
+	}
+	if p.current.Kind == lexer.INLINE {
+		p.advance()
+		attr.IsInline = true
+		if attr.Inline == INLINE_DEFAULT {
+			attr.Inline = INLINE_HINT
+		}
+		return nil
+	}
+
+	p.advance()
+	if err := p.expect("("); err != nil {
+		return err
+	}
+	if err := p.expect("("); err != nil {
+		return err
+	}
+	for i := 0; !p.match(")"); i++ {
+		if i > 0 {
+			if err := p.expect(","); err != nil {
+				return err
+			}
+		}
+		if p.match(",") || p.match(")") {
+			continue
+		}
+		tok := p.current
+		if tok.Kind != lexer.IDENT {
+			return errors.NewPosError("expected attribute name", p.input, tok.Pos)
+		}
+		p.advance()
+		if p.match("(") {
+			if err := p.skipParens(); err != nil {
+				return err
+			}
+		}
+		switch tok.Str {
+		case "always_inline", "__always_inline__":
+			attr.Inline = INLINE_ALWAYS
+		case "noinline", "__noinline__":
+			attr.Inline = INLINE_NEVER
+		default:
+			p.warn(fmt.Sprintf("%s attribute ignored", tok.Str), tok)
+		}
+	}
+	p.advance()
+	return p.expect(")")
+}
+
+// skipParens skips the tokens from "(" to the matching ")".
+func (p *Parser) skipParens() error {
+	start := p.current
+	depth := 0
+	for ; p.current != nil && p.current.Kind != lexer.EOF; p.advance() {
+		switch {
+		case p.match("("):
+			depth++
+		case p.match(")"):
+			depth--
+		}
+		if depth == 0 {
+			p.advance()
+			return nil
+		}
+	}
+	return errors.NewPosError("unbalanced parentheses in attribute", p.input, start.Pos)
+}
+
+// declInline checks that only a function declared name of type ty asks to
+// be inlined in attr.
+func (p *Parser) declInline(name *lexer.Token, ty *Type, attr *VarAttr) error {
+	if attr.Inline != INLINE_DEFAULT && ty.Kind != TY_FUNC {
+		return errors.NewPosError(fmt.Sprintf("variable %s declared inline", name.Str), p.input, name.Pos)
+	}
+	return nil
+}
@@ -9,8 +9,30 @@ import (
 type Parser struct {
 	current *lexer.Token
 	Code    []*Node
-	locals  *LVar
+	Funcs   []*Function
+	Globals []*Global
+	locals  *LVar            // locals of the current function, for its frame
+	scope   *scope           // innermost block scope, for name lookup
+	tags    map[string]*Type // struct tags
+	curFunc *Function        // function being parsed, nil for top-level statements
+	symSeq  int              // counter to give static locals and string literals unique symbols
+	dynInit *lexer.Token     // first file-scope initializer that is not constant
 	input   string
+
+	// Arch is the target, which the parser must be given before parsing.
+	Arch Arch
+
+	// Warnings holds the diagnostics that do not stop compilation.
+	Warnings []*errors.PosError
+}
+
+// VarAttr holds the storage class specifiers of a declaration.
+type VarAttr struct {
+	IsStatic bool
+	IsExtern bool
+	IsInline bool       // declared with the inline keyword
+	Align    int        // alignment requested by _Alignas, 0 if none
+	Inline   InlineKind // requested by inline or an attribute, functions only
 }
 
 func NewParser(token *lexer.Token, input string) *Parser {
@@ -18,60 +40,425 @@ func NewParser(token *lexer.Token, input string) *Parser {
 		current: token,
 		Code:    make([]*Node, 0),
 		locals:  nil,
+		scope:   newScope(nil), // the top-level statements of the implicit main
+		tags:    map[string]*Type{},
 		input:   input,
 	}
 }
 
+// scope holds the local variables declared in a block. A variable hides
+// those of the same name in the enclosing scopes until the block ends.
+type scope struct {
+	vars map[string]*LVar
+	up   *scope
+	sp   *LVar // the stack pointer on entry, if the scope has variable length arrays
+}
+
+func newScope(up *scope) *scope {
+	return &scope{vars: map[string]*LVar{}, up: up}
+}
+
+// scoped parses a construct with parse in a scope of its own. The variable
+// length arrays declared in the scope are freed when it ends, so that a
+// loop declaring one does not use up the stack.
+func (p *Parser) scoped(parse func() (*Node, error)) (*Node, error) {
+	s := newScope(p.scope)
+	p.scope = s
+	node, err := parse()
+	p.scope = s.up
+	if err != nil || s.sp == nil {
+		return node, err
+	}
+	return &Node{Kind: BLOCK, Body: []*Node{
+		{Kind: VLA_SAVE, Var: s.sp},
+		node,
+		{Kind: VLA_FREE, Var: s.sp},
+	}}, nil
+}
+
 // Parse parses the input tokens and returns the root node of the parse tree.
 // supports the following grammar:
-// program = stmt*
+// program = (static-assert | declspec (function | global-declaration) | stmt)*
+// function = declarator "{" compound-stmt
+// compound-stmt = stmt* "}"
 // stmt = expr ";"
-//	| "return" expr ";"
+//	| static-assert
+//	| declaration
+//	| asm-stmt
+//	| "{" compound-stmt
+//	| "return" expr? ";"
 //	| "if" "{" expr "}" stmt ("else" stmt)?
 //	| "while" "(" expr ")" stmt
-//	| "for" "(" expr ";" expr ";" expr ")" stmt
+//	| "for" "(" (declaration | expr? ";") expr? ";" expr? ")" stmt
 //
+// declaration = declspec (declarator ("=" initializer)? ("," declarator ("=" initializer)?)*)? ";"
+// global-declaration = (declarator ("=" initializer)? ("," declarator ("=" initializer)?)*)? ";"
+// declspec = ("void" | "char" | "short" | "int" | "long" | "_Bool" | "float" | "double" | "signed" | "unsigned" | "static" | "extern" | "va_list" | qualifier | alignas | function-specifier | struct-decl)+
+// function-specifier = see inline.go
+// struct-decl = "struct" ident? ("{" (static-assert | declspec member-declarator ("," member-declarator)* ";")* "}")?
+// member-declarator = declarator (":" const-expr)? | ":" const-expr
+// qualifier = "const" | "volatile" | "restrict"
+// declarator = ("*" qualifier*)* ("(" declarator ")" | ident?) type-suffix
+// type-suffix = "(" params ")" | ("[" const-expr? "]")*
+// params = "void" | param ("," param)* ("," "...")?
+// param = declspec declarator
+// type-name = declspec declarator
+// static-assert = "_Static_assert" "(" const-expr "," str ")" ";"
+// alignas = "_Alignas" "(" (type-name | const-expr) ")"
+// initializer = see initializer.go
+// asm-stmt = see asm.go
 // expr = assign
+// const-expr = assign
 // assign = equality ("=" assign)?
 // equality = relational ("==" relational | "!=" relational)*
 // relational = add ("<" add | "<=" add | ">" add | ">=" add)*
 // add = mul ("+" mul | "-" mul)*
-// mul = unary ("*" unary | "/" unary)*
-// unary = ("+" | "-")? unary | primary
-// primary = num | ident | "(" expr ")"
+// mul = cast ("*" cast | "/" cast)*
+// cast = "(" type-name ")" (cast | compound-literal) | unary
+// compound-literal = initializer ("[" expr "]" | "." ident | "->" ident | "(" args ")")*
+// sizeof = "sizeof" ("(" type-name ")" | unary)
+// unary = ("+" | "-" | "*" | "&") cast | sizeof | "_Alignof" "(" type-name ")" | postfix
+// postfix = primary ("[" expr "]" | "." ident | "->" ident | "(" args ")")*
+// primary = num | str+ | ident | funcall | stdarg | generic | "(" expr ")"
+// funcall = ident "(" args ")"
+// args = (assign ("," assign)*)?
+// generic = "_Generic" "(" assign ("," generic-assoc)+ ")"
 func (p *Parser) Parse() error {
 	return p.program()
 }
 
-// program = stmt*
+// program = (static-assert | declspec (function | global-declaration) | stmt)*
+//
+// Statements outside of any function make up the body of an implicit main.
+// File-scope initializers that are not constant expressions are evaluated
+// by the implicit main in the same order.
 func (p *Parser) program() error {
 	for !p.atEnd() {
+		if p.current.Kind == lexer.STATIC_ASSERT {
+			if err := p.staticAssert(); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if p.isTypename() {
+			attr := &VarAttr{}
+			basety, err := p.declspec(attr)
+			if err != nil {
+				return err
+			}
+			if p.isFunction(basety) {
+				err = p.function(basety, attr)
+			} else {
+				err = p.globalDeclaration(basety, attr)
+			}
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
 		node, err := p.stmt()
 		if err != nil {
 			return err
 		}
+		AddType(node)
 		p.Code = append(p.Code, node)
 	}
+
+	if len(p.Code) == 0 {
+		return nil
+	}
+	if fn := p.findFunc("main"); fn != nil && fn.IsDefinition {
+		if p.dynInit != nil {
+			return errors.NewPosError("initializer element is not constant", p.input, p.dynInit.Pos)
+		}
+		return errors.NewPosError(
+			"redefinition of main, which is implicitly defined by top-level statements",
+			p.input,
+			fn.Pos,
+		)
+	}
+	p.Funcs = append(p.Funcs, &Function{
+		Name:         "main",
+		Ty:           funcType(TyInt, nil, true),
+		Locals:       p.locals,
+		Body:         &Node{Kind: BLOCK, Body: p.Code},
+		IsDefinition: true,
+	})
+	return nil
+}
+
+// function = declarator "{" compound-stmt
+func (p *Parser) function(basety *Type, attr *VarAttr) error {
+	ty, name, err := p.declaratorName(basety)
+	if err != nil {
+		return err
+	}
+	if _, err := p.declAlign(name, ty, attr); err != nil {
+		return err
+	}
+	fn, err := p.declareFunc(name, ty, attr, true)
+	if err != nil {
+		return err
+	}
+	if err := p.expect("{"); err != nil {
+		return err
+	}
+
+	// parameters are the first locals of the function, and share the scope
+	// of its body. The locals of the implicit main are not visible.
+	outerLocals, outerScope := p.locals, p.scope
+	p.locals, p.scope = nil, newScope(nil)
+	defer func() { p.locals, p.scope = outerLocals, outerScope }()
+
+	for i, pty := range ty.Params {
+		name := ""
+		if ty.ParamNames[i] != nil {
+			name = ty.ParamNames[i].Str
+		}
+		fn.Params = append(fn.Params, p.newLVar(name, pty))
+	}
+	if fn.Ty.IsVariadic {
+		// the registers that may hold variadic arguments are saved
+		// after the va_list header, see the generator
+		fn.VaArea = p.newLVar("", arrayOf(TyLong, p.Arch.vaAreaSize()))
+	}
+
+	p.curFunc = fn
+	body, err := p.compoundStmt()
+	p.curFunc = nil
+	if err != nil {
+		return err
+	}
+	AddType(body)
+
+	fn.Body = body
+	fn.Locals = p.locals
 	return nil
 }
 
+// declareFunc declares a function, or merges the declaration with an
+// earlier one of the same name. A definition replaces the earlier
+// declaration, and is registered before its body so that it can call
+// itself.
+//
+// A function with external linkage declared inline, and not extern, in
+// every declaration at file scope has only an inline definition, which
+// another file defines externally.
+func (p *Parser) declareFunc(name *lexer.Token, ty *Type, attr *VarAttr, isDefinition bool) (*Function, error) {
+	if p.findGlobal(name.Str) != nil {
+		return nil, errors.NewPosError(fmt.Sprintf("%s redeclared as different kind of symbol", name.Str), p.input, name.Pos)
+	}
+	prev := p.findFunc(name.Str)
+	if prev != nil && !isCompatible(prev.Ty, ty) {
+		return nil, errors.NewPosError(fmt.Sprintf("conflicting types for %s", name.Str), p.input, name.Pos)
+	}
+	if attr.IsStatic && prev != nil && !prev.IsStatic {
+		return nil, errors.NewPosError(
+			fmt.Sprintf("static declaration of %s follows non-static declaration", name.Str),
+			p.input,
+			name.Pos,
+		)
+	}
+	if isDefinition && prev != nil && prev.IsDefinition {
+		return nil, errors.NewPosError(fmt.Sprintf("redefinition of %s", name.Str), p.input, name.Pos)
+	}
+
+	fn := &Function{
+		Name:         name.Str,
+		Ty:           ty,
+		IsDefinition: isDefinition,
+		// a later declaration without static keeps the internal linkage
+		IsStatic: attr.IsStatic || (prev != nil && prev.IsStatic),
+		Inline:   attr.Inline,
+		Pos:      name.Pos,
+	}
+	if fn.Inline == INLINE_DEFAULT && prev != nil {
+		fn.Inline = prev.Inline
+	}
+	external := p.curFunc == nil && (!attr.IsInline || attr.IsExtern)
+	fn.IsInlineDef = !fn.IsStatic && !external && (prev == nil || prev.IsInlineDef)
+	switch {
+	case prev == nil:
+		p.Funcs = append(p.Funcs, fn)
+	case isDefinition:
+		for i, f := range p.Funcs {
+			if f == prev {
+				p.Funcs[i] = fn
+			}
+		}
+	default:
+		prev.IsInlineDef = fn.IsInlineDef
+		return prev, nil
+	}
+	return fn, nil
+}
+
+// params = "void" | param ("," param)* ("," "...")?
+// param = declspec declarator
+//
+// It returns the type of the function returning retTy. An empty list
+// declares a function without a prototype.
+func (p *Parser) params(retTy *Type) (*Type, error) {
+	if p.match(")") {
+		p.advance()
+		return funcType(retTy, nil, false), nil
+	}
+	if p.match("void") && p.current.Next != nil && p.current.Next.Str == ")" {
+		p.advance()
+		p.advance()
+		return funcType(retTy, nil, true), nil
+	}
+	if p.match("...") {
+		return nil, errors.NewPosError("a named parameter is required before ...", p.input, p.current.Pos)
+	}
+
+	var tys []*Type
+	var names []*lexer.Token
+	variadic := false
+	for !p.match(")") {
+		if len(tys) > 0 {
+			if err := p.expect(","); err != nil {
+				return nil, err
+			}
+		}
+		if p.match("...") {
+			p.advance()
+			variadic = true
+			if !p.match(")") {
+				return nil, p.expect(")")
+			}
+			break
+		}
+
+		start := p.current
+		basety, err := p.declspec(nil)
+		if err != nil {
+			return nil, err
+		}
+		if basety == nil {
+			return nil, errors.NewPosError("invalid parameter type", p.input, start.Pos)
+		}
+		ty, name, err := p.declarator(basety)
+		if err != nil {
+			return nil, err
+		}
+		switch ty.Kind {
+		case TY_ARRAY, TY_VLA:
+			// array parameters are pointers to the first element
+			ty = pointerTo(ty.Base)
+		case TY_FUNC:
+			// and function parameters are pointers to functions
+			ty = pointerTo(ty)
+		}
+		if ty.Kind == TY_VOID || ty.Kind == TY_STRUCT {
+			return nil, errors.NewPosError("invalid parameter type", p.input, start.Pos)
+		}
+		if isVM(ty) {
+			return nil, errors.NewPosError("variably modified parameter types are not supported", p.input, start.Pos)
+		}
+
+		if name != nil {
+			for _, prev := range names {
+				if prev != nil && prev.Str == name.Str {
+					return nil, errors.NewPosError(
+						fmt.Sprintf("redefinition of parameter %s", name.Str),
+						p.input,
+						name.Pos,
+					)
+				}
+			}
+		}
+		tys = append(tys, ty)
+		names = append(names, name)
+	}
+	p.advance()
+
+	ty := funcType(retTy, tys, true)
+	ty.ParamNames = names
+	ty.IsVariadic = variadic
+	return ty, nil
+}
+
+// compound-stmt = stmt* "}"
+func (p *Parser) compoundStmt() (*Node, error) {
+	node := &Node{Kind: BLOCK}
+	for !p.match("}") {
+		if p.atEnd() {
+			return nil, p.expect("}")
+		}
+		stmt, err := p.stmt()
+		if err != nil {
+			return nil, err
+		}
+		node.Body = append(node.Body, stmt)
+	}
+	p.advance()
+	return node, nil
+}
+
 // stmt = expr ";"
 //
-//	| "return" expr ";"
+//	| declaration
+//	| asm-stmt
+//	| "{" compound-stmt
+//	| "return" expr? ";"
 //	| "if" "(" expr ")" stmt ("else" stmt)?
 //	| "while" "(" expr ")" stmt
-//	| "for" "(" expr ";" expr ";" expr ")" stmt
+//	| "for" "(" (declaration | expr? ";") expr? ";" expr? ")" stmt
 func (p *Parser) stmt() (*Node, error) {
+	if p.current.Kind == lexer.STATIC_ASSERT {
+		if err := p.staticAssert(); err != nil {
+			return nil, err
+		}
+		return &Node{Kind: BLOCK}, nil
+	}
+
+	if p.isTypename() {
+		return p.declaration()
+	}
+
+	if p.current.Kind == lexer.ASM {
+		return p.asmStmt()
+	}
+
+	if p.match("{") {
+		p.advance()
+		return p.scoped(p.compoundStmt)
+	}
+
 	if p.match("return") {
+		retTy := TyInt // type of the implicit main
+		if p.curFunc != nil {
+			retTy = p.curFunc.Ty.ReturnTy
+		}
+
+		tok := p.current
 		p.advance()
+		if p.match(";") {
+			if retTy.Kind != TY_VOID {
+				return nil, errors.NewPosError("non-void function should return a value", p.input, tok.Pos)
+			}
+			p.advance()
+			return &Node{Kind: RETURN}, nil
+		}
+		if retTy.Kind == TY_VOID {
+			return nil, errors.NewPosError("void function should not return a value", p.input, tok.Pos)
+		}
+
+		start := p.current
 		node, err := p.expr()
 		if err != nil {
 			return nil, err
 		}
+		if err := p.checkAssignable(retTy, node, start); err != nil {
+			return nil, err
+		}
 		if err := p.expect(";"); err != nil {
 			return nil, err
 		}
-		return &Node{Kind: RETURN, Lhs: node}, nil
+		return &Node{Kind: RETURN, Lhs: newCast(node, retTy)}, nil
 	} else if p.match("if") {
 		p.advance()
 		if err := p.expect("("); err != nil {
@@ -97,194 +484,1612 @@ func (p *Parser) stmt() (*Node, error) {
 			}
 		}
 		return &Node{Kind: IF, Cond: conditionNode, Then: thenNode, Else: elseNode}, nil
+	} else if p.match("while") {
+		p.advance()
+		if err := p.expect("("); err != nil {
+			return nil, err
+		}
+		cond, err := p.expr()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect(")"); err != nil {
+			return nil, err
+		}
+		body, err := p.stmt()
+		if err != nil {
+			return nil, err
+		}
+		return &Node{Kind: FOR, Cond: cond, Then: body}, nil
+	} else if p.match("for") {
+		return p.scoped(p.forStmt)
+	}
+
+	node, err := p.expr()
+	if err != nil {
+		return nil, err
+	}
+	if err := p.expect(";"); err != nil {
+		return nil, err
+	}
+	return node, nil
+}
+
+// forStmt parses a for statement. stmt calls it in a scope of its own, so
+// that the variables declared by the initialization end with the loop.
+func (p *Parser) forStmt() (*Node, error) {
+	p.advance()
+	if err := p.expect("("); err != nil {
+		return nil, err
+	}
+	node := &Node{Kind: FOR}
+	var err error
+	if p.isTypename() {
+		node.Init, err = p.declaration()
+	} else if !p.match(";") {
+		node.Init, err = p.expr()
+		if err == nil {
+			err = p.expect(";")
+		}
+	} else {
+		p.advance()
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if !p.match(";") {
+		if node.Cond, err = p.expr(); err != nil {
+			return nil, err
+		}
+	}
+	if err := p.expect(";"); err != nil {
+		return nil, err
+	}
+	if !p.match(")") {
+		if node.Inc, err = p.expr(); err != nil {
+			return nil, err
+		}
+	}
+	if err := p.expect(")"); err != nil {
+		return nil, err
+	}
+	if node.Then, err = p.stmt(); err != nil {
+		return nil, err
+	}
+	return node, nil
+}
+
+// declaration = declspec (declarator ("=" initializer)? ("," declarator ("=" initializer)?)*)? ";"
+func (p *Parser) declaration() (*Node, error) {
+	attr := &VarAttr{}
+	basety, err := p.declspec(attr)
+	if err != nil {
+		return nil, err
+	}
+
+	node := &Node{Kind: BLOCK}
+	for i := 0; !p.match(";"); i++ {
+		if i > 0 {
+			if err := p.expect(","); err != nil {
+				return nil, err
+			}
+		}
+
+		ty, name, err := p.declaratorName(basety)
+		if err != nil {
+			return nil, err
+		}
+		align, err := p.declAlign(name, ty, attr)
+		if err != nil {
+			return nil, err
+		}
+		if err := p.declInline(name, ty, attr); err != nil {
+			return nil, err
+		}
+		if ty.Kind == TY_FUNC {
+			if attr.IsStatic {
+				return nil, errors.NewPosError(
+					fmt.Sprintf("invalid storage class for function %s", name.Str),
+					p.input,
+					name.Pos,
+				)
+			}
+			if _, err := p.declareFunc(name, ty, attr, false); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if p.scope.vars[name.Str] != nil {
+			return nil, errors.NewPosError(
+				fmt.Sprintf("redefinition of %s", name.Str),
+				p.input,
+				name.Pos,
+			)
+		}
+
+		if isVM(ty) {
+			if attr.IsStatic || attr.IsExtern {
+				return nil, errors.NewPosError(
+					fmt.Sprintf("%s has a variably modified type but static storage", name.Str),
+					p.input,
+					name.Pos,
+				)
+			}
+			// the sizes are computed once, when the declaration is reached
+			node.Body = append(node.Body, p.vlaSizes(ty)...)
+			if ty.Kind == TY_VLA {
+				if p.match("=") {
+					return nil, errors.NewPosError("variable-sized object may not be initialized", p.input, p.current.Pos)
+				}
+				// the epilogue frees those of the outermost scope
+				if p.scope.sp == nil && p.scope.up != nil {
+					p.scope.sp = p.newLVar("", TyLong)
+				}
+				lvar := p.newLVar(name.Str, ty)
+				node.Body = append(node.Body, &Node{Kind: VLA_ALLOC, Lhs: sizeNode(ty), Var: lvar})
+				continue
+			}
+		}
+
+		if attr.IsExtern {
+			if p.match("=") {
+				return nil, errors.NewPosError(
+					fmt.Sprintf("%s has both extern and initializer", name.Str),
+					p.input,
+					p.current.Pos,
+				)
+			}
+			g, err := p.declareGlobal(name, ty, attr)
+			if err != nil {
+				return nil, err
+			}
+			p.newStaticLVar(name.Str, g)
+			continue
+		}
+
+		if !p.match("=") {
+			if ty.Size < 0 {
+				return nil, errors.NewPosError(
+					fmt.Sprintf("%s has incomplete type", name.Str),
+					p.input,
+					name.Pos,
+				)
+			}
+			if attr.IsStatic {
+				p.newStaticLVar(name.Str, p.newStaticLocal(name, ty, attr.Align))
+			} else if align > maxStackAlign {
+				return nil, p.stackAlignError(name)
+			} else {
+				p.newAlignedLVar(name.Str, ty, align)
+			}
+			continue
+		}
+
+		p.advance()
+		start := p.current
+		init, ty, err := p.initializer(ty)
+		if err != nil {
+			return nil, err
+		}
+
+		if attr.IsStatic {
+			g := p.newStaticLocal(name, ty, attr.Align)
+			if len(initGlobal(g, init)) > 0 {
+				return nil, errors.NewPosError("initializer element is not constant", p.input, start.Pos)
+			}
+			p.newStaticLVar(name.Str, g)
+			continue
+		}
+
+		if align > maxStackAlign {
+			return nil, p.stackAlignError(name)
+		}
+		lvar := p.newAlignedLVar(name.Str, ty, align)
+		node.Body = append(node.Body, lvarInitializer(lvar, init)...)
+	}
+	p.advance()
+	return node, nil
+}
+
+// lvarInitializer returns the statements initializing the local variable
+// lvar with init.
+func lvarInitializer(lvar *LVar, init *Initializer) []*Node {
+	var nodes []*Node
+	if lvar.Ty.Kind == TY_ARRAY || lvar.Ty.Kind == TY_STRUCT {
+		// clear the whole variable first, so that the elements and members
+		// without an initializer are zero
+		nodes = append(nodes, &Node{Kind: MEMZERO, Var: lvar, Ty: lvar.Ty})
+	}
+	lval := func(offset int, ty *Type) *Node {
+		return &Node{Kind: LVAR, Var: lvar, Offset: offset, Ty: ty}
+	}
+	return append(nodes, initAssigns(init, 0, lval)...)
+}
+
+// vlaSizes returns the statements computing the sizes of the variable
+// length arrays ty is derived from, innermost first, into hidden variables.
+func (p *Parser) vlaSizes(ty *Type) []*Node {
+	switch ty.Kind {
+	case TY_PTR, TY_ARRAY:
+		return p.vlaSizes(ty.Base)
+	case TY_VLA:
+		nodes := p.vlaSizes(ty.Base)
+		ty.VlaSize = p.newLVar("", TyLong)
+		size := &Node{Kind: MUL, Lhs: newCast(ty.VlaLen, TyLong), Rhs: sizeNode(ty.Base)}
+		return append(nodes, &Node{Kind: ASSIGN, Lhs: sizeNode(ty), Rhs: size, IsInit: true})
+	}
+	return nil
+}
+
+// newStaticLocal creates the global holding a static local variable. Static
+// locals live as long as the program, so they are globals with a symbol that
+// cannot clash with other names.
+func (p *Parser) newStaticLocal(name *lexer.Token, ty *Type, align int) *Global {
+	g := &Global{
+		Name:         fmt.Sprintf("%s.%d", name.Str, p.symSeq),
+		Ty:           ty,
+		Align:        align,
+		IsStatic:     true,
+		IsDefinition: true,
+		Pos:          name.Pos,
+	}
+	p.symSeq++
+	p.Globals = append(p.Globals, g)
+	return g
+}
+
+// global-declaration = (declarator ("=" initializer)? ("," declarator ("=" initializer)?)*)? ";"
+//
+// Variables declared at file scope are globals. Their initializers must be
+// constant expressions, except in programs with an implicit main, which
+// evaluates the others at run time.
+func (p *Parser) globalDeclaration(basety *Type, attr *VarAttr) error {
+	for i := 0; !p.match(";"); i++ {
+		if i > 0 {
+			if err := p.expect(","); err != nil {
+				return err
+			}
+		}
+
+		ty, name, err := p.declaratorName(basety)
+		if err != nil {
+			return err
+		}
+		if _, err := p.declAlign(name, ty, attr); err != nil {
+			return err
+		}
+		if err := p.declInline(name, ty, attr); err != nil {
+			return err
+		}
+		if isVM(ty) {
+			return errors.NewPosError(
+				fmt.Sprintf("%s has a variably modified type at file scope", name.Str),
+				p.input,
+				name.Pos,
+			)
+		}
+		if ty.Kind == TY_FUNC {
+			if _, err := p.declareFunc(name, ty, attr, false); err != nil {
+				return err
+			}
+			continue
+		}
+		g, err := p.declareGlobal(name, ty, attr)
+		if err != nil {
+			return err
+		}
+		if !p.match("=") {
+			if g.Ty.Size < 0 && !attr.IsExtern {
+				return errors.NewPosError(
+					fmt.Sprintf("%s has incomplete type", name.Str),
+					p.input,
+					name.Pos,
+				)
+			}
+			continue
+		}
+
+		if g.InitData != nil {
+			return errors.NewPosError(fmt.Sprintf("redefinition of %s", name.Str), p.input, name.Pos)
+		}
+		g.IsDefinition = true
+		p.advance()
+		start := p.current
+		init, ty, err := p.initializer(g.Ty)
+		if err != nil {
+			return err
+		}
+		g.Ty = ty
+
+		dyn := initGlobal(g, init)
+		if len(dyn) == 0 {
+			continue
+		}
+		if p.dynInit == nil {
+			p.dynInit = start
+		}
+		p.Code = append(p.Code, dyn...)
+	}
+	p.advance()
+	return nil
+}
+
+// declaratorName parses the declarator of a variable, which must have a
+// name and must not be void.
+func (p *Parser) declaratorName(basety *Type) (*Type, *lexer.Token, error) {
+	start := p.current
+	ty, name, err := p.declarator(basety)
+	if err != nil {
+		return nil, nil, err
+	}
+	if name == nil {
+		return nil, nil, errors.NewPosError(
+			fmt.Sprintf("expected identifier, but got %s", p.current.Str),
+			p.input,
+			p.current.Pos,
+		)
+	}
+	if ty.Kind == TY_VOID {
+		return nil, nil, errors.NewPosError("variable declared void", p.input, start.Pos)
+	}
+	return ty, name, nil
+}
+
+// declareGlobal declares a file-scope variable, or merges the declaration
+// with an earlier one of the same name.
+func (p *Parser) declareGlobal(name *lexer.Token, ty *Type, attr *VarAttr) (*Global, error) {
+	if p.findFunc(name.Str) != nil {
+		return nil, errors.NewPosError(
+			fmt.Sprintf("%s redeclared as different kind of symbol", name.Str),
+			p.input,
+			name.Pos,
+		)
+	}
+
+	prev := p.findGlobal(name.Str)
+	if prev == nil {
+		g := &Global{
+			Name:         name.Str,
+			Ty:           ty,
+			Align:        attr.Align,
+			IsStatic:     attr.IsStatic,
+			IsDefinition: !attr.IsExtern,
+			Pos:          name.Pos,
+		}
+		p.Globals = append(p.Globals, g)
+		return g, nil
+	}
+
+	if !isCompatible(prev.Ty, ty) {
+		return nil, errors.NewPosError(fmt.Sprintf("conflicting types for %s", name.Str), p.input, name.Pos)
+	}
+	if prev.Ty.Size < 0 {
+		// the length of an array may be given by a later declaration
+		prev.Ty = ty
+	}
+	if attr.IsStatic && !prev.IsStatic {
+		return nil, errors.NewPosError(
+			fmt.Sprintf("static declaration of %s follows non-static declaration", name.Str),
+			p.input,
+			name.Pos,
+		)
+	}
+	// extern keeps the linkage of the earlier declaration
+	if !attr.IsStatic && !attr.IsExtern && prev.IsStatic {
+		return nil, errors.NewPosError(
+			fmt.Sprintf("non-static declaration of %s follows static declaration", name.Str),
+			p.input,
+			name.Pos,
+		)
+	}
+	if !attr.IsExtern {
+		prev.IsDefinition = true
+	}
+	// the strictest alignment of all declarations applies
+	if attr.Align > prev.Align {
+		prev.Align = attr.Align
+	}
+	return prev, nil
+}
+
+// newStaticLVar makes the global g visible in the current function as name.
+func (p *Parser) newStaticLVar(name string, g *Global) *LVar {
+	lvar := &LVar{Name: name, Ty: g.Ty, Next: p.locals, Global: g}
+	p.locals = lvar
+	p.scope.vars[name] = lvar
+	return lvar
+}
+
+// declspec = ("void" | "char" | "short" | "int" | "long" | "_Bool" | "float" | "double" | "signed" | "unsigned" | "static" | "extern" | "va_list" | qualifier | alignas | function-specifier | struct-decl)+
+//
+// The order of the specifiers does not matter, so each one is counted and
+// the resulting combination is mapped to a type. Storage class specifiers
+// are recorded in attr, and are only allowed where attr is not nil.
+// Qualifiers may appear anywhere among the specifiers and apply to the
+// resulting type.
+func (p *Parser) declspec(attr *VarAttr) (*Type, error) {
+	const (
+		VOID     = 1 << 0
+		BOOL     = 1 << 2
+		CHAR     = 1 << 4
+		SHORT    = 1 << 6
+		INT      = 1 << 8
+		LONG     = 1 << 10
+		FLOAT    = 1 << 12
+		DOUBLE   = 1 << 14
+		SIGNED   = 1 << 16
+		UNSIGNED = 1 << 17
+		OTHER    = 1 << 18
+	)
+
+	var ty *Type
+	var quals qualifiers
+	counter := 0
+	for p.isTypename() {
+		if p.qualifier(&quals) {
+			continue
+		}
+
+		if p.match("_Alignas") {
+			if attr == nil {
+				return nil, errors.NewPosError("_Alignas is not allowed in this context", p.input, p.current.Pos)
+			}
+			align, err := p.alignas()
+			if err != nil {
+				return nil, err
+			}
+			if align > attr.Align {
+				attr.Align = align
+			}
+			continue
+		}
+
+		if p.current.Kind == lexer.INLINE || p.current.Kind == lexer.ATTRIBUTE {
+			if err := p.funcSpecifier(attr); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		if p.match("static") || p.match("extern") {
+			if attr == nil {
+				return nil, errors.NewPosError(
+					"storage class specifier is not allowed in this context",
+					p.input,
+					p.current.Pos,
+				)
+			}
+			if p.match("static") {
+				attr.IsStatic = true
+			} else {
+				attr.IsExtern = true
+			}
+			if attr.IsStatic && attr.IsExtern {
+				return nil, errors.NewPosError("cannot combine static and extern", p.input, p.current.Pos)
+			}
+			p.advance()
+			continue
+		}
+
+		if p.match("va_list") {
+			if counter != 0 {
+				return nil, errors.NewPosError("invalid type", p.input, p.current.Pos)
+			}
+			p.advance()
+			ty = p.Arch.vaList()
+			counter += OTHER
+			continue
+		}
+
+		if p.match("struct") {
+			if counter != 0 {
+				return nil, errors.NewPosError("invalid type", p.input, p.current.Pos)
+			}
+			st, err := p.structDecl()
+			if err != nil {
+				return nil, err
+			}
+			ty = st
+			counter += OTHER
+			continue
+		}
+
+		switch p.current.Str {
+		case "void":
+			counter += VOID
+		case "float":
+			counter += FLOAT
+		case "double":
+			counter += DOUBLE
+		case "_Bool":
+			counter += BOOL
+		case "char":
+			counter += CHAR
+		case "short":
+			counter += SHORT
+		case "int":
+			counter += INT
+		case "long":
+			counter += LONG
+		case "signed":
+			counter |= SIGNED
+		case "unsigned":
+			counter |= UNSIGNED
+		}
+
+		switch counter {
+		case VOID:
+			ty = TyVoid
+		case BOOL:
+			ty = TyBool
+		case CHAR:
+			ty = p.Arch.plainChar()
+		case SIGNED + CHAR:
+			ty = TyChar
+		case UNSIGNED + CHAR:
+			ty = TyUChar
+		case SHORT, SHORT + INT, SIGNED + SHORT, SIGNED + SHORT + INT:
+			ty = TyShort
+		case UNSIGNED + SHORT, UNSIGNED + SHORT + INT:
+			ty = TyUShort
+		case INT, SIGNED, SIGNED + INT:
+			ty = TyInt
+		case UNSIGNED, UNSIGNED + INT:
+			ty = TyUInt
+		case LONG, LONG + INT, LONG + LONG, LONG + LONG + INT,
+			SIGNED + LONG, SIGNED + LONG + INT, SIGNED + LONG + LONG, SIGNED + LONG + LONG + INT:
+			ty = TyLong
+		case UNSIGNED + LONG, UNSIGNED + LONG + INT, UNSIGNED + LONG + LONG, UNSIGNED + LONG + LONG + INT:
+			ty = TyULong
+		case FLOAT:
+			ty = TyFloat
+		case DOUBLE, LONG + DOUBLE:
+			ty = TyDouble
+		default:
+			return nil, errors.NewPosError("invalid type", p.input, p.current.Pos)
+		}
+		p.advance()
+	}
+
+	// a storage class, qualifier or alignment alone implies int
+	if ty == nil && (quals != qualifiers{} || attr != nil && (attr.IsStatic || attr.IsExtern || attr.Align != 0)) {
+		ty = TyInt
+	}
+	if ty == nil {
+		return nil, nil
+	}
+	// without typedefs, the specifiers never name a pointer type
+	if quals.restrict != nil {
+		return nil, errors.NewPosError("invalid use of restrict", p.input, quals.restrict.Pos)
+	}
+	return quals.apply(ty), nil
+}
+
+// qualifiers collects the type qualifiers of a declaration specifier list
+// or of a pointer declarator.
+type qualifiers struct {
+	isConst    bool
+	isVolatile bool
+	restrict   *lexer.Token // the "restrict" keyword, if present
+}
+
+// qualifier consumes a type qualifier, if the current token is one, and
+// records it in q.
+func (p *Parser) qualifier(q *qualifiers) bool {
+	switch p.current.Kind {
+	case lexer.CONST:
+		q.isConst = true
+	case lexer.VOLATILE:
+		q.isVolatile = true
+	case lexer.RESTRICT:
+		q.restrict = p.current
+	default:
+		return false
+	}
+	p.advance()
+	return true
+}
+
+// apply returns ty qualified by q.
+func (q qualifiers) apply(ty *Type) *Type {
+	return qualify(ty, q.isConst, q.isVolatile, q.restrict != nil)
+}
+
+// declarator = ("*" qualifier*)* ("(" declarator ")" | ident?) type-suffix
+//
+// The identifier is omitted in abstract declarators, such as in type names
+// and unnamed parameters, in which case the returned token is nil.
+func (p *Parser) declarator(ty *Type) (*Type, *lexer.Token, error) {
+	for p.match("*") {
+		p.advance()
+		ty = pointerTo(ty)
+
+		var quals qualifiers
+		for p.qualifier(&quals) {
+		}
+		ty = quals.apply(ty)
+	}
+
+	if p.match("(") && !isParamsStart(p.current.Next) {
+		// In a nested declarator such as (*fp)(int), the suffix after the
+		// parentheses applies first, so skip the inner declarator to find
+		// it, then come back to apply the inner one to the result.
+		start := p.current
+		p.advance()
+		if _, _, err := p.declarator(TyInt); err != nil {
+			return nil, nil, err
+		}
+		if err := p.expect(")"); err != nil {
+			return nil, nil, err
+		}
+		ty, err := p.typeSuffix(ty)
+		if err != nil {
+			return nil, nil, err
+		}
+		end := p.current
+
+		p.current = start.Next
+		ty, name, err := p.declarator(ty)
+		if err != nil {
+			return nil, nil, err
+		}
+		p.current = end
+		return ty, name, nil
+	}
+
+	var name *lexer.Token
+	if p.current.Kind == lexer.IDENT {
+		name = p.current
+		p.advance()
+	}
+	ty, err := p.typeSuffix(ty)
+	if err != nil {
+		return nil, nil, err
+	}
+	return ty, name, nil
+}
+
+// type-suffix = "(" params ")" | ("[" const-expr? "]")*
+//
+// The length may only be omitted for the outermost array.
+func (p *Parser) typeSuffix(ty *Type) (*Type, error) {
+	if p.match("(") {
+		if ty.Kind == TY_ARRAY || ty.Kind == TY_FUNC || ty.Kind == TY_STRUCT || isVM(ty) {
+			return nil, errors.NewPosError("invalid return type", p.input, p.current.Pos)
+		}
+		p.advance()
+		return p.params(ty)
+	}
+
+	if !p.match("[") {
+		return ty, nil
+	}
+	p.advance()
+
+	// a length that is not a constant expression makes a variable length
+	// array, and so does an element type that is one
+	n := -1
+	var vlaLen *Node
+	lenTok := p.current
+	if !p.match("]") {
+		expr, err := p.assign()
+		if err != nil {
+			return nil, err
+		}
+		AddType(expr)
+		if !expr.Ty.IsInteger() {
+			return nil, errors.NewPosError("size of array has non-integer type", p.input, lenTok.Pos)
+		}
+		if v, label, ok := eval(expr); ok && label == "" {
+			if v < 0 {
+				return nil, errors.NewPosError("size of array is negative", p.input, lenTok.Pos)
+			}
+			n = v
+		} else {
+			vlaLen = expr
+		}
+	}
+	if err := p.expect("]"); err != nil {
+		return nil, err
+	}
+
+	start := p.current
+	ty, err := p.typeSuffix(ty)
+	if err != nil {
+		return nil, err
+	}
+	if ty.Kind == TY_FUNC {
+		return nil, errors.NewPosError("declaration of array of functions", p.input, start.Pos)
+	}
+	if ty.Size < 0 {
+		return nil, errors.NewPosError("array has incomplete element type", p.input, start.Pos)
+	}
+	if vlaLen == nil && ty.Kind == TY_VLA && n >= 0 {
+		vlaLen = &Node{Kind: NUM, Val: n, Ty: TyLong}
+	}
+	if vlaLen != nil {
+		return vlaOf(ty, vlaLen), nil
+	}
+	if ty.Kind == TY_VLA {
+		return nil, errors.NewPosError("variable length array must have a length", p.input, lenTok.Pos)
+	}
+	return arrayOf(ty, n), nil
+}
+
+// struct-decl = "struct" ident? ("{" (static-assert | declspec member-declarator ("," member-declarator)* ";")* "}")?
+// member-declarator = declarator (":" const-expr)? | ":" const-expr
+//
+// A struct tag without members refers to a struct declared before. If there
+// is none, it declares an incomplete struct to be completed later, which
+// can already be pointed to.
+func (p *Parser) structDecl() (*Type, error) {
+	start := p.current
+	p.advance()
+
+	var tag *lexer.Token
+	if p.current.Kind == lexer.IDENT {
+		tag = p.current
+		p.advance()
+	}
+	if tag == nil && !p.match("{") {
+		return nil, errors.NewPosError("expected struct tag or member list", p.input, start.Pos)
+	}
+
+	if !p.match("{") {
+		if ty, ok := p.tags[tag.Str]; ok {
+			return ty, nil
+		}
+		ty := &Type{Kind: TY_STRUCT, Size: -1, Align: 1}
+		p.tags[tag.Str] = ty
+		return ty, nil
+	}
+	p.advance()
+
+	ty := &Type{Kind: TY_STRUCT, Size: -1, Align: 1}
+	if tag != nil {
+		if prev, ok := p.tags[tag.Str]; ok {
+			if prev.Size >= 0 {
+				return nil, errors.NewPosError(
+					fmt.Sprintf("redefinition of struct %s", tag.Str),
+					p.input,
+					tag.Pos,
+				)
+			}
+			ty = prev
+		}
+		// registered before the members, which may point to the struct itself
+		p.tags[tag.Str] = ty
+	}
+
+	var members, fields []*Member
+	for !p.match("}") {
+		if p.atEnd() {
+			return nil, p.expect("}")
+		}
+		if p.current.Kind == lexer.STATIC_ASSERT {
+			if err := p.staticAssert(); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		memberStart := p.current
+		attr := &VarAttr{}
+		basety, err := p.declspec(attr)
+		if err != nil {
+			return nil, err
+		}
+		if basety == nil {
+			return nil, errors.NewPosError("expected member type", p.input, memberStart.Pos)
+		}
+		if attr.IsStatic || attr.IsExtern || attr.Inline != INLINE_DEFAULT {
+			return nil, errors.NewPosError(
+				"storage class specifier is not allowed in this context",
+				p.input,
+				memberStart.Pos,
+			)
+		}
+
+		for i := 0; !p.match(";"); i++ {
+			if i > 0 {
+				if err := p.expect(","); err != nil {
+					return nil, err
+				}
+			}
+			if p.match(":") {
+				// an unnamed bit-field only takes part in the layout
+				width, err := p.bitWidth(basety, nil)
+				if err != nil {
+					return nil, err
+				}
+				fields = append(fields, &Member{Ty: basety, Align: basety.Align, IsBitfield: true, BitWidth: width})
+				continue
+			}
+			mty, name, err := p.declaratorName(basety)
+			if err != nil {
+				return nil, err
+			}
+			if mty.Size < 0 {
+				return nil, errors.NewPosError(
+					fmt.Sprintf("member %s has incomplete type", name.Str),
+					p.input,
+					name.Pos,
+				)
+			}
+			if isVM(mty) {
+				return nil, errors.NewPosError(
+					fmt.Sprintf("member %s has a variably modified type", name.Str),
+					p.input,
+					name.Pos,
+				)
+			}
+			for _, m := range members {
+				if m.Name == name.Str {
+					return nil, errors.NewPosError(
+						fmt.Sprintf("duplicate member %s", name.Str),
+						p.input,
+						name.Pos,
+					)
+				}
+			}
+			align, err := p.declAlign(name, mty, attr)
+			if err != nil {
+				return nil, err
+			}
+			m := &Member{Name: name.Str, Ty: mty, Align: align}
+			if p.match(":") {
+				if attr.Align != 0 {
+					return nil, errors.NewPosError(
+						fmt.Sprintf("alignment specified for bit-field %s", name.Str),
+						p.input,
+						name.Pos,
+					)
+				}
+				width, err := p.bitWidth(mty, name)
+				if err != nil {
+					return nil, err
+				}
+				m.IsBitfield, m.BitWidth = true, width
+			}
+			members = append(members, m)
+			fields = append(fields, m)
+		}
+		p.advance()
+	}
+	p.advance()
+
+	layoutStruct(ty, fields)
+	ty.Members = members
+	return ty, nil
+}
+
+// layoutStruct sets the offsets of fields, which are the members of ty
+// together with its unnamed bit-fields, and the size and alignment of ty as
+// the x86-64 System V ABI lays them out. Members are placed in order, each
+// aligned to its own alignment. A bit-field is packed right after the
+// preceding one, unless it would then straddle a boundary of its type's
+// size, in which case it starts at the next one. A zero-width bit-field
+// moves to such a boundary. Unnamed bit-fields do not affect the alignment
+// of the struct.
+func layoutStruct(ty *Type, fields []*Member) {
+	bits, align := 0, 1
+	for _, m := range fields {
+		unit := m.Ty.Size * 8
+		switch {
+		case m.IsBitfield && m.BitWidth == 0:
+			bits = alignTo(bits, unit)
+		case m.IsBitfield:
+			if bits/unit != (bits+m.BitWidth-1)/unit {
+				bits = alignTo(bits, unit)
+			}
+			m.Offset = bits / unit * m.Ty.Size
+			m.BitOffset = bits % unit
+			bits += m.BitWidth
+		default:
+			bits = alignTo(bits, m.Align*8)
+			m.Offset = bits / 8
+			bits += unit
+		}
+		if m.Name != "" && m.Align > align {
+			align = m.Align
+		}
+	}
+	ty.Align = align
+	ty.Size = alignTo(alignTo(bits, 8)/8, align)
+}
+
+// type-name = declspec declarator
+//
+// Variably modified types are only allowed as the operand of sizeof, which
+// parses them with vmTypeName.
+func (p *Parser) typeName() (*Type, error) {
+	start := p.current
+	ty, err := p.vmTypeName()
+	if err != nil {
+		return nil, err
+	}
+	if isVM(ty) {
+		return nil, errors.NewPosError("variably modified type is not allowed here", p.input, start.Pos)
+	}
+	return ty, nil
+}
+
+// vmTypeName is typeName allowing variably modified types.
+func (p *Parser) vmTypeName() (*Type, error) {
+	basety, err := p.declspec(nil)
+	if err != nil {
+		return nil, err
+	}
+	ty, name, err := p.declarator(basety)
+	if err != nil {
+		return nil, err
+	}
+	if name != nil {
+		return nil, errors.NewPosError("unexpected identifier in type name", p.input, name.Pos)
+	}
+	return ty, nil
+}
+
+// expr = assign
+func (p *Parser) expr() (*Node, error) {
+	return p.assign()
+}
+
+// const-expr = assign
+//
+// The expression must evaluate to an integer at compile time.
+func (p *Parser) constExpr() (int, error) {
+	start := p.current
+	node, err := p.assign()
+	if err != nil {
+		return 0, err
+	}
+	AddType(node)
+	if node.Ty.IsInteger() {
+		if v, label, ok := eval(node); ok && label == "" {
+			return v, nil
+		}
+	}
+	return 0, errors.NewPosError("expected an integer constant expression", p.input, start.Pos)
+}
+
+// assign = equality ("=" assign)?
+func (p *Parser) assign() (*Node, error) {
+	node, err := p.equality()
+	if err != nil {
+		return nil, err
+	}
+	if p.match("=") {
+		tok := p.current
+		p.advance()
+		start := p.current
+		rhs, err := p.assign()
+		if err != nil {
+			return nil, err
+		}
+
+		// the left operand is checked to be a modifiable lvalue by sema
+		AddType(node)
+		if err := p.checkAssignable(node.Ty, rhs, start); err != nil {
+			return nil, err
+		}
+		node = &Node{Kind: ASSIGN, Lhs: node, Rhs: rhs, Pos: tok.Pos}
+	}
+	return node, nil
+}
+
+// IsLvalue reports whether node designates an object.
+func IsLvalue(node *Node) bool {
+	switch node.Kind {
+	case LVAR, GVAR, DEREF, MEMBER:
+		return true
+	case COMMA:
+		// a compound literal, initialized before it is used
+		return IsLvalue(node.Rhs)
+	}
+	return false
+}
+
+// checkAssignable reports an error unless expr, starting at tok, can be
+// converted to ty by assignment. A pointer conversion that loses qualifiers
+// of the pointed-to type is allowed with a warning.
+func (p *Parser) checkAssignable(ty *Type, expr *Node, tok *lexer.Token) error {
+	AddType(expr)
+	from := expr.Ty
+
+	ok := false
+	switch {
+	case ty.Kind == TY_STRUCT:
+		ok = unqual(from) == unqual(ty)
+	case ty.IsScalar() && from.IsScalar():
+		ok = !(ty.IsPointer() && from.IsFloat()) && !(ty.IsFloat() && from.IsPointer())
+	}
+	if !ok {
+		return errors.NewPosError("incompatible types in assignment", p.input, tok.Pos)
+	}
+
+	if ty.IsPointer() && from.IsPointer() {
+		if from.Base.IsConst && !ty.Base.IsConst {
+			p.warn("conversion discards 'const' qualifier from pointer target type", tok)
+		}
+		if from.Base.IsVolatile && !ty.Base.IsVolatile {
+			p.warn("conversion discards 'volatile' qualifier from pointer target type", tok)
+		}
+	}
+	return nil
+}
+
+// warn records a warning located at tok.
+func (p *Parser) warn(msg string, tok *lexer.Token) {
+	p.Warnings = append(p.Warnings, errors.NewPosWarning(msg, p.input, tok.Pos))
+}
+
+// equality = relational ("==" relational | "!=" relational)*
+func (p *Parser) equality() (*Node, error) {
+	node, err := p.relational()
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		switch {
+		case p.match("=="):
+			tok := p.current
+			p.advance()
+			rhs, err := p.relational()
+			if err != nil {
+				return nil, err
+			}
+			if err := p.checkComparison(node, rhs, tok); err != nil {
+				return nil, err
+			}
+			node = &Node{Kind: EQ, Lhs: node, Rhs: rhs}
+		case p.match("!="):
+			tok := p.current
+			p.advance()
+			rhs, err := p.relational()
+			if err != nil {
+				return nil, err
+			}
+			if err := p.checkComparison(node, rhs, tok); err != nil {
+				return nil, err
+			}
+			node = &Node{Kind: NEQ, Lhs: node, Rhs: rhs}
+		default:
+			return node, nil
+		}
+	}
+}
+
+// relational = add ("<" add | "<=" add | ">" add | ">=" add)*
+func (p *Parser) relational() (*Node, error) {
+	node, err := p.add()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		switch {
+		case p.match("<"):
+			tok := p.current
+			p.advance()
+			rhs, err := p.add()
+			if err != nil {
+				return nil, err
+			}
+			if err := p.checkComparison(node, rhs, tok); err != nil {
+				return nil, err
+			}
+			node = &Node{Kind: LT, Lhs: node, Rhs: rhs}
+		case p.match("<="):
+			tok := p.current
+			p.advance()
+			rhs, err := p.add()
+			if err != nil {
+				return nil, err
+			}
+			if err := p.checkComparison(node, rhs, tok); err != nil {
+				return nil, err
+			}
+			node = &Node{Kind: LTE, Lhs: node, Rhs: rhs}
+		case p.match(">"):
+			tok := p.current
+			p.advance()
+			lhs, err := p.add()
+			if err != nil {
+				return nil, err
+			}
+			if err := p.checkComparison(node, lhs, tok); err != nil {
+				return nil, err
+			}
+			// ">" is equivalent to "<" in reverse
+			node = &Node{Kind: LT, Lhs: lhs, Rhs: node}
+		case p.match(">="):
+			tok := p.current
+			p.advance()
+			lhs, err := p.add()
+			if err != nil {
+				return nil, err
+			}
+			if err := p.checkComparison(node, lhs, tok); err != nil {
+				return nil, err
+			}
+			// ">=" is equivalent to "<=" in reverse
+			node = &Node{Kind: LTE, Lhs: lhs, Rhs: node}
+		default:
+			return node, nil
+		}
+	}
+}
+
+// add = mul ("+" mul | "-" mul)*
+func (p *Parser) add() (*Node, error) {
+	node, err := p.mul()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		switch {
+		case p.match("+"):
+			tok := p.current
+			p.advance()
+			rhs, err := p.mul()
+			if err != nil {
+				return nil, err
+			}
+			if node, err = p.newAdd(node, rhs, tok); err != nil {
+				return nil, err
+			}
+		case p.match("-"):
+			tok := p.current
+			p.advance()
+			rhs, err := p.mul()
+			if err != nil {
+				return nil, err
+			}
+			if node, err = p.newSub(node, rhs, tok); err != nil {
+				return nil, err
+			}
+		default:
+			return node, nil
+		}
+	}
+}
+
+// mul = cast ("*" cast | "/" cast)*
+func (p *Parser) mul() (*Node, error) {
+	node, err := p.cast()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		var kind NodeKind
+		switch {
+		case p.match("*"):
+			kind = MUL
+		case p.match("/"):
+			kind = DIV
+		default:
+			return node, nil
+		}
+
+		tok := p.current
+		p.advance()
+		rhs, err := p.cast()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.checkArithmetic(node, rhs, tok); err != nil {
+			return nil, err
+		}
+		node = &Node{Kind: kind, Lhs: node, Rhs: rhs}
+		if kind == DIV {
+			node.Pos = tok.Pos
+			// the division is left to trap at run time
+			AddType(node)
+			if v, label, ok := eval(rhs); ok && label == "" && v == 0 && node.Ty.IsInteger() {
+				p.warn("division by zero", tok)
+			}
+		}
+	}
+}
+
+// cast = "(" type-name ")" (cast | compound-literal) | unary
+func (p *Parser) cast() (*Node, error) {
+	if !p.match("(") || !isTypename(p.current.Next) {
+		return p.unary()
+	}
+
+	start := p.current
+	p.advance()
+	ty, err := p.typeName()
+	if err != nil {
+		return nil, err
+	}
+	if err := p.expect(")"); err != nil {
+		return nil, err
+	}
+	if p.match("{") {
+		node, err := p.compoundLiteral(ty, start)
+		if err != nil {
+			return nil, err
+		}
+		if node, err = p.postfixOps(node); err != nil {
+			return nil, err
+		}
+		return decay(node), nil
+	}
+	node, err := p.cast()
+	if err != nil {
+		return nil, err
+	}
+
+	// anything can be discarded by a cast to void, otherwise only scalars
+	// convert, and never between pointers and floating types
+	AddType(node)
+	if ty.Kind != TY_VOID {
+		from := node.Ty
+		if !from.IsScalar() || !ty.IsScalar() ||
+			(from.IsPointer() && ty.IsFloat()) || (from.IsFloat() && ty.IsPointer()) {
+			return nil, errors.NewPosError("invalid cast", p.input, start.Pos)
+		}
+	}
+	return &Node{Kind: CAST, Lhs: node, Ty: ty}, nil
+}
+
+// compoundLiteral parses the initializer of a compound literal of type ty,
+// whose parenthesized type name starts at start. Outside of functions it
+// is an anonymous static object, like a string literal, and in a function
+// an anonymous local variable initialized where the literal appears.
+func (p *Parser) compoundLiteral(ty *Type, start *lexer.Token) (*Node, error) {
+	if ty.Kind == TY_VOID || ty.Kind == TY_FUNC || (ty.Size < 0 && ty.Kind != TY_ARRAY) {
+		return nil, errors.NewPosError("invalid type for a compound literal", p.input, start.Pos)
+	}
+	init, ty, err := p.initializer(ty)
+	if err != nil {
+		return nil, err
+	}
+
+	if p.curFunc == nil {
+		g := &Global{
+			Name:         fmt.Sprintf(".L.compound.%d", p.symSeq),
+			Ty:           ty,
+			IsStatic:     true,
+			IsDefinition: true,
+			Pos:          start.Pos,
+		}
+		p.symSeq++
+		p.Globals = append(p.Globals, g)
+		node := &Node{Kind: GVAR, Label: g.Name, Ty: ty}
+		// the parts that are not constant are assigned where the literal
+		// appears
+		if dyn := initGlobal(g, init); len(dyn) > 0 {
+			return &Node{Kind: COMMA, Lhs: &Node{Kind: BLOCK, Body: dyn}, Rhs: node}, nil
+		}
+		return node, nil
+	}
+
+	lvar := p.newLVar("", ty)
+	node := &Node{Kind: LVAR, Var: lvar, Ty: ty}
+	return &Node{Kind: COMMA, Lhs: &Node{Kind: BLOCK, Body: lvarInitializer(lvar, init)}, Rhs: node}, nil
+}
+
+// unary = ("+" | "-" | "*" | "&") cast | sizeof | "_Alignof" "(" type-name ")" | primary
+func (p *Parser) unary() (*Node, error) {
+	if p.match("+") {
+		p.advance()
+		node, err := p.cast()
+		if err != nil {
+			return nil, err
+		}
+		return node, nil
+	}
+
+	if p.match("-") {
+		tok := p.current
+		p.advance()
+		node, err := p.cast()
+		if err != nil {
+			return nil, err
+		}
+		// "-" unary is equivalent to 0 - val
+		return p.newSub(&Node{Kind: NUM, Val: 0, Ty: TyInt}, node, tok)
 	}
 
-	node, err := p.expr()
-	if err != nil {
-		return nil, err
-	}
-	if err := p.expect(";"); err != nil {
-		return nil, err
+	if p.match("&") {
+		tok := p.current
+		p.advance()
+		node, err := p.cast()
+		if err != nil {
+			return nil, err
+		}
+		// arrays and functions decay to pointers, except as the operand of &
+		return &Node{Kind: ADDR, Lhs: undecay(node), Pos: tok.Pos}, nil
 	}
-	return node, nil
-}
 
-// expr = assign
-func (p *Parser) expr() (*Node, error) {
-	return p.assign()
-}
+	if p.current.Kind == lexer.ALIGNOF {
+		return p.alignof()
+	}
 
-// assign = equality ("=" assign)?
-func (p *Parser) assign() (*Node, error) {
-	node, err := p.equality()
-	if err != nil {
-		return nil, err
+	if p.current.Kind == lexer.SIZEOF {
+		return p.sizeof()
 	}
-	if p.match("=") {
+
+	if p.match("*") {
+		tok := p.current
 		p.advance()
-		rhs, err := p.assign()
+		node, err := p.cast()
 		if err != nil {
 			return nil, err
 		}
-		node = &Node{Kind: ASSIGN, Lhs: node, Rhs: rhs}
+		AddType(node)
+		if !node.Ty.IsPointer() || node.Ty.Base.Kind == TY_VOID {
+			return nil, errors.NewPosError("invalid pointer dereference", p.input, tok.Pos)
+		}
+		return decay(&Node{Kind: DEREF, Lhs: node}), nil
 	}
-	return node, nil
-}
 
-// equality = relational ("==" relational | "!=" relational)*
-func (p *Parser) equality() (*Node, error) {
-	node, err := p.relational()
+	node, err := p.postfix()
 	if err != nil {
 		return nil, err
 	}
+	return decay(node), nil
+}
 
-	for {
-		switch {
-		case p.match("=="):
-			p.advance()
-			rhs, err := p.relational()
+// sizeof = "sizeof" ("(" type-name ")" | unary)
+//
+// The operand is not evaluated, except for the lengths of a variable length
+// array type name. The size of a variable length array is read from its
+// hidden size variable at runtime.
+func (p *Parser) sizeof() (*Node, error) {
+	tok := p.current
+	p.advance()
+
+	var ty *Type
+	var sizes []*Node
+	if p.match("(") && isTypename(p.current.Next) {
+		start := p.current
+		p.advance()
+		t, err := p.vmTypeName()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect(")"); err != nil {
+			return nil, err
+		}
+		if p.match("{") {
+			if isVM(t) {
+				return nil, errors.NewPosError("invalid type for a compound literal", p.input, start.Pos)
+			}
+			node, err := p.compoundLiteral(t, start)
 			if err != nil {
 				return nil, err
 			}
-			node = &Node{Kind: EQ, Lhs: node, Rhs: rhs}
-		case p.match("!="):
-			p.advance()
-			rhs, err := p.relational()
-			if err != nil {
+			if node, err = p.postfixOps(node); err != nil {
 				return nil, err
 			}
-			node = &Node{Kind: NEQ, Lhs: node, Rhs: rhs}
-		default:
-			return node, nil
+			AddType(node)
+			t = node.Ty
+		} else if t.Kind == TY_VLA {
+			sizes = p.vlaSizes(t)
+		}
+		ty = t
+	} else {
+		node, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		AddType(node)
+		if isBitfield(node) {
+			return nil, errors.NewPosError("invalid application of sizeof to a bit-field", p.input, tok.Pos)
+		}
+		ty = undecay(node).Ty
+	}
+
+	if ty.Kind == TY_VLA {
+		size := &Node{Kind: CAST, Lhs: sizeNode(ty), Ty: TyULong}
+		if len(sizes) == 0 {
+			return size, nil
 		}
+		return &Node{Kind: COMMA, Lhs: &Node{Kind: BLOCK, Body: sizes}, Rhs: size}, nil
 	}
+	if ty.Kind == TY_VOID || ty.Kind == TY_FUNC || ty.Size < 0 {
+		return nil, errors.NewPosError("invalid application of sizeof", p.input, tok.Pos)
+	}
+	return &Node{Kind: NUM, Val: ty.Size, Ty: TyULong}, nil
 }
 
-// relational = add ("<" add | "<=" add | ">" add | ">=" add)*
-func (p *Parser) relational() (*Node, error) {
-	node, err := p.add()
+// postfix = primary ("[" expr "]" | "." ident | "->" ident | "(" args ")")*
+func (p *Parser) postfix() (*Node, error) {
+	node, err := p.primary()
 	if err != nil {
 		return nil, err
 	}
+	return p.postfixOps(node)
+}
+
+// postfixOps parses the postfix operators applied to node.
+func (p *Parser) postfixOps(node *Node) (*Node, error) {
+	var err error
 	for {
 		switch {
-		case p.match("<"):
+		case p.match("["):
+			// x[y] is short for *(x+y)
+			tok := p.current
 			p.advance()
-			rhs, err := p.add()
+			idx, err := p.expr()
 			if err != nil {
 				return nil, err
 			}
-			node = &Node{Kind: LT, Lhs: node, Rhs: rhs}
-		case p.match("<="):
-			p.advance()
-			rhs, err := p.add()
-			if err != nil {
+			if err := p.expect("]"); err != nil {
 				return nil, err
 			}
-			node = &Node{Kind: LTE, Lhs: node, Rhs: rhs}
-		case p.match(">"):
-			p.advance()
-			lhs, err := p.add()
+			sum, err := p.newAdd(decay(node), decay(idx), tok)
 			if err != nil {
 				return nil, err
 			}
-			// ">" is equivalent to "<" in reverse
-			node = &Node{Kind: LT, Lhs: lhs, Rhs: node}
-		case p.match(">="):
+			AddType(sum)
+			if !sum.Ty.IsPointer() || sum.Ty.Base.Kind == TY_VOID {
+				return nil, errors.NewPosError("subscripted value is not an array or pointer", p.input, tok.Pos)
+			}
+			node = &Node{Kind: DEREF, Lhs: sum}
+		case p.match("."):
 			p.advance()
-			lhs, err := p.add()
-			if err != nil {
+			if node, err = p.structRef(node); err != nil {
 				return nil, err
 			}
-			// ">=" is equivalent to "<=" in reverse
-			node = &Node{Kind: LTE, Lhs: lhs, Rhs: node}
-		default:
-			return node, nil
-		}
-	}
-}
-
-// add = mul ("+" mul | "-" mul)*
-func (p *Parser) add() (*Node, error) {
-	node, err := p.mul()
-	if err != nil {
-		return nil, err
-	}
-	for {
-		switch {
-		case p.match("+"):
+		case p.match("("):
+			// call through a pointer to a function
+			tok := p.current
+			fn := decay(node)
+			if !fn.Ty.IsPointer() || fn.Ty.Base.Kind != TY_FUNC {
+				return nil, errors.NewPosError("called object is not a function or function pointer", p.input, tok.Pos)
+			}
 			p.advance()
-			rhs, err := p.mul()
+			args, err := p.args(fn.Ty.Base, "call", tok)
 			if err != nil {
 				return nil, err
 			}
-			node = &Node{Kind: ADD, Lhs: node, Rhs: rhs}
-		case p.match("-"):
+			node = &Node{Kind: FUNCALL, Lhs: fn, Args: args, Ty: fn.Ty.Base.ReturnTy, Fixed: fixedArgs(fn.Ty.Base)}
+		case p.match("->"):
+			// x->y is short for (*x).y
+			tok := p.current
 			p.advance()
-			rhs, err := p.mul()
-			if err != nil {
+			ptr := decay(node)
+			if !ptr.Ty.IsPointer() {
+				return nil, errors.NewPosError("invalid type argument of ->", p.input, tok.Pos)
+			}
+			if node, err = p.structRef(&Node{Kind: DEREF, Lhs: ptr}); err != nil {
 				return nil, err
 			}
-			node = &Node{Kind: SUB, Lhs: node, Rhs: rhs}
 		default:
 			return node, nil
 		}
 	}
 }
 
-// mul = unary ("*" unary | "/" unary)*
-func (p *Parser) mul() (*Node, error) {
-	node, err := p.unary()
-	if err != nil {
-		return nil, err
+// structRef builds the access to the member named by the current token of
+// the struct node.
+func (p *Parser) structRef(node *Node) (*Node, error) {
+	AddType(node)
+	if node.Ty.Kind != TY_STRUCT {
+		return nil, errors.NewPosError("request for a member in something not a struct", p.input, p.current.Pos)
 	}
-	for {
-		switch {
-		case p.match("*"):
-			p.advance()
-			rhs, err := p.unary()
-			if err != nil {
-				return nil, err
-			}
-			node = &Node{Kind: MUL, Lhs: node, Rhs: rhs}
-		case p.match("/"):
+	for _, m := range unqual(node.Ty).Members {
+		if p.current.Kind == lexer.IDENT && m.Name == p.current.Str {
 			p.advance()
-			rhs, err := p.unary()
-			if err != nil {
-				return nil, err
-			}
-			node = &Node{Kind: DIV, Lhs: node, Rhs: rhs}
-		default:
-			return node, nil
+			// a member of a qualified struct is qualified the same way
+			return &Node{Kind: MEMBER, Lhs: node, Member: m, Ty: qualifyAs(m.Ty, node.Ty)}, nil
 		}
 	}
+	return nil, errors.NewPosError(
+		fmt.Sprintf("struct has no member named %s", p.current.Str),
+		p.input,
+		p.current.Pos,
+	)
 }
 
-// unary = ("+" | "-")? unary | primary
-func (p *Parser) unary() (*Node, error) {
-	if p.match("+") {
-		p.advance()
-		node, err := p.unary()
-		if err != nil {
-			return nil, err
-		}
-		return node, nil
+// decay converts an array to a pointer to its first element, and a
+// function to a pointer to it, as happens to them when used as values.
+func decay(node *Node) *Node {
+	AddType(node)
+	switch node.Ty.Kind {
+	case TY_ARRAY, TY_VLA:
+		return &Node{Kind: ADDR, Lhs: node, Ty: pointerTo(node.Ty.Base)}
+	case TY_FUNC:
+		return &Node{Kind: ADDR, Lhs: node, Ty: pointerTo(node.Ty)}
 	}
+	return node
+}
 
-	if p.match("-") {
-		p.advance()
-		node, err := p.unary()
-		if err != nil {
-			return nil, err
-		}
-		// "-" unary is equivalent to 0 - val
-		return &Node{Kind: SUB, Lhs: &Node{Kind: NUM, Val: 0}, Rhs: node}, nil
+// undecay reverts decay, for the operands of & and sizeof.
+func undecay(node *Node) *Node {
+	if node.Kind == ADDR && (node.Lhs.Ty.Kind == TY_ARRAY || node.Lhs.Ty.Kind == TY_VLA || node.Lhs.Ty.Kind == TY_FUNC) {
+		return node.Lhs
+	}
+	return node
+}
+
+// newAdd builds an addition. Adding an integer n to a pointer advances it
+// by n elements, so n is scaled by the size of the pointed-to type.
+func (p *Parser) newAdd(lhs, rhs *Node, tok *lexer.Token) (*Node, error) {
+	AddType(lhs)
+	AddType(rhs)
+
+	if lhs.Ty.IsArithmetic() && rhs.Ty.IsArithmetic() {
+		return &Node{Kind: ADD, Lhs: lhs, Rhs: rhs}, nil
+	}
+
+	// canonicalize int + ptr to ptr + int
+	if !lhs.Ty.IsPointer() {
+		lhs, rhs = rhs, lhs
+	}
+	if !lhs.Ty.IsPointer() || !rhs.Ty.IsInteger() {
+		return nil, errors.NewPosError("invalid operands to binary +", p.input, tok.Pos)
+	}
+	return &Node{Kind: ADD, Lhs: lhs, Rhs: scaleIndex(rhs, lhs.Ty.Base), Ty: lhs.Ty}, nil
+}
+
+// newSub builds a subtraction. A pointer minus an integer moves back by
+// that many elements, and the difference of two pointers is the number of
+// elements between them.
+func (p *Parser) newSub(lhs, rhs *Node, tok *lexer.Token) (*Node, error) {
+	AddType(lhs)
+	AddType(rhs)
+
+	switch {
+	case lhs.Ty.IsArithmetic() && rhs.Ty.IsArithmetic():
+		return &Node{Kind: SUB, Lhs: lhs, Rhs: rhs}, nil
+	case lhs.Ty.IsPointer() && rhs.Ty.IsInteger():
+		return &Node{Kind: SUB, Lhs: lhs, Rhs: scaleIndex(rhs, lhs.Ty.Base), Ty: lhs.Ty}, nil
+	case lhs.Ty.IsPointer() && rhs.Ty.IsPointer() && isCompatible(lhs.Ty.Base, rhs.Ty.Base):
+		diff := &Node{Kind: SUB, Lhs: newCast(lhs, TyLong), Rhs: newCast(rhs, TyLong), Ty: TyLong}
+		return &Node{Kind: DIV, Lhs: diff, Rhs: sizeNode(lhs.Ty.Base), Ty: TyLong}, nil
+	}
+	return nil, errors.NewPosError("invalid operands to binary -", p.input, tok.Pos)
+}
+
+// scaleIndex converts an element index into a byte offset for elements of
+// type base.
+func scaleIndex(index *Node, base *Type) *Node {
+	node := &Node{Kind: MUL, Lhs: newCast(index, TyLong), Rhs: sizeNode(base)}
+	AddType(node)
+	return node
+}
+
+// checkArithmetic reports an error unless both operands of the binary
+// operator tok have arithmetic types.
+func (p *Parser) checkArithmetic(lhs, rhs *Node, tok *lexer.Token) error {
+	AddType(lhs)
+	AddType(rhs)
+	if !lhs.Ty.IsArithmetic() || !rhs.Ty.IsArithmetic() {
+		return errors.NewPosError(
+			fmt.Sprintf("invalid operands to binary %s", tok.Str),
+			p.input,
+			tok.Pos,
+		)
 	}
+	return nil
+}
 
-	return p.primary()
+// checkComparison reports an error unless the operands of the comparison
+// tok are scalars that can be compared with each other.
+func (p *Parser) checkComparison(lhs, rhs *Node, tok *lexer.Token) error {
+	AddType(lhs)
+	AddType(rhs)
+	ok := lhs.Ty.IsScalar() && rhs.Ty.IsScalar() &&
+		!(lhs.Ty.IsPointer() && rhs.Ty.IsFloat()) && !(lhs.Ty.IsFloat() && rhs.Ty.IsPointer())
+	if !ok {
+		return errors.NewPosError(
+			fmt.Sprintf("invalid operands to binary %s", tok.Str),
+			p.input,
+			tok.Pos,
+		)
+	}
+	return nil
 }
 
-// primary = num | ident | "(" expr ")"
+// primary = num | str+ | ident | funcall | stdarg | generic | "(" expr ")"
 func (p *Parser) primary() (*Node, error) {
 	if p.match("(") {
 		p.advance()
@@ -298,35 +2103,48 @@ func (p *Parser) primary() (*Node, error) {
 		return node, nil
 	}
 
+	if isStdarg(p.current) {
+		return p.stdarg()
+	}
+
+	if p.current.Kind == lexer.GENERIC {
+		return p.generic()
+	}
+
+	if p.current.Kind == lexer.STR {
+		g := p.newStringLiteral(p.stringLiteral())
+		return &Node{Kind: GVAR, Label: g.Name, Ty: g.Ty}, nil
+	}
+
 	if p.current.Kind == lexer.NUM {
-		val := p.current.Val
+		node := &Node{Kind: NUM, Val: p.current.Val, FVal: p.current.FVal, Ty: literalType(p.current.LitType)}
 		p.advance()
-		return &Node{Kind: NUM, Val: val}, nil
+		return node, nil
 	} else if p.current.Kind == lexer.IDENT {
-		node := &Node{Kind: LVAR}
 		lvar := p.findLVar(p.current)
-		if lvar != nil {
-			node.Offset = int(lvar.Offset)
-		} else {
-			var offset int
-			var next *LVar
-			if p.locals == nil {
-				offset = 8
-				next = nil
-			} else {
-				offset = p.locals.Offset + 8
-				next = p.locals
+		g := p.findGlobal(p.current.Str)
+
+		// a call by name, unless a variable of the name hides the function
+		if lvar == nil && g == nil && p.current.Next != nil && p.current.Next.Str == "(" {
+			return p.funcall()
+		}
+
+		if lvar == nil {
+			if g != nil {
+				p.advance()
+				return &Node{Kind: GVAR, Label: g.Name, Ty: g.Ty}, nil
 			}
-			lvar = &LVar{
-				Name:   p.current.Str,
-				Next:   next,
-				Offset: offset,
+			if fn := p.findFunc(p.current.Str); fn != nil {
+				p.advance()
+				return &Node{Kind: GVAR, Label: fn.Name, Ty: fn.Ty}, nil
 			}
-			node.Offset = lvar.Offset
-			p.locals = lvar
+			return nil, errors.NewPosError(fmt.Sprintf("undeclared identifier %s", p.current.Str), p.input, p.current.Pos)
 		}
 		p.advance()
-		return node, nil
+		if lvar.Global != nil {
+			return &Node{Kind: GVAR, Label: lvar.Global.Name, Ty: lvar.Ty}, nil
+		}
+		return &Node{Kind: LVAR, Var: lvar, Ty: lvar.Ty}, nil
 	} else {
 		return nil, errors.NewPosError(
 			fmt.Sprintf("expected number or identifier, but got %s", p.current.Str),
@@ -336,6 +2154,112 @@ func (p *Parser) primary() (*Node, error) {
 	}
 }
 
+// funcall = ident "(" args ")"
+//
+// Functions called without a declaration are assumed to return int.
+func (p *Parser) funcall() (*Node, error) {
+	nameTok := p.current
+	p.advance()
+	p.advance()
+
+	ty := funcType(TyInt, nil, false)
+	if fn := p.findFunc(nameTok.Str); fn != nil {
+		ty = fn.Ty
+	}
+	args, err := p.args(ty, nameTok.Str, nameTok)
+	if err != nil {
+		return nil, err
+	}
+	return &Node{Kind: FUNCALL, FuncName: nameTok.Str, Args: args, Ty: ty.ReturnTy, Fixed: fixedArgs(ty)}, nil
+}
+
+// fixedArgs returns the number of named parameters of the function type ty
+// if it is variadic, or 0. Some targets pass the variadic arguments
+// differently.
+func fixedArgs(ty *Type) int {
+	if ty.IsVariadic {
+		return len(ty.Params)
+	}
+	return 0
+}
+
+// args = (assign ("," assign)*)? ")"
+//
+// The arguments are converted to the parameter types of the function type
+// ty, or get the default argument promotions where there is no parameter.
+// name and tok identify the call in errors.
+func (p *Parser) args(ty *Type, name string, tok *lexer.Token) ([]*Node, error) {
+	var args []*Node
+	var starts []*lexer.Token
+	for !p.match(")") {
+		if len(args) > 0 {
+			if err := p.expect(","); err != nil {
+				return nil, err
+			}
+		}
+		starts = append(starts, p.current)
+		arg, err := p.assign()
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, arg)
+	}
+	p.advance()
+
+	if ty.Prototyped && len(args) < len(ty.Params) {
+		return nil, errors.NewPosError(
+			fmt.Sprintf("too few arguments to function %s", name), p.input, tok.Pos)
+	}
+	if ty.Prototyped && !ty.IsVariadic && len(args) > len(ty.Params) {
+		return nil, errors.NewPosError(
+			fmt.Sprintf("too many arguments to function %s", name), p.input, tok.Pos)
+	}
+
+	for i, arg := range args {
+		AddType(arg)
+		if ty.Prototyped && i < len(ty.Params) {
+			if err := p.checkAssignable(ty.Params[i], arg, starts[i]); err != nil {
+				return nil, err
+			}
+			args[i] = newCast(arg, ty.Params[i])
+		} else {
+			if !arg.Ty.IsScalar() {
+				return nil, errors.NewPosError("invalid argument type", p.input, starts[i].Pos)
+			}
+			arg = promoteBitfield(arg)
+			args[i] = newCast(arg, defaultArgPromote(arg.Ty))
+		}
+	}
+	return args, nil
+}
+
+// stringLiteral consumes adjacent string literals and returns their
+// concatenated contents.
+func (p *Parser) stringLiteral() string {
+	s := ""
+	for p.current.Kind == lexer.STR {
+		s += p.current.StrVal
+		p.advance()
+	}
+	return s
+}
+
+// newStringLiteral creates an anonymous global holding the characters of a
+// string literal.
+func (p *Parser) newStringLiteral(s string) *Global {
+	data := append([]byte(s), 0)
+	g := &Global{
+		Name:         fmt.Sprintf(".L.str.%d", p.symSeq),
+		Ty:           arrayOf(p.Arch.plainChar(), len(data)),
+		IsStatic:     true,
+		IsDefinition: true,
+		InitData:     data,
+	}
+	p.symSeq++
+	p.Globals = append(p.Globals, g)
+	return g
+}
+
 func (p *Parser) atEnd() bool {
 	return p.current == nil || p.current.Kind == lexer.EOF
 }
@@ -350,8 +2274,42 @@ func (p *Parser) match(op string) bool {
 	return p.current != nil && p.current.Str == op
 }
 
+func (p *Parser) isTypename() bool {
+	return isTypename(p.current)
+}
+
+func isTypename(tok *lexer.Token) bool {
+	if tok == nil {
+		return false
+	}
+	switch tok.Kind {
+	case lexer.VOID, lexer.CHAR, lexer.SHORT, lexer.INT, lexer.LONG, lexer.BOOL,
+		lexer.FLOAT, lexer.DOUBLE, lexer.SIGNED, lexer.UNSIGNED, lexer.STATIC, lexer.EXTERN, lexer.STRUCT,
+		lexer.VA_LIST, lexer.CONST, lexer.VOLATILE, lexer.RESTRICT, lexer.ALIGNAS, lexer.INLINE, lexer.ATTRIBUTE:
+		return true
+	}
+	return false
+}
+
+// isFunction reports whether the declarator ahead, applied to basety,
+// starts a function definition. Function declarations without a body are
+// global declarations.
+func (p *Parser) isFunction(basety *Type) bool {
+	start := p.current
+	defer func() { p.current = start }()
+
+	ty, _, err := p.declarator(basety)
+	return err == nil && ty.Kind == TY_FUNC && p.match("{")
+}
+
+// isParamsStart reports whether tok, following "(" in a declarator, starts a
+// parameter list rather than a nested declarator.
+func isParamsStart(tok *lexer.Token) bool {
+	return tok != nil && (tok.Str == ")" || tok.Str == "..." || isTypename(tok))
+}
+
 func (p *Parser) expect(op string) error {
-	if p.current == nil {
+	if p.current == nil || p.current.Kind == lexer.EOF {
 		return errors.NewPosError(
 			fmt.Sprintf("expected %s, but got EOF", op),
 			p.input,
@@ -369,13 +2327,56 @@ func (p *Parser) expect(op string) error {
 	return nil
 }
 
+// findLVar looks the name up from the innermost scope outwards.
 func (p *Parser) findLVar(token *lexer.Token) *LVar {
-	if p.locals == nil {
-		return nil
+	for s := p.scope; s != nil; s = s.up {
+		if lvar := s.vars[token.Str]; lvar != nil {
+			return lvar
+		}
 	}
-	for l := p.locals; l != nil; l = l.Next {
-		if l.Name == token.Str {
-			return l
+	return nil
+}
+
+// newLVar declares a new local variable of the current function in the
+// current scope. Its place in the stack frame is assigned by sema.
+func (p *Parser) newLVar(name string, ty *Type) *LVar {
+	return p.newAlignedLVar(name, ty, ty.Align)
+}
+
+// newAlignedLVar is newLVar for a variable aligned to align bytes instead
+// of the alignment of its type.
+func (p *Parser) newAlignedLVar(name string, ty *Type, align int) *LVar {
+	lvar := &LVar{
+		Name:  name,
+		Ty:    ty,
+		Next:  p.locals,
+		Align: align,
+	}
+	p.locals = lvar
+	if name != "" {
+		p.scope.vars[name] = lvar
+	}
+	return lvar
+}
+
+// alignTo rounds n up to the nearest multiple of align.
+func alignTo(n, align int) int {
+	return (n + align - 1) / align * align
+}
+
+func (p *Parser) findGlobal(name string) *Global {
+	for _, g := range p.Globals {
+		if g.Name == name {
+			return g
+		}
+	}
+	return nil
+}
+
+func (p *Parser) findFunc(name string) *Function {
+	for _, fn := range p.Funcs {
+		if fn.Name == name {
+			return fn
 		}
 	}
 	return nil
@@ -1,7 +1,10 @@
 package parser_test
 
 import (
+	stderrors "errors"
 	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+	"rkitamu/gocc/errors"
 	"rkitamu/gocc/lexer"
 	"rkitamu/gocc/parser"
 	"testing"
@@ -26,113 +29,113 @@ func TestParse_GrammarCoverage(t *testing.T) {
 	}{
 		{
 			name:  "equality ==: 1 + 2 == 3",
-			input: "1 + 2 == 3",
+			input: "1 + 2 == 3;",
 			tokens: &lexer.Token{
 				Kind: lexer.NUM, Val: 1, Str: "1", Next: &lexer.Token{
 					Kind: lexer.RESERVED, Str: "+", Next: &lexer.Token{
 						Kind: lexer.NUM, Val: 2, Str: "2", Next: &lexer.Token{
 							Kind: lexer.RESERVED, Str: "==", Next: &lexer.Token{
 								Kind: lexer.NUM, Val: 3, Str: "3", Next: &lexer.Token{
-									Kind: lexer.EOF,
+									Kind: lexer.RESERVED, Str: ";", Next: &lexer.Token{Kind: lexer.EOF},
 								},
 							},
 						},
 					},
 				},
 			},
-			want: &parser.Node{Kind: parser.EQ,
-				Lhs: &parser.Node{Kind: parser.ADD,
-					Lhs: &parser.Node{Kind: parser.NUM, Val: 1},
-					Rhs: &parser.Node{Kind: parser.NUM, Val: 2},
+			want: &parser.Node{Kind: parser.EQ, Ty: parser.TyInt,
+				Lhs: &parser.Node{Kind: parser.ADD, Ty: parser.TyInt,
+					Lhs: &parser.Node{Kind: parser.NUM, Val: 1, Ty: parser.TyInt},
+					Rhs: &parser.Node{Kind: parser.NUM, Val: 2, Ty: parser.TyInt},
 				},
-				Rhs: &parser.Node{Kind: parser.NUM, Val: 3},
+				Rhs: &parser.Node{Kind: parser.NUM, Val: 3, Ty: parser.TyInt},
 			},
 		},
 		{
 			name:  "add +: 1 + 2 + 3",
-			input: "1 + 2 + 3",
+			input: "1 + 2 + 3;",
 			tokens: &lexer.Token{
 				Kind: lexer.NUM, Val: 1, Str: "1", Next: &lexer.Token{
 					Kind: lexer.RESERVED, Str: "+", Next: &lexer.Token{
 						Kind: lexer.NUM, Val: 2, Str: "2", Next: &lexer.Token{
 							Kind: lexer.RESERVED, Str: "+", Next: &lexer.Token{
 								Kind: lexer.NUM, Val: 3, Str: "3", Next: &lexer.Token{
-									Kind: lexer.EOF,
+									Kind: lexer.RESERVED, Str: ";", Next: &lexer.Token{Kind: lexer.EOF},
 								},
 							},
 						},
 					},
 				},
 			},
-			want: &parser.Node{Kind: parser.ADD,
-				Lhs: &parser.Node{Kind: parser.ADD,
-					Lhs: &parser.Node{Kind: parser.NUM, Val: 1},
-					Rhs: &parser.Node{Kind: parser.NUM, Val: 2},
+			want: &parser.Node{Kind: parser.ADD, Ty: parser.TyInt,
+				Lhs: &parser.Node{Kind: parser.ADD, Ty: parser.TyInt,
+					Lhs: &parser.Node{Kind: parser.NUM, Val: 1, Ty: parser.TyInt},
+					Rhs: &parser.Node{Kind: parser.NUM, Val: 2, Ty: parser.TyInt},
 				},
-				Rhs: &parser.Node{Kind: parser.NUM, Val: 3},
+				Rhs: &parser.Node{Kind: parser.NUM, Val: 3, Ty: parser.TyInt},
 			},
 		},
 		{
 			name:   "unary -: -1 + 2",
-			input:  "-1 + 2",
-			tokens: &lexer.Token{Kind: lexer.RESERVED, Str: "-", Next: &lexer.Token{Kind: lexer.NUM, Val: 1, Str: "1", Next: &lexer.Token{Kind: lexer.RESERVED, Str: "+", Next: &lexer.Token{Kind: lexer.NUM, Val: 2, Str: "2", Next: &lexer.Token{Kind: lexer.EOF}}}}},
-			want: &parser.Node{Kind: parser.ADD,
-				Lhs: &parser.Node{Kind: parser.SUB,
-					Lhs: &parser.Node{Kind: parser.NUM, Val: 0},
-					Rhs: &parser.Node{Kind: parser.NUM, Val: 1},
+			input:  "-1 + 2;",
+			tokens: &lexer.Token{Kind: lexer.RESERVED, Str: "-", Next: &lexer.Token{Kind: lexer.NUM, Val: 1, Str: "1", Next: &lexer.Token{Kind: lexer.RESERVED, Str: "+", Next: &lexer.Token{Kind: lexer.NUM, Val: 2, Str: "2", Next: &lexer.Token{Kind: lexer.RESERVED, Str: ";", Next: &lexer.Token{Kind: lexer.EOF}}}}}},
+			want: &parser.Node{Kind: parser.ADD, Ty: parser.TyInt,
+				Lhs: &parser.Node{Kind: parser.SUB, Ty: parser.TyInt,
+					Lhs: &parser.Node{Kind: parser.NUM, Val: 0, Ty: parser.TyInt},
+					Rhs: &parser.Node{Kind: parser.NUM, Val: 1, Ty: parser.TyInt},
 				},
-				Rhs: &parser.Node{Kind: parser.NUM, Val: 2},
+				Rhs: &parser.Node{Kind: parser.NUM, Val: 2, Ty: parser.TyInt},
 			},
 		},
 		{
 			name:   "relational >=: 5 >= 1 + 2",
-			input:  "5 >= 1 + 2",
-			tokens: &lexer.Token{Kind: lexer.NUM, Val: 5, Str: "5", Next: &lexer.Token{Kind: lexer.RESERVED, Str: ">=", Next: &lexer.Token{Kind: lexer.NUM, Val: 1, Str: "1", Next: &lexer.Token{Kind: lexer.RESERVED, Str: "+", Next: &lexer.Token{Kind: lexer.NUM, Val: 2, Str: "2", Next: &lexer.Token{Kind: lexer.EOF}}}}}},
-			want: &parser.Node{Kind: parser.LTE,
-				Lhs: &parser.Node{Kind: parser.ADD,
-					Lhs: &parser.Node{Kind: parser.NUM, Val: 1},
-					Rhs: &parser.Node{Kind: parser.NUM, Val: 2},
+			input:  "5 >= 1 + 2;",
+			tokens: &lexer.Token{Kind: lexer.NUM, Val: 5, Str: "5", Next: &lexer.Token{Kind: lexer.RESERVED, Str: ">=", Next: &lexer.Token{Kind: lexer.NUM, Val: 1, Str: "1", Next: &lexer.Token{Kind: lexer.RESERVED, Str: "+", Next: &lexer.Token{Kind: lexer.NUM, Val: 2, Str: "2", Next: &lexer.Token{Kind: lexer.RESERVED, Str: ";", Next: &lexer.Token{Kind: lexer.EOF}}}}}}},
+			want: &parser.Node{Kind: parser.LTE, Ty: parser.TyInt,
+				Lhs: &parser.Node{Kind: parser.ADD, Ty: parser.TyInt,
+					Lhs: &parser.Node{Kind: parser.NUM, Val: 1, Ty: parser.TyInt},
+					Rhs: &parser.Node{Kind: parser.NUM, Val: 2, Ty: parser.TyInt},
 				},
-				Rhs: &parser.Node{Kind: parser.NUM, Val: 5},
+				Rhs: &parser.Node{Kind: parser.NUM, Val: 5, Ty: parser.TyInt},
 			},
 		},
 		{
 			name:   "grouping (1 + 2) * 3: (1 + 2) * 3",
-			input:  "(1 + 2) * 3",
-			tokens: &lexer.Token{Kind: lexer.RESERVED, Str: "(", Next: &lexer.Token{Kind: lexer.NUM, Val: 1, Str: "1", Next: &lexer.Token{Kind: lexer.RESERVED, Str: "+", Next: &lexer.Token{Kind: lexer.NUM, Val: 2, Str: "2", Next: &lexer.Token{Kind: lexer.RESERVED, Str: ")", Next: &lexer.Token{Kind: lexer.RESERVED, Str: "*", Next: &lexer.Token{Kind: lexer.NUM, Val: 3, Str: "3", Next: &lexer.Token{Kind: lexer.EOF}}}}}}}},
-			want: &parser.Node{Kind: parser.MUL,
-				Lhs: &parser.Node{Kind: parser.ADD,
-					Lhs: &parser.Node{Kind: parser.NUM, Val: 1},
-					Rhs: &parser.Node{Kind: parser.NUM, Val: 2},
+			input:  "(1 + 2) * 3;",
+			tokens: &lexer.Token{Kind: lexer.RESERVED, Str: "(", Next: &lexer.Token{Kind: lexer.NUM, Val: 1, Str: "1", Next: &lexer.Token{Kind: lexer.RESERVED, Str: "+", Next: &lexer.Token{Kind: lexer.NUM, Val: 2, Str: "2", Next: &lexer.Token{Kind: lexer.RESERVED, Str: ")", Next: &lexer.Token{Kind: lexer.RESERVED, Str: "*", Next: &lexer.Token{Kind: lexer.NUM, Val: 3, Str: "3", Next: &lexer.Token{Kind: lexer.RESERVED, Str: ";", Next: &lexer.Token{Kind: lexer.EOF}}}}}}}}},
+			want: &parser.Node{Kind: parser.MUL, Ty: parser.TyInt,
+				Lhs: &parser.Node{Kind: parser.ADD, Ty: parser.TyInt,
+					Lhs: &parser.Node{Kind: parser.NUM, Val: 1, Ty: parser.TyInt},
+					Rhs: &parser.Node{Kind: parser.NUM, Val: 2, Ty: parser.TyInt},
 				},
-				Rhs: &parser.Node{Kind: parser.NUM, Val: 3},
+				Rhs: &parser.Node{Kind: parser.NUM, Val: 3, Ty: parser.TyInt},
 			},
 		},
 		{
 			name:   "not equal !=: 1 != 2",
-			input:  "1 != 2",
-			tokens: &lexer.Token{Kind: lexer.NUM, Val: 1, Str: "1", Next: &lexer.Token{Kind: lexer.RESERVED, Str: "!=", Next: &lexer.Token{Kind: lexer.NUM, Val: 2, Str: "2", Next: &lexer.Token{Kind: lexer.EOF}}}},
-			want: &parser.Node{Kind: parser.NEQ,
-				Lhs: &parser.Node{Kind: parser.NUM, Val: 1},
-				Rhs: &parser.Node{Kind: parser.NUM, Val: 2},
+			input:  "1 != 2;",
+			tokens: &lexer.Token{Kind: lexer.NUM, Val: 1, Str: "1", Next: &lexer.Token{Kind: lexer.RESERVED, Str: "!=", Next: &lexer.Token{Kind: lexer.NUM, Val: 2, Str: "2", Next: &lexer.Token{Kind: lexer.RESERVED, Str: ";", Next: &lexer.Token{Kind: lexer.EOF}}}}},
+			want: &parser.Node{Kind: parser.NEQ, Ty: parser.TyInt,
+				Lhs: &parser.Node{Kind: parser.NUM, Val: 1, Ty: parser.TyInt},
+				Rhs: &parser.Node{Kind: parser.NUM, Val: 2, Ty: parser.TyInt},
 			},
 		},
 		{
 			name:   "less than <: 1 < 2",
-			input:  "1 < 2",
-			tokens: &lexer.Token{Kind: lexer.NUM, Val: 1, Str: "1", Next: &lexer.Token{Kind: lexer.RESERVED, Str: "<", Next: &lexer.Token{Kind: lexer.NUM, Val: 2, Str: "2", Next: &lexer.Token{Kind: lexer.EOF}}}},
-			want: &parser.Node{Kind: parser.LT,
-				Lhs: &parser.Node{Kind: parser.NUM, Val: 1},
-				Rhs: &parser.Node{Kind: parser.NUM, Val: 2},
+			input:  "1 < 2;",
+			tokens: &lexer.Token{Kind: lexer.NUM, Val: 1, Str: "1", Next: &lexer.Token{Kind: lexer.RESERVED, Str: "<", Next: &lexer.Token{Kind: lexer.NUM, Val: 2, Str: "2", Next: &lexer.Token{Kind: lexer.RESERVED, Str: ";", Next: &lexer.Token{Kind: lexer.EOF}}}}},
+			want: &parser.Node{Kind: parser.LT, Ty: parser.TyInt,
+				Lhs: &parser.Node{Kind: parser.NUM, Val: 1, Ty: parser.TyInt},
+				Rhs: &parser.Node{Kind: parser.NUM, Val: 2, Ty: parser.TyInt},
 			},
 		},
 		{
 			name:   "multiply *: 2 * 3",
-			input:  "2 * 3",
-			tokens: &lexer.Token{Kind: lexer.NUM, Val: 2, Str: "2", Next: &lexer.Token{Kind: lexer.RESERVED, Str: "*", Next: &lexer.Token{Kind: lexer.NUM, Val: 3, Str: "3", Next: &lexer.Token{Kind: lexer.EOF}}}},
-			want: &parser.Node{Kind: parser.MUL,
-				Lhs: &parser.Node{Kind: parser.NUM, Val: 2},
-				Rhs: &parser.Node{Kind: parser.NUM, Val: 3},
+			input:  "2 * 3;",
+			tokens: &lexer.Token{Kind: lexer.NUM, Val: 2, Str: "2", Next: &lexer.Token{Kind: lexer.RESERVED, Str: "*", Next: &lexer.Token{Kind: lexer.NUM, Val: 3, Str: "3", Next: &lexer.Token{Kind: lexer.RESERVED, Str: ";", Next: &lexer.Token{Kind: lexer.EOF}}}}},
+			want: &parser.Node{Kind: parser.MUL, Ty: parser.TyInt,
+				Lhs: &parser.Node{Kind: parser.NUM, Val: 2, Ty: parser.TyInt},
+				Rhs: &parser.Node{Kind: parser.NUM, Val: 3, Ty: parser.TyInt},
 			},
 		},
 	}
@@ -141,13 +144,1456 @@ func TestParse_GrammarCoverage(t *testing.T) {
 		t.Run(tt.name, func(t *testing.T) {
 			p := parser.NewParser(tt.tokens, tt.input)
 			err := p.Parse()
-			got := p.Code[0]
 			if err != nil {
 				t.Fatalf("parse error: %v", err)
 			}
+			got := p.Code[0]
 			if diff := cmp.Diff(tt.want, got); diff != "" {
 				t.Errorf("AST mismatch (-want +got):\n%s", diff)
 			}
 		})
 	}
 }
+
+func TestParse_Declaration(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  *parser.Node
+	}{
+		{
+			name:  "without initializer",
+			input: "int main() { long a; }",
+			want:  &parser.Node{Kind: parser.BLOCK},
+		},
+		{
+			name:  "with initializers",
+			input: "int main() { char a = 1, b; }",
+			want: &parser.Node{Kind: parser.BLOCK, Body: []*parser.Node{
+				{Kind: parser.ASSIGN, Ty: parser.TyPlainChar, IsInit: true,
+					Lhs: &parser.Node{Kind: parser.LVAR, Ty: parser.TyPlainChar},
+					Rhs: &parser.Node{Kind: parser.CAST, Ty: parser.TyPlainChar,
+						Lhs: &parser.Node{Kind: parser.NUM, Val: 1, Ty: parser.TyInt},
+					},
+				},
+			}},
+		},
+		{
+			name:  "specifiers in any order",
+			input: "int main() { int long unsigned long a = 2; }",
+			want: &parser.Node{Kind: parser.BLOCK, Body: []*parser.Node{
+				{Kind: parser.ASSIGN, Ty: parser.TyULong, IsInit: true,
+					Lhs: &parser.Node{Kind: parser.LVAR, Ty: parser.TyULong},
+					Rhs: &parser.Node{Kind: parser.CAST, Ty: parser.TyULong,
+						Lhs: &parser.Node{Kind: parser.NUM, Val: 2, Ty: parser.TyInt},
+					},
+				},
+			}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tokens, err := lexer.NewLexer(tt.input).Lex()
+			if err != nil {
+				t.Fatalf("lex error: %v", err)
+			}
+			p := parser.NewParser(tokens, tt.input)
+			if err := p.Parse(); err != nil {
+				t.Fatalf("parse error: %v", err)
+			}
+			got := p.Funcs[0].Body.Body[0]
+			if diff := cmp.Diff(tt.want, got, cmpopts.IgnoreFields(parser.Node{}, "Var")); diff != "" {
+				t.Errorf("AST mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestParse_DeclarationErrors(t *testing.T) {
+	inputs := []string{
+		"{ int a; int a; }",
+		"short long a;",
+		"unsigned char int a;",
+		"int 1;",
+	}
+
+	for _, input := range inputs {
+		t.Run(input, func(t *testing.T) {
+			tokens, err := lexer.NewLexer(input).Lex()
+			if err != nil {
+				t.Fatalf("lex error: %v", err)
+			}
+			if err := parser.NewParser(tokens, input).Parse(); err == nil {
+				t.Errorf("expected error, but got none")
+			}
+		})
+	}
+}
+
+func TestParse_Loops(t *testing.T) {
+	input := "int main() { int s = 0; while (s < 3) s = s + 1; for (int i = 0; i < 2; i = i + 1) s = s + i; for (;;) return s; }"
+	tokens, err := lexer.NewLexer(input).Lex()
+	if err != nil {
+		t.Fatalf("lex error: %v", err)
+	}
+	p := parser.NewParser(tokens, input)
+	if err := p.Parse(); err != nil {
+		t.Fatalf("parse error: %v", err)
+	}
+
+	body := p.Funcs[0].Body.Body
+	while, forStmt, forever := body[1], body[2], body[3]
+	if while.Kind != parser.FOR || while.Init != nil || while.Cond == nil || while.Inc != nil || while.Then.Kind != parser.ASSIGN {
+		t.Errorf("unexpected while statement %+v", while)
+	}
+	if forStmt.Kind != parser.FOR || forStmt.Init == nil || forStmt.Cond == nil || forStmt.Inc == nil || forStmt.Then == nil {
+		t.Errorf("unexpected for statement %+v", forStmt)
+	}
+	if forever.Kind != parser.FOR || forever.Init != nil || forever.Cond != nil || forever.Inc != nil || forever.Then.Kind != parser.RETURN {
+		t.Errorf("unexpected for statement %+v", forever)
+	}
+	if forStmt.Cond.Ty != parser.TyInt {
+		t.Errorf("expected the condition to be typed, but got %v", forStmt.Cond.Ty)
+	}
+
+	for _, input := range []string{
+		"int main() { while 1 return 0; }",
+		"int main() { while (1) }",
+		"int main() { for (;) return 0; }",
+		"int main() { for (int i = 0; i < 1) return 0; }",
+	} {
+		tokens, err := lexer.NewLexer(input).Lex()
+		if err != nil {
+			t.Fatalf("lex error: %v", err)
+		}
+		if err := parser.NewParser(tokens, input).Parse(); err == nil {
+			t.Errorf("%s: expected error, but got none", input)
+		}
+	}
+}
+
+func TestParse_Undeclared(t *testing.T) {
+	tests := []struct {
+		input string
+		pos   int
+	}{
+		{"int main() { return x; }", 20},
+		{"int main() { int a = 1; b = a; return b; }", 24},
+		{"int main() { return sizeof(y); }", 27},
+		{"int g = h;", 8},
+		{"int main() { for (int i = 0; i < 3; i = i + 1) {} return i; }", 57},
+		{"int main() { { int a = 1; } return a; }", 35},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			tokens, err := lexer.NewLexer(tt.input).Lex()
+			if err != nil {
+				t.Fatalf("lex error: %v", err)
+			}
+			err = parser.NewParser(tokens, tt.input).Parse()
+			var perr *errors.PosError
+			if !stderrors.As(err, &perr) {
+				t.Fatalf("expected a located error, but got %v", err)
+			}
+			name := tt.input[tt.pos : tt.pos+1]
+			if perr.Message != "undeclared identifier "+name || perr.Pos != tt.pos {
+				t.Errorf("expected \"undeclared identifier %s\" at %d, but got %q at %d", name, tt.pos, perr.Message, perr.Pos)
+			}
+		})
+	}
+}
+
+func TestAddType_UsualArithmeticConversions(t *testing.T) {
+	lvar := func(ty *parser.Type) *parser.Node {
+		return &parser.Node{Kind: parser.LVAR, Offset: 8, Ty: ty}
+	}
+
+	tests := []struct {
+		name string
+		lhs  *parser.Type
+		rhs  *parser.Type
+		want *parser.Type
+	}{
+		{"char + char", parser.TyChar, parser.TyChar, parser.TyInt},
+		{"unsigned short + short", parser.TyUShort, parser.TyShort, parser.TyInt},
+		{"_Bool + _Bool", parser.TyBool, parser.TyBool, parser.TyInt},
+		{"int + unsigned int", parser.TyInt, parser.TyUInt, parser.TyUInt},
+		{"unsigned int + long", parser.TyUInt, parser.TyLong, parser.TyLong},
+		{"long + unsigned long", parser.TyLong, parser.TyULong, parser.TyULong},
+		{"unsigned char + unsigned int", parser.TyUChar, parser.TyUInt, parser.TyUInt},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			node := &parser.Node{Kind: parser.ADD, Lhs: lvar(tt.lhs), Rhs: lvar(tt.rhs)}
+			parser.AddType(node)
+			if node.Ty != tt.want {
+				t.Errorf("result type: got %+v, want %+v", node.Ty, tt.want)
+			}
+			if node.Lhs.Ty != tt.want || node.Rhs.Ty != tt.want {
+				t.Errorf("operands not converted: lhs %+v, rhs %+v", node.Lhs.Ty, node.Rhs.Ty)
+			}
+		})
+	}
+}
+
+func TestParse_Function(t *testing.T) {
+	input := "double half(double x); int main() { return half(3); } double half(double x) { return x / 2; }"
+	tokens, err := lexer.NewLexer(input).Lex()
+	if err != nil {
+		t.Fatalf("lex error: %v", err)
+	}
+	p := parser.NewParser(tokens, input)
+	if err := p.Parse(); err != nil {
+		t.Fatalf("parse error: %v", err)
+	}
+
+	if len(p.Funcs) != 2 {
+		t.Fatalf("got %d functions, want 2", len(p.Funcs))
+	}
+	half := p.Funcs[0]
+	if half.Name != "half" || !half.IsDefinition || len(half.Params) != 1 {
+		t.Errorf("declaration not replaced by definition: %+v", half)
+	}
+
+	// the argument is converted to the parameter type, and the result to
+	// the return type of main
+	call := p.Funcs[1].Body.Body[0].Lhs.Lhs
+	want := &parser.Node{Kind: parser.FUNCALL, FuncName: "half", Ty: parser.TyDouble, Args: []*parser.Node{
+		{Kind: parser.CAST, Ty: parser.TyDouble,
+			Lhs: &parser.Node{Kind: parser.NUM, Val: 3, Ty: parser.TyInt},
+		},
+	}}
+	if diff := cmp.Diff(want, call); diff != "" {
+		t.Errorf("AST mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestParse_FunctionErrors(t *testing.T) {
+	inputs := []string{
+		"int f(int a); long f(int a);",
+		"int f() { return 1; } int f() { return 2; }",
+		"int f(int a, int b); int main() { return f(1); }",
+		"void f() { return 1; }",
+		"int f() { return; }",
+		"int main() { return 0; } return 1;",
+	}
+
+	for _, input := range inputs {
+		t.Run(input, func(t *testing.T) {
+			tokens, err := lexer.NewLexer(input).Lex()
+			if err != nil {
+				t.Fatalf("lex error: %v", err)
+			}
+			if err := parser.NewParser(tokens, input).Parse(); err == nil {
+				t.Errorf("expected error, but got none")
+			}
+		})
+	}
+}
+
+func TestParse_CastAndPointer(t *testing.T) {
+	intPtr := &parser.Type{Kind: parser.TY_PTR, Size: 8, Align: 8, Unsigned: true, Base: parser.TyInt}
+	p := &parser.Node{Kind: parser.GVAR, Label: "p", Ty: intPtr}
+
+	tests := []struct {
+		name  string
+		input string
+		want  *parser.Node
+	}{
+		{
+			name:  "cast",
+			input: "(unsigned char)1;",
+			want: &parser.Node{Kind: parser.CAST, Ty: parser.TyUChar,
+				Lhs: &parser.Node{Kind: parser.NUM, Val: 1, Ty: parser.TyInt},
+			},
+		},
+		{
+			name:  "parenthesized expression",
+			input: "(1);",
+			want:  &parser.Node{Kind: parser.NUM, Val: 1, Ty: parser.TyInt},
+		},
+		{
+			name:  "pointer addition is scaled",
+			input: "int *p; 1 + p;",
+			want: &parser.Node{Kind: parser.ADD, Ty: intPtr,
+				Lhs: p,
+				Rhs: &parser.Node{Kind: parser.MUL, Ty: parser.TyLong,
+					Lhs: &parser.Node{Kind: parser.CAST, Ty: parser.TyLong,
+						Lhs: &parser.Node{Kind: parser.NUM, Val: 1, Ty: parser.TyInt},
+					},
+					Rhs: &parser.Node{Kind: parser.NUM, Val: 4, Ty: parser.TyLong},
+				},
+			},
+		},
+		{
+			name:  "dereference",
+			input: "int *p; *p;",
+			want:  &parser.Node{Kind: parser.DEREF, Ty: parser.TyInt, Lhs: p},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tokens, err := lexer.NewLexer(tt.input).Lex()
+			if err != nil {
+				t.Fatalf("lex error: %v", err)
+			}
+			p := parser.NewParser(tokens, tt.input)
+			if err := p.Parse(); err != nil {
+				t.Fatalf("parse error: %v", err)
+			}
+			if diff := cmp.Diff(tt.want, p.Code[len(p.Code)-1]); diff != "" {
+				t.Errorf("AST mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestParse_CastAndPointerErrors(t *testing.T) {
+	inputs := []string{
+		"int *p; (double)p;",
+		"double d; (int *)d;",
+		"(int x)1;",
+		"int x; *x;",
+		"int *p; p + p;",
+		"int *p; 1 - p;",
+		"int *p; p * 2;",
+		"void *p; *p;",
+	}
+
+	for _, input := range inputs {
+		t.Run(input, func(t *testing.T) {
+			tokens, err := lexer.NewLexer(input).Lex()
+			if err != nil {
+				t.Fatalf("lex error: %v", err)
+			}
+			if err := parser.NewParser(tokens, input).Parse(); err == nil {
+				t.Errorf("expected error, but got none")
+			}
+		})
+	}
+}
+
+func TestParse_Globals(t *testing.T) {
+	input := "static int a = 1 + 2; char b; extern long c; int *d = &a + 1; double e = 0.5; int f() { static short n; return n; }"
+	tokens, err := lexer.NewLexer(input).Lex()
+	if err != nil {
+		t.Fatalf("lex error: %v", err)
+	}
+	p := parser.NewParser(tokens, input)
+	if err := p.Parse(); err != nil {
+		t.Fatalf("parse error: %v", err)
+	}
+
+	intPtr := &parser.Type{Kind: parser.TY_PTR, Size: 8, Align: 8, Unsigned: true, Base: parser.TyInt}
+	want := []*parser.Global{
+		{Name: "a", Ty: parser.TyInt, IsStatic: true, IsDefinition: true, InitData: []byte{3, 0, 0, 0}, Pos: 11},
+		{Name: "b", Ty: parser.TyPlainChar, IsDefinition: true, Pos: 27},
+		{Name: "c", Ty: parser.TyLong, Pos: 42},
+		{Name: "d", Ty: intPtr, IsDefinition: true, InitData: make([]byte, 8),
+			Relocs: []*parser.Reloc{{Offset: 0, Label: "a", Addend: 4}}, Pos: 50},
+		{Name: "e", Ty: parser.TyDouble, IsDefinition: true, InitData: []byte{0, 0, 0, 0, 0, 0, 0xe0, 0x3f}, Pos: 69},
+		{Name: "n.0", Ty: parser.TyShort, IsStatic: true, IsDefinition: true, Pos: 101},
+	}
+	if diff := cmp.Diff(want, p.Globals); diff != "" {
+		t.Errorf("globals mismatch (-want +got):\n%s", diff)
+	}
+	if len(p.Code) != 0 {
+		t.Errorf("expected no top-level code, but got %d statements", len(p.Code))
+	}
+}
+
+func TestParse_StorageClassErrors(t *testing.T) {
+	inputs := []string{
+		"static int x; int x;",
+		"int x; static int x;",
+		"int x = 1; int x = 2;",
+		"int x; long x;",
+		"int f(); int f;",
+		"int f(int static a);",
+		"(static int)1;",
+		"static extern int x;",
+		"int f(); static int f() { return 0; }",
+		"int main() { extern int x = 1; }",
+		"int f(); int main() { static int x = f(); }",
+		"int f(); int x = f(); int main() { return x; }",
+	}
+
+	for _, input := range inputs {
+		t.Run(input, func(t *testing.T) {
+			tokens, err := lexer.NewLexer(input).Lex()
+			if err != nil {
+				t.Fatalf("lex error: %v", err)
+			}
+			if err := parser.NewParser(tokens, input).Parse(); err == nil {
+				t.Errorf("expected error, but got none")
+			}
+		})
+	}
+}
+
+func TestParse_GlobalInitializers(t *testing.T) {
+	input := `struct P { char x; int y; }; struct P s = {.y = 2, .x = 1}; int a[] = {1, [3] = 4}; char c[4] = "ab"; char *p = "hi";`
+	tokens, err := lexer.NewLexer(input).Lex()
+	if err != nil {
+		t.Fatalf("lex error: %v", err)
+	}
+	p := parser.NewParser(tokens, input)
+	if err := p.Parse(); err != nil {
+		t.Fatalf("parse error: %v", err)
+	}
+
+	type global struct {
+		Name     string
+		Size     int
+		InitData []byte
+		Relocs   []*parser.Reloc
+		Pos      int
+	}
+	want := []global{
+		{Name: "s", Size: 8, InitData: []byte{1, 0, 0, 0, 2, 0, 0, 0}, Pos: 38},
+		{Name: "a", Size: 16, InitData: []byte{1, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 4, 0, 0, 0}, Pos: 64},
+		{Name: "c", Size: 4, InitData: []byte{'a', 'b', 0, 0}, Pos: 89},
+		{Name: "p", Size: 8, InitData: make([]byte, 8),
+			Relocs: []*parser.Reloc{{Offset: 0, Label: ".L.str.0", Addend: 0}}, Pos: 108},
+		{Name: ".L.str.0", Size: 3, InitData: []byte{'h', 'i', 0}},
+	}
+	var got []global
+	for _, g := range p.Globals {
+		got = append(got, global{g.Name, g.Ty.Size, g.InitData, g.Relocs, g.Pos})
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("globals mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestParse_LocalInitializers(t *testing.T) {
+	input := "int main() { int a[3] = {1, [2] = 5}; }"
+	tokens, err := lexer.NewLexer(input).Lex()
+	if err != nil {
+		t.Fatalf("lex error: %v", err)
+	}
+	p := parser.NewParser(tokens, input)
+	if err := p.Parse(); err != nil {
+		t.Fatalf("parse error: %v", err)
+	}
+
+	a := p.Funcs[0].Locals
+	body := p.Funcs[0].Body.Body[0].Body
+	if len(body) != 3 {
+		t.Fatalf("expected 3 statements, but got %d", len(body))
+	}
+	if body[0].Kind != parser.MEMZERO || body[0].Var != a || body[0].Ty.Size != 12 {
+		t.Errorf("expected MEMZERO of the 12 bytes of a, but got %+v", body[0])
+	}
+	for i, want := range []struct{ offset, val int }{{0, 1}, {8, 5}} {
+		node := body[i+1]
+		if node.Kind != parser.ASSIGN || node.Lhs.Kind != parser.LVAR || node.Lhs.Var != a || node.Lhs.Offset != want.offset {
+			t.Errorf("statement %d: expected assignment to offset %d, but got %+v", i+1, want.offset, node)
+			continue
+		}
+		if v, ok := constValue(node.Rhs); !ok || v != want.val {
+			t.Errorf("statement %d: expected value %d", i+1, want.val)
+		}
+	}
+}
+
+// constValue looks through casts for a number.
+func constValue(node *parser.Node) (int, bool) {
+	for node.Kind == parser.CAST {
+		node = node.Lhs
+	}
+	return node.Val, node.Kind == parser.NUM
+}
+
+func TestParse_InitializerErrors(t *testing.T) {
+	inputs := []string{
+		"int a[2] = {1, 2, 3};",
+		"int a[2] = {[2] = 1};",
+		"struct { int x; } s = {1, 2};",
+		"struct { int x; } s = {.y = 1};",
+		"int a[];",
+		"int main() { int a[]; }",
+		"int x; int a[x];",
+		"int a[2] = 1;",
+		"int x = {1, 2};",
+		"struct P { int x; } p = 1;",
+		"struct P { int x; }; struct P { int y; };",
+		"struct Q q;",
+		"struct P { struct P p; };",
+		`int *p = "abc" + 1.0;`,
+		"int main() { int x; return x.y; }",
+		"struct P { int x; }; int f(struct P p);",
+		"struct P { int x; }; struct P f();",
+		"int main() { struct { int x; } a, b; return a == b; }",
+		"int main() { int a[2]; return a < 1.0; }",
+		"int main() { struct { int x; } a; return a; }",
+	}
+
+	for _, input := range inputs {
+		t.Run(input, func(t *testing.T) {
+			tokens, err := lexer.NewLexer(input).Lex()
+			if err != nil {
+				t.Fatalf("lex error: %v", err)
+			}
+			if err := parser.NewParser(tokens, input).Parse(); err == nil {
+				t.Errorf("expected error, but got none")
+			}
+		})
+	}
+}
+
+func TestParse_Variadic(t *testing.T) {
+	input := "int printf(char *fmt, ...); int f(int n, ...) { va_list ap; va_start(ap, n); return va_arg(ap, int); } int main() { return printf(\"%f\", 1.5f); }"
+	tokens, err := lexer.NewLexer(input).Lex()
+	if err != nil {
+		t.Fatalf("lex error: %v", err)
+	}
+	p := parser.NewParser(tokens, input)
+	if err := p.Parse(); err != nil {
+		t.Fatalf("parse error: %v", err)
+	}
+
+	printf, f, main := p.Funcs[0], p.Funcs[1], p.Funcs[2]
+	if !printf.Ty.IsVariadic || len(printf.Ty.Params) != 1 || printf.VaArea != nil {
+		t.Errorf("printf: expected a variadic declaration with 1 parameter, but got %+v", printf.Ty)
+	}
+	if !f.Ty.IsVariadic || f.VaArea == nil {
+		t.Fatalf("f: expected a variadic definition with a register save area")
+	}
+
+	start := f.Body.Body[1]
+	if start.Kind != parser.VA_START || start.Var != f.VaArea {
+		t.Errorf("expected va_start from the register save area, but got %+v", start)
+	}
+	if arg := f.Body.Body[2].Lhs; arg.Kind != parser.VA_ARG || arg.Ty != parser.TyInt {
+		t.Errorf("expected va_arg of int, but got %+v", arg)
+	}
+
+	// variadic arguments get the default argument promotions
+	call := main.Body.Body[0].Lhs
+	if got := call.Args[1].Ty; got != parser.TyDouble {
+		t.Errorf("expected float argument promoted to double, but got %+v", got)
+	}
+}
+
+func TestParse_AArch64(t *testing.T) {
+	input := "int f(int n, ...) { va_list ap; va_start(ap, n); long x; asm(\"add %x0, %x0, %w1\" : \"+r\"(x) : \"r\"(n) : \"w19\", \"v8\", \"x19\", \"q2\", \"memory\"); return sizeof(ap); }"
+	tokens, err := lexer.NewLexer(input).Lex()
+	if err != nil {
+		t.Fatalf("lex error: %v", err)
+	}
+	p := parser.NewParser(tokens, input)
+	p.Arch = parser.ARCH_AARCH64
+	if err := p.Parse(); err != nil {
+		t.Fatalf("parse error: %v", err)
+	}
+
+	f := p.Funcs[0]
+	// the va_list header, x0-x7 and q0-q7
+	if got := f.VaArea.Ty.Size; got != 224 {
+		t.Errorf("expected a register save area of 224 bytes, but got %d", got)
+	}
+	ap := f.Locals
+	for ap != nil && ap.Name != "ap" {
+		ap = ap.Next
+	}
+	if ap == nil || ap.Ty.Size != 32 {
+		t.Fatalf("expected a va_list of 32 bytes, but got %+v", ap)
+	}
+
+	asm := f.Body.Body[3].Asm
+	if diff := cmp.Diff([]string{"x19", "d8"}, asm.Clobbers); diff != "" {
+		t.Errorf("clobbers mismatch (-want +got):\n%s", diff)
+	}
+	if asm.Template[1].Modifier != 'x' || asm.Template[5].Modifier != 'w' {
+		t.Errorf("expected the x and w modifiers, but got %+v", asm.Template)
+	}
+
+	for _, input := range []string{
+		"int main() { int x; asm(\"mov %k0, 1\" : \"=r\"(x)); return 0; }",
+		"int main() { asm(\"\" : : : \"x29\"); return 0; }",
+		"int main() { asm(\"\" : : : \"rax\"); return 0; }",
+	} {
+		tokens, err := lexer.NewLexer(input).Lex()
+		if err != nil {
+			t.Fatalf("lex error: %v", err)
+		}
+		p := parser.NewParser(tokens, input)
+		p.Arch = parser.ARCH_AARCH64
+		if err := p.Parse(); err == nil {
+			t.Errorf("%s: expected error, but got none", input)
+		}
+	}
+}
+
+func TestParse_RISCV64(t *testing.T) {
+	input := "int g(int n, ...); int f(int n, ...) { va_list ap; va_start(ap, n); long x; asm(\"add %0, %0, %1\" : \"+r\"(x) : \"r\"(n) : \"s1\", \"f8\", \"x9\", \"ft0\", \"memory\"); return g(1, 2.0, x) + sizeof(ap); }"
+	tokens, err := lexer.NewLexer(input).Lex()
+	if err != nil {
+		t.Fatalf("lex error: %v", err)
+	}
+	p := parser.NewParser(tokens, input)
+	p.Arch = parser.ARCH_RISCV64
+	if err := p.Parse(); err != nil {
+		t.Fatalf("parse error: %v", err)
+	}
+
+	f := p.Funcs[1]
+	// only the pointer va_start copies, as a0-a7 are saved by the callee
+	if got := f.VaArea.Ty.Size; got != 8 {
+		t.Errorf("expected a register save area of 8 bytes, but got %d", got)
+	}
+	ap := f.Locals
+	for ap != nil && ap.Name != "ap" {
+		ap = ap.Next
+	}
+	if ap == nil || ap.Ty.Kind != parser.TY_PTR {
+		t.Fatalf("expected a pointer va_list, but got %+v", ap)
+	}
+
+	asm := f.Body.Body[3].Asm
+	if diff := cmp.Diff([]string{"s1", "fs0"}, asm.Clobbers); diff != "" {
+		t.Errorf("clobbers mismatch (-want +got):\n%s", diff)
+	}
+	call := f.Body.Body[4].Lhs.Lhs
+	for call != nil && call.Kind != parser.FUNCALL {
+		call = call.Lhs
+	}
+	if call == nil || call.Fixed != 1 {
+		t.Errorf("expected a call with one named argument, but got %+v", call)
+	}
+
+	for _, input := range []string{
+		"int main() { int x; asm(\"mv %w0, zero\" : \"=r\"(x)); return 0; }",
+		"int main() { asm(\"\" : : : \"sp\"); return 0; }",
+		"int main() { asm(\"\" : : : \"fp\"); return 0; }",
+		"int main() { asm(\"\" : : : \"rax\"); return 0; }",
+	} {
+		tokens, err := lexer.NewLexer(input).Lex()
+		if err != nil {
+			t.Fatalf("lex error: %v", err)
+		}
+		p := parser.NewParser(tokens, input)
+		p.Arch = parser.ARCH_RISCV64
+		if err := p.Parse(); err == nil {
+			t.Errorf("%s: expected error, but got none", input)
+		}
+	}
+}
+
+func TestParse_PlainChar(t *testing.T) {
+	tests := []struct {
+		arch     parser.Arch
+		unsigned bool
+	}{
+		{parser.ARCH_X86_64, false},
+		{parser.ARCH_AARCH64, true},
+		{parser.ARCH_RISCV64, true},
+	}
+
+	input := "char c; signed char s; char *p = \"a\";"
+	for _, tt := range tests {
+		tokens, err := lexer.NewLexer(input).Lex()
+		if err != nil {
+			t.Fatalf("lex error: %v", err)
+		}
+		p := parser.NewParser(tokens, input)
+		p.Arch = tt.arch
+		if err := p.Parse(); err != nil {
+			t.Fatalf("parse error: %v", err)
+		}
+
+		types := map[string]*parser.Type{}
+		for _, g := range p.Globals {
+			types[g.Name] = g.Ty
+		}
+		if types["c"].Unsigned != tt.unsigned || types[".L.str.0"].Base.Unsigned != tt.unsigned {
+			t.Errorf("arch %d: expected plain char and string literals to be unsigned: %v", tt.arch, tt.unsigned)
+		}
+		if types["s"].Unsigned {
+			t.Errorf("arch %d: expected signed char to be signed", tt.arch)
+		}
+	}
+}
+
+func TestParse_VariadicErrors(t *testing.T) {
+	inputs := []string{
+		"int f(...);",
+		"int f(int a, ..., int b);",
+		"int f(int a, ...); int f(int a);",
+		"int f(int n) { va_list ap; va_start(ap, n); }",
+		"int f(int n, ...) { int x; va_start(x, n); }",
+		"int f(int n, ...) { va_list ap; return va_arg(ap, float); }",
+		"struct S { int x; }; int f(int n, ...) { va_list ap; va_arg(ap, struct S); }",
+		"va_list int ap;",
+	}
+
+	for _, input := range inputs {
+		t.Run(input, func(t *testing.T) {
+			tokens, err := lexer.NewLexer(input).Lex()
+			if err != nil {
+				t.Fatalf("lex error: %v", err)
+			}
+			if err := parser.NewParser(tokens, input).Parse(); err == nil {
+				t.Errorf("expected error, but got none")
+			}
+		})
+	}
+}
+
+func TestParse_Declarators(t *testing.T) {
+	tests := []struct {
+		input string
+		want  []parser.TypeKind // kinds from the outermost type inwards
+	}{
+		{"int *a[3];", []parser.TypeKind{parser.TY_ARRAY, parser.TY_PTR, parser.TY_INT}},
+		{"int (*a)[3];", []parser.TypeKind{parser.TY_PTR, parser.TY_ARRAY, parser.TY_INT}},
+		{"int (*a)(int);", []parser.TypeKind{parser.TY_PTR, parser.TY_FUNC, parser.TY_INT}},
+		{"int (*a[2])(int);", []parser.TypeKind{parser.TY_ARRAY, parser.TY_PTR, parser.TY_FUNC, parser.TY_INT}},
+		{"char *(*(*a)(void))[4];", []parser.TypeKind{parser.TY_PTR, parser.TY_FUNC, parser.TY_PTR, parser.TY_ARRAY, parser.TY_PTR, parser.TY_CHAR}},
+		{"long ((a));", []parser.TypeKind{parser.TY_LONG}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			tokens, err := lexer.NewLexer(tt.input).Lex()
+			if err != nil {
+				t.Fatalf("lex error: %v", err)
+			}
+			p := parser.NewParser(tokens, tt.input)
+			if err := p.Parse(); err != nil {
+				t.Fatalf("parse error: %v", err)
+			}
+
+			var got []parser.TypeKind
+			for ty := p.Globals[0].Ty; ty != nil; {
+				got = append(got, ty.Kind)
+				if ty.Kind == parser.TY_FUNC {
+					ty = ty.ReturnTy
+				} else {
+					ty = ty.Base
+				}
+			}
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				t.Errorf("type mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestParse_FunctionPointer(t *testing.T) {
+	input := "int add(int a, int b) { return a + b; } int main() { int (*fp)(int, int) = add; return fp(1, 2); }"
+	tokens, err := lexer.NewLexer(input).Lex()
+	if err != nil {
+		t.Fatalf("lex error: %v", err)
+	}
+	p := parser.NewParser(tokens, input)
+	if err := p.Parse(); err != nil {
+		t.Fatalf("parse error: %v", err)
+	}
+
+	add := p.Funcs[0]
+	if len(add.Params) != 2 || add.Params[0].Name != "a" || add.Params[1].Name != "b" {
+		t.Errorf("expected parameters a and b, but got %+v", add.Params)
+	}
+
+	// the function decays to a pointer, which is called indirectly
+	rhs := p.Funcs[1].Body.Body[0].Body[0].Rhs
+	for rhs.Kind == parser.CAST {
+		rhs = rhs.Lhs
+	}
+	if rhs.Kind != parser.ADDR || rhs.Lhs.Kind != parser.GVAR || rhs.Lhs.Label != "add" {
+		t.Errorf("expected the address of add, but got %+v", rhs)
+	}
+	call := p.Funcs[1].Body.Body[1].Lhs
+	if call.Kind != parser.FUNCALL || call.FuncName != "" || call.Lhs.Kind != parser.LVAR || len(call.Args) != 2 {
+		t.Errorf("expected an indirect call through fp, but got %+v", call)
+	}
+}
+
+func TestParse_DeclaratorErrors(t *testing.T) {
+	inputs := []string{
+		"int f(int)[3];",
+		"int f(int)(int);",
+		"int a[2](int);",
+		"int (*fp)(int, int); int main() { return fp(1); }",
+		"int main() { int x; return x(1); }",
+		"int f(int a, int a) { return a; }",
+		"int (*fp)(int) { return 0; }",
+		"int main() { static int f(int); }",
+		"int (x;",
+	}
+
+	for _, input := range inputs {
+		t.Run(input, func(t *testing.T) {
+			tokens, err := lexer.NewLexer(input).Lex()
+			if err != nil {
+				t.Fatalf("lex error: %v", err)
+			}
+			if err := parser.NewParser(tokens, input).Parse(); err == nil {
+				t.Errorf("expected error, but got none")
+			}
+		})
+	}
+}
+
+func TestParse_Qualifiers(t *testing.T) {
+	type quals struct{ Const, Volatile, Restrict bool }
+	tests := []struct {
+		input string
+		want  []quals // qualifiers from the outermost type inwards
+	}{
+		{"const int a;", []quals{{Const: true}}},
+		{"int const volatile a;", []quals{{Const: true, Volatile: true}}},
+		{"const char *a;", []quals{{}, {Const: true}}},
+		{"char *const a;", []quals{{Const: true}, {}}},
+		{"int *restrict *volatile a;", []quals{{Volatile: true}, {Restrict: true}, {}}},
+		{"const int a[2];", []quals{{}, {Const: true}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			tokens, err := lexer.NewLexer(tt.input).Lex()
+			if err != nil {
+				t.Fatalf("lex error: %v", err)
+			}
+			p := parser.NewParser(tokens, tt.input)
+			if err := p.Parse(); err != nil {
+				t.Fatalf("parse error: %v", err)
+			}
+
+			var got []quals
+			for ty := p.Globals[0].Ty; ty != nil; ty = ty.Base {
+				got = append(got, quals{ty.IsConst, ty.IsVolatile, ty.IsRestrict})
+			}
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				t.Errorf("qualifier mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestParse_QualifierWarnings(t *testing.T) {
+	tests := []struct {
+		input string
+		want  int
+	}{
+		{"int main() { int x; const int *p = &x; return *p; }", 0},
+		{"int main() { const int x = 1; int *p = &x; return *p; }", 1},
+		{"int f(char *s); int main() { const char *s = \"a\"; return f(s); }", 1},
+		{"int main() { volatile int x; const int *p; p = &x; return 0; }", 1},
+		{"int main() { const int x = 1; int *p = (int *)&x; return *p; }", 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			tokens, err := lexer.NewLexer(tt.input).Lex()
+			if err != nil {
+				t.Fatalf("lex error: %v", err)
+			}
+			p := parser.NewParser(tokens, tt.input)
+			if err := p.Parse(); err != nil {
+				t.Fatalf("parse error: %v", err)
+			}
+			if len(p.Warnings) != tt.want {
+				t.Errorf("expected %d warnings, but got %v", tt.want, p.Warnings)
+			}
+		})
+	}
+}
+
+func TestParse_QualifierErrors(t *testing.T) {
+	inputs := []string{
+		"restrict int x;",
+		"int f(const int *p); int f(int *p) { return 0; }",
+	}
+
+	for _, input := range inputs {
+		t.Run(input, func(t *testing.T) {
+			tokens, err := lexer.NewLexer(input).Lex()
+			if err != nil {
+				t.Fatalf("lex error: %v", err)
+			}
+			if err := parser.NewParser(tokens, input).Parse(); err == nil {
+				t.Errorf("expected error, but got none")
+			}
+		})
+	}
+}
+
+func TestParse_C11(t *testing.T) {
+	tests := []struct {
+		input string
+		want  int
+	}{
+		{"_Static_assert(1, \"ok\"); int main() { return 1; }", 1},
+		{"int main() { _Static_assert(2 > 1, \"ok\"); return 2; }", 2},
+		{"int main() { return _Alignof(long); }", 8},
+		{"int main() { return _Alignof(struct { char c; double d; }); }", 8},
+		{"int main() { return _Alignof(char[3]); }", 1},
+		{"int main() { long x; return _Generic(x, int: 1, long: 2, default: 3); }", 2},
+		{"int main() { char a[2]; return _Generic(a, char *: 4, default: 5); }", 4},
+		{"int main() { const int x = 0; return _Generic(x, int: 6, default: 7); }", 6},
+		{"int main() { return _Generic(1.0, float: 8, default: 9); }", 9},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			tokens, err := lexer.NewLexer(tt.input).Lex()
+			if err != nil {
+				t.Fatalf("lex error: %v", err)
+			}
+			p := parser.NewParser(tokens, tt.input)
+			if err := p.Parse(); err != nil {
+				t.Fatalf("parse error: %v", err)
+			}
+
+			body := p.Funcs[len(p.Funcs)-1].Body.Body
+			ret := body[len(body)-1].Lhs
+			for ret.Kind == parser.CAST {
+				ret = ret.Lhs
+			}
+			if ret.Kind != parser.NUM || ret.Val != tt.want {
+				t.Errorf("expected %d, but got %+v", tt.want, ret)
+			}
+		})
+	}
+}
+
+func TestParse_Alignas(t *testing.T) {
+	input := "_Alignas(32) int g; struct S { char c; _Alignas(8) char d; }; int main() { char a; _Alignas(16) char b; struct S s; return 0; }"
+	tokens, err := lexer.NewLexer(input).Lex()
+	if err != nil {
+		t.Fatalf("lex error: %v", err)
+	}
+	p := parser.NewParser(tokens, input)
+	if err := p.Parse(); err != nil {
+		t.Fatalf("parse error: %v", err)
+	}
+
+	if p.Globals[0].Align != 32 {
+		t.Errorf("expected g to be aligned to 32, but got %d", p.Globals[0].Align)
+	}
+	locals := p.Funcs[0].Locals
+	st := locals.Ty
+	if st.Size != 16 || st.Align != 8 || st.Members[1].Offset != 8 {
+		t.Errorf("expected the second member at offset 8 of a 16 byte struct, but got %+v", st)
+	}
+	if b := locals.Next; b.Align != 16 {
+		t.Errorf("expected b to be aligned to 16, but got %d", b.Align)
+	}
+}
+
+func TestParse_C11Errors(t *testing.T) {
+	inputs := []string{
+		"_Static_assert(0, \"fails\");",
+		"int main() { _Static_assert(1 - 1, \"fails\"); return 0; }",
+		"int main() { int x = 1; _Static_assert(x, \"not constant\"); return 0; }",
+		"_Static_assert(1);",
+		"struct S { _Static_assert(0, \"fails\"); int x; };",
+		"_Alignas(3) int x;",
+		"_Alignas(1) int x;",
+		"_Alignas(8) int f(void);",
+		"int main() { _Alignas(32) int x; return 0; }",
+		"int main() { return (_Alignas(8) int)1; }",
+		"struct S { static int x; };",
+		"int main() { return _Alignof(void (int)); }",
+		"int main() { return _Generic(1, long: 1); }",
+		"int main() { return _Generic(1, int: 1, int: 2); }",
+		"int main() { return _Generic(1, default: 1, default: 2); }",
+		"int main() { return _Generic(1, 2: 1); }",
+	}
+
+	for _, input := range inputs {
+		t.Run(input, func(t *testing.T) {
+			tokens, err := lexer.NewLexer(input).Lex()
+			if err != nil {
+				t.Fatalf("lex error: %v", err)
+			}
+			if err := parser.NewParser(tokens, input).Parse(); err == nil {
+				t.Errorf("expected error, but got none")
+			}
+		})
+	}
+}
+
+func TestParse_Sizeof(t *testing.T) {
+	tests := []struct {
+		input string
+		want  int
+	}{
+		{"int main() { return sizeof(int); }", 4},
+		{"int main() { return sizeof(char *[3]); }", 24},
+		{"int main() { long x; return sizeof x; }", 8},
+		{"int main() { int a[5]; return sizeof a; }", 20},
+		{"int main() { int a[2][3]; return sizeof a[1]; }", 12},
+		{"int main() { return sizeof \"abc\"; }", 4},
+		{"struct P { char c; int x; }; int main() { return sizeof(struct P); }", 8},
+		{"int main() { return sizeof (int[]){1, 2, 3}; }", 12},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			tokens, err := lexer.NewLexer(tt.input).Lex()
+			if err != nil {
+				t.Fatalf("lex error: %v", err)
+			}
+			p := parser.NewParser(tokens, tt.input)
+			if err := p.Parse(); err != nil {
+				t.Fatalf("parse error: %v", err)
+			}
+
+			body := p.Funcs[len(p.Funcs)-1].Body.Body
+			ret := body[len(body)-1].Lhs
+			for ret.Kind == parser.CAST {
+				ret = ret.Lhs
+			}
+			if ret.Kind != parser.NUM || ret.Val != tt.want {
+				t.Errorf("expected %d, but got %+v", tt.want, ret)
+			}
+		})
+	}
+}
+
+func TestParse_CompoundLiteral(t *testing.T) {
+	input := "int *g = (int[]){1, 2}; int main() { int x = 3; return (int){x}; }"
+	tokens, err := lexer.NewLexer(input).Lex()
+	if err != nil {
+		t.Fatalf("lex error: %v", err)
+	}
+	p := parser.NewParser(tokens, input)
+	if err := p.Parse(); err != nil {
+		t.Fatalf("parse error: %v", err)
+	}
+
+	// at file scope, the literal is an anonymous global
+	lit := p.Globals[1]
+	if lit.Ty.Kind != parser.TY_ARRAY || lit.Ty.ArrayLen != 2 || !lit.IsStatic {
+		t.Errorf("expected a static array of 2 elements, but got %+v", lit)
+	}
+	if len(p.Globals[0].Relocs) != 1 || p.Globals[0].Relocs[0].Label != lit.Name {
+		t.Errorf("expected g to point to %s, but got %+v", lit.Name, p.Globals[0].Relocs)
+	}
+
+	// in a function, it is a local initialized where it appears
+	ret := p.Funcs[0].Body.Body[1].Lhs
+	if ret.Kind != parser.COMMA || ret.Rhs.Kind != parser.LVAR || ret.Lhs.Kind != parser.BLOCK {
+		t.Errorf("expected an initialized anonymous local, but got %+v", ret)
+	}
+}
+
+func TestParse_VLA(t *testing.T) {
+	input := "int main() { int n = 2; long a[n][n + 1]; return sizeof a; }"
+	tokens, err := lexer.NewLexer(input).Lex()
+	if err != nil {
+		t.Fatalf("lex error: %v", err)
+	}
+	p := parser.NewParser(tokens, input)
+	if err := p.Parse(); err != nil {
+		t.Fatalf("parse error: %v", err)
+	}
+
+	// the sizes of both dimensions are computed before the allocation
+	decl := p.Funcs[0].Body.Body[1].Body
+	kinds := []parser.NodeKind{}
+	for _, n := range decl {
+		kinds = append(kinds, n.Kind)
+	}
+	want := []parser.NodeKind{parser.ASSIGN, parser.ASSIGN, parser.VLA_ALLOC}
+	if diff := cmp.Diff(want, kinds); diff != "" {
+		t.Errorf("declaration mismatch (-want +got):\n%s", diff)
+	}
+
+	a := p.Funcs[0].Locals
+	if a.Ty.Kind != parser.TY_VLA || a.Ty.Base.Kind != parser.TY_VLA || a.Ty.VlaSize == nil {
+		t.Errorf("expected a variable length array of them, but got %+v", a.Ty)
+	}
+
+	// sizeof reads the size computed at runtime
+	ret := p.Funcs[0].Body.Body[2].Lhs
+	for ret.Kind == parser.CAST {
+		ret = ret.Lhs
+	}
+	if ret.Kind != parser.LVAR || ret.Var != a.Ty.VlaSize {
+		t.Errorf("expected the runtime size of a, but got %+v", ret)
+	}
+}
+
+func TestParse_VLAErrors(t *testing.T) {
+	inputs := []string{
+		"int n = 3; int a[n];",
+		"int main() { int n = 3; static int a[n]; return 0; }",
+		"int main() { int n = 3; int a[n] = {1}; return 0; }",
+		"int main() { int n = 3; struct S { int a[n]; }; return 0; }",
+		"int f(int n, int (*a)[n]) { return 0; }",
+		"int main() { int n = 3; return (int (*)[n])0 == 0; }",
+		"int main() { double d = 2; int a[d]; return 0; }",
+		"int main() { return sizeof(void); }",
+		"int main() { return sizeof(int (int)); }",
+		"struct S; int main() { return sizeof(struct S); }",
+		"int main() { return (void){1}; }",
+	}
+
+	for _, input := range inputs {
+		t.Run(input, func(t *testing.T) {
+			tokens, err := lexer.NewLexer(input).Lex()
+			if err != nil {
+				t.Fatalf("lex error: %v", err)
+			}
+			if err := parser.NewParser(tokens, input).Parse(); err == nil {
+				t.Errorf("expected error, but got none")
+			}
+		})
+	}
+}
+
+func TestParse_Bitfields(t *testing.T) {
+	input := "struct S { char a; short b : 9; char c : 7; long d : 40; unsigned e : 31; int : 3; int f : 5; } g = {1, 2, 3, 4, 5, 6}; struct T { char c; int : 4; }; int main() { return sizeof(struct T); }"
+	tokens, err := lexer.NewLexer(input).Lex()
+	if err != nil {
+		t.Fatalf("lex error: %v", err)
+	}
+	p := parser.NewParser(tokens, input)
+	if err := p.Parse(); err != nil {
+		t.Fatalf("parse error: %v", err)
+	}
+
+	// laid out as by the x86-64 System V ABI
+	g := p.Globals[0]
+	if g.Ty.Size != 24 || g.Ty.Align != 8 {
+		t.Errorf("expected a 24 byte struct aligned to 8, but got size %d and alignment %d", g.Ty.Size, g.Ty.Align)
+	}
+	want := []struct{ offset, bitOffset, width int }{
+		{0, 0, 0}, {2, 0, 9}, {3, 1, 7}, {8, 0, 40}, {16, 0, 31}, {20, 3, 5},
+	}
+	if len(g.Ty.Members) != len(want) {
+		t.Fatalf("expected %d members, but got %d", len(want), len(g.Ty.Members))
+	}
+	for i, w := range want {
+		m := g.Ty.Members[i]
+		if m.Offset != w.offset || m.BitOffset != w.bitOffset || m.BitWidth != w.width || m.IsBitfield != (w.width > 0) {
+			t.Errorf("member %s: expected %+v, but got %+v", m.Name, w, m)
+		}
+	}
+
+	// the bits of each member are merged into the initial data
+	data := []byte{1, 0, 2, 6, 0, 0, 0, 0, 4, 0, 0, 0, 0, 0, 0, 0, 5, 0, 0, 0, 6 << 3, 0, 0, 0}
+	if diff := cmp.Diff(data, g.InitData); diff != "" {
+		t.Errorf("initial data mismatch (-want +got):\n%s", diff)
+	}
+
+	// an unnamed bit-field does not affect the alignment
+	body := p.Funcs[0].Body.Body
+	ret := body[len(body)-1].Lhs
+	for ret.Kind == parser.CAST {
+		ret = ret.Lhs
+	}
+	if ret.Kind != parser.NUM || ret.Val != 2 {
+		t.Errorf("expected a size of 2, but got %+v", ret)
+	}
+}
+
+func TestParse_BitfieldErrors(t *testing.T) {
+	inputs := []string{
+		"struct S { int a : -1; };",
+		"struct S { int a : 33; };",
+		"struct S { _Bool a : 2; };",
+		"struct S { int a : 0; };",
+		"struct S { float a : 3; };",
+		"struct S { int *p : 3; };",
+		"struct S { double : 3; };",
+		"struct S { _Alignas(8) int a : 3; };",
+		"struct S { int a : 3; } s; int main() { return sizeof s.a; }",
+	}
+
+	for _, input := range inputs {
+		t.Run(input, func(t *testing.T) {
+			tokens, err := lexer.NewLexer(input).Lex()
+			if err != nil {
+				t.Fatalf("lex error: %v", err)
+			}
+			if err := parser.NewParser(tokens, input).Parse(); err == nil {
+				t.Errorf("expected error, but got none")
+			}
+		})
+	}
+}
+
+func TestParse_Inline(t *testing.T) {
+	tests := []struct {
+		input    string
+		want     parser.InlineKind
+		warnings int
+	}{
+		{"int f() { return 0; }", parser.INLINE_DEFAULT, 0},
+		{"inline int f() { return 0; }", parser.INLINE_HINT, 0},
+		{"static __inline__ int f() { return 0; }", parser.INLINE_HINT, 0},
+		{"static inline __attribute__((always_inline)) int f() { return 0; }", parser.INLINE_ALWAYS, 0},
+		{"__attribute__((noinline, unused)) int f() { return 0; }", parser.INLINE_NEVER, 1},
+		{"__attribute__((aligned(8), noinline)) int f() { return 0; }", parser.INLINE_NEVER, 1},
+		{"int __attribute__(()) f() { return 0; }", parser.INLINE_DEFAULT, 0},
+		// the definition keeps what an earlier declaration asked
+		{"__attribute__((noinline)) int f(); int f() { return 0; }", parser.INLINE_NEVER, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			tokens, err := lexer.NewLexer(tt.input).Lex()
+			if err != nil {
+				t.Fatalf("lex error: %v", err)
+			}
+			p := parser.NewParser(tokens, tt.input)
+			if err := p.Parse(); err != nil {
+				t.Fatalf("parse error: %v", err)
+			}
+			if got := p.Funcs[0].Inline; got != tt.want {
+				t.Errorf("expected inline kind %d, but got %d", tt.want, got)
+			}
+			if len(p.Warnings) != tt.warnings {
+				t.Errorf("expected %d warnings, but got %v", tt.warnings, p.Warnings)
+			}
+		})
+	}
+}
+
+func TestParse_InlineDef(t *testing.T) {
+	tests := []struct {
+		input string
+		want  bool
+	}{
+		{"inline int f() { return 0; }", true},
+		{"inline int f(); inline int f() { return 0; }", true},
+		{"int f() { return 0; }", false},
+		{"static inline int f() { return 0; }", false},
+		{"extern inline int f() { return 0; }", false},
+		{"__attribute__((always_inline)) int f() { return 0; }", false},
+		// any declaration without inline or with extern makes the
+		// definition external, even after it
+		{"extern int f(); inline int f() { return 0; }", false},
+		{"inline int f() { return 0; } int f();", false},
+		{"inline int f() { return 0; } extern inline int f();", false},
+		// but not one in a block
+		{"inline int f() { return 0; } int g() { int f(); return f(); }", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			tokens, err := lexer.NewLexer(tt.input).Lex()
+			if err != nil {
+				t.Fatalf("lex error: %v", err)
+			}
+			p := parser.NewParser(tokens, tt.input)
+			if err := p.Parse(); err != nil {
+				t.Fatalf("parse error: %v", err)
+			}
+			if got := p.Funcs[0].IsInlineDef; got != tt.want {
+				t.Errorf("expected IsInlineDef = %v, but got %v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestParse_InlineErrors(t *testing.T) {
+	inputs := []string{
+		"inline int x;",
+		"int main() { inline int x = 1; return x; }",
+		"__attribute__((noinline)) int x;",
+		"struct S { inline int a; };",
+		"int f(inline int a) { return a; }",
+		"__attribute__((noinline) int f() { return 0; }",
+		"__attribute__((1)) int f() { return 0; }",
+	}
+
+	for _, input := range inputs {
+		t.Run(input, func(t *testing.T) {
+			tokens, err := lexer.NewLexer(input).Lex()
+			if err != nil {
+				t.Fatalf("lex error: %v", err)
+			}
+			if err := parser.NewParser(tokens, input).Parse(); err == nil {
+				t.Errorf("expected error, but got none")
+			}
+		})
+	}
+}
+
+func TestParse_Asm(t *testing.T) {
+	input := "int main() { int x = 1; long y; asm volatile(\"lea %[out], [%1 + %c2]\\n\" \"add %k0, %%eax\" : [out] \"=r\"(y), \"+m\"(x) : \"i\"(3), \"g\"(x) : \"eax\", \"rax\", \"memory\"); asm(\"nop %0\"); return 0; }"
+	tokens, err := lexer.NewLexer(input).Lex()
+	if err != nil {
+		t.Fatalf("lex error: %v", err)
+	}
+	p := parser.NewParser(tokens, input)
+	if err := p.Parse(); err != nil {
+		t.Fatalf("parse error: %v", err)
+	}
+
+	body := p.Funcs[0].Body.Body
+	asm := body[2].Asm
+	if body[2].Kind != parser.ASM || asm == nil {
+		t.Fatalf("expected an asm statement, but got %+v", body[2])
+	}
+	wantOps := []struct {
+		constraint parser.AsmConstraint
+		inOut      bool
+	}{
+		{parser.ASM_REG, false}, {parser.ASM_MEM, true}, {parser.ASM_IMM, false}, {parser.ASM_REG, false},
+	}
+	ops := append(append([]*parser.AsmOperand{}, asm.Outputs...), asm.Inputs...)
+	if len(ops) != len(wantOps) {
+		t.Fatalf("expected %d operands, but got %d", len(wantOps), len(ops))
+	}
+	for i, w := range wantOps {
+		if ops[i].Constraint != w.constraint || ops[i].InOut != w.inOut {
+			t.Errorf("operand %d: expected %+v, but got %+v", i, w, ops[i])
+		}
+	}
+	if ops[2].Val != 3 {
+		t.Errorf("expected the immediate 3, but got %d", ops[2].Val)
+	}
+
+	want := []*parser.AsmPiece{
+		{Text: "lea ", Operand: -1},
+		{Operand: 0},
+		{Text: ", [", Operand: -1},
+		{Operand: 1},
+		{Text: " + ", Operand: -1},
+		{Operand: 2, Modifier: 'c'},
+		{Text: "]\nadd ", Operand: -1},
+		{Operand: 0, Modifier: 'k'},
+		{Text: ", %eax", Operand: -1},
+	}
+	if diff := cmp.Diff(want, asm.Template); diff != "" {
+		t.Errorf("template mismatch (-want +got):\n%s", diff)
+	}
+	if diff := cmp.Diff([]string{"rax"}, asm.Clobbers); diff != "" {
+		t.Errorf("clobbers mismatch (-want +got):\n%s", diff)
+	}
+
+	// basic asm is kept as written
+	basic := body[3].Asm
+	if diff := cmp.Diff([]*parser.AsmPiece{{Text: "nop %0", Operand: -1}}, basic.Template); diff != "" {
+		t.Errorf("template mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestParse_AsmErrors(t *testing.T) {
+	inputs := []string{
+		"int main() { asm(1); return 0; }",
+		"int main() { int x; asm(\"\" : \"r\"(x)); return 0; }",
+		"int main() { int x; asm(\"\" : : \"+r\"(x)); return 0; }",
+		"int main() { int x; asm(\"\" : \"=x\"(x)); return 0; }",
+		"int main() { double d; asm(\"\" : \"=r\"(d)); return 0; }",
+		"int main() { int x = 1; asm(\"\" : : \"i\"(x)); return 0; }",
+		"int main() { int x; asm(\"\" : : \"m\"(x + 1)); return 0; }",
+		"int main() { int x; asm(\"mov %1, 1\" : \"=r\"(x)); return 0; }",
+		"int main() { int x; asm(\"mov %[y], 1\" : [x] \"=r\"(x)); return 0; }",
+		"int main() { int x; asm(\"mov %z0, 1\" : \"=r\"(x)); return 0; }",
+		"int main() { int x; asm(\"mov %c0, 1\" : \"=r\"(x)); return 0; }",
+		"int main() { asm(\"\" : : : \"rsp\"); return 0; }",
+		"int main() { asm(\"\" : : : \"foo\"); return 0; }",
+	}
+
+	for _, input := range inputs {
+		t.Run(input, func(t *testing.T) {
+			tokens, err := lexer.NewLexer(input).Lex()
+			if err != nil {
+				t.Fatalf("lex error: %v", err)
+			}
+			if err := parser.NewParser(tokens, input).Parse(); err == nil {
+				t.Errorf("expected error, but got none")
+			}
+		})
+	}
+}
+
+func TestFold(t *testing.T) {
+	tests := []struct {
+		input string
+		kind  parser.NodeKind // of the returned expression
+		val   int             // of the returned number, or of the shift count
+	}{
+		{"int main() { return 1 + 2 * 3; }", parser.NUM, 7},
+		{"int main() { return (char)300; }", parser.NUM, 44},
+		{"int main() { return (unsigned char)-1 + 0; }", parser.NUM, 255},
+		{"int main() { return 2147483647 + 1; }", parser.NUM, -2147483648},
+		{"int main() { return -7 / 2; }", parser.NUM, -3},
+		{"int main() { return 3 < 4 == 1; }", parser.NUM, 1},
+		{"int main() { int x; return x * 1; }", parser.LVAR, 0},
+		{"int main() { int x; return 0 + x - 0; }", parser.LVAR, 0},
+		{"int main() { int x; return x / 1; }", parser.LVAR, 0},
+		{"int main() { int x; return x * 8; }", parser.SHL, 3},
+		{"int main() { long x; return 4 * x; }", parser.SHL, 2},
+		{"int main() { int x; return x * 3; }", parser.MUL, 0},
+		{"int main() { if (1 - 1) return 5; else return 6; }", parser.NUM, 6},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			tokens, err := lexer.NewLexer(tt.input).Lex()
+			if err != nil {
+				t.Fatalf("lex error: %v", err)
+			}
+			p := parser.NewParser(tokens, tt.input)
+			if err := p.Parse(); err != nil {
+				t.Fatalf("parse error: %v", err)
+			}
+			p.Fold()
+
+			body := p.Funcs[0].Body.Body
+			ret := body[len(body)-1]
+			if ret.Kind != parser.RETURN {
+				t.Fatalf("expected a return statement, but got %+v", ret)
+			}
+			expr := ret.Lhs
+			for expr.Kind == parser.CAST && tt.kind != parser.CAST {
+				expr = expr.Lhs
+			}
+			if expr.Kind != tt.kind {
+				t.Fatalf("expected kind %d, but got %+v", tt.kind, expr)
+			}
+			switch expr.Kind {
+			case parser.NUM:
+				if expr.Val != tt.val {
+					t.Errorf("expected %d, but got %d", tt.val, expr.Val)
+				}
+			case parser.SHL:
+				if expr.Rhs.Val != tt.val {
+					t.Errorf("expected a shift by %d, but got %d", tt.val, expr.Rhs.Val)
+				}
+			}
+		})
+	}
+}
+
+func TestParse_DivisionByZero(t *testing.T) {
+	tests := []struct {
+		input string
+		want  int
+	}{
+		{"int main() { return 1 / 0; }", 1},
+		{"int main() { int x; return x / (2 - 2); }", 1},
+		{"int main() { double x; return x / 0; }", 0},
+		{"int main() { int x; return x / 2; }", 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			tokens, err := lexer.NewLexer(tt.input).Lex()
+			if err != nil {
+				t.Fatalf("lex error: %v", err)
+			}
+			p := parser.NewParser(tokens, tt.input)
+			if err := p.Parse(); err != nil {
+				t.Fatalf("parse error: %v", err)
+			}
+			if len(p.Warnings) != tt.want {
+				t.Errorf("expected %d warnings, but got %v", tt.want, p.Warnings)
+			}
+		})
+	}
+}
@@ -28,14 +28,35 @@ func printTreeRec(node *Node, prefix string, isTail bool) {
 	}
 
 	label := ""
-	if node.Kind == NUM {
+	if node.Kind == NUM && node.Ty != nil && node.Ty.IsFloat() {
+		label = fmt.Sprintf("%g", node.FVal)
+	} else if node.Kind == NUM {
 		label = fmt.Sprintf("%d", node.Val)
+	} else if node.Kind == FUNCALL && node.Lhs != nil {
+		label = "(indirect call)"
+	} else if node.Kind == FUNCALL {
+		label = fmt.Sprintf("(%s())", node.FuncName)
+	} else if node.Kind == GVAR {
+		label = fmt.Sprintf("(GVAR %s)", node.Label)
+	} else if node.Kind == MEMBER {
+		label = fmt.Sprintf("(.%s)", node.Member.Name)
 	} else {
 		label = fmt.Sprintf("(%s)", nodeKindToString(node.Kind))
 	}
 
 	fmt.Printf("%s%s%s\n", prefix, connector, label)
 
+	if node.Kind == BLOCK {
+		for i, n := range node.Body {
+			printTreeRec(n, nextPrefix, i == len(node.Body)-1)
+		}
+	}
+	if node.Kind == FUNCALL {
+		for i, n := range node.Args {
+			printTreeRec(n, nextPrefix, i == len(node.Args)-1)
+		}
+	}
+
 	if node.Lhs != nil || node.Rhs != nil {
 		if node.Rhs != nil {
 			printTreeRec(node.Lhs, nextPrefix, false)
@@ -68,6 +89,34 @@ func nodeKindToString(kind NodeKind) string {
 		return "="
 	case LVAR:
 		return "LVAR"
+	case BLOCK:
+		return "BLOCK"
+	case CAST:
+		return "CAST"
+	case ADDR:
+		return "&"
+	case DEREF:
+		return "DEREF"
+	case MEMZERO:
+		return "MEMZERO"
+	case VA_START:
+		return "va_start"
+	case VA_ARG:
+		return "va_arg"
+	case COMMA:
+		return ","
+	case VLA_ALLOC:
+		return "VLA_ALLOC"
+	case VLA_SAVE:
+		return "VLA_SAVE"
+	case VLA_FREE:
+		return "VLA_FREE"
+	case ASM:
+		return "asm"
+	case SHL:
+		return "<<"
+	case FOR:
+		return "for"
 	default:
 		return "?"
 	}
@@ -0,0 +1,107 @@
+package parser
+
+import (
+	"rkitamu/gocc/errors"
+	"rkitamu/gocc/lexer"
+)
+
+// isStdarg reports whether tok starts one of the stdarg macros, which are
+// built into the compiler as there is no preprocessor to define them.
+func isStdarg(tok *lexer.Token) bool {
+	switch tok.Kind {
+	case lexer.VA_START, lexer.VA_ARG, lexer.VA_END, lexer.VA_COPY:
+		return true
+	}
+	return false
+}
+
+// stdarg = "va_start" "(" assign "," assign ")"
+//
+//	| "va_arg" "(" assign "," type-name ")"
+//	| "va_end" "(" assign ")"
+//	| "va_copy" "(" assign "," assign ")"
+func (p *Parser) stdarg() (*Node, error) {
+	tok := p.current
+	p.advance()
+	if err := p.expect("("); err != nil {
+		return nil, err
+	}
+	ap, err := p.vaList()
+	if err != nil {
+		return nil, err
+	}
+
+	var node *Node
+	switch tok.Kind {
+	case lexer.VA_START:
+		if p.curFunc == nil || !p.curFunc.Ty.IsVariadic {
+			return nil, errors.NewPosError("va_start used in function with fixed arguments", p.input, tok.Pos)
+		}
+		// the second argument only names the last parameter, which is
+		// already known
+		if err := p.expect(","); err != nil {
+			return nil, err
+		}
+		if _, err := p.assign(); err != nil {
+			return nil, err
+		}
+		node = &Node{Kind: VA_START, Lhs: ap, Var: p.curFunc.VaArea, Ty: TyVoid}
+	case lexer.VA_ARG:
+		if err := p.expect(","); err != nil {
+			return nil, err
+		}
+		start := p.current
+		ty, err := p.typeName()
+		if err != nil {
+			return nil, err
+		}
+		if !ty.IsScalar() {
+			return nil, errors.NewPosError("unsupported type for va_arg", p.input, start.Pos)
+		}
+		if ty.Kind == TY_FLOAT {
+			return nil, errors.NewPosError("float is promoted to double when passed through ...", p.input, start.Pos)
+		}
+		node = &Node{Kind: VA_ARG, Lhs: ap, Ty: ty}
+	case lexer.VA_END:
+		// nothing to release
+		node = newCast(ap, TyVoid)
+	case lexer.VA_COPY:
+		if err := p.expect(","); err != nil {
+			return nil, err
+		}
+		src, err := p.vaList()
+		if err != nil {
+			return nil, err
+		}
+		copy := &Node{Kind: ASSIGN, Lhs: &Node{Kind: DEREF, Lhs: ap}, Rhs: &Node{Kind: DEREF, Lhs: src}, Pos: tok.Pos}
+		node = newCast(copy, TyVoid)
+	}
+
+	if err := p.expect(")"); err != nil {
+		return nil, err
+	}
+	return node, nil
+}
+
+// vaList parses an expression that must be a va_list, which has decayed to
+// a pointer to its element. On RISC-V, where va_list is a pointer itself,
+// the expression must be an lvalue, and its address is taken instead so
+// that the stdarg macros see the same on every target.
+func (p *Parser) vaList() (*Node, error) {
+	start := p.current
+	node, err := p.assign()
+	if err != nil {
+		return nil, err
+	}
+	AddType(node)
+	if p.Arch == ARCH_RISCV64 {
+		if !IsLvalue(node) || !isCompatible(unqual(node.Ty), p.Arch.vaList()) {
+			return nil, errors.NewPosError("expected a va_list", p.input, start.Pos)
+		}
+		return &Node{Kind: ADDR, Lhs: node, Ty: pointerTo(node.Ty), Pos: start.Pos}, nil
+	}
+	if !node.Ty.IsPointer() || unqual(node.Ty.Base) != p.Arch.vaList().Base {
+		return nil, errors.NewPosError("expected a va_list", p.input, start.Pos)
+	}
+	return node, nil
+}
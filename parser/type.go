@@ -0,0 +1,432 @@
+package parser
+
+import "rkitamu/gocc/lexer"
+
+// TypeKind represents the kind of a C type.
+type TypeKind int
+
+const (
+	TY_VOID   TypeKind = iota // void
+	TY_BOOL                   // _Bool
+	TY_CHAR                   // char
+	TY_SHORT                  // short
+	TY_INT                    // int
+	TY_LONG                   // long, long long
+	TY_FLOAT                  // float
+	TY_DOUBLE                 // double, long double
+	TY_FUNC                   // function
+	TY_PTR                    // pointer
+	TY_ARRAY                  // array
+	TY_STRUCT                 // struct
+	TY_VLA                    // variable length array
+)
+
+// Type represents a C type.
+type Type struct {
+	Kind     TypeKind
+	Size     int  // sizeof() value
+	Align    int  // alignment in bytes
+	Unsigned bool // true for unsigned integer types
+	Plain    bool // true for plain char, which is neither signed char nor unsigned char
+
+	// qualifiers; a qualified type is a copy of its unqualified Origin
+	IsConst    bool
+	IsVolatile bool // accesses must be neither removed nor merged by optimizations
+	IsRestrict bool
+	Origin     *Type
+
+	// pointer or array type
+	Base     *Type
+	ArrayLen int // number of elements, -1 if not known yet
+
+	// variable length array type. A variable of this type holds a pointer
+	// to the elements, which are allocated when the declaration is reached.
+	VlaLen  *Node // number of elements
+	VlaSize *LVar // hidden variable holding the size in bytes at runtime
+
+	// struct type
+	Members []*Member
+
+	// function type
+	ReturnTy   *Type
+	Params     []*Type
+	ParamNames []*lexer.Token // nil for unnamed parameters
+	Prototyped bool           // false for "()" declarations, whose calls use default argument promotions
+	IsVariadic bool           // true if the parameters end with "..."
+}
+
+var (
+	TyVoid   = &Type{Kind: TY_VOID, Size: 1, Align: 1}
+	TyBool   = &Type{Kind: TY_BOOL, Size: 1, Align: 1, Unsigned: true}
+	TyChar   = &Type{Kind: TY_CHAR, Size: 1, Align: 1}
+	TyShort  = &Type{Kind: TY_SHORT, Size: 2, Align: 2}
+	TyInt    = &Type{Kind: TY_INT, Size: 4, Align: 4}
+	TyLong   = &Type{Kind: TY_LONG, Size: 8, Align: 8}
+	TyUChar  = &Type{Kind: TY_CHAR, Size: 1, Align: 1, Unsigned: true}
+	TyUShort = &Type{Kind: TY_SHORT, Size: 2, Align: 2, Unsigned: true}
+	TyUInt   = &Type{Kind: TY_INT, Size: 4, Align: 4, Unsigned: true}
+	TyULong  = &Type{Kind: TY_LONG, Size: 8, Align: 8, Unsigned: true}
+	TyFloat  = &Type{Kind: TY_FLOAT, Size: 4, Align: 4}
+	TyDouble = &Type{Kind: TY_DOUBLE, Size: 8, Align: 8}
+
+	// plain char has the representation of signed char or unsigned char,
+	// depending on the target, but is a different type from both
+	TyPlainChar  = &Type{Kind: TY_CHAR, Size: 1, Align: 1, Plain: true}
+	TyPlainUChar = &Type{Kind: TY_CHAR, Size: 1, Align: 1, Unsigned: true, Plain: true}
+)
+
+// Member represents a member of a struct.
+type Member struct {
+	Name   string
+	Ty     *Type
+	Align  int // alignment of the member, at least that of its type
+	Offset int
+
+	// A bit-field occupies BitWidth bits from bit BitOffset of the storage
+	// unit of its type at Offset.
+	IsBitfield bool
+	BitOffset  int
+	BitWidth   int
+}
+
+// pointerTo returns the type of a pointer to base.
+func pointerTo(base *Type) *Type {
+	return &Type{Kind: TY_PTR, Size: 8, Align: 8, Unsigned: true, Base: base}
+}
+
+// arrayOf returns the type of an array of n elements of base. A negative n
+// makes an incomplete array, whose length is given by its initializer.
+func arrayOf(base *Type, n int) *Type {
+	size := base.Size * n
+	if n < 0 {
+		size = -1
+	}
+	return &Type{Kind: TY_ARRAY, Size: size, Align: base.Align, Base: base, ArrayLen: n}
+}
+
+// vlaOf returns the type of a variable length array of base, whose length
+// is computed by n at runtime.
+func vlaOf(base *Type, n *Node) *Type {
+	return &Type{Kind: TY_VLA, Size: 8, Align: 8, Base: base, VlaLen: n}
+}
+
+// isVM reports whether ty is variably modified, that is, whether its size
+// or the size of a type it is derived from is only known at runtime.
+func isVM(ty *Type) bool {
+	switch ty.Kind {
+	case TY_VLA:
+		return true
+	case TY_PTR, TY_ARRAY:
+		return isVM(ty.Base)
+	}
+	return false
+}
+
+// sizeNode returns an expression for the size of ty in bytes, which is read
+// from the hidden size variable for a variable length array.
+func sizeNode(ty *Type) *Node {
+	if ty.Kind == TY_VLA {
+		return &Node{Kind: LVAR, Var: ty.VlaSize, Ty: TyLong}
+	}
+	return &Node{Kind: NUM, Val: ty.Size, Ty: TyLong}
+}
+
+// funcType returns the type of a function returning ret.
+func funcType(ret *Type, params []*Type, prototyped bool) *Type {
+	return &Type{Kind: TY_FUNC, Size: 1, Align: 1, ReturnTy: ret, Params: params, Prototyped: prototyped}
+}
+
+// qualify returns ty with the given qualifiers added. The result is a copy,
+// so the shared basic types and struct identities are never modified.
+func qualify(ty *Type, isConst, isVolatile, isRestrict bool) *Type {
+	if ty.Kind == TY_ARRAY && (isConst || isVolatile || isRestrict) {
+		// qualifying an array qualifies its elements
+		return arrayOf(qualify(ty.Base, isConst, isVolatile, isRestrict), ty.ArrayLen)
+	}
+	isConst = isConst || ty.IsConst
+	isVolatile = isVolatile || ty.IsVolatile
+	isRestrict = isRestrict || ty.IsRestrict
+	if isConst == ty.IsConst && isVolatile == ty.IsVolatile && isRestrict == ty.IsRestrict {
+		return ty
+	}
+	q := *unqual(ty)
+	q.IsConst, q.IsVolatile, q.IsRestrict = isConst, isVolatile, isRestrict
+	q.Origin = unqual(ty)
+	return &q
+}
+
+// qualifyAs returns ty with the qualifiers of from added.
+func qualifyAs(ty, from *Type) *Type {
+	return qualify(ty, from.IsConst, from.IsVolatile, from.IsRestrict)
+}
+
+// unqual returns the unqualified version of ty.
+func unqual(ty *Type) *Type {
+	if ty.Origin != nil {
+		return ty.Origin
+	}
+	return ty
+}
+
+// IsReadOnly reports whether an lvalue of the type cannot be assigned to,
+// which is the case for const types and structs with a const member.
+func (t *Type) IsReadOnly() bool {
+	if t.IsConst {
+		return true
+	}
+	if t.Kind == TY_STRUCT {
+		for _, m := range unqual(t).Members {
+			if m.Ty.IsReadOnly() {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// literalType returns the type of a numeric literal as chosen by the lexer.
+func literalType(lt lexer.LiteralType) *Type {
+	switch lt {
+	case lexer.LIT_UINT:
+		return TyUInt
+	case lexer.LIT_LONG:
+		return TyLong
+	case lexer.LIT_ULONG:
+		return TyULong
+	case lexer.LIT_FLOAT:
+		return TyFloat
+	case lexer.LIT_DOUBLE:
+		return TyDouble
+	default:
+		return TyInt
+	}
+}
+
+// IsInteger reports whether the type is an integer type.
+func (t *Type) IsInteger() bool {
+	switch t.Kind {
+	case TY_BOOL, TY_CHAR, TY_SHORT, TY_INT, TY_LONG:
+		return true
+	}
+	return false
+}
+
+// IsFloat reports whether the type is a floating-point type.
+func (t *Type) IsFloat() bool {
+	return t.Kind == TY_FLOAT || t.Kind == TY_DOUBLE
+}
+
+// IsArithmetic reports whether the type is an integer or floating-point type.
+func (t *Type) IsArithmetic() bool {
+	return t.IsInteger() || t.IsFloat()
+}
+
+// IsPointer reports whether the type is a pointer type.
+func (t *Type) IsPointer() bool {
+	return t.Kind == TY_PTR
+}
+
+// IsScalar reports whether the type is an arithmetic or pointer type.
+func (t *Type) IsScalar() bool {
+	return t.IsArithmetic() || t.IsPointer()
+}
+
+// isCompatible reports whether two types are compatible (C11 6.2.7), which
+// for the types supported so far means they are the same, except that an
+// array of unknown length is compatible with arrays of any length.
+// Qualified types are only compatible with identically qualified ones.
+func isCompatible(a, b *Type) bool {
+	if a == b {
+		return true
+	}
+	if a.IsConst != b.IsConst || a.IsVolatile != b.IsVolatile || a.IsRestrict != b.IsRestrict {
+		return false
+	}
+	a, b = unqual(a), unqual(b)
+	if a == b {
+		return true
+	}
+	if (a.Kind == TY_VLA || b.Kind == TY_VLA) &&
+		(a.Kind == TY_VLA || a.Kind == TY_ARRAY) && (b.Kind == TY_VLA || b.Kind == TY_ARRAY) {
+		// the lengths can only be compared at runtime
+		return isCompatible(a.Base, b.Base)
+	}
+	if a.Kind != b.Kind || a.Unsigned != b.Unsigned || a.Plain != b.Plain {
+		return false
+	}
+	switch a.Kind {
+	case TY_PTR:
+		return isCompatible(a.Base, b.Base)
+	case TY_ARRAY:
+		if a.ArrayLen >= 0 && b.ArrayLen >= 0 && a.ArrayLen != b.ArrayLen {
+			return false
+		}
+		return isCompatible(a.Base, b.Base)
+	case TY_STRUCT:
+		// every struct declaration makes a distinct type
+		return false
+	}
+	if a.Size != b.Size {
+		return false
+	}
+	if a.Kind != TY_FUNC {
+		return true
+	}
+	if !isCompatible(a.ReturnTy, b.ReturnTy) {
+		return false
+	}
+	if !a.Prototyped || !b.Prototyped {
+		return true
+	}
+	if len(a.Params) != len(b.Params) || a.IsVariadic != b.IsVariadic {
+		return false
+	}
+	for i := range a.Params {
+		// top-level qualifiers of parameters do not affect the function type
+		if !isCompatible(unqual(a.Params[i]), unqual(b.Params[i])) {
+			return false
+		}
+	}
+	return true
+}
+
+// integerRank returns the conversion rank of an integer type (C11 6.3.1.1).
+func integerRank(t *Type) int {
+	switch t.Kind {
+	case TY_BOOL:
+		return 0
+	case TY_CHAR:
+		return 1
+	case TY_SHORT:
+		return 2
+	case TY_INT:
+		return 3
+	default:
+		return 4
+	}
+}
+
+// promote applies the integer promotions: every type whose rank is below
+// int is converted to int, since int can represent all of its values.
+func promote(t *Type) *Type {
+	if t.IsInteger() && integerRank(t) < integerRank(TyInt) {
+		return TyInt
+	}
+	return t
+}
+
+// defaultArgPromote applies the default argument promotions used for
+// arguments without a prototype: integer promotions, and float to double.
+func defaultArgPromote(t *Type) *Type {
+	if t.Kind == TY_FLOAT {
+		return TyDouble
+	}
+	return promote(t)
+}
+
+// commonType returns the type both operands are converted to by the usual
+// arithmetic conversions (C11 6.3.1.8).
+func commonType(a, b *Type) *Type {
+	if a.Kind == TY_DOUBLE || b.Kind == TY_DOUBLE {
+		return TyDouble
+	}
+	if a.Kind == TY_FLOAT || b.Kind == TY_FLOAT {
+		return TyFloat
+	}
+
+	a, b = unqual(promote(a)), unqual(promote(b))
+	if integerRank(a) < integerRank(b) {
+		a, b = b, a
+	}
+	// a now has the greater (or equal) rank
+	if a.Unsigned || a.Size > b.Size {
+		return a
+	}
+	// same size, the unsigned one wins
+	if b.Unsigned {
+		return b
+	}
+	return a
+}
+
+// newCast wraps expr in a conversion to ty. No node is added if expr is
+// already of that type.
+func newCast(expr *Node, ty *Type) *Node {
+	AddType(expr)
+	if expr.Ty == ty {
+		return expr
+	}
+	return &Node{Kind: CAST, Lhs: expr, Ty: ty}
+}
+
+// usualArithConv converts both operands of a binary node to their common type.
+func usualArithConv(node *Node) {
+	node.Lhs, node.Rhs = promoteBitfield(node.Lhs), promoteBitfield(node.Rhs)
+	ty := commonType(node.Lhs.Ty, node.Rhs.Ty)
+	node.Lhs = newCast(node.Lhs, ty)
+	node.Rhs = newCast(node.Rhs, ty)
+}
+
+// AddType assigns a type to node and all of its children, inserting the
+// implicit conversions required by C. Nodes that already have a type are
+// left untouched, so it is safe to call AddType more than once. The parser
+// types the nodes as it builds them, so the AST it returns is fully typed.
+func AddType(node *Node) {
+	if node == nil || node.Ty != nil {
+		return
+	}
+
+	AddType(node.Lhs)
+	AddType(node.Rhs)
+	AddType(node.Cond)
+	AddType(node.Then)
+	AddType(node.Else)
+	AddType(node.Init)
+	AddType(node.Inc)
+	for _, n := range node.Body {
+		AddType(n)
+	}
+	for _, n := range node.Args {
+		AddType(n)
+	}
+
+	switch node.Kind {
+	case NUM:
+		if node.Val == int(int32(node.Val)) {
+			node.Ty = TyInt
+		} else {
+			node.Ty = TyLong
+		}
+	case ADD, SUB, MUL, DIV:
+		usualArithConv(node)
+		node.Ty = node.Lhs.Ty
+	case EQ, NEQ, LT, LTE:
+		// pointers are compared as addresses, other operands are
+		// converted to the pointer type
+		switch {
+		case node.Lhs.Ty.IsPointer():
+			node.Rhs = newCast(node.Rhs, node.Lhs.Ty)
+		case node.Rhs.Ty.IsPointer():
+			node.Lhs = newCast(node.Lhs, node.Rhs.Ty)
+		default:
+			usualArithConv(node)
+		}
+		node.Ty = TyInt
+	case ASSIGN:
+		node.Rhs = newCast(node.Rhs, node.Lhs.Ty)
+		node.Ty = node.Lhs.Ty
+	case LVAR:
+		// variables are typed by the parser; undeclared ones are int
+		node.Ty = TyInt
+	case ADDR:
+		node.Ty = pointerTo(node.Lhs.Ty)
+	case DEREF:
+		node.Ty = node.Lhs.Ty.Base
+	case MEMBER:
+		node.Ty = node.Member.Ty
+	case COMMA:
+		node.Ty = node.Rhs.Ty
+	case SHL:
+		node.Ty = node.Lhs.Ty
+	}
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"rkitamu/gocc/generator"
+	"rkitamu/gocc/ir"
+	"rkitamu/gocc/parser"
+)
+
+// optLevel is an optimization level given by -O.
+type optLevel int
+
+const (
+	O0 optLevel = iota // no optimization
+	O1                 // cheap optimizations
+	O2                 // all optimizations
+	Os                 // optimizations that do not grow the code
+)
+
+// parseOptLevel parses the level of -O<level>.
+func parseOptLevel(s string) (optLevel, error) {
+	switch s {
+	case "0":
+		return O0, nil
+	case "1":
+		return O1, nil
+	case "2":
+		return O2, nil
+	case "s":
+		return Os, nil
+	}
+	return 0, fmt.Errorf("unknown optimization level -O%s", s)
+}
+
+// A pass is a named transformation of the AST, the IR or the assembly.
+// Exactly one of ast, prog, ir and asm is set.
+type pass struct {
+	name string
+	ast  func(p *parser.Parser)  // rewrites the AST of the parsed functions
+	prog func(prog *ir.Program)  // rewrites the whole IR
+	ir   func(f *ir.Func)        // rewrites a function of the IR
+	ssa  bool                    // the ir pass needs SSA form
+	asm  func(asm string) string // rewrites the generated assembly
+}
+
+// passManager runs the passes registered for an optimization level in
+// order. The IR passes that need SSA form run after the ssa pass, and the
+// IR leaves SSA form after the last of them, before it is generated.
+type passManager struct {
+	level         optLevel
+	passes        []*pass
+	disabled      map[string]bool
+	printAfterAll bool // print the AST, IR or assembly after each pass
+}
+
+// inlineLimits is the cost limit of ir.Inline at each level. At -O0 only
+// the functions that ask to be are inlined.
+var inlineLimits = map[optLevel]int{O0: -1, O1: 10, O2: 25, Os: 5}
+
+// newPassManager returns a pass manager with the passes of level for target,
+// and the loop unrolling of -funroll-loops if unrollLoops is set.
+func newPassManager(level optLevel, target *generator.Target, unrollLoops bool) *passManager {
+	pm := &passManager{level: level, disabled: map[string]bool{}}
+	inline := &pass{name: "inline", prog: func(prog *ir.Program) { ir.Inline(prog, inlineLimits[level]) }}
+	if level == O0 {
+		pm.register(inline)
+		return pm
+	}
+
+	pm.register(&pass{name: "fold", ast: (*parser.Parser).Fold})
+	pm.register(inline)
+	pm.register(&pass{name: "ssa", ir: ir.BuildSSA})
+	if unrollLoops {
+		pm.register(&pass{name: "unroll", ir: ir.Unroll, ssa: true})
+	}
+	pm.register(&pass{name: "sccp", ir: ir.SCCP, ssa: true})
+	if level >= O2 {
+		pm.register(&pass{name: "gvn", ir: ir.GVN, ssa: true})
+	}
+	pm.register(&pass{name: "licm", ir: ir.LICM, ssa: true})
+	// the new induction variables make the code larger
+	if level == O2 {
+		pm.register(&pass{name: "strength", ir: ir.StrengthReduce, ssa: true})
+	}
+	pm.register(&pass{name: "dce", ir: ir.DCE, ssa: true})
+	if level >= O2 {
+		pm.register(&pass{name: "tailcall", ir: ir.TailCalls})
+	}
+	if target.Peephole != nil {
+		pm.register(&pass{name: "peephole", asm: target.Peephole})
+	}
+	return pm
+}
+
+// register appends p to the passes.
+func (pm *passManager) register(p *pass) {
+	pm.passes = append(pm.passes, p)
+}
+
+// disable keeps the passes named in the comma-separated list names from
+// running.
+func (pm *passManager) disable(names string) error {
+	for _, name := range strings.Split(names, ",") {
+		if name = strings.TrimSpace(name); name == "" {
+			continue
+		}
+		if pm.lookup(name) == nil {
+			return fmt.Errorf("unknown pass %q at this optimization level", name)
+		}
+		pm.disabled[name] = true
+	}
+	return nil
+}
+
+func (pm *passManager) lookup(name string) *pass {
+	for _, p := range pm.passes {
+		if p.name == name {
+			return p
+		}
+	}
+	return nil
+}
+
+// enabled returns the passes to run, in order.
+func (pm *passManager) enabled() []*pass {
+	var passes []*pass
+	for _, p := range pm.passes {
+		if !pm.disabled[p.name] {
+			passes = append(passes, p)
+		}
+	}
+	return passes
+}
+
+// runAST runs the AST passes on the functions parsed by p.
+func (pm *passManager) runAST(p *parser.Parser) {
+	for _, ps := range pm.enabled() {
+		if ps.ast == nil {
+			continue
+		}
+		ps.ast(p)
+		if pm.printAfterAll {
+			fmt.Printf("=== AST after %s ===\n", ps.name)
+			for _, fn := range p.Funcs {
+				if fn.IsDefinition {
+					fmt.Printf("%s:\n", fn.Name)
+					p.PrintTree(fn.Body)
+				}
+			}
+		}
+	}
+}
+
+// runIR runs the IR passes on prog, and verifies the IR after each of them.
+// Without the ssa pass, the passes that need SSA form are skipped.
+func (pm *passManager) runIR(prog *ir.Program) error {
+	inSSA := false
+	for _, ps := range pm.enabled() {
+		switch {
+		case ps.prog != nil:
+			ps.prog(prog)
+		case ps.ir != nil && (!ps.ssa || inSSA):
+			for _, f := range prog.Funcs {
+				ps.ir(f)
+			}
+		default:
+			continue
+		}
+		if ps.name == "ssa" {
+			inSSA = true
+		}
+		if err := ir.VerifyProgram(prog); err != nil {
+			return fmt.Errorf("invalid IR after %s: %w", ps.name, err)
+		}
+		if pm.printAfterAll {
+			fmt.Printf("=== IR after %s ===\n", ps.name)
+			fmt.Print(ir.Dump(prog))
+		}
+	}
+
+	if inSSA {
+		for _, f := range prog.Funcs {
+			ir.LeaveSSA(f)
+		}
+		if err := ir.VerifyProgram(prog); err != nil {
+			return fmt.Errorf("invalid IR after leaving SSA form: %w", err)
+		}
+	}
+	return nil
+}
+
+// runAsm runs the assembly passes on asm.
+func (pm *passManager) runAsm(asm string) string {
+	for _, ps := range pm.enabled() {
+		if ps.asm == nil {
+			continue
+		}
+		asm = ps.asm(asm)
+		if pm.printAfterAll {
+			fmt.Printf("=== assembly after %s ===\n", ps.name)
+			fmt.Print(asm)
+		}
+	}
+	return asm
+}
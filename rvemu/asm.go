@@ -0,0 +1,354 @@
+package rvemu
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// The address space of a program. Instructions are not stored in memory:
+// the i-th instruction of the text is at codeBase+4*i, and jumping to
+// libcBase+4*k calls the k-th function of the C library. Returning to
+// exitAddr ends the program.
+const (
+	memSize  = 32 << 20
+	dataBase = 0x10000
+	codeBase = 0x100000000
+	libcBase = 0x200000000
+	exitAddr = 0x300000000
+)
+
+type operandKind int
+
+const (
+	opReg   operandKind = iota // integer register
+	opFReg                     // floating-point register
+	opImm                      // integer constant, or resolved symbol
+	opMem                      // imm(reg)
+	opSym                      // address of a symbol plus imm
+	opGot                      // %got_pcrel_hi(sym): address of the GOT slot of sym
+	opRound                    // rounding mode of a conversion
+)
+
+// operand is an operand of an instruction. Symbols are resolved to imm once
+// the whole program is read.
+type operand struct {
+	kind operandKind
+	reg  int
+	imm  int64
+	sym  string
+}
+
+type inst struct {
+	op   string
+	args []operand
+	line int // line of the instruction in the assembly, from 1
+}
+
+// program is an assembled program: its instructions, the initial contents
+// of memory from dataBase, and the address of every symbol.
+type program struct {
+	insts   []inst
+	data    []byte
+	symbols map[string]uint64
+}
+
+var regNames = map[string]int{
+	"zero": 0, "ra": 1, "sp": 2, "gp": 3, "tp": 4, "t0": 5, "t1": 6, "t2": 7,
+	"s0": 8, "fp": 8, "s1": 9, "t3": 28, "t4": 29, "t5": 30, "t6": 31,
+}
+
+var fregNames = map[string]int{"fs0": 8, "fs1": 9}
+
+func init() {
+	for i := 0; i < 8; i++ {
+		regNames[fmt.Sprintf("a%d", i)] = 10 + i
+		fregNames[fmt.Sprintf("fa%d", i)] = 10 + i
+		fregNames[fmt.Sprintf("ft%d", i)] = i
+	}
+	for i := 2; i < 12; i++ {
+		regNames[fmt.Sprintf("s%d", i)] = 16 + i
+		fregNames[fmt.Sprintf("fs%d", i)] = 16 + i
+	}
+	for i := 8; i < 12; i++ {
+		fregNames[fmt.Sprintf("ft%d", i)] = 20 + i
+	}
+	for i := 0; i < 32; i++ {
+		regNames[fmt.Sprintf("x%d", i)] = i
+		fregNames[fmt.Sprintf("f%d", i)] = i
+	}
+}
+
+// fixup is an address to be stored in the data once the symbols are known.
+type fixup struct {
+	offset int // in the data
+	size   int
+	sym    string
+	addend int64
+	line   int
+}
+
+// assemble reads the assembly text src.
+func assemble(src string) (*program, error) {
+	p := &program{symbols: map[string]uint64{}}
+	var fixups []fixup
+	section := ".text"
+
+	for n, line := range strings.Split(src, "\n") {
+		n++
+		if i := strings.IndexByte(line, '#'); i >= 0 {
+			line = line[:i]
+		}
+		line = strings.TrimSpace(line)
+		for {
+			i := strings.IndexByte(line, ':')
+			if i < 0 || strings.ContainsAny(line[:i], " \t\"") {
+				break
+			}
+			name := line[:i]
+			if _, ok := p.symbols[name]; ok {
+				return nil, fmt.Errorf("line %d: symbol %q is already defined", n, name)
+			}
+			if section == ".text" {
+				p.symbols[name] = codeBase + 4*uint64(len(p.insts))
+			} else {
+				p.symbols[name] = dataBase + uint64(len(p.data))
+			}
+			line = strings.TrimSpace(line[i+1:])
+		}
+		if line == "" {
+			continue
+		}
+
+		op := strings.Fields(line)[0]
+		rest := strings.TrimSpace(line[len(op):])
+		if strings.HasPrefix(op, ".") {
+			switch op {
+			case ".text", ".data", ".bss":
+				section = op
+			case ".section":
+				section = strings.TrimSpace(strings.Split(rest, ",")[0])
+			case ".global", ".globl", ".local", ".type", ".size", ".p2align", ".align", ".option":
+			case ".balign":
+				align, err := strconv.Atoi(rest)
+				if err != nil || align <= 0 {
+					return nil, fmt.Errorf("line %d: bad alignment %q", n, rest)
+				}
+				for len(p.data)%align != 0 {
+					p.data = append(p.data, 0)
+				}
+			case ".zero":
+				size, err := strconv.Atoi(rest)
+				if err != nil || size < 0 {
+					return nil, fmt.Errorf("line %d: bad size %q", n, rest)
+				}
+				p.data = append(p.data, make([]byte, size)...)
+			case ".byte", ".half", ".short", ".word", ".long", ".dword", ".quad":
+				size := map[string]int{".byte": 1, ".half": 2, ".short": 2, ".word": 4, ".long": 4, ".dword": 8, ".quad": 8}[op]
+				for _, expr := range splitOperands(rest) {
+					if v, err := parseInt(expr); err == nil {
+						p.data = appendInt(p.data, size, uint64(v))
+						continue
+					}
+					sym, addend, err := parseSym(expr)
+					if err != nil {
+						return nil, fmt.Errorf("line %d: %v", n, err)
+					}
+					fixups = append(fixups, fixup{len(p.data), size, sym, addend, n})
+					p.data = appendInt(p.data, size, 0)
+				}
+			default:
+				return nil, fmt.Errorf("line %d: unsupported directive %s", n, op)
+			}
+			continue
+		}
+
+		if section != ".text" {
+			return nil, fmt.Errorf("line %d: instruction outside .text", n)
+		}
+		in := inst{op: op, line: n}
+		ops := splitOperands(rest)
+		for i, s := range ops {
+			arg, err := parseOperand(s)
+			if isSymOperand(op, i, len(ops)) {
+				// a symbol may be named like a register
+				arg.kind = opSym
+				arg.sym, arg.imm, err = parseSym(s)
+			}
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %v", n, err)
+			}
+			in.args = append(in.args, arg)
+		}
+		p.insts = append(p.insts, in)
+	}
+
+	// the GOT follows the data
+	got := map[string]uint64{}
+	for i := range p.insts {
+		for j := range p.insts[i].args {
+			arg := &p.insts[i].args[j]
+			switch arg.kind {
+			case opSym:
+				addr, err := p.lookup(arg.sym)
+				if err != nil {
+					return nil, fmt.Errorf("line %d: %v", p.insts[i].line, err)
+				}
+				arg.imm += int64(addr)
+			case opGot:
+				if _, ok := got[arg.sym]; !ok {
+					addr, err := p.lookup(arg.sym)
+					if err != nil {
+						return nil, fmt.Errorf("line %d: %v", p.insts[i].line, err)
+					}
+					for len(p.data)%8 != 0 {
+						p.data = append(p.data, 0)
+					}
+					got[arg.sym] = dataBase + uint64(len(p.data))
+					p.data = appendInt(p.data, 8, addr)
+				}
+				arg.imm = int64(got[arg.sym])
+			}
+		}
+	}
+	for _, f := range fixups {
+		addr, err := p.lookup(f.sym)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", f.line, err)
+		}
+		v := addr + uint64(f.addend)
+		for i := 0; i < f.size; i++ {
+			p.data[f.offset+i] = byte(v >> (8 * i))
+		}
+	}
+	if dataBase+len(p.data) > memSize/2 {
+		return nil, fmt.Errorf("data too large")
+	}
+	return p, nil
+}
+
+// isSymOperand reports whether the i-th of n operands of op is a symbol.
+func isSymOperand(op string, i, n int) bool {
+	switch op {
+	case "lla", "la":
+		return i == 1
+	case "call", "tail", "j":
+		return true
+	case "jal":
+		return i == n-1
+	}
+	return op[0] == 'b' && i == n-1
+}
+
+// lookup returns the address of the symbol sym, which is a function of the
+// C library if the program does not define it.
+func (p *program) lookup(sym string) (uint64, error) {
+	if addr, ok := p.symbols[sym]; ok {
+		return addr, nil
+	}
+	if k, ok := libcIndex[sym]; ok {
+		return libcBase + 4*uint64(k), nil
+	}
+	return 0, fmt.Errorf("undefined symbol %q", sym)
+}
+
+func appendInt(b []byte, size int, v uint64) []byte {
+	for i := 0; i < size; i++ {
+		b = append(b, byte(v>>(8*i)))
+	}
+	return b
+}
+
+// splitOperands splits s at the commas outside parentheses.
+func splitOperands(s string) []string {
+	var ops []string
+	depth, start := 0, 0
+	for i, c := range s {
+		switch c {
+		case '(':
+			depth++
+		case ')':
+			depth--
+		case ',':
+			if depth == 0 {
+				ops = append(ops, strings.TrimSpace(s[start:i]))
+				start = i + 1
+			}
+		}
+	}
+	if rest := strings.TrimSpace(s[start:]); rest != "" || len(ops) > 0 {
+		ops = append(ops, rest)
+	}
+	return ops
+}
+
+func parseInt(s string) (int64, error) {
+	if v, err := strconv.ParseInt(s, 0, 64); err == nil {
+		return v, nil
+	}
+	v, err := strconv.ParseUint(s, 0, 64)
+	return int64(v), err
+}
+
+// parseSym parses a symbol with an optional addend, such as x+8.
+func parseSym(s string) (string, int64, error) {
+	sym, addend := s, int64(0)
+	if i := strings.LastIndexAny(s, "+-"); i > 0 {
+		v, err := parseInt(s[i:])
+		if err != nil {
+			return "", 0, fmt.Errorf("bad expression %q", s)
+		}
+		sym, addend = s[:i], v
+	}
+	if sym == "" || strings.ContainsAny(sym, " ()%,") {
+		return "", 0, fmt.Errorf("bad expression %q", s)
+	}
+	return sym, addend, nil
+}
+
+func parseOperand(s string) (operand, error) {
+	if r, ok := regNames[s]; ok {
+		return operand{kind: opReg, reg: r}, nil
+	}
+	if r, ok := fregNames[s]; ok {
+		return operand{kind: opFReg, reg: r}, nil
+	}
+	switch s {
+	case "rne", "rtz", "rdn", "rup", "rmm", "dyn":
+		return operand{kind: opRound, sym: s}, nil
+	}
+	if v, err := parseInt(s); err == nil {
+		return operand{kind: opImm, imm: v}, nil
+	}
+
+	// %got_pcrel_hi(sym) and %pcrel_hi(sym) give the whole address to
+	// auipc, so the %pcrel_lo that follows is 0
+	for prefix, kind := range map[string]operandKind{"%got_pcrel_hi(": opGot, "%pcrel_hi(": opSym} {
+		if strings.HasPrefix(s, prefix) && strings.HasSuffix(s, ")") {
+			sym, addend, err := parseSym(s[len(prefix) : len(s)-1])
+			return operand{kind: kind, sym: sym, imm: addend}, err
+		}
+	}
+
+	if strings.HasSuffix(s, ")") {
+		i := strings.LastIndexByte(s, '(')
+		r, ok := regNames[s[i+1:len(s)-1]]
+		disp := strings.TrimSpace(s[:i])
+		switch {
+		case !ok && strings.HasPrefix(s, "%pcrel_lo("):
+			return operand{kind: opImm}, nil
+		case !ok:
+			return operand{}, fmt.Errorf("bad operand %q", s)
+		case disp == "" || strings.HasPrefix(disp, "%pcrel_lo("):
+			return operand{kind: opMem, reg: r}, nil
+		}
+		off, err := parseInt(disp)
+		if err != nil {
+			return operand{}, fmt.Errorf("bad offset %q", disp)
+		}
+		return operand{kind: opMem, reg: r, imm: off}, nil
+	}
+
+	sym, addend, err := parseSym(s)
+	return operand{kind: opSym, sym: sym, imm: addend}, err
+}
@@ -0,0 +1,187 @@
+package rvemu
+
+import (
+	"fmt"
+	"math"
+	"strings"
+)
+
+// libcFunc is a function of the C library, implemented in Go on the
+// registers and memory of the machine. It is called as the program calls
+// any function and returns to ra.
+type libcFunc struct {
+	name string
+	fn   func(m *machine)
+}
+
+var libc = []libcFunc{
+	{"strlen", func(m *machine) { m.x[10] = uint64(len(m.cstring(m.x[10]))) }},
+	{"strcmp", func(m *machine) {
+		m.x[10] = uint64(int64(strings.Compare(m.cstring(m.x[10]), m.cstring(m.x[11]))))
+	}},
+	{"memcpy", func(m *machine) {
+		m.check(m.x[11], int(m.x[12]))
+		m.check(m.x[10], int(m.x[12]))
+		copy(m.mem[m.x[10]:m.x[10]+m.x[12]], m.mem[m.x[11]:m.x[11]+m.x[12]])
+	}},
+	{"memset", func(m *machine) {
+		m.check(m.x[10], int(m.x[12]))
+		for i := uint64(0); i < m.x[12]; i++ {
+			m.mem[m.x[10]+i] = byte(m.x[11])
+		}
+	}},
+	{"malloc", func(m *machine) { m.x[10] = m.malloc(m.x[10]) }},
+	{"calloc", func(m *machine) { m.x[10] = m.malloc(m.x[10] * m.x[11]) }},
+	{"free", func(m *machine) {}},
+	{"exit", func(m *machine) { m.exited, m.status = true, int(uint8(m.x[10])) }},
+	{"abort", func(m *machine) { m.fault("abort called") }},
+	{"sqrt", func(m *machine) { m.setF64(10, math.Sqrt(m.getF64(10))) }},
+	{"putchar", func(m *machine) { fmt.Fprintf(m.stdout, "%c", byte(m.x[10])) }},
+	{"puts", func(m *machine) { fmt.Fprintln(m.stdout, m.cstring(m.x[10])) }},
+	{"printf", func(m *machine) {
+		s := m.format(m.x[10], m.regArgs(1))
+		fmt.Fprint(m.stdout, s)
+		m.x[10] = uint64(len(s))
+	}},
+	{"vprintf", func(m *machine) {
+		s := m.format(m.x[10], m.vaArgs(m.x[11]))
+		fmt.Fprint(m.stdout, s)
+		m.x[10] = uint64(len(s))
+	}},
+	{"sprintf", func(m *machine) { m.x[10] = m.writeString(m.x[10], math.MaxInt, m.format(m.x[11], m.regArgs(2))) }},
+	{"snprintf", func(m *machine) { m.x[10] = m.writeString(m.x[10], m.x[11], m.format(m.x[12], m.regArgs(3))) }},
+	{"vsprintf", func(m *machine) { m.x[10] = m.writeString(m.x[10], math.MaxInt, m.format(m.x[11], m.vaArgs(m.x[12]))) }},
+	{"vsnprintf", func(m *machine) { m.x[10] = m.writeString(m.x[10], m.x[11], m.format(m.x[12], m.vaArgs(m.x[13]))) }},
+}
+
+// libcIndex is the index of each function in libc.
+var libcIndex = map[string]int{}
+
+func init() {
+	for i, f := range libc {
+		libcIndex[f.name] = i
+	}
+}
+
+// cstring returns the NUL-terminated string at addr.
+func (m *machine) cstring(addr uint64) string {
+	var sb strings.Builder
+	for ; ; addr++ {
+		c := byte(m.load(addr, 1))
+		if c == 0 {
+			return sb.String()
+		}
+		sb.WriteByte(c)
+	}
+}
+
+// writeString stores s at buf as snprintf does with a buffer of n bytes,
+// returning the length of s.
+func (m *machine) writeString(buf, n uint64, s string) uint64 {
+	if n > 0 {
+		b := []byte(s)[:min(uint64(len(s)), n-1)]
+		for i, c := range append(b, 0) {
+			m.store(buf+uint64(i), 1, uint64(c))
+		}
+	}
+	return uint64(len(s))
+}
+
+// malloc allocates size bytes, aligned to 16, from the memory between the
+// data and the stack. It is never freed.
+func (m *machine) malloc(size uint64) uint64 {
+	addr := m.heap
+	m.heap = (m.heap + size + 15) &^ 15
+	if m.heap > memSize/2 {
+		m.fault("out of memory")
+	}
+	return addr
+}
+
+// regArgs returns the variadic arguments of a call from the argument
+// register a<first> on: the rest of a0-a7, then the stack.
+func (m *machine) regArgs(first int) func() uint64 {
+	i := first
+	return func() uint64 {
+		defer func() { i++ }()
+		if i < 8 {
+			return m.x[10+i]
+		}
+		return m.load(m.x[2]+8*uint64(i-8), 8)
+	}
+}
+
+// vaArgs returns the variadic arguments of a va_list, a pointer to the
+// next of them.
+func (m *machine) vaArgs(ap uint64) func() uint64 {
+	return func() uint64 {
+		defer func() { ap += 8 }()
+		return m.load(ap, 8)
+	}
+}
+
+// format formats the arguments given by next, which returns them one by
+// one as 64-bit values, as printf does with the format at addr.
+func (m *machine) format(addr uint64, next func() uint64) string {
+	f := m.cstring(addr)
+	var sb strings.Builder
+	for i := 0; i < len(f); i++ {
+		if f[i] != '%' {
+			sb.WriteByte(f[i])
+			continue
+		}
+
+		// flags, width and precision are as in Go, the length is dropped
+		spec := "%"
+		for i++; i < len(f) && strings.IndexByte("-+ #0123456789.*", f[i]) >= 0; i++ {
+			if f[i] == '*' {
+				spec += fmt.Sprint(int32(next()))
+			} else {
+				spec += string(f[i])
+			}
+		}
+		long := false
+		for ; i < len(f) && strings.IndexByte("hlzjt", f[i]) >= 0; i++ {
+			long = long || f[i] != 'h'
+		}
+		if i == len(f) {
+			sb.WriteString(spec)
+			break
+		}
+
+		switch c := f[i]; c {
+		case '%':
+			sb.WriteByte('%')
+		case 'd', 'i':
+			v := int64(next())
+			if !long {
+				v = int64(int32(v))
+			}
+			sb.WriteString(fmt.Sprintf(spec+"d", v))
+		case 'u', 'x', 'X', 'o':
+			v := next()
+			if !long {
+				v = uint64(uint32(v))
+			}
+			if c == 'u' {
+				c = 'd'
+			}
+			sb.WriteString(fmt.Sprintf(spec+string(c), v))
+		case 'c':
+			sb.WriteString(fmt.Sprintf(spec+"c", rune(byte(next()))))
+		case 's':
+			sb.WriteString(fmt.Sprintf(spec+"s", m.cstring(next())))
+		case 'p':
+			sb.WriteString(fmt.Sprintf("%#x", next()))
+		case 'f', 'F', 'e', 'E', 'g', 'G':
+			// C prints 6 digits unless told otherwise, Go as few as needed
+			if !strings.Contains(spec, ".") {
+				spec += ".6"
+			}
+			sb.WriteString(fmt.Sprintf(spec+string(c), math.Float64frombits(next())))
+		default:
+			m.fault("unsupported conversion %%%c in printf format", c)
+		}
+	}
+	return sb.String()
+}
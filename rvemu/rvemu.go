@@ -0,0 +1,584 @@
+// Package rvemu runs the RISC-V assembly that gocc generates for the
+// riscv64-linux-gnu target, so that the backend can be tested without a
+// cross toolchain and qemu-riscv64. It assembles the text itself and
+// interprets the RV64GC instructions and pseudo-instructions the backend
+// emits, along with the common ones written in inline assembly, and
+// provides the few functions of the C library the tests call.
+package rvemu
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"math/bits"
+)
+
+// maxSteps bounds the number of instructions a program runs, so that a
+// miscompiled loop fails instead of hanging.
+const maxSteps = 100_000_000
+
+// canonicalNaN32 is what reading a float from a register that does not
+// hold a properly NaN-boxed float gives, as on the hardware.
+const canonicalNaN32 = 0x7fc00000
+
+type machine struct {
+	prog   *program
+	mem    []byte
+	x      [32]uint64
+	f      [32]uint64
+	pc     uint64
+	heap   uint64 // end of the memory allocated by malloc
+	stdout io.Writer
+	exited bool // set by exit
+	status int
+}
+
+// fault is a runtime error of the program, raised by panicking and
+// returned by Run.
+type fault struct{ msg string }
+
+func (m *machine) fault(format string, args ...any) {
+	panic(fault{fmt.Sprintf(format, args...)})
+}
+
+// Run assembles asm and runs its main function, writing what the program
+// prints to stdout. It returns the exit status of the program: the value
+// main returns, or the argument of exit, truncated to 8 bits.
+func Run(asm string, stdout io.Writer) (status int, err error) {
+	prog, err := assemble(asm)
+	if err != nil {
+		return 0, err
+	}
+	entry, ok := prog.symbols["main"]
+	if !ok || entry < codeBase {
+		return 0, fmt.Errorf("no main function")
+	}
+
+	m := &machine{prog: prog, mem: make([]byte, memSize), pc: entry, stdout: stdout}
+	copy(m.mem[dataBase:], prog.data)
+	m.heap = (dataBase + uint64(len(prog.data)) + 15) &^ 15
+	m.x[1] = exitAddr
+	m.x[2] = memSize - 16
+
+	defer func() {
+		if r := recover(); r != nil {
+			f, ok := r.(fault)
+			if !ok {
+				panic(r)
+			}
+			err = fmt.Errorf("%s", f.msg)
+			if i := (m.pc - codeBase) / 4; m.pc >= codeBase && i < uint64(len(prog.insts)) {
+				err = fmt.Errorf("line %d: %s: %s", prog.insts[i].line, prog.insts[i].op, f.msg)
+			}
+		}
+	}()
+	for steps := 0; ; steps++ {
+		if steps == maxSteps {
+			return 0, fmt.Errorf("program did not finish in %d steps", maxSteps)
+		}
+		switch {
+		case m.pc == exitAddr:
+			return int(uint8(m.x[10])), nil
+		case m.pc >= libcBase && m.pc < libcBase+4*uint64(len(libc)):
+			libc[(m.pc-libcBase)/4].fn(m)
+			if m.exited {
+				return m.status, nil
+			}
+			m.pc = m.x[1]
+			continue
+		case m.pc < codeBase || m.pc >= codeBase+4*uint64(len(prog.insts)) || m.pc%4 != 0:
+			return 0, fmt.Errorf("jump to invalid address %#x", m.pc)
+		}
+		m.step(&prog.insts[(m.pc-codeBase)/4])
+	}
+}
+
+func (m *machine) load(addr uint64, size int) uint64 {
+	m.check(addr, size)
+	v := uint64(0)
+	for i := 0; i < size; i++ {
+		v |= uint64(m.mem[addr+uint64(i)]) << (8 * i)
+	}
+	return v
+}
+
+func (m *machine) store(addr uint64, size int, v uint64) {
+	m.check(addr, size)
+	for i := 0; i < size; i++ {
+		m.mem[addr+uint64(i)] = byte(v >> (8 * i))
+	}
+}
+
+// check faults unless size bytes at addr are in memory. The first page is
+// left out to catch null pointers.
+func (m *machine) check(addr uint64, size int) {
+	if addr < 0x1000 || addr >= memSize || addr+uint64(size) > memSize {
+		m.fault("invalid memory access at %#x", addr)
+	}
+}
+
+func (m *machine) setX(r int, v uint64) {
+	if r != 0 {
+		m.x[r] = v
+	}
+}
+
+func (m *machine) getF32(r int) float32 {
+	if m.f[r]>>32 != 0xffffffff {
+		return math.Float32frombits(canonicalNaN32)
+	}
+	return math.Float32frombits(uint32(m.f[r]))
+}
+
+// setF32 sets r to v, NaN-boxed.
+func (m *machine) setF32(r int, v float32) {
+	m.f[r] = 0xffffffff00000000 | uint64(math.Float32bits(v))
+}
+
+func (m *machine) getF64(r int) float64 {
+	return math.Float64frombits(m.f[r])
+}
+
+func (m *machine) setF64(r int, v float64) {
+	m.f[r] = math.Float64bits(v)
+}
+
+func sext32(v uint64) uint64 {
+	return uint64(int64(int32(v)))
+}
+
+// want faults unless the operands of in are of the given kinds. A kind
+// of opImm also accepts resolved symbols.
+func (m *machine) want(in *inst, kinds ...operandKind) {
+	if len(in.args) != len(kinds) {
+		m.fault("expected %d operands", len(kinds))
+	}
+	for i, kind := range kinds {
+		got := in.args[i].kind
+		if got != kind && !(kind == opImm && got == opSym) {
+			m.fault("bad operand %d", i+1)
+		}
+	}
+}
+
+// step runs the instruction in at pc.
+func (m *machine) step(in *inst) {
+	next := m.pc + 4
+	a := in.args
+	// the operands as integer registers and immediates, checked by want
+	rd := func() int { return a[0].reg }
+	rs := func(i int) uint64 { return m.x[a[i].reg] }
+	imm := func(i int) int64 { return a[i].imm }
+
+	switch in.op {
+	case "nop":
+		m.want(in)
+	case "li":
+		m.want(in, opReg, opImm)
+		m.setX(rd(), uint64(imm(1)))
+	case "lui":
+		m.want(in, opReg, opImm)
+		m.setX(rd(), sext32(uint64(imm(1))<<12))
+	case "lla", "la":
+		m.want(in, opReg, opSym)
+		m.setX(rd(), uint64(imm(1)))
+	case "auipc":
+		// only %got_pcrel_hi and %pcrel_hi, which give the whole address
+		if len(a) != 2 || a[0].kind != opReg || (a[1].kind != opGot && a[1].kind != opSym) {
+			m.fault("unsupported operands")
+		}
+		m.setX(rd(), uint64(imm(1)))
+	case "mv":
+		m.want(in, opReg, opReg)
+		m.setX(rd(), rs(1))
+	case "not":
+		m.want(in, opReg, opReg)
+		m.setX(rd(), ^rs(1))
+	case "neg":
+		m.want(in, opReg, opReg)
+		m.setX(rd(), -rs(1))
+	case "negw":
+		m.want(in, opReg, opReg)
+		m.setX(rd(), sext32(-rs(1)))
+	case "seqz":
+		m.want(in, opReg, opReg)
+		m.setX(rd(), b2u(rs(1) == 0))
+	case "snez":
+		m.want(in, opReg, opReg)
+		m.setX(rd(), b2u(rs(1) != 0))
+	case "sltz":
+		m.want(in, opReg, opReg)
+		m.setX(rd(), b2u(int64(rs(1)) < 0))
+	case "sgtz":
+		m.want(in, opReg, opReg)
+		m.setX(rd(), b2u(int64(rs(1)) > 0))
+	case "sext.w":
+		m.want(in, opReg, opReg)
+		m.setX(rd(), sext32(rs(1)))
+	case "zext.b":
+		m.want(in, opReg, opReg)
+		m.setX(rd(), rs(1)&0xff)
+
+	case "addi", "andi", "ori", "xori", "slti", "sltiu", "slli", "srli", "srai",
+		"addiw", "slliw", "srliw", "sraiw":
+		m.want(in, opReg, opReg, opImm)
+		m.setX(rd(), m.alu(immOps[in.op], rs(1), uint64(imm(2))))
+	case "add", "sub", "and", "or", "xor", "slt", "sltu", "sll", "srl", "sra",
+		"mul", "mulh", "mulhu", "div", "divu", "rem", "remu",
+		"addw", "subw", "sllw", "srlw", "sraw", "mulw", "divw", "divuw", "remw", "remuw":
+		m.want(in, opReg, opReg, opReg)
+		m.setX(rd(), m.alu(in.op, rs(1), rs(2)))
+
+	case "lb", "lbu", "lh", "lhu", "lw", "lwu", "ld":
+		m.want(in, opReg, opMem)
+		size := map[byte]int{'b': 1, 'h': 2, 'w': 4, 'd': 8}[in.op[1]]
+		v := m.load(rs(1)+uint64(imm(1)), size)
+		if len(in.op) == 2 && size < 8 {
+			shift := 64 - 8*size
+			v = uint64(int64(v<<shift) >> shift)
+		}
+		m.setX(rd(), v)
+	case "sb", "sh", "sw", "sd":
+		m.want(in, opReg, opMem)
+		size := map[byte]int{'b': 1, 'h': 2, 'w': 4, 'd': 8}[in.op[1]]
+		m.store(rs(1)+uint64(imm(1)), size, m.x[a[0].reg])
+	case "flw", "fld":
+		m.want(in, opFReg, opMem)
+		addr := rs(1) + uint64(imm(1))
+		if in.op == "flw" {
+			m.f[a[0].reg] = 0xffffffff00000000 | m.load(addr, 4)
+		} else {
+			m.f[a[0].reg] = m.load(addr, 8)
+		}
+	case "fsw", "fsd":
+		m.want(in, opFReg, opMem)
+		addr := rs(1) + uint64(imm(1))
+		if in.op == "fsw" {
+			m.store(addr, 4, m.f[a[0].reg])
+		} else {
+			m.store(addr, 8, m.f[a[0].reg])
+		}
+
+	case "j":
+		m.want(in, opImm)
+		next = uint64(imm(0))
+	case "jal", "call":
+		m.want(in, opImm)
+		m.x[1], next = next, uint64(imm(0))
+	case "tail":
+		m.want(in, opImm)
+		m.x[6], next = uint64(imm(0)), uint64(imm(0))
+	case "jr":
+		m.want(in, opReg)
+		next = rs(0)
+	case "jalr":
+		switch {
+		case len(a) == 1 && a[0].kind == opReg:
+			m.x[1], next = next, rs(0)
+		case len(a) == 2 && a[0].kind == opReg && a[1].kind == opMem:
+			target := rs(1) + uint64(imm(1))
+			m.setX(rd(), next)
+			next = target
+		default:
+			m.fault("unsupported operands")
+		}
+	case "ret":
+		m.want(in)
+		next = m.x[1]
+	case "beqz", "bnez", "blez", "bgez", "bltz", "bgtz":
+		m.want(in, opReg, opImm)
+		if branch(in.op[:len(in.op)-1], rs(0), 0) {
+			next = uint64(imm(1))
+		}
+	case "beq", "bne", "blt", "bge", "bltu", "bgeu", "bgt", "ble", "bgtu", "bleu":
+		m.want(in, opReg, opReg, opImm)
+		if branch(in.op, rs(0), rs(1)) {
+			next = uint64(imm(2))
+		}
+
+	default:
+		if !m.stepFloat(in) {
+			m.fault("unsupported instruction")
+		}
+	}
+	m.pc = next
+}
+
+func b2u(b bool) uint64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// branch reports whether the branch op is taken on x and y.
+func branch(op string, x, y uint64) bool {
+	switch op {
+	case "beq":
+		return x == y
+	case "bne":
+		return x != y
+	case "blt":
+		return int64(x) < int64(y)
+	case "bge":
+		return int64(x) >= int64(y)
+	case "bgt":
+		return int64(x) > int64(y)
+	case "ble":
+		return int64(x) <= int64(y)
+	case "bltu":
+		return x < y
+	case "bgeu":
+		return x >= y
+	case "bgtu":
+		return x > y
+	case "bleu":
+		return x <= y
+	}
+	return false
+}
+
+// immOps maps the instructions with an immediate operand to the operation
+// they compute.
+var immOps = map[string]string{
+	"addi": "add", "andi": "and", "ori": "or", "xori": "xor", "slti": "slt", "sltiu": "sltu",
+	"slli": "sll", "srli": "srl", "srai": "sra", "addiw": "addw", "slliw": "sllw", "srliw": "srlw", "sraiw": "sraw",
+}
+
+// alu computes the integer operation op, named by its instruction with
+// register operands, on x and y.
+func (m *machine) alu(op string, x, y uint64) uint64 {
+	switch op {
+	case "add":
+		return x + y
+	case "sub":
+		return x - y
+	case "and":
+		return x & y
+	case "or":
+		return x | y
+	case "xor":
+		return x ^ y
+	case "slt":
+		return b2u(int64(x) < int64(y))
+	case "sltu":
+		return b2u(x < y)
+	case "sll":
+		return x << (y & 63)
+	case "srl":
+		return x >> (y & 63)
+	case "sra":
+		return uint64(int64(x) >> (y & 63))
+	case "mul":
+		return x * y
+	case "mulhu":
+		hi, _ := bits.Mul64(x, y)
+		return hi
+	case "mulh":
+		hi, _ := bits.Mul64(x, y)
+		if int64(x) < 0 {
+			hi -= y
+		}
+		if int64(y) < 0 {
+			hi -= x
+		}
+		return hi
+	case "div":
+		switch {
+		case y == 0:
+			return math.MaxUint64
+		case int64(x) == math.MinInt64 && int64(y) == -1:
+			return x
+		}
+		return uint64(int64(x) / int64(y))
+	case "divu":
+		if y == 0 {
+			return math.MaxUint64
+		}
+		return x / y
+	case "rem":
+		switch {
+		case y == 0:
+			return x
+		case int64(x) == math.MinInt64 && int64(y) == -1:
+			return 0
+		}
+		return uint64(int64(x) % int64(y))
+	case "remu":
+		if y == 0 {
+			return x
+		}
+		return x % y
+	case "addw":
+		return sext32(x + y)
+	case "subw":
+		return sext32(x - y)
+	case "mulw":
+		return sext32(x * y)
+	case "sllw":
+		return sext32(x << (y & 31))
+	case "srlw":
+		return sext32(uint64(uint32(x) >> (y & 31)))
+	case "sraw":
+		return sext32(uint64(int32(x) >> (y & 31)))
+	case "divw", "remw":
+		return sext32(m.alu(op[:len(op)-1], sext32(x), sext32(y)))
+	case "divuw", "remuw":
+		return sext32(m.alu(op[:len(op)-1], uint64(uint32(x)), uint64(uint32(y))))
+	}
+	m.fault("unsupported operation %s", op)
+	return 0
+}
+
+// stepFloat runs the floating-point instruction in, reporting whether it
+// is one.
+func (m *machine) stepFloat(in *inst) bool {
+	a := in.args
+	fd, fs := func() int { return a[0].reg }, func(i int) int { return a[i].reg }
+
+	switch in.op {
+	case "fmv.d.x":
+		m.want(in, opFReg, opReg)
+		m.f[fd()] = m.x[a[1].reg]
+	case "fmv.x.d":
+		m.want(in, opReg, opFReg)
+		m.setX(a[0].reg, m.f[fs(1)])
+	case "fmv.w.x":
+		m.want(in, opFReg, opReg)
+		m.f[fd()] = 0xffffffff00000000 | uint64(uint32(m.x[a[1].reg]))
+	case "fmv.x.w":
+		m.want(in, opReg, opFReg)
+		m.setX(a[0].reg, sext32(m.f[fs(1)]))
+	case "fmv.d", "fmv.s":
+		m.want(in, opFReg, opFReg)
+		m.f[fd()] = m.f[fs(1)]
+	case "fneg.d":
+		m.want(in, opFReg, opFReg)
+		m.setF64(fd(), -m.getF64(fs(1)))
+	case "fneg.s":
+		m.want(in, opFReg, opFReg)
+		m.setF32(fd(), -m.getF32(fs(1)))
+	case "fabs.d":
+		m.want(in, opFReg, opFReg)
+		m.setF64(fd(), math.Abs(m.getF64(fs(1))))
+	case "fsqrt.d":
+		m.want(in, opFReg, opFReg)
+		m.setF64(fd(), math.Sqrt(m.getF64(fs(1))))
+	case "fsqrt.s":
+		m.want(in, opFReg, opFReg)
+		m.setF32(fd(), float32(math.Sqrt(float64(m.getF32(fs(1))))))
+
+	case "fadd.d", "fsub.d", "fmul.d", "fdiv.d":
+		m.want(in, opFReg, opFReg, opFReg)
+		x, y := m.getF64(fs(1)), m.getF64(fs(2))
+		m.setF64(fd(), map[string]float64{"fadd.d": x + y, "fsub.d": x - y, "fmul.d": x * y, "fdiv.d": x / y}[in.op])
+	case "fadd.s", "fsub.s", "fmul.s", "fdiv.s":
+		m.want(in, opFReg, opFReg, opFReg)
+		x, y := m.getF32(fs(1)), m.getF32(fs(2))
+		m.setF32(fd(), map[string]float32{"fadd.s": x + y, "fsub.s": x - y, "fmul.s": x * y, "fdiv.s": x / y}[in.op])
+	case "feq.d", "flt.d", "fle.d":
+		m.want(in, opReg, opFReg, opFReg)
+		x, y := m.getF64(fs(1)), m.getF64(fs(2))
+		m.setX(a[0].reg, b2u(map[string]bool{"feq.d": x == y, "flt.d": x < y, "fle.d": x <= y}[in.op]))
+	case "feq.s", "flt.s", "fle.s":
+		m.want(in, opReg, opFReg, opFReg)
+		x, y := m.getF32(fs(1)), m.getF32(fs(2))
+		m.setX(a[0].reg, b2u(map[string]bool{"feq.s": x == y, "flt.s": x < y, "fle.s": x <= y}[in.op]))
+
+	case "fcvt.d.s":
+		m.want(in, opFReg, opFReg)
+		m.setF64(fd(), float64(m.getF32(fs(1))))
+	case "fcvt.s.d":
+		m.want(in, opFReg, opFReg)
+		m.setF32(fd(), float32(m.getF64(fs(1))))
+	case "fcvt.d.l", "fcvt.d.lu", "fcvt.d.w", "fcvt.d.wu", "fcvt.s.l", "fcvt.s.lu", "fcvt.s.w", "fcvt.s.wu":
+		m.want(in, opFReg, opReg)
+		x := m.x[a[1].reg]
+		switch single := in.op[5] == 's'; in.op[7:] {
+		case "l":
+			if single {
+				m.setF32(fd(), float32(int64(x)))
+			} else {
+				m.setF64(fd(), float64(int64(x)))
+			}
+		case "lu":
+			if single {
+				m.setF32(fd(), float32(x))
+			} else {
+				m.setF64(fd(), float64(x))
+			}
+		case "w":
+			if single {
+				m.setF32(fd(), float32(int32(x)))
+			} else {
+				m.setF64(fd(), float64(int32(x)))
+			}
+		case "wu":
+			if single {
+				m.setF32(fd(), float32(uint32(x)))
+			} else {
+				m.setF64(fd(), float64(uint32(x)))
+			}
+		}
+	case "fcvt.l.d", "fcvt.lu.d", "fcvt.w.d", "fcvt.wu.d", "fcvt.l.s", "fcvt.lu.s", "fcvt.w.s", "fcvt.wu.s":
+		if len(a) == 3 {
+			m.want(in, opReg, opFReg, opRound)
+		} else {
+			m.want(in, opReg, opFReg)
+		}
+		var v float64
+		if in.op[len(in.op)-1] == 's' {
+			v = float64(m.getF32(fs(1)))
+		} else {
+			v = m.getF64(fs(1))
+		}
+		if len(a) == 3 && a[2].sym == "rtz" {
+			v = math.Trunc(v)
+		} else {
+			v = math.RoundToEven(v)
+		}
+		m.setX(a[0].reg, cvtInt(v, in.op[5:len(in.op)-2]))
+	default:
+		return false
+	}
+	return true
+}
+
+// cvtInt converts the integral v to the integer type kind, l, lu, w or wu,
+// saturating as RISC-V does.
+func cvtInt(v float64, kind string) uint64 {
+	switch kind {
+	case "l":
+		switch {
+		case math.IsNaN(v) || v >= 0x1p63:
+			return math.MaxInt64
+		case v < -0x1p63:
+			return 1 << 63
+		}
+		return uint64(int64(v))
+	case "lu":
+		switch {
+		case math.IsNaN(v) || v >= 0x1p64:
+			return math.MaxUint64
+		case v <= -1:
+			return 0
+		}
+		return uint64(v)
+	case "w":
+		switch {
+		case math.IsNaN(v) || v >= 0x1p31:
+			return math.MaxInt32
+		case v < -0x1p31:
+			return sext32(1 << 31)
+		}
+		return uint64(int64(v))
+	default:
+		switch {
+		case math.IsNaN(v) || v >= 0x1p32:
+			return math.MaxUint64
+		case v <= -1:
+			return 0
+		}
+		return sext32(uint64(v))
+	}
+}
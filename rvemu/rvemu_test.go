@@ -0,0 +1,89 @@
+package rvemu_test
+
+import (
+	"strings"
+	"testing"
+
+	"rkitamu/gocc/rvemu"
+)
+
+func TestRun(t *testing.T) {
+	tests := []struct {
+		name   string
+		asm    string
+		want   int
+		stdout string
+	}{
+		{"return value", "main:\n  li a0, 300\n  ret", 44, ""},
+		{"arithmetic", "main:\n  li t0, -7\n  li t1, 2\n  div a0, t0, t1\n  rem t2, t0, t1\n  sub a0, a0, t2\n  addi a0, a0, 10\n  ret", 8, ""},
+		{"division by zero", "main:\n  li t0, 5\n  divu t1, t0, zero\n  remu a0, t0, zero\n  add a0, a0, t1\n  ret", 4, ""},
+		{"word operations", "main:\n  li t0, 0x7fffffff\n  addiw a0, t0, 1\n  sltz a0, a0\n  ret", 1, ""},
+		{"loads extend", "main:\n  lla t0, b\n  lb t1, 0(t0)\n  lbu t2, 0(t0)\n  add a0, t1, t2\n  ret\n.data\nb:\n  .byte 255", 254, ""},
+		{
+			"data relocations",
+			".data\n.balign 8\np:\n  .quad x+4\nx:\n  .zero 4\n  .byte 9\n.text\nmain:\n  lla t0, p\n  ld t0, 0(t0)\n  lbu a0, 0(t0)\n  ret",
+			9, "",
+		},
+		{
+			"calls and the stack",
+			"f:\n  addi sp, sp, -16\n  sd ra, 8(sp)\n  addi a0, a0, 1\n  ld ra, 8(sp)\n  addi sp, sp, 16\n  ret\nmain:\n  addi sp, sp, -16\n  sd ra, 8(sp)\n  li a0, 4\n  call f\n  lla t5, f\n  jalr t5\n  ld ra, 8(sp)\n  addi sp, sp, 16\n  ret",
+			6, "",
+		},
+		{"branches", "main:\n  li a0, 0\n  li t0, 5\n.L1:\n  add a0, a0, t0\n  addi t0, t0, -1\n  bnez t0, .L1\n  bltu t0, a0, .L2\n  li a0, 0\n.L2:\n  ret", 15, ""},
+		{
+			"floats",
+			"main:\n  li t0, 3\n  fcvt.d.l ft0, t0\n  fsqrt.d ft1, ft0\n  fmul.d ft1, ft1, ft1\n  fcvt.l.d a0, ft1, rtz\n  fcvt.s.d ft2, ft0\n  fmv.x.w t1, ft2\n  fmv.w.x ft3, t1\n  feq.s t2, ft2, ft3\n  add a0, a0, t2\n  ret",
+			3, "",
+		},
+		// a float not NaN-boxed reads as the canonical NaN
+		{"NaN boxing", "main:\n  li t0, 0x3f800000\n  fmv.d.x ft0, t0\n  feq.s a0, ft0, ft0\n  ret", 0, ""},
+		{
+			"C library",
+			"main:\n  addi sp, sp, -16\n  sd ra, 8(sp)\n.Lgot0:\n  auipc a0, %got_pcrel_hi(fmt)\n  ld a0, %pcrel_lo(.Lgot0)(a0)\n  li a1, 42\n  li a2, 0x4004000000000000\n  lla a3, s\n  call printf\n  lla a0, s\n  call strlen\n  ld ra, 8(sp)\n  addi sp, sp, 16\n  ret\n.data\nfmt:\n  .byte 37, 100, 32, 37, 46, 49, 102, 32, 37, 115, 10, 0\ns:\n  .byte 114, 118, 0",
+			2, "42 2.5 rv\n",
+		},
+		{"exit", "main:\n  li a0, 3\n  call exit\n  li a0, 5\n  ret", 3, ""},
+		{"inline assembly comments", "main:\n#APP\n  li a0, 1 # one\n#NO_APP\n  ret", 1, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var stdout strings.Builder
+			got, err := rvemu.Run(tt.asm, &stdout)
+			if err != nil {
+				t.Fatalf("run error: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("exit status: got = %d, want = %d", got, tt.want)
+			}
+			if stdout.String() != tt.stdout {
+				t.Errorf("stdout: got = %q, want = %q", stdout.String(), tt.stdout)
+			}
+		})
+	}
+}
+
+func TestRun_Errors(t *testing.T) {
+	tests := []struct {
+		name string
+		asm  string
+		want string
+	}{
+		{"no main", "f:\n  ret", "no main function"},
+		{"undefined symbol", "main:\n  call g\n  ret", "line 2: undefined symbol \"g\""},
+		{"unsupported instruction", "main:\n  ecall\n  ret", "line 2: ecall: unsupported instruction"},
+		{"bad operands", "main:\n  add a0, a1\n  ret", "line 2: add: expected 3 operands"},
+		{"null pointer", "main:\n  ld a0, 8(zero)\n  ret", "line 2: ld: invalid memory access at 0x8"},
+		{"duplicate symbol", "main:\nmain:\n  ret", "line 2: symbol \"main\" is already defined"},
+		{"endless loop", "main:\n  j main", "program did not finish"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := rvemu.Run(tt.asm, nil)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("expected an error containing %q, but got %v", tt.want, err)
+			}
+		})
+	}
+}
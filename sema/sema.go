@@ -0,0 +1,123 @@
+// Package sema checks the AST built and typed by the parser before code is
+// generated for it. It checks that assignments, & and asm outputs operate on
+// modifiable lvalues, and lays out the stack frame of every function.
+package sema
+
+import (
+	"fmt"
+
+	"rkitamu/gocc/errors"
+	"rkitamu/gocc/parser"
+)
+
+// checker holds the state of a Check call.
+type checker struct {
+	input string // source code, for error messages
+}
+
+// Check analyzes the bodies of the function definitions in funcs, parsed from
+// input. It reports the first error found.
+func Check(funcs []*parser.Function, input string) error {
+	c := &checker{input: input}
+	for _, fn := range funcs {
+		if !fn.IsDefinition {
+			continue
+		}
+		if err := c.walk(fn.Body); err != nil {
+			return err
+		}
+		layoutFrame(fn)
+	}
+	return nil
+}
+
+// walk checks node and all of its children.
+func (c *checker) walk(node *parser.Node) error {
+	if node == nil {
+		return nil
+	}
+	if err := c.check(node); err != nil {
+		return err
+	}
+	for _, child := range []*parser.Node{node.Lhs, node.Rhs, node.Cond, node.Then, node.Else, node.Init, node.Inc} {
+		if err := c.walk(child); err != nil {
+			return err
+		}
+	}
+	for _, list := range [][]*parser.Node{node.Body, node.Args} {
+		for _, child := range list {
+			if err := c.walk(child); err != nil {
+				return err
+			}
+		}
+	}
+	if node.Asm != nil {
+		for _, op := range append(append([]*parser.AsmOperand{}, node.Asm.Outputs...), node.Asm.Inputs...) {
+			if err := c.walk(op.Expr); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// check checks node itself, without its children.
+func (c *checker) check(node *parser.Node) error {
+	switch node.Kind {
+	case parser.ASSIGN:
+		if node.IsInit {
+			return nil
+		}
+		lhs := node.Lhs
+		if !parser.IsLvalue(lhs) || lhs.Ty.Kind == parser.TY_ARRAY || lhs.Ty.Kind == parser.TY_VLA {
+			return errors.NewPosError("lvalue required as left operand of assignment", c.input, node.Pos)
+		}
+		if lhs.Ty.IsReadOnly() {
+			return errors.NewPosError("assignment of read-only location", c.input, node.Pos)
+		}
+	case parser.ADDR:
+		lhs := node.Lhs
+		if !parser.IsLvalue(lhs) {
+			return errors.NewPosError("lvalue required as unary & operand", c.input, node.Pos)
+		}
+		if lhs.Kind == parser.MEMBER && lhs.Member.IsBitfield {
+			return errors.NewPosError(fmt.Sprintf("cannot take address of bit-field %s", lhs.Member.Name), c.input, node.Pos)
+		}
+	case parser.ASM:
+		for i, op := range node.Asm.Outputs {
+			if !parser.IsLvalue(op.Expr) {
+				return errors.NewPosError(fmt.Sprintf("invalid lvalue in asm output %d", i), c.input, op.Pos)
+			}
+			if op.Expr.Ty.IsReadOnly() {
+				return errors.NewPosError(fmt.Sprintf("read-only location used as asm output %d", i), c.input, op.Pos)
+			}
+		}
+	}
+	return nil
+}
+
+// layoutFrame places the local variables of fn below rbp in the order they
+// were declared, each aligned as it requires, and sets the size of the
+// frame. The size is a multiple of 16 to keep rsp aligned as the ABI
+// requires at call sites.
+func layoutFrame(fn *parser.Function) {
+	var locals []*parser.LVar
+	for v := fn.Locals; v != nil; v = v.Next {
+		locals = append(locals, v)
+	}
+
+	offset := 0
+	for i := len(locals) - 1; i >= 0; i-- {
+		v := locals[i]
+		if v.Global == nil {
+			offset = alignTo(offset+v.Ty.Size, v.Align)
+		}
+		v.Offset = offset
+	}
+	fn.StackSize = alignTo(offset, 16)
+}
+
+// alignTo rounds n up to the nearest multiple of align.
+func alignTo(n, align int) int {
+	return (n + align - 1) / align * align
+}
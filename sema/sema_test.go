@@ -0,0 +1,79 @@
+package sema_test
+
+import (
+	"strings"
+	"testing"
+
+	"rkitamu/gocc/lexer"
+	"rkitamu/gocc/parser"
+	"rkitamu/gocc/sema"
+)
+
+// parse parses input, failing the test on errors.
+func parse(t *testing.T, input string) *parser.Parser {
+	t.Helper()
+	tokens, err := lexer.NewLexer(input).Lex()
+	if err != nil {
+		t.Fatalf("lex error: %v", err)
+	}
+	p := parser.NewParser(tokens, input)
+	if err := p.Parse(); err != nil {
+		t.Fatalf("parse error: %v", err)
+	}
+	return p
+}
+
+func TestCheck_Frame(t *testing.T) {
+	input := "int f(char c, long l) { int a; _Alignas(16) char b; static int s; short d[3]; return 0; } int main() { return 0; }"
+	p := parse(t, input)
+	if err := sema.Check(p.Funcs, input); err != nil {
+		t.Fatalf("check error: %v", err)
+	}
+
+	want := map[string]int{"c": 1, "l": 16, "a": 20, "b": 32, "s": 32, "d": 38}
+	f := p.Funcs[0]
+	for v := f.Locals; v != nil; v = v.Next {
+		if v.Offset != want[v.Name] {
+			t.Errorf("%s: expected offset %d, but got %d", v.Name, want[v.Name], v.Offset)
+		}
+	}
+	if f.StackSize != 48 {
+		t.Errorf("expected a 48 byte frame, but got %d", f.StackSize)
+	}
+	if main := p.Funcs[1]; main.StackSize != 0 {
+		t.Errorf("expected an empty frame for main, but got %d", main.StackSize)
+	}
+}
+
+func TestCheck_Errors(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{"&1;", "lvalue required as unary & operand"},
+		{"int main() { int a[2]; a = 0; }", "lvalue required as left operand of assignment"},
+		{"int main() { int n = 3; int a[n]; a = 0; return 0; }", "lvalue required as left operand of assignment"},
+		{"int main() { const int x = 1; x = 2; return x; }", "assignment of read-only location"},
+		{"int main() { int x; const int *p = &x; *p = 2; return 0; }", "assignment of read-only location"},
+		{"int main() { int x; int *const p = &x; p = 0; return 0; }", "assignment of read-only location"},
+		{"struct P { const int x; }; int main() { struct P a = {1}; struct P b = {2}; a = b; return 0; }", "assignment of read-only location"},
+		{"struct P { int x; }; int main() { const struct P a = {1}; a.x = 2; return 0; }", "assignment of read-only location"},
+		{"const int a[2]; int main() { a[0] = 1; return 0; }", "assignment of read-only location"},
+		{"struct S { int a : 3; } s; int main() { int *p = &s.a; return 0; }", "cannot take address of bit-field a"},
+		{"int main() { asm(\"\" : \"=r\"(1)); return 0; }", "invalid lvalue in asm output 0"},
+		{"int main() { const int x = 1; asm(\"\" : \"=r\"(x)); return 0; }", "read-only location used as asm output 0"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			p := parse(t, tt.input)
+			err := sema.Check(p.Funcs, tt.input)
+			if err == nil {
+				t.Fatalf("expected error, but got none")
+			}
+			if !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("expected error %q, but got %q", tt.want, err.Error())
+			}
+		})
+	}
+}